@@ -19,7 +19,7 @@ export default function ActorView() {
     }
 
     useEffect(() => {
-        fetch(`http://localhost:1313/api/actors/${id}`)
+        fetch(`http://localhost:1313/api/actors/${id}/filmography`)
             .then(response => response.json())
             .then(data => setActor(data))
             .catch(error => console.error('Error fetching actor items:', error));
@@ -19,7 +19,7 @@ export default function DirectorView() {
     }
 
     useEffect(() => {
-        fetch(`http://localhost:1313/api/directors/${id}`)
+        fetch(`http://localhost:1313/api/directors/${id}/filmography`)
             .then(response => response.json())
             .then(data => setDirector(data))
             .catch(error => console.error('Error fetching director items:', error));
@@ -35,20 +35,21 @@ export default class LoginPage extends React.Component {
             .then((res) => res.json())
             .then((data) => {
                 if (data.status === "ok") {
-                    const { user, token } = data.data;
+                    const { user, token, refresh_token } = data.data;
                     window.localStorage.setItem("token", token);
+                    window.localStorage.setItem("refreshToken", refresh_token);
                     window.localStorage.setItem("loggedIn", true);
                     window.localStorage.setItem("username", user.Username);
                     window.localStorage.setItem("email", user.Email);
                     window.location.href = "/";
                 }
 
-                if (data.status === "passNoMatch") {
+                if (data.status === "error" && data.error.code === "unauthorized") {
                     this.setState({ wrongpass: true });
                     this.setState({ nouser: false });
                 }
 
-                if (data.status === "usernotfound") {
+                if (data.status === "error" && data.error.code === "not_found") {
                     this.setState({ nouser: true });
                     this.setState({ wrongpass: false });
                 }
@@ -62,6 +62,7 @@ export default function MovieView() {
                 'Content-Type': 'application/json',
                 Accept: 'application/json',
                 'Access-Control-Allow-Origin': '*',
+                Authorization: `Bearer ${localStorage.getItem('token')}`,
             },
             body: JSON.stringify(watchlistPayload),
         })
@@ -88,6 +89,7 @@ export default function MovieView() {
                 'Content-Type': 'application/json',
                 Accept: 'application/json',
                 'Access-Control-Allow-Origin': '*',
+                Authorization: `Bearer ${localStorage.getItem('token')}`,
             },
             body: JSON.stringify(likedPayload),
         })
@@ -116,6 +118,7 @@ export default function MovieView() {
                 "Content-Type": "application/json",
                 Accept: "application/json",
                 "Access-Control-Allow-Origin": "*",
+                Authorization: `Bearer ${localStorage.getItem("token")}`,
             },
             body: JSON.stringify({
                 reviewText: inputValue,
@@ -18,8 +18,19 @@ export default function NavBar({ searchTerm, handleChange }) {
 
 
     const logout = () => {
-        window.localStorage.clear();
-        window.location.href = '/';
+        fetch('http://localhost:1313/logout', {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+                Accept: 'application/json',
+            },
+            body: JSON.stringify({ refresh_token: localStorage.getItem('refreshToken') }),
+        })
+            .catch(error => console.error('Error logging out:', error))
+            .finally(() => {
+                window.localStorage.clear();
+                window.location.href = '/';
+            });
     };
 
     const login = () => {
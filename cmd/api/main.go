@@ -1,15 +1,129 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	_ "github.com/joho/godotenv/autoload"
+	"lab2324omada7/internal/config"
+	"lab2324omada7/internal/database"
 	"lab2324omada7/internal/server"
 )
 
+const usage = `usage:
+  api [flags]                          run the HTTP API
+  api migrate [flags] up               apply every pending migration
+  api migrate [flags] down [n]         revert the last n migrations (default 1)
+  api migrate [flags] to <version>     migrate up or down to version
+  api migrate [flags] status           list migrations and whether they are applied
+  api seed [flags] [fixtures.json]     load the sample catalog (or the given fixtures)
+  api role [flags] grant <user> <role> give a user the moderator or admin role
+  api role [flags] revoke <user> <role>
+                                       take a role away and sign the user out
+  api role [flags] show <user>         list a user's roles
+
+Run "api -h" to list the flags.`
+
 func main() {
+	args := os.Args[1:]
+	command := "serve"
+	if len(args) > 0 && args[0] != "" && args[0][0] != '-' {
+		command, args = args[0], args[1:]
+	}
+
+	cfg, args, err := config.Load(args)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	switch command {
+	case "serve":
+		serve(cfg)
+	case "migrate":
+		if err := migrate(cfg, args); err != nil {
+			log.Fatal(err)
+		}
+	case "seed":
+		if err := seedCatalog(cfg, args); err != nil {
+			log.Fatal(err)
+		}
+	case "role":
+		if err := role(cfg, args); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
+
+// shutdownTimeout is how long serve waits, once told to stop, for the
+// requests in flight to finish.
+const shutdownTimeout = 10 * time.Second
 
-	server := server.NewServer()
+func serve(cfg *config.Config) {
+	if err := cfg.CheckServe(); err != nil {
+		log.Fatal(err)
+	}
 
-	err := server.ListenAndServe()
+	db, err := database.New(cfg)
 	if err != nil {
-		panic("cannot start server")
+		log.Fatalf("cannot open database: %v", err)
+	}
+
+	// ctx ends on SIGINT or SIGTERM, which stops the server and the purges.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	server := server.NewServer(cfg, db)
+	purged := make(chan struct{})
+	go func() {
+		defer close(purged)
+		purgeAccounts(ctx, db)
+	}()
+
+	errs := make(chan error, 1)
+	go func() { errs <- server.ListenAndServe() }()
+	select {
+	case err := <-errs:
+		log.Fatalf("cannot start server: %v", err)
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("shutting down: %v", err)
+	}
+	<-purged
+}
+
+// purgeInterval is how often serve deletes the accounts whose grace period
+// is over.
+const purgeInterval = time.Hour
+
+// purgeAccounts deletes the accounts due for deletion now and then every
+// purgeInterval, until ctx ends, which also cancels a purge under way.
+func purgeAccounts(ctx context.Context, db database.Service) {
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+	for {
+		n, err := db.PurgeAccounts(ctx, time.Now())
+		if err != nil && ctx.Err() == nil {
+			log.Printf("purging deleted accounts: %v", err)
+		}
+		if n > 0 {
+			log.Printf("purged %d deleted accounts", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
 	}
 }
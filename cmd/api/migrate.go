@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"lab2324omada7/internal/config"
+	"lab2324omada7/internal/database"
+	"lab2324omada7/internal/migrations"
+)
+
+var errUsage = errors.New(usage)
+
+func migrate(cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	db, err := database.Open(cfg.Database)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	m, err := migrations.New(db, cfg.Database.Driver)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	var done []migrations.Migration
+
+	switch args[0] {
+	case "up":
+		done, err = m.Up(ctx)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return fmt.Errorf("down: %q is not a positive number of steps", args[1])
+			}
+		}
+		done, err = m.Down(ctx, steps)
+	case "to":
+		if len(args) < 2 {
+			return errUsage
+		}
+		version, perr := strconv.ParseInt(args[1], 10, 64)
+		if perr != nil {
+			return fmt.Errorf("to: %q is not a version", args[1])
+		}
+		done, err = m.To(ctx, version)
+	case "status":
+		return printStatus(ctx, m)
+	default:
+		return errUsage
+	}
+
+	for _, mig := range done {
+		fmt.Printf("migrated %04d_%s\n", mig.Version, mig.Name)
+	}
+	if err == nil && len(done) == 0 {
+		fmt.Println("nothing to migrate")
+	}
+	return err
+}
+
+func printStatus(ctx context.Context, m *migrations.Migrator) error {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+	for _, s := range statuses {
+		appliedAt := "pending"
+		if s.Applied {
+			appliedAt = s.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(w, "%04d\t%s\t%s\n", s.Version, s.Name, appliedAt)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"lab2324omada7/internal/config"
+	"lab2324omada7/internal/database"
+)
+
+// role manages user roles from the command line, which is how the first
+// admin gets appointed. Changes are logged like those made through the API,
+// with no acting user.
+func role(cfg *config.Config, args []string) error {
+	if len(args) < 2 || (args[0] != "show" && len(args) < 3) {
+		return errUsage
+	}
+
+	db, err := database.New(cfg)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	userID, err := db.GetUserID(ctx, args[1])
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "grant":
+		err = db.GrantRole(ctx, 0, userID, args[2])
+	case "revoke":
+		err = db.RevokeRole(ctx, 0, userID, args[2])
+	case "show":
+	default:
+		return errUsage
+	}
+	if err != nil {
+		return err
+	}
+
+	roles, err := db.GetUserRoles(ctx, userID)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s: %s\n", args[1], strings.Join(roles, ", "))
+	return nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"lab2324omada7/internal/config"
+	"lab2324omada7/internal/database"
+	"lab2324omada7/internal/seed"
+)
+
+func seedCatalog(cfg *config.Config, args []string) error {
+	var (
+		fixtures *seed.Fixtures
+		err      error
+	)
+
+	if len(args) > 0 {
+		f, ferr := os.Open(args[0])
+		if ferr != nil {
+			return ferr
+		}
+		defer f.Close()
+		fixtures, err = seed.Load(f)
+	} else {
+		fixtures, err = seed.Default()
+	}
+	if err != nil {
+		return err
+	}
+
+	db, err := database.Open(cfg.Database)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := seed.Apply(context.Background(), db, fixtures); err != nil {
+		return err
+	}
+
+	fmt.Printf("seeded %d movies, %d actors, %d directors, %d users and %d reviews\n",
+		len(fixtures.Movies), len(fixtures.Actors), len(fixtures.Directors), len(fixtures.Users), len(fixtures.Reviews))
+	return nil
+}
@@ -0,0 +1,285 @@
+// Package config loads the API's settings from, in increasing order of
+// precedence, built-in defaults, an optional dotenv style config file, the
+// environment and command line flags.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/joho/godotenv"
+)
+
+type Config struct {
+	AppEnv             string
+	Port               int
+	JWTKey             []byte
+	CORSAllowedOrigins []string
+	ReadTimeout        time.Duration
+	WriteTimeout       time.Duration
+	IdleTimeout        time.Duration
+	Database           Database
+
+	// AccountDeletionGrace is how long an account its user deleted lingers,
+	// for them to change their mind, before it is removed for good.
+	AccountDeletionGrace time.Duration
+}
+
+// Supported values of DB_DRIVER.
+const (
+	DriverMySQL  = "mysql"
+	DriverSQLite = "sqlite"
+)
+
+type Database struct {
+	Driver          string
+	Path            string
+	Host            string
+	Port            int
+	Name            string
+	Username        string
+	Password        string
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	// QueryTimeout bounds every database.Service call. Zero means no
+	// deadline beyond the request's own.
+	QueryTimeout time.Duration
+}
+
+// DSN returns the data source name for d's driver. SQLite databases get
+// foreign keys enabled, a busy timeout and immediate write transactions so
+// that concurrent writers queue up instead of failing.
+func (d Database) DSN() string {
+	if d.Driver == DriverSQLite {
+		return "file:" + d.Path + "?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_txlock=immediate"
+	}
+
+	cfg := mysql.NewConfig()
+	cfg.User = d.Username
+	cfg.Passwd = d.Password
+	cfg.Net = "tcp"
+	cfg.Addr = net.JoinHostPort(d.Host, strconv.Itoa(d.Port))
+	cfg.DBName = d.Name
+	return cfg.FormatDSN()
+}
+
+// setting describes one configuration value. Secrets have no flag so that
+// they never show up in process listings.
+type setting struct {
+	env   string
+	flag  string
+	def   string
+	usage string
+}
+
+var settings = []setting{
+	{"APP_ENV", "env", "local", "deployment environment"},
+	{"PORT", "port", "1313", "HTTP listen port"},
+	{"KEY", "", "", "secret used to sign access tokens (needed only to serve the API)"},
+	{"CORS_ALLOWED_ORIGINS", "cors-origins", "*", "comma separated list of allowed CORS origins"},
+	{"READ_TIMEOUT", "read-timeout", "10s", "HTTP server read timeout"},
+	{"WRITE_TIMEOUT", "write-timeout", "30s", "HTTP server write timeout"},
+	{"IDLE_TIMEOUT", "idle-timeout", "1m", "HTTP server idle timeout"},
+	{"ACCOUNT_DELETION_GRACE", "account-deletion-grace", "720h", "how long deleted accounts can be restored by signing in (0 deletes them at once)"},
+	{"DB_DRIVER", "db-driver", DriverMySQL, "database backend: mysql or sqlite"},
+	{"DB_PATH", "db-path", "lab2324omada7.db", "SQLite database file"},
+	{"DB_HOST", "db-host", "", "MySQL host"},
+	{"DB_PORT", "db-port", "3306", "MySQL port"},
+	{"DB_DATABASE", "db-name", "", "MySQL database name"},
+	{"DB_USERNAME", "db-user", "", "MySQL user"},
+	{"DB_PASSWORD", "", "", "MySQL password"},
+	{"DB_MAX_OPEN_CONNS", "db-max-open-conns", "50", "maximum number of open database connections"},
+	{"DB_MAX_IDLE_CONNS", "db-max-idle-conns", "50", "maximum number of idle database connections"},
+	{"DB_CONN_MAX_LIFETIME", "db-conn-max-lifetime", "0s", "maximum lifetime of a database connection (0 keeps them forever)"},
+	{"DB_QUERY_TIMEOUT", "db-query-timeout", "5s", "deadline for each database call (0 disables it)"},
+}
+
+// Load builds the configuration from the given command line arguments
+// (without the program name), the environment and the config file named by
+// -config or CONFIG_FILE. Every invalid or missing value is reported at once.
+// The arguments left after the flags are returned alongside.
+func Load(args []string) (*Config, []string, error) {
+	fs := flag.NewFlagSet("api", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "path to a KEY=value config file")
+
+	envByFlag := make(map[string]string)
+	for _, s := range settings {
+		if s.flag != "" {
+			fs.String(s.flag, s.def, s.usage+" ($"+s.env+")")
+			envByFlag[s.flag] = s.env
+		}
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	values := make(map[string]string)
+	for _, s := range settings {
+		values[s.env] = s.def
+	}
+
+	if *configFile != "" {
+		file, err := godotenv.Read(*configFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("config: reading %s: %w", *configFile, err)
+		}
+		for _, s := range settings {
+			if v, ok := file[s.env]; ok {
+				values[s.env] = v
+			}
+		}
+	}
+
+	for _, s := range settings {
+		if v, ok := os.LookupEnv(s.env); ok {
+			values[s.env] = v
+		}
+	}
+
+	// Only flags given explicitly override the environment.
+	fs.Visit(func(f *flag.Flag) {
+		if env, ok := envByFlag[f.Name]; ok {
+			values[env] = f.Value.String()
+		}
+	})
+
+	cfg, err := parse(values)
+	return cfg, fs.Args(), err
+}
+
+func parse(values map[string]string) (*Config, error) {
+	p := parser{values: values}
+
+	cfg := &Config{
+		AppEnv:             p.str("APP_ENV"),
+		Port:               p.port("PORT"),
+		JWTKey:             []byte(p.str("KEY")),
+		CORSAllowedOrigins: p.list("CORS_ALLOWED_ORIGINS"),
+		ReadTimeout:        p.duration("READ_TIMEOUT"),
+		WriteTimeout:       p.duration("WRITE_TIMEOUT"),
+		IdleTimeout:        p.duration("IDLE_TIMEOUT"),
+		Database: Database{
+			Driver:          p.str("DB_DRIVER"),
+			MaxOpenConns:    p.positive("DB_MAX_OPEN_CONNS"),
+			MaxIdleConns:    p.positive("DB_MAX_IDLE_CONNS"),
+			ConnMaxLifetime: p.duration("DB_CONN_MAX_LIFETIME"),
+			QueryTimeout:    p.duration("DB_QUERY_TIMEOUT"),
+		},
+		AccountDeletionGrace: p.duration("ACCOUNT_DELETION_GRACE"),
+	}
+
+	switch cfg.Database.Driver {
+	case DriverMySQL:
+		cfg.Database.Host = p.required("DB_HOST")
+		cfg.Database.Port = p.port("DB_PORT")
+		cfg.Database.Name = p.required("DB_DATABASE")
+		cfg.Database.Username = p.required("DB_USERNAME")
+		cfg.Database.Password = p.str("DB_PASSWORD")
+	case DriverSQLite:
+		cfg.Database.Path = p.required("DB_PATH")
+	default:
+		p.fail("DB_DRIVER", fmt.Sprintf("must be %q or %q, got %q", DriverMySQL, DriverSQLite, cfg.Database.Driver))
+	}
+
+	if cfg.Database.MaxIdleConns > cfg.Database.MaxOpenConns {
+		p.fail("DB_MAX_IDLE_CONNS", "must not exceed DB_MAX_OPEN_CONNS")
+	}
+
+	if len(p.errs) > 0 {
+		return nil, fmt.Errorf("config: %w", errors.Join(p.errs...))
+	}
+
+	return cfg, nil
+}
+
+// CheckServe reports the settings that only serving the API needs. Load
+// leaves them unchecked so that commands such as migrate and seed run
+// without them.
+func (c *Config) CheckServe() error {
+	if len(c.JWTKey) == 0 {
+		return errors.New("config: KEY must be set")
+	}
+	return nil
+}
+
+// parser converts raw values, collecting an error per bad setting instead of
+// stopping at the first one.
+type parser struct {
+	values map[string]string
+	errs   []error
+}
+
+func (p *parser) fail(key, msg string) {
+	p.errs = append(p.errs, fmt.Errorf("%s %s", key, msg))
+}
+
+func (p *parser) str(key string) string {
+	return strings.TrimSpace(p.values[key])
+}
+
+func (p *parser) required(key string) string {
+	v := p.str(key)
+	if v == "" {
+		p.fail(key, "must be set")
+	}
+	return v
+}
+
+func (p *parser) list(key string) []string {
+	var out []string
+	for _, v := range strings.Split(p.str(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	if len(out) == 0 {
+		p.fail(key, "must list at least one value")
+	}
+	return out
+}
+
+func (p *parser) int(key string) (int, bool) {
+	n, err := strconv.Atoi(p.str(key))
+	if err != nil {
+		p.fail(key, fmt.Sprintf("must be an integer, got %q", p.values[key]))
+		return 0, false
+	}
+	return n, true
+}
+
+func (p *parser) port(key string) int {
+	n, ok := p.int(key)
+	if ok && (n < 1 || n > 65535) {
+		p.fail(key, fmt.Sprintf("must be between 1 and 65535, got %d", n))
+	}
+	return n
+}
+
+func (p *parser) positive(key string) int {
+	n, ok := p.int(key)
+	if ok && n < 1 {
+		p.fail(key, fmt.Sprintf("must be positive, got %d", n))
+	}
+	return n
+}
+
+func (p *parser) duration(key string) time.Duration {
+	d, err := time.ParseDuration(p.str(key))
+	if err != nil {
+		p.fail(key, fmt.Sprintf("must be a duration such as 30s, got %q", p.values[key]))
+		return 0
+	}
+	if d < 0 {
+		p.fail(key, "must not be negative")
+	}
+	return d
+}
@@ -0,0 +1,115 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func setRequired(t *testing.T) {
+	t.Helper()
+	t.Setenv("KEY", "secret")
+	t.Setenv("DB_HOST", "localhost")
+	t.Setenv("DB_DATABASE", "movies")
+	t.Setenv("DB_USERNAME", "user")
+}
+
+func TestLoadDefaults(t *testing.T) {
+	setRequired(t)
+
+	cfg, _, err := Load(nil)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	if cfg.Port != 1313 || cfg.Database.Port != 3306 || cfg.WriteTimeout != 30*time.Second || cfg.Database.QueryTimeout != 5*time.Second ||
+		cfg.AccountDeletionGrace != 30*24*time.Hour {
+		t.Errorf("unexpected defaults: %+v", cfg)
+	}
+	if got, want := cfg.Database.DSN(), "user@tcp(localhost:3306)/movies"; got != want {
+		t.Errorf("DSN = %q; want %q", got, want)
+	}
+}
+
+func TestLoadReportsEveryProblem(t *testing.T) {
+	t.Setenv("DB_HOST", "")
+	t.Setenv("DB_DATABASE", "movies")
+	t.Setenv("DB_USERNAME", "user")
+	t.Setenv("PORT", "http")
+
+	_, _, err := Load(nil)
+	if err == nil {
+		t.Fatal("Load: expected an error")
+	}
+	for _, want := range []string{"DB_HOST must be set", "PORT must be an integer"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+}
+
+func TestKeyNeededOnlyToServe(t *testing.T) {
+	setRequired(t)
+	t.Setenv("KEY", "")
+
+	cfg, _, err := Load(nil)
+	if err != nil {
+		t.Fatalf("Load without KEY: %v", err)
+	}
+	if err := cfg.CheckServe(); err == nil || !strings.Contains(err.Error(), "KEY must be set") {
+		t.Errorf("CheckServe = %v; want KEY must be set", err)
+	}
+
+	t.Setenv("KEY", "secret")
+	if cfg, _, err = Load(nil); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if err := cfg.CheckServe(); err != nil {
+		t.Errorf("CheckServe: %v", err)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	setRequired(t)
+
+	file := filepath.Join(t.TempDir(), "api.env")
+	if err := os.WriteFile(file, []byte("PORT=2000\nDB_PORT=3307\nIDLE_TIMEOUT=5m\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("DB_PORT", "3308")
+
+	cfg, args, err := Load([]string{"-config", file, "-port", "4000", "up"})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	if len(args) != 1 || args[0] != "up" {
+		t.Errorf("args = %q; want [up]", args)
+	}
+	if cfg.Port != 4000 {
+		t.Errorf("Port = %d; flag should win", cfg.Port)
+	}
+	if cfg.Database.Port != 3308 {
+		t.Errorf("DB port = %d; environment should beat the config file", cfg.Database.Port)
+	}
+	if cfg.IdleTimeout != 5*time.Minute {
+		t.Errorf("IdleTimeout = %v; config file should beat the default", cfg.IdleTimeout)
+	}
+}
+
+func TestLoadSQLiteNeedsNoServer(t *testing.T) {
+	t.Setenv("KEY", "secret")
+	t.Setenv("DB_DRIVER", "sqlite")
+	t.Setenv("DB_HOST", "")
+	t.Setenv("DB_PATH", "/tmp/movies.db")
+
+	cfg, _, err := Load(nil)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if !strings.HasPrefix(cfg.Database.DSN(), "file:/tmp/movies.db?") {
+		t.Errorf("DSN = %q", cfg.Database.DSN())
+	}
+}
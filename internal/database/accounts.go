@@ -0,0 +1,208 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Users can delete their accounts. Deleting one signs its user out
+// everywhere and marks it with the time to remove it, which leaves a grace
+// period in which signing in again cancels the deletion. PurgeAccounts then
+// removes it for good, reviews included, which come out of the movies'
+// ratings, and helpful votes, which come out of the reviews' counts; the rest
+// of the user's rows go with the USER row through the foreign keys. Only the
+// role audit log, which keeps plain IDs, outlives it. Until then the account
+// is hidden as if it were gone: GetUserID and GetProfile don't find it, and
+// its reviews, its lists and its name on the lists of others are left out.
+// Its reviews still count in the movies' ratings until they go. The last admin can't
+// delete their account, just as they can't lose the role, so there's always
+// someone left to grant roles.
+
+// DeleteAccount schedules the user's account for deletion at the given
+// time, if password is theirs, and ends every session they have. An account
+// due by now is deleted at once. The last admin gets ErrConflict.
+func (s *service) DeleteAccount(ctx context.Context, userID int, password string, at time.Time) (err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var hash string
+	err = tx.QueryRowContext(ctx, "SELECT Password FROM USER WHERE user_id = ?"+s.forUpdate(), userID).Scan(&hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("user %d: %w", userID, ErrNotFound)
+	}
+	if err != nil {
+		return err
+	}
+	if !comparePasswords(hash, password) {
+		return ErrWrongPassword
+	}
+	last, err := s.lastAdmin(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+	if last {
+		return fmt.Errorf("user %d is the last admin: %w", userID, ErrConflict)
+	}
+
+	if !at.After(time.Now()) {
+		if err := s.deleteAccount(ctx, tx, userID); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE USER SET DeleteAfter = ? WHERE user_id = ?", at.Unix(), userID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE REFRESH_TOKEN SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL", time.Now().Unix(), userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// PurgeAccounts deletes the accounts due for deletion by now, each in a
+// transaction of its own, and returns how many it deleted.
+func (s *service) PurgeAccounts(ctx context.Context, now time.Time) (int, error) {
+	ids, err := s.dueAccounts(ctx, now)
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, id := range ids {
+		deleted, err := s.purgeAccount(ctx, id, now)
+		if err != nil {
+			return purged, fmt.Errorf("deleting user %d: %w", id, err)
+		}
+		if deleted {
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+func (s *service) dueAccounts(ctx context.Context, now time.Time) (_ []int, err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
+
+	rows, err := s.db.QueryContext(ctx, "SELECT user_id FROM USER WHERE DeleteAfter <= ? ORDER BY user_id", now.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// purgeAccount deletes the account if it is still due, since the user may
+// have signed in since dueAccounts looked. An admin whose role was revoked
+// from everyone else meanwhile is kept until there's another.
+func (s *service) purgeAccount(ctx context.Context, userID int, now time.Time) (_ bool, err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var deleteAfter sql.NullInt64
+	err = tx.QueryRowContext(ctx, "SELECT DeleteAfter FROM USER WHERE user_id = ?"+s.forUpdate(), userID).Scan(&deleteAfter)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !deleteAfter.Valid || deleteAfter.Int64 > now.Unix() {
+		return false, nil
+	}
+	if last, err := s.lastAdmin(ctx, tx, userID); err != nil || last {
+		return false, err
+	}
+
+	if err := s.deleteAccount(ctx, tx, userID); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// lastAdmin reports whether the user is an admin and every other admin, if
+// any, is leaving too. DeleteAccount, PurgeAccounts and RevokeRole all keep
+// the last admin by it.
+func (s *service) lastAdmin(ctx context.Context, tx *sql.Tx, userID int) (bool, error) {
+	var admin, others int
+	err := tx.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(UR.user_id = ?), 0), COALESCE(SUM(UR.user_id <> ? AND U.DeleteAfter IS NULL), 0)
+		FROM USER_ROLE UR JOIN USER U ON U.user_id = UR.user_id
+		WHERE UR.role = ?`+s.forUpdate(), userID, userID, RoleAdmin).Scan(&admin, &others)
+	return admin > 0 && others == 0, err
+}
+
+// deleteAccount removes the user's reviews, taking them out of the ratings
+// of their movies, and then the user. The movies are locked in order, as
+// AddReview and DeleteReview lock them too.
+func (s *service) deleteAccount(ctx context.Context, tx *sql.Tx, userID int) error {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT R.review_id, R.movie_id, R.RatingStars FROM REVIEW R JOIN WROTE W ON W.review_id = R.review_id
+		WHERE W.user_id = ?`, userID)
+	if err != nil {
+		return err
+	}
+	type review struct{ id, movieID, stars int }
+	var reviews []review
+	for rows.Next() {
+		var r review
+		if err := rows.Scan(&r.id, &r.movieID, &r.stars); err != nil {
+			rows.Close()
+			return err
+		}
+		reviews = append(reviews, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	sort.Slice(reviews, func(i, j int) bool { return reviews[i].movieID < reviews[j].movieID })
+
+	for _, r := range reviews {
+		var movieID int
+		if err := tx.QueryRowContext(ctx, "SELECT movie_id FROM MOVIE WHERE movie_id = ?"+s.forUpdate(), r.movieID).Scan(&movieID); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM REVIEW WHERE review_id = ?", r.id); err != nil {
+			return err
+		}
+		if err := adjustRating(ctx, tx, r.movieID, -r.stars, -1); err != nil {
+			return err
+		}
+	}
+
+	// The user's helpful votes go with them, so they come out of the counts.
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE REVIEW SET HelpfulCount = HelpfulCount - 1
+		WHERE review_id IN (SELECT review_id FROM REVIEW_HELPFUL WHERE user_id = ?)`, userID); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM USER WHERE user_id = ?", userID)
+	return err
+}
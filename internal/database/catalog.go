@@ -0,0 +1,575 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"lab2324omada7/internal/slug"
+)
+
+// Catalog editing. Movies and people are created and changed through
+// MovieFields and PersonFields, whose nil fields mean "not given": the create
+// methods require every field but Slug, which then defaults to one derived
+// from the title or name, and the update methods leave nil fields as they are.
+// A slug never changes unless it is given explicitly, so that URLs handed out
+// before a title was corrected keep working.
+
+// Roles a person can be credited with on a movie.
+const (
+	CreditActor    = "actor"
+	CreditDirector = "director"
+)
+
+type MovieFields struct {
+	Title       *string
+	ReleaseDate *string
+	Genre       *string
+	Slug        *string
+}
+
+type PersonFields struct {
+	Name        *string
+	DateOfBirth *string
+	Nationality *string
+	Slug        *string
+}
+
+// fieldChecker collects every problem with a set of fields so that clients
+// can fix them all at once.
+type fieldChecker struct {
+	required bool
+	problems []string
+}
+
+// text trims *v in place and checks that it is non-empty and at most max
+// characters long.
+func (c *fieldChecker) text(name string, v *string, max int) {
+	if v == nil {
+		c.missing(name)
+		return
+	}
+	*v = strings.TrimSpace(*v)
+	switch {
+	case *v == "":
+		c.problems = append(c.problems, name+" must not be empty")
+	case utf8.RuneCountInString(*v) > max:
+		c.problems = append(c.problems, fmt.Sprintf("%s must be at most %d characters", name, max))
+	}
+}
+
+func (c *fieldChecker) date(name string, v *string) {
+	if v == nil {
+		c.missing(name)
+		return
+	}
+	*v = strings.TrimSpace(*v)
+	if _, err := time.Parse("2006-01-02", *v); err != nil {
+		c.problems = append(c.problems, fmt.Sprintf("%s must be a date such as 2006-01-02, got %q", name, *v))
+	}
+}
+
+func (c *fieldChecker) slug(v *string) {
+	if v != nil && (*v == "" || slug.Make(*v) != *v || slug.IsID(*v)) {
+		c.problems = append(c.problems, fmt.Sprintf("slug %q must be lower case letters and digits separated by single hyphens, and not only digits", *v))
+	}
+}
+
+func (c *fieldChecker) missing(name string) {
+	if c.required {
+		c.problems = append(c.problems, name+" is required")
+	}
+}
+
+func (c *fieldChecker) err() error {
+	if len(c.problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%s: %w", strings.Join(c.problems, "; "), ErrValidation)
+}
+
+// clean validates f and returns it with its text trimmed. required is set
+// when creating a movie.
+func (f MovieFields) clean(required bool) (MovieFields, error) {
+	f = MovieFields{Title: copyString(f.Title), ReleaseDate: copyString(f.ReleaseDate), Genre: copyString(f.Genre), Slug: copyString(f.Slug)}
+
+	c := fieldChecker{required: required}
+	c.text("Title", f.Title, 255)
+	c.date("ReleaseDate", f.ReleaseDate)
+	c.text("Genre", f.Genre, 100)
+	c.slug(f.Slug)
+	return f, c.err()
+}
+
+// clean validates f and returns it with its text trimmed. required is set
+// when creating a person.
+func (f PersonFields) clean(required bool) (PersonFields, error) {
+	f = PersonFields{Name: copyString(f.Name), DateOfBirth: copyString(f.DateOfBirth), Nationality: copyString(f.Nationality), Slug: copyString(f.Slug)}
+
+	c := fieldChecker{required: required}
+	c.text("Name", f.Name, 255)
+	c.date("DateOfBirth", f.DateOfBirth)
+	c.text("Nationality", f.Nationality, 100)
+	c.slug(f.Slug)
+	return f, c.err()
+}
+
+func copyString(s *string) *string {
+	if s == nil {
+		return nil
+	}
+	v := *s
+	return &v
+}
+
+// catalogTable describes MOVIE, ACTOR or DIRECTOR for the statements the
+// three have in common.
+type catalogTable struct {
+	name       string // table name
+	kind       string // used in error messages
+	idColumn   string
+	nameColumn string
+}
+
+var (
+	movieTable    = catalogTable{"MOVIE", "movie", "movie_id", "Title"}
+	actorTable    = catalogTable{"ACTOR", "actor", "actor_id", "ActorName"}
+	directorTable = catalogTable{"DIRECTOR", "director", "director_id", "DirectorName"}
+)
+
+// assignment is a column and the value to set it to.
+type assignment struct {
+	column string
+	value  interface{}
+}
+
+// assignments returns the assignments for the non-nil values, in order.
+func assignments(columns []string, values ...*string) []assignment {
+	var out []assignment
+	for i, v := range values {
+		if v != nil {
+			out = append(out, assignment{columns[i], *v})
+		}
+	}
+	return out
+}
+
+// insert adds a row to t and returns its ID. Without an explicit slug the
+// row gets slug.For(name), which may need the new ID; until it is known the
+// row carries a random placeholder.
+func (s *service) insert(ctx context.Context, tx *sql.Tx, t catalogTable, values []assignment, name string, explicitSlug *string) (int, error) {
+	rowSlug, needsID := "", false
+	if explicitSlug != nil {
+		rowSlug = *explicitSlug
+	} else {
+		rowSlug = slug.Make(name)
+		if rowSlug == "" || slug.IsID(rowSlug) {
+			needsID = true
+		} else {
+			var taken bool
+			err := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM "+t.name+" WHERE Slug = ?)", rowSlug).Scan(&taken)
+			if err != nil {
+				return 0, err
+			}
+			needsID = taken
+		}
+		if needsID {
+			token, err := randomToken(12)
+			if err != nil {
+				return 0, err
+			}
+			rowSlug = "pending-" + token
+		}
+	}
+
+	columns := []string{"Slug"}
+	args := []interface{}{rowSlug}
+	for _, a := range values {
+		columns = append(columns, a.column)
+		args = append(args, a.value)
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")
+
+	insertQuery := "INSERT INTO " + t.name + " (" + strings.Join(columns, ", ") + ") VALUES (" + placeholders + ")"
+	result, err := tx.ExecContext(ctx, insertQuery, args...)
+	if err != nil {
+		return 0, slugConflict(translateError(err), t, rowSlug)
+	}
+	id64, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	id := int(id64)
+
+	if needsID {
+		rowSlug = slug.For(name, id, func(string) bool { return true })
+		updateSlugQuery := "UPDATE " + t.name + " SET Slug = ? WHERE " + t.idColumn + " = ?"
+		if _, err := tx.ExecContext(ctx, updateSlugQuery, rowSlug, id); err != nil {
+			return 0, slugConflict(translateError(err), t, rowSlug)
+		}
+	}
+
+	return id, nil
+}
+
+// lock locks the row of t with the given ID until tx ends, reporting
+// ErrNotFound if there is none.
+func (s *service) lock(ctx context.Context, tx *sql.Tx, t catalogTable, id int) error {
+	var locked int
+	err := tx.QueryRowContext(ctx, "SELECT "+t.idColumn+" FROM "+t.name+" WHERE "+t.idColumn+" = ?"+s.forUpdate(), id).Scan(&locked)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%s %d: %w", t.kind, id, ErrNotFound)
+	}
+	return err
+}
+
+// update changes the given columns of the row of t with the given ID,
+// reporting ErrNotFound if there is none.
+func (s *service) update(ctx context.Context, tx *sql.Tx, t catalogTable, id int, values []assignment) error {
+	if err := s.lock(ctx, tx, t, id); err != nil {
+		return err
+	}
+	if len(values) == 0 {
+		return nil
+	}
+
+	var (
+		columns []string
+		args    []interface{}
+		newSlug string
+	)
+	for _, a := range values {
+		columns = append(columns, a.column+" = ?")
+		args = append(args, a.value)
+		if a.column == "Slug" {
+			newSlug = a.value.(string)
+		}
+	}
+	args = append(args, id)
+
+	updateQuery := "UPDATE " + t.name + " SET " + strings.Join(columns, ", ") + " WHERE " + t.idColumn + " = ?"
+	if _, err := tx.ExecContext(ctx, updateQuery, args...); err != nil {
+		return slugConflict(translateError(err), t, newSlug)
+	}
+	return nil
+}
+
+// delete removes the row of t with the given ID, reporting ErrNotFound if
+// there is none.
+func (s *service) delete(ctx context.Context, q execer, t catalogTable, id int) error {
+	result, err := q.ExecContext(ctx, "DELETE FROM "+t.name+" WHERE "+t.idColumn+" = ?", id)
+	if err != nil {
+		return translateError(err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("%s %d: %w", t.kind, id, ErrNotFound)
+	}
+	return nil
+}
+
+// slugConflict explains which slug a unique key violation was about; slugs
+// are the only unique columns of the catalog tables.
+func slugConflict(err error, t catalogTable, rowSlug string) error {
+	if errors.Is(err, ErrConflict) {
+		return fmt.Errorf("%s slug %q is taken: %w", t.kind, rowSlug, ErrConflict)
+	}
+	return err
+}
+
+func (s *service) CreateMovie(ctx context.Context, f MovieFields) (_ Movie, err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
+
+	if f, err = f.clean(true); err != nil {
+		return Movie{}, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Movie{}, err
+	}
+	defer tx.Rollback()
+
+	values := assignments([]string{"Title", "ReleaseDate", "Genre"}, f.Title, f.ReleaseDate, f.Genre)
+	id, err := s.insert(ctx, tx, movieTable, values, *f.Title, f.Slug)
+	if err != nil {
+		return Movie{}, err
+	}
+	movie, err := getMovieByID(ctx, tx, id)
+	if err != nil {
+		return Movie{}, err
+	}
+
+	return movie, tx.Commit()
+}
+
+// UpdateMovie changes the given fields of a movie and returns the result.
+func (s *service) UpdateMovie(ctx context.Context, movieID int, f MovieFields) (_ Movie, err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
+
+	if f, err = f.clean(false); err != nil {
+		return Movie{}, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Movie{}, err
+	}
+	defer tx.Rollback()
+
+	values := assignments([]string{"Title", "ReleaseDate", "Genre", "Slug"}, f.Title, f.ReleaseDate, f.Genre, f.Slug)
+	if err := s.update(ctx, tx, movieTable, movieID, values); err != nil {
+		return Movie{}, err
+	}
+	movie, err := getMovieByID(ctx, tx, movieID)
+	if err != nil {
+		return Movie{}, err
+	}
+
+	return movie, tx.Commit()
+}
+
+// DeleteMovie removes a movie and its credits. Unless cascade is set it
+// refuses with ErrConflict while users have reviewed, liked, listed or logged
+// the movie in their diaries; with it, their reviews, likes, watchlist, list
+// and diary entries go too.
+func (s *service) DeleteMovie(ctx context.Context, movieID int, cascade bool) (err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// AddReview locks the movie too, so no review can slip in between the
+	// check below and the delete.
+	if err := s.lock(ctx, tx, movieTable, movieID); err != nil {
+		return err
+	}
+
+	if !cascade {
+		var reviews, likes, listed, curated, watched int
+		usageQuery := `SELECT
+			(SELECT COUNT(*) FROM REVIEW WHERE movie_id = ?),
+			(SELECT COUNT(*) FROM LIKES WHERE movie_id = ?),
+			(SELECT COUNT(*) FROM ADDS_TO_WATCHLIST WHERE movie_id = ?),
+			(SELECT COUNT(*) FROM LIST_ENTRY WHERE movie_id = ?),
+			(SELECT COUNT(*) FROM DIARY_ENTRY WHERE movie_id = ?)`
+		err := tx.QueryRowContext(ctx, usageQuery, movieID, movieID, movieID, movieID, movieID).Scan(&reviews, &likes, &listed, &curated, &watched)
+		if err != nil {
+			return err
+		}
+		if reviews+likes+listed+curated+watched > 0 {
+			return fmt.Errorf("movie %d has %d reviews, %d likes, %d watchlist entries, %d list entries and %d diary entries; delete it with cascade to remove them too: %w",
+				movieID, reviews, likes, listed, curated, watched, ErrConflict)
+		}
+	}
+
+	// REVIEW, WROTE, LIKES, ADDS_TO_WATCHLIST, LIST_ENTRY, DIARY_ENTRY, ACTED
+	// and DIRECTED rows go with the movie through their ON DELETE CASCADE
+	// foreign keys.
+	if err := s.delete(ctx, tx, movieTable, movieID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *service) CreateActor(ctx context.Context, f PersonFields) (_ Actor, err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
+
+	id, err := s.createPerson(ctx, actorTable, f)
+	if err != nil {
+		return Actor{}, err
+	}
+	return getActorByID(ctx, s.db, id)
+}
+
+func (s *service) UpdateActor(ctx context.Context, actorID int, f PersonFields) (_ Actor, err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
+
+	if err := s.updatePerson(ctx, actorTable, actorID, f); err != nil {
+		return Actor{}, err
+	}
+	return getActorByID(ctx, s.db, actorID)
+}
+
+// DeleteActor removes an actor along with their credits.
+func (s *service) DeleteActor(ctx context.Context, actorID int) (err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
+
+	return s.delete(ctx, s.db, actorTable, actorID)
+}
+
+func (s *service) CreateDirector(ctx context.Context, f PersonFields) (_ Director, err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
+
+	id, err := s.createPerson(ctx, directorTable, f)
+	if err != nil {
+		return Director{}, err
+	}
+	return getDirectorByID(ctx, s.db, id)
+}
+
+func (s *service) UpdateDirector(ctx context.Context, directorID int, f PersonFields) (_ Director, err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
+
+	if err := s.updatePerson(ctx, directorTable, directorID, f); err != nil {
+		return Director{}, err
+	}
+	return getDirectorByID(ctx, s.db, directorID)
+}
+
+// DeleteDirector removes a director along with their credits.
+func (s *service) DeleteDirector(ctx context.Context, directorID int) (err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
+
+	return s.delete(ctx, s.db, directorTable, directorID)
+}
+
+func (s *service) createPerson(ctx context.Context, t catalogTable, f PersonFields) (int, error) {
+	f, err := f.clean(true)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	values := assignments([]string{t.nameColumn, "DateOfBirth", "Nationality"}, f.Name, f.DateOfBirth, f.Nationality)
+	id, err := s.insert(ctx, tx, t, values, *f.Name, f.Slug)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, tx.Commit()
+}
+
+func (s *service) updatePerson(ctx context.Context, t catalogTable, id int, f PersonFields) error {
+	f, err := f.clean(false)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	values := assignments([]string{t.nameColumn, "DateOfBirth", "Nationality", "Slug"}, f.Name, f.DateOfBirth, f.Nationality, f.Slug)
+	if err := s.update(ctx, tx, t, id, values); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// creditTable returns the link table and person column for a Credit* role.
+func creditTable(role string) (string, string, error) {
+	switch role {
+	case CreditActor:
+		return "ACTED", "actor_id", nil
+	case CreditDirector:
+		return "DIRECTED", "director_id", nil
+	}
+	return "", "", fmt.Errorf("unknown credit role %q: %w", role, ErrValidation)
+}
+
+// AddCredit credits a person with a role on a movie. Adding a credit that
+// already exists succeeds without changing anything.
+func (s *service) AddCredit(ctx context.Context, movieID int, role string, personID int) (err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
+
+	table, personColumn, err := creditTable(role)
+	if err != nil {
+		return err
+	}
+
+	insertCreditQuery := "INSERT INTO " + table + " (" + personColumn + ", movie_id) VALUES (?, ?)"
+	_, err = s.db.ExecContext(ctx, insertCreditQuery, personID, movieID)
+	if err = translateError(err); errors.Is(err, ErrConflict) {
+		return nil
+	}
+	if errors.Is(err, ErrValidation) {
+		return fmt.Errorf("movie %d or %s %d doesn't exist: %w", movieID, role, personID, err)
+	}
+	return err
+}
+
+// RemoveCredit takes a person's credit in a role off a movie, reporting
+// ErrNotFound if they didn't have it.
+func (s *service) RemoveCredit(ctx context.Context, movieID int, role string, personID int) (err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
+
+	table, personColumn, err := creditTable(role)
+	if err != nil {
+		return err
+	}
+
+	deleteCreditQuery := "DELETE FROM " + table + " WHERE " + personColumn + " = ? AND movie_id = ?"
+	result, err := s.db.ExecContext(ctx, deleteCreditQuery, personID, movieID)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("%s %d isn't credited on movie %d: %w", role, personID, movieID, ErrNotFound)
+	}
+	return nil
+}
+
+func getMovieByID(ctx context.Context, q rowQuerier, id int) (Movie, error) {
+	var movie Movie
+	err := q.QueryRowContext(ctx, "SELECT "+movieColumns+" FROM MOVIE WHERE movie_id = ?", id).
+		Scan(&movie.Id, &movie.Title, &movie.ReleaseDate, &movie.Genre, &movie.AvgRating, &movie.ReviewCount, &movie.Slug)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Movie{}, fmt.Errorf("movie %d: %w", id, ErrNotFound)
+	}
+	return movie, err
+}
+
+func getActorByID(ctx context.Context, q rowQuerier, id int) (Actor, error) {
+	var actor Actor
+	err := q.QueryRowContext(ctx, "SELECT "+actorColumns+" FROM ACTOR WHERE actor_id = ?", id).
+		Scan(&actor.ID, &actor.Name, &actor.Dob, &actor.Nationality, &actor.Slug)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Actor{}, fmt.Errorf("actor %d: %w", id, ErrNotFound)
+	}
+	return actor, err
+}
+
+func getDirectorByID(ctx context.Context, q rowQuerier, id int) (Director, error) {
+	var director Director
+	err := q.QueryRowContext(ctx, "SELECT "+directorColumns+" FROM DIRECTOR WHERE director_id = ?", id).
+		Scan(&director.ID, &director.Name, &director.Dob, &director.Nationality, &director.Slug)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Director{}, fmt.Errorf("director %d: %w", id, ErrNotFound)
+	}
+	return director, err
+}
@@ -0,0 +1,243 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"lab2324omada7/internal/config"
+)
+
+// Collections are the movies a user saves: the ones they liked and the ones
+// on their watchlist, each with the time it was added. A movie is in a
+// collection at most once, which the tables' primary keys enforce (migration
+// 0009_collection_keys), so adding and removing are idempotent.
+
+// The collections, as the methods here name them.
+const (
+	CollectionLikes     = "likes"
+	CollectionWatchlist = "watchlist"
+)
+
+// dateAddedLayout is how the DateAdded of likes and watchlist entries is
+// stored, so that it sorts as text in SQLite as well as it does as a
+// DATETIME in MySQL.
+const dateAddedLayout = "2006-01-02 15:04:05"
+
+// SavedMovie is a movie in a user's likes or watchlist.
+type SavedMovie struct {
+	Movie
+	DateAdded string `json:"DateAdded"`
+}
+
+// MovieStatus says which of the user's collections a movie is in.
+type MovieStatus struct {
+	Liked       bool `json:"liked"`
+	Watchlisted bool `json:"watchlist"`
+}
+
+// MaxStatusLookup is how many movies GetMovieStatuses takes at once, enough
+// for the largest page of movies.
+const MaxStatusLookup = MaxPageSize
+
+var savedMovieSorts = map[string]listSort[SavedMovie]{
+	"date_added":   {"S.DateAdded", func(m SavedMovie) interface{} { return m.DateAdded }},
+	"title":        {"M.Title", func(m SavedMovie) interface{} { return m.Title }},
+	"release_date": {"M.ReleaseDate", func(m SavedMovie) interface{} { return m.ReleaseDate }},
+	"avg_rating":   {"M.AvgRating", func(m SavedMovie) interface{} { return m.AvgRating }},
+}
+
+// collectionTable returns the table holding the collection.
+func collectionTable(collection string) (string, error) {
+	switch collection {
+	case CollectionLikes:
+		return "LIKES", nil
+	case CollectionWatchlist:
+		return "ADDS_TO_WATCHLIST", nil
+	}
+	return "", fmt.Errorf("unknown collection %q: %w", collection, ErrValidation)
+}
+
+// GetSavedMovies lists the movies in one of the user's collections, sorted
+// by "date_added", "title", "release_date" or "avg_rating".
+func (s *service) GetSavedMovies(ctx context.Context, userID int, collection string, req PageRequest) (_ Page[SavedMovie], err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
+
+	table, err := collectionTable(collection)
+	if err != nil {
+		return Page[SavedMovie]{}, err
+	}
+	p, err := planPage(req, savedMovieSorts)
+	if err != nil {
+		return Page[SavedMovie]{}, err
+	}
+
+	return fetchPage(ctx, s.db, listQuery[SavedMovie]{
+		columns:  "M.movie_id, M.Title, M.ReleaseDate, M.Genre, M.AvgRating, M.RatingCount, M.Slug, S.DateAdded",
+		from:     table + " S JOIN MOVIE M ON M.movie_id = S.movie_id",
+		idColumn: "M.movie_id",
+		where:    []string{"S.user_id = ?"},
+		args:     []interface{}{userID},
+		scan: func(rows *sql.Rows) (m SavedMovie, err error) {
+			err = rows.Scan(&m.Id, &m.Title, &m.ReleaseDate, &m.Genre, &m.AvgRating, &m.ReviewCount, &m.Slug, &m.DateAdded)
+			return m, err
+		},
+		id: func(m SavedMovie) int { return m.Id },
+	}, p)
+}
+
+// AddToCollection puts the movie in the user's collection, keeping the date
+// it was first added if it was there already, and returns its status.
+func (s *service) AddToCollection(ctx context.Context, userID int, collection string, movieID int) (_ MovieStatus, err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
+
+	table, err := collectionTable(collection)
+	if err != nil {
+		return MovieStatus{}, err
+	}
+	if err := s.insertSaved(ctx, table, movieID, userID); err != nil {
+		return MovieStatus{}, err
+	}
+	return s.movieStatus(ctx, userID, movieID)
+}
+
+// RemoveFromCollection takes the movie out of the user's collection, if it's
+// there, and returns its status.
+func (s *service) RemoveFromCollection(ctx context.Context, userID int, collection string, movieID int) (_ MovieStatus, err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
+
+	table, err := collectionTable(collection)
+	if err != nil {
+		return MovieStatus{}, err
+	}
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM "+table+" WHERE movie_id = ? AND user_id = ?", movieID, userID); err != nil {
+		return MovieStatus{}, err
+	}
+	return s.movieStatus(ctx, userID, movieID)
+}
+
+// ToggleWatchlist and ToggleLiked add the movie to the collection, or remove
+// it if it was there. They remain for the deprecated POST /api/watchlist and
+// /api/liked; a retried toggle undoes itself, which AddToCollection and
+// RemoveFromCollection don't.
+func (s *service) ToggleWatchlist(ctx context.Context, movieID, userID int) (err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
+
+	return s.toggle(ctx, "ADDS_TO_WATCHLIST", movieID, userID)
+}
+
+func (s *service) ToggleLiked(ctx context.Context, movieID, userID int) (err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
+
+	return s.toggle(ctx, "LIKES", movieID, userID)
+}
+
+// toggle deletes first and inserts only if nothing was deleted, so that
+// unlike checking first it never stores an entry twice.
+func (s *service) toggle(ctx context.Context, table string, movieID, userID int) error {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM "+table+" WHERE movie_id = ? AND user_id = ?", movieID, userID)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+	return s.insertSaved(ctx, table, movieID, userID)
+}
+
+// insertSaved adds a row to LIKES or ADDS_TO_WATCHLIST unless there is one.
+func (s *service) insertSaved(ctx context.Context, table string, movieID, userID int) error {
+	onConflict := " ON DUPLICATE KEY UPDATE user_id = user_id"
+	if s.driver == config.DriverSQLite {
+		onConflict = " ON CONFLICT DO NOTHING"
+	}
+	_, err := s.db.ExecContext(ctx, "INSERT INTO "+table+" (movie_id, user_id, DateAdded) VALUES (?, ?, ?)"+onConflict,
+		movieID, userID, time.Now().UTC().Format(dateAddedLayout))
+	return translateError(err)
+}
+
+func (s *service) movieStatus(ctx context.Context, userID, movieID int) (MovieStatus, error) {
+	statuses, err := movieStatuses(ctx, s.db, userID, []int{movieID})
+	if err != nil {
+		return MovieStatus{}, err
+	}
+	return statuses[movieID], nil
+}
+
+// GetMovieStatuses looks up at once which of the user's collections each of
+// the movies is in, so that a client showing a page of movies needn't ask
+// about every one. Every ID given gets a status; IDs of movies that don't
+// exist simply aren't in any collection.
+func (s *service) GetMovieStatuses(ctx context.Context, userID int, movieIDs []int) (_ map[int]MovieStatus, err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
+
+	if err := checkStatusLookup(movieIDs); err != nil {
+		return nil, err
+	}
+	return movieStatuses(ctx, s.db, userID, movieIDs)
+}
+
+func movieStatuses(ctx context.Context, db querier, userID int, movieIDs []int) (map[int]MovieStatus, error) {
+	statuses := make(map[int]MovieStatus, len(movieIDs))
+	if len(movieIDs) == 0 {
+		return statuses, nil
+	}
+
+	in := strings.TrimSuffix(strings.Repeat("?, ", len(movieIDs)), ", ")
+	args := []interface{}{userID}
+	for _, id := range movieIDs {
+		args = append(args, id)
+		statuses[id] = MovieStatus{}
+	}
+	args = append(args, args...)
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT movie_id, 'likes' FROM LIKES WHERE user_id = ? AND movie_id IN (`+in+`)
+		UNION ALL
+		SELECT movie_id, 'watchlist' FROM ADDS_TO_WATCHLIST WHERE user_id = ? AND movie_id IN (`+in+`)`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			movieID    int
+			collection string
+		)
+		if err := rows.Scan(&movieID, &collection); err != nil {
+			return nil, err
+		}
+		status := statuses[movieID]
+		if collection == CollectionLikes {
+			status.Liked = true
+		} else {
+			status.Watchlisted = true
+		}
+		statuses[movieID] = status
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return statuses, nil
+}
+
+func checkStatusLookup(movieIDs []int) error {
+	if len(movieIDs) > MaxStatusLookup {
+		return fmt.Errorf("can look up at most %d movies at once, got %d: %w", MaxStatusLookup, len(movieIDs), ErrValidation)
+	}
+	return nil
+}
@@ -5,47 +5,98 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
-	"log"
-	"os"
 	"strconv"
 	"strings"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
-	"github.com/golang-jwt/jwt/v5"
-	_ "github.com/joho/godotenv/autoload"
 	"golang.org/x/crypto/bcrypt"
+	"lab2324omada7/internal/config"
+	"lab2324omada7/internal/slug"
+	_ "modernc.org/sqlite"
 )
 
-var secretKey = []byte(fmt.Sprint(os.Getenv("KEY")))
-
 type Service interface {
-	Health() map[string]string
-	GetMovies() []Movie
-	GetMovie(url string) (Movie, error)
-	GetDirectors() []Director
-	GetDirector(id string) (Director, error)
-	GetActors() []Actor
-	GetActor(id string) (Actor, error)
-	ShowReview(url string) ([]Review, error)
-	AddReview(url string, stars int, reviewText string, userName string)
-	AuthenticateUser(username string, password string) (User, string, string)
-	RegisterUser(username string, password string, email string) (string, error)
-	//GetUserData(id int) (User, error)
-	ToggleWatchlist(movieID, userID int) error
-	ToggleLiked(movieID, userID int) error
-	GetMoviesByDirectorID(directorID int) ([]DirectedMovie, error)
-	GetMoviesByActorID(actorID int) ([]ActedMovie, error)
-	GetStaffByMovieID(movieID int) ([]StaffMember, error)
-	GetUserID(username string) (int, error)
-	GetWatchlistStatus(movieID int, username string) string
-	GetLikedStatus(movieID int, username string) string
+	Health(ctx context.Context) (map[string]string, error)
+	GetMovies(ctx context.Context, f MovieFilter, req PageRequest) (Page[Movie], error)
+	GetMovie(ctx context.Context, ref string) (Movie, error)
+	GetDirectors(ctx context.Context, f PersonFilter, req PageRequest) (Page[Director], error)
+	GetDirector(ctx context.Context, ref string) (Director, error)
+	GetActors(ctx context.Context, f PersonFilter, req PageRequest) (Page[Actor], error)
+	GetActor(ctx context.Context, ref string) (Actor, error)
+	ShowReview(ctx context.Context, ref string, f ReviewFilter, req PageRequest) (Page[MovieReview], error)
+	GetMovieReview(ctx context.Context, userID, movieID int) (MovieReview, error)
+	SetHelpful(ctx context.Context, userID, reviewID int, helpful bool) (ReviewVote, error)
+	Search(ctx context.Context, query string, limit int) ([]SearchResult, error)
+	AddReview(ctx context.Context, ref string, stars int, reviewText string, userID int) error
+	DeleteReview(ctx context.Context, reviewID int) error
+	AuthenticateUser(ctx context.Context, username string, password string) (User, TokenPair, error)
+	RegisterUser(ctx context.Context, username string, password string, email string) (TokenPair, error)
+	IssueTokens(ctx context.Context, userID int) (TokenPair, error)
+	RefreshTokens(ctx context.Context, refreshToken string) (TokenPair, error)
+	RevokeRefreshToken(ctx context.Context, refreshToken string) error
+	SessionActive(ctx context.Context, familyID string) (bool, error)
+	GetUserRoles(ctx context.Context, userID int) ([]string, error)
+	GrantRole(ctx context.Context, actorID, userID int, role string) error
+	RevokeRole(ctx context.Context, actorID, userID int, role string) error
+	GetRoleChanges(ctx context.Context, userID int) ([]RoleChange, error)
+	GetProfile(ctx context.Context, userID int) (Profile, error)
+	UpdateProfile(ctx context.Context, userID int, f ProfileFields) (Profile, error)
+	ChangePassword(ctx context.Context, userID int, current, password string) (TokenPair, error)
+	GetUserReviews(ctx context.Context, userID int, req PageRequest) (Page[UserReview], error)
+	GetSavedMovies(ctx context.Context, userID int, collection string, req PageRequest) (Page[SavedMovie], error)
+	GetMovieStatuses(ctx context.Context, userID int, movieIDs []int) (map[int]MovieStatus, error)
+	AddToCollection(ctx context.Context, userID int, collection string, movieID int) (MovieStatus, error)
+	RemoveFromCollection(ctx context.Context, userID int, collection string, movieID int) (MovieStatus, error)
+	ToggleWatchlist(ctx context.Context, movieID, userID int) error
+	ToggleLiked(ctx context.Context, movieID, userID int) error
+	GetLists(ctx context.Context, f ListFilter, req PageRequest) (Page[ListSummary], error)
+	GetList(ctx context.Context, userID int, key string) (MovieList, error)
+	CreateList(ctx context.Context, ownerID int, f ListFields) (MovieList, error)
+	UpdateList(ctx context.Context, userID int, key string, f ListFields) (MovieList, error)
+	DeleteList(ctx context.Context, userID int, key string) error
+	PutListEntry(ctx context.Context, userID int, key string, movieID int, f EntryFields) (MovieList, error)
+	RemoveListEntry(ctx context.Context, userID int, key string, movieID int) (MovieList, error)
+	AddCollaborator(ctx context.Context, userID int, key string, collaboratorID int) error
+	RemoveCollaborator(ctx context.Context, userID int, key string, collaboratorID int) error
+	LogWatch(ctx context.Context, userID, movieID int, f DiaryFields) (DiaryEntry, error)
+	GetDiaryEntry(ctx context.Context, userID, entryID int) (DiaryEntry, error)
+	UpdateDiaryEntry(ctx context.Context, userID, entryID int, f DiaryFields) (DiaryEntry, error)
+	DeleteDiaryEntry(ctx context.Context, userID, entryID int) error
+	GetDiary(ctx context.Context, userID int, f DiaryFilter, req PageRequest) (Page[DiaryEntry], error)
+	GetDiaryMonths(ctx context.Context, userID int) ([]DiaryMonth, error)
+	FindMovie(ctx context.Context, title string, year int) (Movie, error)
+	CreateImport(ctx context.Context, userID int, source string) (ImportJob, error)
+	FinishImport(ctx context.Context, jobID int, r ImportResult) error
+	GetImport(ctx context.Context, userID, jobID int) (ImportJob, error)
+	ResolveImportRow(ctx context.Context, userID, jobID, rowID, movieID int) (ImportRow, error)
+	ReopenImportRow(ctx context.Context, userID, jobID, rowID int) error
+	GetMoviesByDirectorID(ctx context.Context, directorID int) ([]DirectedMovie, error)
+	GetMoviesByActorID(ctx context.Context, actorID int) ([]ActedMovie, error)
+	GetStaffByMovieID(ctx context.Context, movieID int) ([]StaffMember, error)
+	GetUserID(ctx context.Context, username string) (int, error)
+	GetWatchlistStatus(ctx context.Context, movieID int, username string) (bool, error)
+	GetLikedStatus(ctx context.Context, movieID int, username string) (bool, error)
+	CreateMovie(ctx context.Context, f MovieFields) (Movie, error)
+	UpdateMovie(ctx context.Context, movieID int, f MovieFields) (Movie, error)
+	DeleteMovie(ctx context.Context, movieID int, cascade bool) error
+	CreateActor(ctx context.Context, f PersonFields) (Actor, error)
+	UpdateActor(ctx context.Context, actorID int, f PersonFields) (Actor, error)
+	DeleteActor(ctx context.Context, actorID int) error
+	CreateDirector(ctx context.Context, f PersonFields) (Director, error)
+	UpdateDirector(ctx context.Context, directorID int, f PersonFields) (Director, error)
+	DeleteDirector(ctx context.Context, directorID int) error
+	AddCredit(ctx context.Context, movieID int, role string, personID int) error
+	RemoveCredit(ctx context.Context, movieID int, role string, personID int) error
+	DeleteAccount(ctx context.Context, userID int, password string, at time.Time) error
+	PurgeAccounts(ctx context.Context, now time.Time) (int, error)
 }
 
 type StaffMember struct {
 	ID     int    `db:"staff_id" json:"staff_id"`
 	MTitle string `json:"movie_title"`
 	Name   string `json:"name"`
+	Slug   string `json:"slug"`
 	Role   string `json:"role"`
 	TypeID int    `json:"type_id"`
 }
@@ -71,6 +122,7 @@ type Actor struct {
 	Name        string `json:"ActorName"`
 	Dob         string `json:"DateOfBirth"`
 	Nationality string `json:"Nationality"`
+	Slug        string `json:"slug"`
 }
 
 type Director struct {
@@ -78,12 +130,13 @@ type Director struct {
 	Name        string `json:"DirectorName"`
 	Dob         string `json:"DateOfBirth"`
 	Nationality string `json:"Nationality"`
+	Slug        string `json:"slug"`
 }
 
 type User struct {
 	ID       int    `json:"user_id"`
 	Username string `json:"Username"`
-	Password string `json:"Password"`
+	Password string `json:"-"` // the bcrypt hash, never sent to clients
 	Email    string `json:"Email"`
 }
 
@@ -93,6 +146,8 @@ type Movie struct {
 	ReleaseDate string  `json:"ReleaseDate"`
 	Genre       string  `json:"Genre"`
 	AvgRating   float64 `json:"AvgRating"`
+	ReviewCount int     `json:"ReviewCount"`
+	Slug        string  `json:"slug"`
 }
 
 type Review struct {
@@ -103,278 +158,362 @@ type Review struct {
 	MovieId    string `json:"movie_id"`
 }
 
+// The columns scanned into a Movie, Actor and Director, in order.
+const (
+	movieColumns    = "movie_id, Title, ReleaseDate, Genre, AvgRating, RatingCount, Slug"
+	actorColumns    = "actor_id, ActorName, DateOfBirth, Nationality, Slug"
+	directorColumns = "director_id, DirectorName, DateOfBirth, Nationality, Slug"
+)
+
+// avgRatingExpr derives AvgRating from the running totals kept on MOVIE. The
+// multiplication avoids SQLite's integer division.
+const avgRatingExpr = "CASE WHEN RatingCount > 0 THEN RatingSum * 1.0 / RatingCount ELSE 0 END"
+
 type service struct {
-	db *sql.DB
+	db           *sql.DB
+	driver       string
+	jwtKey       []byte
+	queryTimeout time.Duration
 }
 
-// var (
-// 	dbname   = os.Getenv("DB_DATABASE")
-// 	password = os.Getenv("DB_PASSWORD")
-// 	username = os.Getenv("DB_USERNAME")
-// 	port     = os.Getenv("DB_PORT")
-// 	host     = os.Getenv("DB_HOST")
-// )
+// Open returns a connection pool for the database described by cfg, using
+// MySQL or the pure Go SQLite driver depending on cfg.Driver. Opening a
+// driver typically will not attempt to connect to the database, so errors
+// here are DSN parse or other initialization errors.
+func Open(cfg config.Database) (*sql.DB, error) {
+	switch cfg.Driver {
+	case config.DriverMySQL, config.DriverSQLite:
+	default:
+		return nil, fmt.Errorf("unsupported database driver %q", cfg.Driver)
+	}
 
-func New() Service {
-	// Opening a driver typically will not attempt to connect to the database.
-	// db, err := sql.Open("mysql", fmt.Sprintf("%s:%s@tcp(%s:%s)/%s", username, password, host, port, dbname))
-    db, err := sql.Open("mysql", "lab2324omada7:lab2324omada7@tcp(godockerDB)/lab2324omada7_tainia")
+	db, err := sql.Open(cfg.Driver, cfg.DSN())
 	if err != nil {
-		// This will not be a connection error, but a DSN parse error or
-		// another initialization error.
-		log.Fatal(err)
+		return nil, err
 	}
-	db.SetConnMaxLifetime(0)
-	db.SetMaxIdleConns(50)
-	db.SetMaxOpenConns(50)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
 
-	s := &service{db: db}
-	return s
+	return db, nil
 }
 
-func (s *service) Health() map[string]string {
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
-	defer cancel()
-
-	err := s.db.PingContext(ctx)
+func New(cfg *config.Config) (Service, error) {
+	db, err := Open(cfg.Database)
 	if err != nil {
-		log.Fatalf(fmt.Sprintf("db down: %v", err))
+		return nil, err
 	}
 
-	return map[string]string{
-		"message": "It's healthy",
+	s := &service{
+		db:           db,
+		driver:       cfg.Database.Driver,
+		jwtKey:       cfg.JWTKey,
+		queryTimeout: cfg.Database.QueryTimeout,
 	}
+	return s, nil
 }
 
-func (s *service) GetUserID(username string) (int, error) {
-	selectDataQuery := fmt.Sprintf("SELECT user_id FROM USER where Username=%q", username)
-
-	var userID int
-	err := s.db.QueryRow(selectDataQuery).Scan(&userID)
-	if err != nil {
-		return -1, errors.New("User id doesn't exist") 
+// forUpdate returns the row locking clause for SELECTs inside a transaction.
+// SQLite doesn't support it; there, transactions are opened with
+// _txlock=immediate and so already hold the database write lock.
+func (s *service) forUpdate() string {
+	if s.driver == config.DriverSQLite {
+		return ""
 	}
-
-	return userID, nil
+	return " FOR UPDATE"
 }
 
-func (s *service) GetMovies() []Movie {
-	selectDataQuery := "SELECT * FROM MOVIE"
+// rowQuerier is satisfied by both *sql.DB and *sql.Tx.
+type rowQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
 
-	rows, err := s.db.Query(selectDataQuery)
-	if err != nil {
-		panic(err.Error())
+// findByRef scans into dest the first row that ref identifies. query ends in
+// WHERE; findByRef adds the condition, then suffix. A ref made of digits is
+// the row's ID. Anything else is its slug or, failing that, its title or name
+// with spaces written as hyphens, which is how URLs were built before slugs
+// existed. It returns sql.ErrNoRows if no row matches.
+func findByRef(ctx context.Context, q rowQuerier, query, idColumn, nameColumn, ref, suffix string, dest ...interface{}) error {
+	type lookup struct {
+		column string
+		value  interface{}
 	}
-	defer rows.Close()
-
-	var movies []Movie
 
-	for rows.Next() {
-		var movie Movie
-		err := rows.Scan(&movie.Id, &movie.Title, &movie.ReleaseDate, &movie.Genre, &movie.AvgRating)
+	var lookups []lookup
+	if slug.IsID(ref) {
+		id, err := strconv.Atoi(ref)
 		if err != nil {
-			panic(err.Error())
+			return sql.ErrNoRows
 		}
-		movies = append(movies, movie)
+		lookups = []lookup{{idColumn, id}}
+	} else {
+		lookups = []lookup{{"Slug", ref}, {nameColumn, strings.ReplaceAll(ref, "-", " ")}}
 	}
 
-	fmt.Println(movies)
-
-	return movies
+	for _, l := range lookups {
+		err := q.QueryRowContext(ctx, query+" "+l.column+" = ? ORDER BY "+idColumn+" LIMIT 1"+suffix, l.value).Scan(dest...)
+		if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+	}
+	return sql.ErrNoRows
 }
 
-func (s *service) GetLikedStatus(movieID int, username string) string {
-	selectDataQuery := "SELECT EXISTS (SELECT 1 FROM LIKES WHERE movie_id = ? AND user_id = ?) AS likes_status"
+// withTimeout bounds ctx by the configured query timeout. Callers defer the
+// returned func, which releases the timer and, if the method failed because
+// the deadline passed or the caller went away, rewrites *errp to say so.
+func (s *service) withTimeout(ctx context.Context, errp *error) (context.Context, func()) {
+	cancel := context.CancelFunc(func() {})
+	if s.queryTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, s.queryTimeout)
+	}
 
-	var likesStatus string
-    foo, err := s.GetUserID(username)
-    if err != nil {
-        log.Print(err)
-    }
+	return ctx, func() {
+		if *errp != nil {
+			*errp = contextError(ctx, *errp)
+		}
+		cancel()
+	}
+}
 
-	err = s.db.QueryRow(selectDataQuery, movieID, foo).Scan(&likesStatus)
+func (s *service) Health(ctx context.Context) (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 1*time.Second)
+	defer cancel()
+
+	err := s.db.PingContext(ctx)
 	if err != nil {
-		log.Printf("Error checking likes status: %v", err)
-		return "error"
+		return nil, fmt.Errorf("db down: %w: %w", ErrUnavailable, err)
 	}
-	fmt.Println(likesStatus)
 
-	return likesStatus
+	return map[string]string{
+		"message": "It's healthy",
+	}, nil
 }
 
-func (s *service) GetWatchlistStatus(movieID int, username string) string {
-	selectDataQuery := "SELECT EXISTS (SELECT 1 FROM ADDS_TO_WATCHLIST WHERE movie_id = ? AND user_id = ?) AS watchlist_status"
+func (s *service) GetUserID(ctx context.Context, username string) (_ int, err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
 
-	var watchlistStatus string
-    foo, err := s.GetUserID(username)
-    if err != nil {
-        log.Print(err)
-    }
+	selectDataQuery := "SELECT user_id FROM USER WHERE Username = ? AND DeleteAfter IS NULL"
 
-	err = s.db.QueryRow(selectDataQuery, movieID, foo).Scan(&watchlistStatus)
+	var userID int
+	err = s.db.QueryRowContext(ctx, selectDataQuery, username).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return -1, fmt.Errorf("user %q: %w", username, ErrNotFound)
+	}
 	if err != nil {
-		log.Printf("Error checking watchlist status: %v", err)
-		return "error"
+		return -1, err
 	}
-	return watchlistStatus
+
+	return userID, nil
 }
 
-func (s *service) GetMovie(url string) (Movie, error) {
-	modifiedTitle := strings.ReplaceAll(url, "-", " ")
-	selectDataQuery := fmt.Sprintf("SELECT * FROM MOVIE WHERE Title=%q", modifiedTitle)
+func (s *service) GetMovies(ctx context.Context, f MovieFilter, req PageRequest) (_ Page[Movie], err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
 
-	movieRow, err := s.db.Query(selectDataQuery)
+	p, err := planPage(req, movieSorts)
 	if err != nil {
-		panic(err.Error())
+		return Page[Movie]{}, err
 	}
-	defer movieRow.Close()
 
-	if movieRow.Next() {
-		var movie Movie
-		err := movieRow.Scan(&movie.Id, &movie.Title, &movie.ReleaseDate, &movie.Genre, &movie.AvgRating)
-		if err != nil {
-			return Movie{}, err
-		}
-		return movie, nil
-	}
+	where, args := f.where()
+	return fetchPage(ctx, s.db, listQuery[Movie]{
+		columns:  movieColumns,
+		from:     "MOVIE",
+		idColumn: "movie_id",
+		where:    where,
+		args:     args,
+		scan: func(rows *sql.Rows) (movie Movie, err error) {
+			err = rows.Scan(&movie.Id, &movie.Title, &movie.ReleaseDate, &movie.Genre, &movie.AvgRating, &movie.ReviewCount, &movie.Slug)
+			return movie, err
+		},
+		id: func(m Movie) int { return m.Id },
+	}, p)
+}
 
-	fmt.Println(Movie{})
+func (s *service) GetLikedStatus(ctx context.Context, movieID int, username string) (_ bool, err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
 
-	return Movie{}, errors.New("movie not found")
-}
+	selectDataQuery := "SELECT EXISTS (SELECT 1 FROM LIKES WHERE movie_id = ? AND user_id = ?) AS likes_status"
 
-func (s *service) GetActors() []Actor {
-	selectDataQuery := "SELECT * FROM ACTOR"
+	userID, err := s.GetUserID(ctx, username)
+	if err != nil {
+		return false, err
+	}
 
-	rows, err := s.db.Query(selectDataQuery)
+	var liked bool
+	err = s.db.QueryRowContext(ctx, selectDataQuery, movieID, userID).Scan(&liked)
 	if err != nil {
-		panic(err.Error())
+		return false, err
 	}
-	defer rows.Close()
 
-	var actors []Actor
+	return liked, nil
+}
+
+func (s *service) GetWatchlistStatus(ctx context.Context, movieID int, username string) (_ bool, err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
 
-	for rows.Next() {
-		var actor Actor
-		err := rows.Scan(&actor.ID, &actor.Name, &actor.Dob, &actor.Nationality)
-		if err != nil {
-			panic(err.Error())
-		}
-		actors = append(actors, actor)
+	selectDataQuery := "SELECT EXISTS (SELECT 1 FROM ADDS_TO_WATCHLIST WHERE movie_id = ? AND user_id = ?) AS watchlist_status"
+
+	userID, err := s.GetUserID(ctx, username)
+	if err != nil {
+		return false, err
 	}
 
-	fmt.Println(actors)
+	var added bool
+	err = s.db.QueryRowContext(ctx, selectDataQuery, movieID, userID).Scan(&added)
+	if err != nil {
+		return false, err
+	}
 
-	return actors
+	return added, nil
 }
 
-func (s *service) GetDirectors() []Director {
-	selectDataQuery := "SELECT * FROM DIRECTOR"
+// GetMovie looks a movie up by a reference taken from its URL; see findByRef.
+func (s *service) GetMovie(ctx context.Context, ref string) (_ Movie, err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
 
-	rows, err := s.db.Query(selectDataQuery)
+	selectDataQuery := "SELECT " + movieColumns + " FROM MOVIE WHERE"
+
+	var movie Movie
+	err = findByRef(ctx, s.db, selectDataQuery, "movie_id", "Title", ref, "",
+		&movie.Id, &movie.Title, &movie.ReleaseDate, &movie.Genre, &movie.AvgRating, &movie.ReviewCount, &movie.Slug)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Movie{}, fmt.Errorf("movie %q: %w", ref, ErrNotFound)
+	}
 	if err != nil {
-		panic(err.Error())
+		return Movie{}, err
 	}
-	defer rows.Close()
 
-	var directors []Director
+	return movie, nil
+}
 
-	for rows.Next() {
-		var director Director
-		err := rows.Scan(&director.ID, &director.Name, &director.Dob, &director.Nationality)
-		if err != nil {
-			panic(err.Error())
-		}
-		directors = append(directors, director)
-	}
+func (s *service) GetActors(ctx context.Context, f PersonFilter, req PageRequest) (_ Page[Actor], err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
 
-	fmt.Println(directors)
+	p, err := planPage(req, actorSorts)
+	if err != nil {
+		return Page[Actor]{}, err
+	}
 
-	return directors
+	where, args := f.where()
+	return fetchPage(ctx, s.db, listQuery[Actor]{
+		columns:  actorColumns,
+		from:     "ACTOR",
+		idColumn: "actor_id",
+		where:    where,
+		args:     args,
+		scan: func(rows *sql.Rows) (actor Actor, err error) {
+			err = rows.Scan(&actor.ID, &actor.Name, &actor.Dob, &actor.Nationality, &actor.Slug)
+			return actor, err
+		},
+		id: func(a Actor) int { return a.ID },
+	}, p)
 }
 
-func (s *service) GetDirector(id string) (Director, error) {
-	idNum, _ := strconv.Atoi(id)
-	selectDataQuery := fmt.Sprintf("SELECT * FROM DIRECTOR WHERE director_id=%d", idNum)
+func (s *service) GetDirectors(ctx context.Context, f PersonFilter, req PageRequest) (_ Page[Director], err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
 
-	directorRow, err := s.db.Query(selectDataQuery)
+	p, err := planPage(req, directorSorts)
 	if err != nil {
-		panic(err.Error())
+		return Page[Director]{}, err
 	}
-	defer directorRow.Close()
 
-	if directorRow.Next() {
-		var director Director
-		err := directorRow.Scan(&director.ID, &director.Name, &director.Dob, &director.Nationality)
-		if err != nil {
-			return Director{}, err
-		}
-		return director, nil
-	}
-
-	fmt.Println(Director{})
-
-	return Director{}, errors.New("director not found")
+	where, args := f.where()
+	return fetchPage(ctx, s.db, listQuery[Director]{
+		columns:  directorColumns,
+		from:     "DIRECTOR",
+		idColumn: "director_id",
+		where:    where,
+		args:     args,
+		scan: func(rows *sql.Rows) (director Director, err error) {
+			err = rows.Scan(&director.ID, &director.Name, &director.Dob, &director.Nationality, &director.Slug)
+			return director, err
+		},
+		id: func(d Director) int { return d.ID },
+	}, p)
 }
 
-func (s *service) GetActor(id string) (Actor, error) {
-	idNum, _ := strconv.Atoi(id)
-	selectDataQuery := fmt.Sprintf("SELECT * FROM ACTOR WHERE actor_id=%d", idNum)
+func (s *service) GetDirector(ctx context.Context, ref string) (_ Director, err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
+
+	selectDataQuery := "SELECT " + directorColumns + " FROM DIRECTOR WHERE"
 
-	actorRow, err := s.db.Query(selectDataQuery)
+	var director Director
+	err = findByRef(ctx, s.db, selectDataQuery, "director_id", "DirectorName", ref, "",
+		&director.ID, &director.Name, &director.Dob, &director.Nationality, &director.Slug)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Director{}, fmt.Errorf("director %q: %w", ref, ErrNotFound)
+	}
 	if err != nil {
-		panic(err.Error())
+		return Director{}, err
 	}
-	defer actorRow.Close()
 
-	if actorRow.Next() {
-		var actor Actor
-		err := actorRow.Scan(&actor.ID, &actor.Name, &actor.Dob, &actor.Nationality)
-		if err != nil {
-			return Actor{}, err
-		}
-		return actor, nil
-	}
+	return director, nil
+}
 
-	fmt.Println(Actor{})
+func (s *service) GetActor(ctx context.Context, ref string) (_ Actor, err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
 
-	return Actor{}, errors.New("actor not found")
+	selectDataQuery := "SELECT " + actorColumns + " FROM ACTOR WHERE"
+
+	var actor Actor
+	err = findByRef(ctx, s.db, selectDataQuery, "actor_id", "ActorName", ref, "",
+		&actor.ID, &actor.Name, &actor.Dob, &actor.Nationality, &actor.Slug)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Actor{}, fmt.Errorf("actor %q: %w", ref, ErrNotFound)
+	}
+	if err != nil {
+		return Actor{}, err
+	}
+
+	return actor, nil
 }
 
-func (s *service) GetStaffByMovieID(movieID int) ([]StaffMember, error) {
+func (s *service) GetStaffByMovieID(ctx context.Context, movieID int) (_ []StaffMember, err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
+
 	query := `
      SELECT
          M.movie_id,
          M.Title AS movie_title,
          A.actor_id,
          A.ActorName AS actor_name,
+         A.Slug,
          'Actor' AS role
      FROM
          MOVIE M
          JOIN ACTED ACT ON M.movie_id = ACT.movie_id
          JOIN ACTOR A ON ACT.actor_id = A.actor_id
      WHERE
-         M.movie_id = %d
+         M.movie_id = ?
      UNION
      SELECT
          M.movie_id,
          M.Title AS movie_title,
          D.director_id,
          D.DirectorName AS director_name,
+         D.Slug,
          'Director' AS role
      FROM
          MOVIE M
          JOIN DIRECTED DIR ON M.movie_id = DIR.movie_id
          JOIN DIRECTOR D ON DIR.director_id = D.director_id
      WHERE
-         M.movie_id = %d;
+         M.movie_id = ?;
      `
 
-	foo := fmt.Sprintf(query, movieID, movieID)
-
-	rows, err := s.db.Query(foo)
+	rows, err := s.db.QueryContext(ctx, query, movieID, movieID)
 	if err != nil {
-		fmt.Println(err)
 		return nil, err
 	}
 	defer rows.Close()
@@ -387,29 +526,31 @@ func (s *service) GetStaffByMovieID(movieID int) ([]StaffMember, error) {
 			&member.MTitle,
 			&member.TypeID,
 			&member.Name,
+			&member.Slug,
 			&member.Role,
 		)
 		if err != nil {
-			fmt.Println(err)
 			return nil, err
 		}
 		staff = append(staff, member)
 	}
 
-	return staff, nil
+	return staff, rows.Err()
 }
 
-func (s *service) GetMoviesByDirectorID(directorID int) ([]DirectedMovie, error) {
+func (s *service) GetMoviesByDirectorID(ctx context.Context, directorID int) (_ []DirectedMovie, err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
+
 	query := `
-		SELECT M.movie_id, M.Title, M.ReleaseDate, M.Genre, M.AvgRating, D.director_id, DIR.DateOfBirth, DIR.DirectorName, DIR.Nationality
+		SELECT M.movie_id, M.Title, M.ReleaseDate, M.Genre, M.AvgRating, M.RatingCount, M.Slug, D.director_id, DIR.DateOfBirth, DIR.DirectorName, DIR.Nationality
 		FROM MOVIE M
 		JOIN DIRECTED D ON M.movie_id = D.movie_id
 		JOIN DIRECTOR DIR ON D.director_id = DIR.director_id
 		WHERE D.director_id = ?`
 
-	rows, err := s.db.Query(query, directorID)
+	rows, err := s.db.QueryContext(ctx, query, directorID)
 	if err != nil {
-		fmt.Println(err)
 		return nil, err
 	}
 	defer rows.Close()
@@ -423,32 +564,35 @@ func (s *service) GetMoviesByDirectorID(directorID int) ([]DirectedMovie, error)
 			&movie.ReleaseDate,
 			&movie.Genre,
 			&movie.AvgRating,
+			&movie.ReviewCount,
+			&movie.Slug,
 			&movie.DirectorID,
 			&movie.DirectorDob,
 			&movie.DirectorName,
 			&movie.Nationality,
 		)
 		if err != nil {
-			fmt.Println(err)
 			return nil, err
 		}
 		movies = append(movies, movie)
 	}
 
-	return movies, nil
+	return movies, rows.Err()
 }
 
-func (s *service) GetMoviesByActorID(actorID int) ([]ActedMovie, error) {
+func (s *service) GetMoviesByActorID(ctx context.Context, actorID int) (_ []ActedMovie, err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
+
 	query := `
-		SELECT M.movie_id, M.Title, M.ReleaseDate, M.Genre, M.AvgRating, ACT.actor_id, ACT.DateOfBirth, ACT.ActorName, ACT.Nationality
+		SELECT M.movie_id, M.Title, M.ReleaseDate, M.Genre, M.AvgRating, M.RatingCount, M.Slug, ACT.actor_id, ACT.DateOfBirth, ACT.ActorName, ACT.Nationality
 		FROM MOVIE M
 		JOIN ACTED A ON M.movie_id = A.movie_id
 		JOIN ACTOR ACT ON A.actor_id = ACT.actor_id
 		WHERE A.actor_id = ?`
 
-	rows, err := s.db.Query(query, actorID)
+	rows, err := s.db.QueryContext(ctx, query, actorID)
 	if err != nil {
-		fmt.Println(err)
 		return nil, err
 	}
 	defer rows.Close()
@@ -462,167 +606,186 @@ func (s *service) GetMoviesByActorID(actorID int) ([]ActedMovie, error) {
 			&movie.ReleaseDate,
 			&movie.Genre,
 			&movie.AvgRating,
+			&movie.ReviewCount,
+			&movie.Slug,
 			&movie.ActorID,
 			&movie.ActorDob,
 			&movie.ActorName,
 			&movie.Nationality,
 		)
 		if err != nil {
-			fmt.Println(err)
 			return nil, err
 		}
 		movies = append(movies, movie)
 	}
 
-	return movies, nil
-}
-
-// func (s *service) GetUserData(id int) (User, error) {
-// 	selectDataQuery := fmt.Sprintf("SELECT * FROM USER WHERE user_id=%d", id)
-// 
-// 	userRow, err := s.db.Query(selectDataQuery)
-// 	if err != nil {
-// 		panic(err.Error())
-// 	}
-// 	defer userRow.Close()
-// 
-// 	if userRow.Next() {
-// 		var user User
-// 		err := userRow.Scan(&user.ID, &user.Username, &user.Email, &user.Password)
-// 		if err != nil {
-// 			return User{}, err
-// 		}
-// 		return user, nil
-// 	}
-// 
-// 	fmt.Println(User{})
-// 
-// 	return User{}, errors.New("user not found")
-// }
-
-func (s *service) ShowReview(url string) ([]Review, error) {
-	modifiedTitle := strings.ReplaceAll(url, "-", " ")
-	selectDataQuery := fmt.Sprintf("SELECT * FROM MOVIE WHERE Title=%q", modifiedTitle)
-
-	movieRow, err := s.db.Query(selectDataQuery)
-	if err != nil {
-		panic(err.Error())
-	}
-	defer movieRow.Close()
-
-	var movie Movie
-	if movieRow.Next() {
-		err := movieRow.Scan(&movie.Id, &movie.Title, &movie.ReleaseDate, &movie.Genre, &movie.AvgRating)
-		if err != nil {
-			panic(err.Error())
-		}
-	}
+	return movies, rows.Err()
+}
 
-	reviewDataQuery := fmt.Sprintf("SELECT * FROM REVIEW WHERE movie_id=%d", movie.Id)
+// ShowReview lists the reviews of a movie with their authors, sorted by
+// "date", "rating" or "helpful".
+func (s *service) ShowReview(ctx context.Context, ref string, f ReviewFilter, req PageRequest) (_ Page[MovieReview], err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
 
-	reviewRow, err := s.db.Query(reviewDataQuery)
-	if err != nil {
-		panic(err.Error())
+	if err := f.check(); err != nil {
+		return Page[MovieReview]{}, err
 	}
-	defer reviewRow.Close()
-
-	var reviews []Review
-
-	var ErrNoReviews = errors.New("no reviews")
-
-	for reviewRow.Next() {
-		var review Review
-		err := reviewRow.Scan(&review.Id, &review.Review, &review.Stars, &review.DatePosted, &review.MovieId)
-		if err != nil {
-			log.Printf("Error scanning review row: %v", err)
-		}
-		reviews = append(reviews, review)
+	p, err := planPage(req, reviewSorts)
+	if err != nil {
+		return Page[MovieReview]{}, err
 	}
 
-	if len(reviews) == 0 {
-		return nil, ErrNoReviews
+	movie, err := s.GetMovie(ctx, ref)
+	if err != nil {
+		return Page[MovieReview]{}, err
 	}
 
-	return reviews, nil
+	where, args := f.where()
+	return fetchPage(ctx, s.db, listQuery[MovieReview]{
+		columns:  movieReviewColumns,
+		from:     movieReviewTables,
+		idColumn: "R.review_id",
+		where:    append([]string{"R.movie_id = ?", "U.DeleteAfter IS NULL"}, where...),
+		args:     append([]interface{}{movie.Id}, args...),
+		scan:     func(rows *sql.Rows) (MovieReview, error) { return scanMovieReview(rows) },
+		id:       func(r MovieReview) int { return r.Id },
+	}, p)
 }
 
-func (s *service) AddReview(url string, stars int, reviewText string, username string) {
-	modifiedTitle := strings.ReplaceAll(url, "-", " ")
+// AddReview stores userID's review of the movie, replacing their earlier
+// review if there is one. The review, its authorship and the movie's rating
+// totals change in a single transaction; the movie row stays locked until it
+// commits, so concurrent reviews of the same movie can't lose updates.
+func (s *service) AddReview(ctx context.Context, ref string, stars int, reviewText string, userID int) (err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
 
-	selectDataQuery := fmt.Sprintf("SELECT * FROM MOVIE WHERE Title=%q", modifiedTitle)
-	movieRow, err := s.db.Query(selectDataQuery)
-	if err != nil {
-		panic(err.Error())
-	}
-	defer movieRow.Close()
-
-	var movie Movie
-	if movieRow.Next() {
-		err := movieRow.Scan(&movie.Id, &movie.Title, &movie.ReleaseDate, &movie.Genre, &movie.AvgRating)
-		if err != nil {
-			panic(err.Error())
-		}
+	if stars < 1 || stars > 5 {
+		return fmt.Errorf("rating must be between 1 and 5 stars, got %d: %w", stars, ErrValidation)
 	}
 
-	getUserIDQuery := fmt.Sprintf("SELECT user_id FROM USER WHERE Username=%q", username)
-	var userID int
-	err = s.db.QueryRow(getUserIDQuery).Scan(&userID)
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
-		panic(err.Error())
+		return err
 	}
+	defer tx.Rollback()
 
-	existingReviewQuery := "SELECT R.review_id FROM WROTE W JOIN REVIEW R ON W.review_id = R.review_id WHERE W.user_id = ? AND R.movie_id = ?"
-	rows, err := s.db.Query(existingReviewQuery, userID, movie.Id)
+	var movieID int
+	err = findByRef(ctx, tx, "SELECT movie_id FROM MOVIE WHERE", "movie_id", "Title", ref, s.forUpdate(), &movieID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("movie %q: %w", ref, ErrNotFound)
+	}
 	if err != nil {
-		panic(err.Error())
+		return err
 	}
-	defer rows.Close()
 
-	var existingReviewIDs []int
-	for rows.Next() {
-		var reviewID int
-		if err := rows.Scan(&reviewID); err != nil {
-			panic(err.Error())
-		}
-		existingReviewIDs = append(existingReviewIDs, reviewID)
+	var (
+		reviewID  int64
+		prevStars int
+	)
+	existingReviewQuery := "SELECT R.review_id, R.RatingStars FROM WROTE W JOIN REVIEW R ON W.review_id = R.review_id WHERE W.user_id = ? AND W.movie_id = ?"
+	err = tx.QueryRowContext(ctx, existingReviewQuery, userID, movieID).Scan(&reviewID, &prevStars)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
 	}
+	exists := err == nil
 
-	currentTime := time.Now()
-	dateToday := fmt.Sprintf("%d-%d-%d", currentTime.Year(), currentTime.Month(), currentTime.Day())
+	dateToday := time.Now().Format("2006-01-02")
+	sumDelta, countDelta := stars, 1
 
-	if len(existingReviewIDs) > 0 {
+	if exists {
 		updateReviewQuery := "UPDATE REVIEW SET ReviewText = ?, RatingStars = ?, DatePosted = ? WHERE review_id = ?"
-		_, err = s.db.Exec(updateReviewQuery, reviewText, stars, dateToday, existingReviewIDs[len(existingReviewIDs)-1])
-		if err != nil {
-			panic(err.Error())
+		if _, err := tx.ExecContext(ctx, updateReviewQuery, reviewText, stars, dateToday, reviewID); err != nil {
+			return translateError(err)
 		}
+		sumDelta, countDelta = stars-prevStars, 0
 	} else {
-		insertReviewQuery := fmt.Sprintf("INSERT INTO REVIEW (ReviewText, RatingStars, DatePosted, movie_id) VALUES (%q, %d, '%s', %d);", reviewText, stars, dateToday, movie.Id)
-
-		_, err = s.db.Exec(insertReviewQuery)
+		insertReviewQuery := "INSERT INTO REVIEW (ReviewText, RatingStars, DatePosted, movie_id) VALUES (?, ?, ?, ?)"
+		result, err := tx.ExecContext(ctx, insertReviewQuery, reviewText, stars, dateToday, movieID)
 		if err != nil {
-			panic(err.Error())
+			return translateError(err)
 		}
 
-		getLastReviewIDQuery := "SELECT LAST_INSERT_ID()"
-		var lastReviewID int
-		err = s.db.QueryRow(getLastReviewIDQuery).Scan(&lastReviewID)
+		reviewID, err = result.LastInsertId()
 		if err != nil {
-			panic(err.Error())
+			return err
 		}
 
-		insertWroteQuery := "INSERT INTO WROTE (review_id, user_id) VALUES (?, ?)"
-		_, err = s.db.Exec(insertWroteQuery, lastReviewID, userID)
-		if err != nil {
-			panic(err.Error())
+		insertWroteQuery := "INSERT INTO WROTE (review_id, user_id, movie_id) VALUES (?, ?, ?)"
+		if _, err := tx.ExecContext(ctx, insertWroteQuery, reviewID, userID, movieID); err != nil {
+			err = translateError(err)
+			if errors.Is(err, ErrConflict) {
+				return fmt.Errorf("review of movie %d by user %d: %w", movieID, userID, ErrConflict)
+			}
+			return err
 		}
 	}
 
-	updateAvgRatingQuery := fmt.Sprintf("UPDATE MOVIE SET AvgRating = (SELECT AVG(RatingStars) FROM REVIEW WHERE movie_id = %d) WHERE movie_id = %d", movie.Id, movie.Id)
-	_, err = s.db.Exec(updateAvgRatingQuery)
+	if err := adjustRating(ctx, tx, movieID, sumDelta, countDelta); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteReview removes a review and takes its stars out of the movie's
+// rating. It locks the movie first, like AddReview, so the two can't
+// interleave.
+func (s *service) DeleteReview(ctx context.Context, reviewID int) (err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var movieID int
+	err = tx.QueryRowContext(ctx, "SELECT movie_id FROM REVIEW WHERE review_id = ?", reviewID).Scan(&movieID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("review %d: %w", reviewID, ErrNotFound)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := tx.QueryRowContext(ctx, "SELECT movie_id FROM MOVIE WHERE movie_id = ?"+s.forUpdate(), movieID).Scan(&movieID); err != nil {
+		return err
+	}
+
+	var stars int
+	err = tx.QueryRowContext(ctx, "SELECT RatingStars FROM REVIEW WHERE review_id = ?", reviewID).Scan(&stars)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("review %d: %w", reviewID, ErrNotFound)
+	}
 	if err != nil {
-		panic(err.Error())
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM REVIEW WHERE review_id = ?", reviewID); err != nil {
+		return err
+	}
+	if err := adjustRating(ctx, tx, movieID, -stars, -1); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// adjustRating updates the running rating totals of a movie and the average
+// derived from them.
+func adjustRating(ctx context.Context, tx *sql.Tx, movieID, sumDelta, countDelta int) error {
+	// Two statements because MySQL evaluates SET assignments left to right
+	// while SQLite uses the old values throughout.
+	updateTotalsQuery := "UPDATE MOVIE SET RatingSum = RatingSum + ?, RatingCount = RatingCount + ? WHERE movie_id = ?"
+	if _, err := tx.ExecContext(ctx, updateTotalsQuery, sumDelta, countDelta, movieID); err != nil {
+		return err
 	}
+	updateAvgRatingQuery := "UPDATE MOVIE SET AvgRating = " + avgRatingExpr + " WHERE movie_id = ?"
+	_, err := tx.ExecContext(ctx, updateAvgRatingQuery, movieID)
+	return err
 }
 
 func hashPassword(password string) (string, error) {
@@ -633,48 +796,46 @@ func hashPassword(password string) (string, error) {
 	return string(hashedPassword), nil
 }
 
-func (s *service) RegisterUser(username string, password string, email string) (string, error) {
-	hashedPassword, err := hashPassword(password)
-	if err != nil {
-		return "", err
+func (s *service) RegisterUser(ctx context.Context, username string, password string, email string) (_ TokenPair, err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
+
+	if strings.TrimSpace(username) == "" || password == "" {
+		return TokenPair{}, fmt.Errorf("username and password are required: %w", ErrValidation)
+	}
+	if !strings.Contains(email, "@") {
+		return TokenPair{}, fmt.Errorf("invalid email address %q: %w", email, ErrValidation)
 	}
 
-	insertUserQuery := fmt.Sprintf("INSERT INTO USER (Username, Password, Email) VALUES (%q, %q, %q)", username, hashedPassword, email)
-	_, err = s.db.Exec(insertUserQuery)
+	hashedPassword, err := hashPassword(password)
 	if err != nil {
-		return "", err
+		return TokenPair{}, err
 	}
 
-	getUserIdQuery := fmt.Sprintf("SELECT user_id FROM USER WHERE Username=%q", username)
-	var userID int
-	err = s.db.QueryRow(getUserIdQuery).Scan(&userID)
+	insertUserQuery := "INSERT INTO USER (Username, Password, Email) VALUES (?, ?, ?)"
+	_, err = s.db.ExecContext(ctx, insertUserQuery, username, hashedPassword, email)
 	if err != nil {
-		return "", err
+		err = translateError(err)
+		if errors.Is(err, ErrConflict) {
+			return TokenPair{}, fmt.Errorf("user %q: %w", username, ErrConflict)
+		}
+		return TokenPair{}, err
 	}
 
-	token, err := createToken(userID)
+	userID, err := s.GetUserID(ctx, username)
 	if err != nil {
-		return "", err
+		return TokenPair{}, err
 	}
 
-	return token, nil
+	return s.IssueTokens(ctx, userID)
 }
 
-func createToken(userID int) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"sub": userID,
-		"exp": time.Now().Add(time.Hour * 24).Unix(),
-	})
-
-	tokenString, err := token.SignedString(secretKey)
-	if err != nil {
-		return "", err
-	}
-	return tokenString, nil
-}
+// compareHash is bcrypt.CompareHashAndPassword, swapped out by tests that
+// count the comparisons.
+var compareHash = bcrypt.CompareHashAndPassword
 
 func comparePasswords(hashedPassword string, password string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
+	err := compareHash([]byte(hashedPassword), []byte(password))
 	if err == nil {
 		return true
 	} else {
@@ -682,78 +843,46 @@ func comparePasswords(hashedPassword string, password string) bool {
 	}
 }
 
-func (s *service) AuthenticateUser(username string, password string) (User, string, string) {
-	selectUserQuery := fmt.Sprintf("SELECT * FROM USER WHERE Username=%q", username)
-	userRow, err := s.db.Query(selectUserQuery)
-	if err != nil {
-		return User{}, "", "database error"
-	}
-	defer userRow.Close()
+// ErrBadCredentials is what AuthenticateUser returns for an unknown username
+// and a wrong password alike, so that signing in doesn't tell which
+// usernames exist.
+var ErrBadCredentials = fmt.Errorf("invalid username or password: %w", ErrUnauthorized)
 
-	var user User
-	if userRow.Next() {
-		err := userRow.Scan(&user.ID, &user.Username, &user.Email, &user.Password)
-		if err != nil {
-			return User{}, "", "database error"
-		}
-	} else {
-		return User{}, "", "usernotfound"
-	}
+// dummyHash is compared against when the username is unknown, so that the
+// answer takes as long as for a wrong password. It is a bcrypt hash at
+// bcrypt.DefaultCost, the cost RegisterUser hashes with, of a password
+// nobody has.
+const dummyHash = "$2a$10$1C4Tp3j5suYyzJSp2nq.MOMj4AKXSXWdtpx.F/Z7bTHTQz3rWj5Tu"
 
-	if comparePasswords(user.Password, password) {
-		log.Printf("Authentication successful for user ID: %d, username: %s", user.ID, user.Username)
-		token, err := createToken(user.ID)
-		if err != nil {
-			log.Println("Error creating token:", err)
-			return User{}, "", "token creation error"
-		}
+func (s *service) AuthenticateUser(ctx context.Context, username string, password string) (_ User, _ TokenPair, err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
 
-		return user, token, ""
-	} else {
-		return User{}, "", "passNoMatch"
-	}
-}
+	selectUserQuery := "SELECT user_id, Username, Email, Password FROM USER WHERE Username = ?"
 
-func (s *service) ToggleWatchlist(movieID, userID int) error {
-	var exists bool
-	err := s.db.QueryRow("SELECT EXISTS(SELECT 1 FROM ADDS_TO_WATCHLIST WHERE movie_id = ? AND user_id = ?)", movieID, userID).Scan(&exists)
+	var user User
+	err = s.db.QueryRowContext(ctx, selectUserQuery, username).Scan(&user.ID, &user.Username, &user.Email, &user.Password)
+	if errors.Is(err, sql.ErrNoRows) {
+		comparePasswords(dummyHash, password)
+		return User{}, TokenPair{}, ErrBadCredentials
+	}
 	if err != nil {
-		return err
+		return User{}, TokenPair{}, err
 	}
 
-	if exists {
-		_, err = s.db.Exec("DELETE FROM ADDS_TO_WATCHLIST WHERE movie_id = ? AND user_id = ?", movieID, userID)
-		if err != nil {
-			return err
-		}
-	} else {
-		_, err = s.db.Exec("INSERT INTO ADDS_TO_WATCHLIST (movie_id, user_id, DateAdded) VALUES (?, ?, ?)", movieID, userID, time.Now())
-		if err != nil {
-			return err
-		}
+	if !comparePasswords(user.Password, password) {
+		return User{}, TokenPair{}, ErrBadCredentials
 	}
 
-	return nil
-}
-
-func (s *service) ToggleLiked(movieID, userID int) error {
-	var exists bool
-	err := s.db.QueryRow("SELECT EXISTS(SELECT 1 FROM LIKES WHERE movie_id = ? AND user_id = ?)", movieID, userID).Scan(&exists)
-	if err != nil {
-		return err
+	// Signing in cancels a deletion the user asked for; see DeleteAccount.
+	if _, err := s.db.ExecContext(ctx, "UPDATE USER SET DeleteAfter = NULL WHERE user_id = ? AND DeleteAfter IS NOT NULL", user.ID); err != nil {
+		return User{}, TokenPair{}, err
 	}
 
-	if exists {
-		_, err = s.db.Exec("DELETE FROM LIKES WHERE movie_id = ? AND user_id = ?", movieID, userID)
-		if err != nil {
-			return err
-		}
-	} else {
-		_, err = s.db.Exec("INSERT INTO LIKES (movie_id, user_id, DateAdded) VALUES (?, ?, ?)", movieID, userID, time.Now())
-		if err != nil {
-			return err
-		}
+	tokens, err := s.IssueTokens(ctx, user.ID)
+	if err != nil {
+		return User{}, TokenPair{}, fmt.Errorf("creating token: %w", err)
 	}
 
-	return nil
+	return user, tokens, nil
 }
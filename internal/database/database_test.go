@@ -0,0 +1,435 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"golang.org/x/crypto/bcrypt"
+
+	"lab2324omada7/internal/config"
+)
+
+// hostileInputs are fed to every Service method that takes user input. None
+// of them may ever appear in the SQL text sent to the server; they must only
+// travel as placeholder arguments.
+var hostileInputs = []string{
+	`Robert'); DROP TABLE MOVIE; --`,
+	`" OR "1"="1`,
+	`' OR 1=1 #`,
+	`O'Brien's "Movie"`,
+	`C:\path\to\nowhere\`,
+	`100% %s %d %q`,
+	"tab\there\nnewline",
+	`Ωραίος Κόσμος`,
+}
+
+func newMockService(t *testing.T, input string) (*service, sqlmock.Sqlmock) {
+	t.Helper()
+
+	matcher := sqlmock.QueryMatcherFunc(func(expectedSQL, actualSQL string) error {
+		if strings.Contains(actualSQL, input) {
+			return fmt.Errorf("user input interpolated into query: %s", actualSQL)
+		}
+		return sqlmock.QueryMatcherRegexp.Match(expectedSQL, actualSQL)
+	})
+
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(matcher))
+	if err != nil {
+		t.Fatalf("error creating sqlmock. Err: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return &service{db: db, driver: config.DriverMySQL}, mock
+}
+
+func q(sql string) string {
+	return regexp.QuoteMeta(sql)
+}
+
+var movieColumnNames = []string{"movie_id", "Title", "ReleaseDate", "Genre", "AvgRating", "RatingCount", "Slug"}
+
+func TestServiceParameterizesUserInput(t *testing.T) {
+	ctx := context.Background()
+
+	hashed, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		run  func(t *testing.T, s *service, mock sqlmock.Sqlmock, input string)
+	}{
+		{"GetUserID", func(t *testing.T, s *service, mock sqlmock.Sqlmock, input string) {
+			mock.ExpectQuery(q("SELECT user_id FROM USER WHERE Username = ?")).
+				WithArgs(input).
+				WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(5))
+
+			if id, err := s.GetUserID(ctx, input); err != nil || id != 5 {
+				t.Errorf("GetUserID = %d, %v; want 5, nil", id, err)
+			}
+		}},
+		{"GetMovie", func(t *testing.T, s *service, mock sqlmock.Sqlmock, input string) {
+			title := strings.ReplaceAll(input, "-", " ")
+			mock.ExpectQuery(q("SELECT " + movieColumns + " FROM MOVIE WHERE Slug = ? ORDER BY movie_id LIMIT 1")).
+				WithArgs(input).
+				WillReturnRows(sqlmock.NewRows(movieColumnNames))
+			mock.ExpectQuery(q("SELECT " + movieColumns + " FROM MOVIE WHERE Title = ? ORDER BY movie_id LIMIT 1")).
+				WithArgs(title).
+				WillReturnRows(sqlmock.NewRows(movieColumnNames).AddRow(7, title, "2001-01-01", "Drama", 4.5, 2, "slug"))
+
+			movie, err := s.GetMovie(ctx, input)
+			if err != nil || movie.Title != title {
+				t.Errorf("GetMovie = %+v, %v; want title %q", movie, err, title)
+			}
+		}},
+		{"GetDirector", func(t *testing.T, s *service, mock sqlmock.Sqlmock, input string) {
+			mock.ExpectQuery(q("SELECT " + directorColumns + " FROM DIRECTOR WHERE Slug = ?")).
+				WithArgs(input).
+				WillReturnRows(sqlmock.NewRows(nil))
+			mock.ExpectQuery(q("SELECT " + directorColumns + " FROM DIRECTOR WHERE DirectorName = ?")).
+				WithArgs(strings.ReplaceAll(input, "-", " ")).
+				WillReturnRows(sqlmock.NewRows(nil))
+
+			if _, err := s.GetDirector(ctx, input); !errors.Is(err, ErrNotFound) {
+				t.Errorf("GetDirector: got %v; want %v", err, ErrNotFound)
+			}
+		}},
+		{"GetActor", func(t *testing.T, s *service, mock sqlmock.Sqlmock, input string) {
+			mock.ExpectQuery(q("SELECT " + actorColumns + " FROM ACTOR WHERE Slug = ?")).
+				WithArgs(input).
+				WillReturnRows(sqlmock.NewRows(nil))
+			mock.ExpectQuery(q("SELECT " + actorColumns + " FROM ACTOR WHERE ActorName = ?")).
+				WithArgs(strings.ReplaceAll(input, "-", " ")).
+				WillReturnRows(sqlmock.NewRows(nil))
+
+			if _, err := s.GetActor(ctx, input); !errors.Is(err, ErrNotFound) {
+				t.Errorf("GetActor: got %v; want %v", err, ErrNotFound)
+			}
+		}},
+		{"ShowReview", func(t *testing.T, s *service, mock sqlmock.Sqlmock, input string) {
+			mock.ExpectQuery(q("SELECT " + movieColumns + " FROM MOVIE WHERE Slug = ?")).
+				WithArgs(input).
+				WillReturnRows(sqlmock.NewRows(movieColumnNames).AddRow(7, input, "2001-01-01", "Drama", 4.5, 2, "slug"))
+			mock.ExpectQuery(q("SELECT COUNT(*) FROM " + movieReviewTables + " WHERE R.movie_id = ?")).
+				WithArgs(7).
+				WillReturnRows(sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(1))
+			mock.ExpectQuery(q("SELECT "+movieReviewColumns+" FROM "+movieReviewTables+" WHERE R.movie_id = ? AND U.DeleteAfter IS NULL ORDER BY R.review_id ASC LIMIT ?")).
+				WithArgs(7, DefaultPageSize+1).
+				WillReturnRows(sqlmock.NewRows([]string{"review_id", "ReviewText", "RatingStars", "DatePosted", "movie_id", "HelpfulCount", "Username", "DisplayName", "AvatarURL"}).
+					AddRow(1, input, 3, "2023-12-01", "7", 0, "bob", "", ""))
+
+			reviews, err := s.ShowReview(ctx, input, ReviewFilter{}, PageRequest{})
+			if err != nil || len(reviews.Items) != 1 || reviews.Items[0].Review.Review != input || reviews.Total != 1 || reviews.Next != "" {
+				t.Errorf("ShowReview = %+v, %v", reviews, err)
+			}
+		}},
+		{"Search", func(t *testing.T, s *service, mock sqlmock.Sqlmock, input string) {
+			against := sqlmock.AnyArg()
+			mock.ExpectQuery(q("SELECT 'movie', movie_id, Slug, Title, Title, MATCH (Title) AGAINST (? IN BOOLEAN MODE) * ? AS score")).
+				WithArgs(against, 3.0, against, against, 3.0, against, against, 3.0, against, against, 1.0, against, DefaultSearchLimit).
+				WillReturnRows(sqlmock.NewRows([]string{"type", "id", "Slug", "Title", "text", "score"}).
+					AddRow("movie", 7, "slug", input, input, 1.5))
+
+			results, err := s.Search(ctx, input, 0)
+			if err != nil || len(results) != 1 || results[0].Title != input || !strings.Contains(results[0].Highlight, "<mark>") {
+				t.Errorf("Search = %+v, %v", results, err)
+			}
+		}},
+		{"AddReview", func(t *testing.T, s *service, mock sqlmock.Sqlmock, input string) {
+			mock.ExpectBegin()
+			mock.ExpectQuery(q("SELECT movie_id FROM MOVIE WHERE Slug = ? ORDER BY movie_id LIMIT 1 FOR UPDATE")).
+				WithArgs(input).
+				WillReturnRows(sqlmock.NewRows([]string{"movie_id"}).AddRow(7))
+			mock.ExpectQuery(q("SELECT R.review_id, R.RatingStars FROM WROTE W JOIN REVIEW R")).
+				WithArgs(3, 7).
+				WillReturnRows(sqlmock.NewRows([]string{"review_id", "RatingStars"}))
+			mock.ExpectExec(q("INSERT INTO REVIEW (ReviewText, RatingStars, DatePosted, movie_id) VALUES (?, ?, ?, ?)")).
+				WithArgs(input, 4, sqlmock.AnyArg(), 7).
+				WillReturnResult(sqlmock.NewResult(11, 1))
+			mock.ExpectExec(q("INSERT INTO WROTE (review_id, user_id, movie_id) VALUES (?, ?, ?)")).
+				WithArgs(11, 3, 7).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec(q("UPDATE MOVIE SET RatingSum = RatingSum + ?, RatingCount = RatingCount + ?")).
+				WithArgs(4, 1, 7).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec(q("UPDATE MOVIE SET AvgRating")).
+				WithArgs(7).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+
+			if err := s.AddReview(ctx, input, 4, input, 3); err != nil {
+				t.Errorf("AddReview: %v", err)
+			}
+		}},
+		{"RegisterUser", func(t *testing.T, s *service, mock sqlmock.Sqlmock, input string) {
+			mock.ExpectExec(q("INSERT INTO USER (Username, Password, Email) VALUES (?, ?, ?)")).
+				WithArgs(input, sqlmock.AnyArg(), input+"@example.com").
+				WillReturnResult(sqlmock.NewResult(5, 1))
+			mock.ExpectQuery(q("SELECT user_id FROM USER WHERE Username = ?")).
+				WithArgs(input).
+				WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(5))
+			mock.ExpectQuery(q("SELECT role FROM USER_ROLE WHERE user_id = ?")).
+				WithArgs(5).
+				WillReturnRows(sqlmock.NewRows([]string{"role"}))
+			mock.ExpectExec(q("INSERT INTO REFRESH_TOKEN")).
+				WithArgs(5, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			if _, err := s.RegisterUser(ctx, input, input, input+"@example.com"); err != nil {
+				t.Errorf("RegisterUser: %v", err)
+			}
+		}},
+		{"AuthenticateUser", func(t *testing.T, s *service, mock sqlmock.Sqlmock, input string) {
+			mock.ExpectQuery(q("SELECT user_id, Username, Email, Password FROM USER WHERE Username = ?")).
+				WithArgs(input).
+				WillReturnRows(sqlmock.NewRows([]string{"user_id", "Username", "Email", "Password"}).
+					AddRow(5, input, "user@example.com", string(hashed)))
+			mock.ExpectExec(q("UPDATE USER SET DeleteAfter = NULL WHERE user_id = ?")).
+				WithArgs(5).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectQuery(q("SELECT role FROM USER_ROLE WHERE user_id = ?")).
+				WithArgs(5).
+				WillReturnRows(sqlmock.NewRows([]string{"role"}))
+			mock.ExpectExec(q("INSERT INTO REFRESH_TOKEN")).
+				WithArgs(5, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			if _, _, err := s.AuthenticateUser(ctx, input, "secret"); err != nil {
+				t.Errorf("AuthenticateUser: %v", err)
+			}
+		}},
+		{"GetWatchlistStatus", func(t *testing.T, s *service, mock sqlmock.Sqlmock, input string) {
+			mock.ExpectQuery(q("SELECT user_id FROM USER WHERE Username = ?")).
+				WithArgs(input).
+				WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(5))
+			mock.ExpectQuery(q("FROM ADDS_TO_WATCHLIST WHERE movie_id = ? AND user_id = ?")).
+				WithArgs(7, 5).
+				WillReturnRows(sqlmock.NewRows([]string{"watchlist_status"}).AddRow(true))
+
+			if added, err := s.GetWatchlistStatus(ctx, 7, input); err != nil || !added {
+				t.Errorf("GetWatchlistStatus = %v, %v; want true, nil", added, err)
+			}
+		}},
+		{"GetLikedStatus", func(t *testing.T, s *service, mock sqlmock.Sqlmock, input string) {
+			mock.ExpectQuery(q("SELECT user_id FROM USER WHERE Username = ?")).
+				WithArgs(input).
+				WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(5))
+			mock.ExpectQuery(q("FROM LIKES WHERE movie_id = ? AND user_id = ?")).
+				WithArgs(7, 5).
+				WillReturnRows(sqlmock.NewRows([]string{"likes_status"}).AddRow(false))
+
+			if liked, err := s.GetLikedStatus(ctx, 7, input); err != nil || liked {
+				t.Errorf("GetLikedStatus = %v, %v; want false, nil", liked, err)
+			}
+		}},
+		{"RefreshTokens", func(t *testing.T, s *service, mock sqlmock.Sqlmock, input string) {
+			mock.ExpectBegin()
+			mock.ExpectQuery(q("FROM REFRESH_TOKEN WHERE token_hash = ? FOR UPDATE")).
+				WithArgs(hashToken(input)).
+				WillReturnRows(sqlmock.NewRows([]string{"token_id"}))
+			mock.ExpectRollback()
+
+			if _, err := s.RefreshTokens(ctx, input); !errors.Is(err, ErrInvalidRefreshToken) {
+				t.Errorf("RefreshTokens: got %v; want %v", err, ErrInvalidRefreshToken)
+			}
+		}},
+		{"RevokeRefreshToken", func(t *testing.T, s *service, mock sqlmock.Sqlmock, input string) {
+			mock.ExpectQuery(q("SELECT family_id FROM REFRESH_TOKEN WHERE token_hash = ?")).
+				WithArgs(hashToken(input)).
+				WillReturnRows(sqlmock.NewRows([]string{"family_id"}))
+
+			if err := s.RevokeRefreshToken(ctx, input); err != nil {
+				t.Errorf("RevokeRefreshToken: %v", err)
+			}
+		}},
+		{"SessionActive", func(t *testing.T, s *service, mock sqlmock.Sqlmock, input string) {
+			mock.ExpectQuery(q("FROM REFRESH_TOKEN WHERE family_id = ?")).
+				WithArgs(input, sqlmock.AnyArg()).
+				WillReturnRows(sqlmock.NewRows([]string{"active"}).AddRow(false))
+
+			if active, err := s.SessionActive(ctx, input); err != nil || active {
+				t.Errorf("SessionActive = %v, %v; want false, nil", active, err)
+			}
+		}},
+	}
+
+	for i, input := range hostileInputs {
+		for _, c := range cases {
+			t.Run(fmt.Sprintf("%s/%d", c.name, i), func(t *testing.T) {
+				s, mock := newMockService(t, input)
+				defer func() {
+					if r := recover(); r != nil {
+						t.Fatalf("%s panicked with input %q: %v", c.name, input, r)
+					}
+				}()
+
+				c.run(t, s, mock, input)
+
+				if err := mock.ExpectationsWereMet(); err != nil {
+					t.Errorf("%s with input %q: %v", c.name, input, err)
+				}
+			})
+		}
+	}
+}
+
+func TestServiceErrorsWrapSentinels(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("movie not found", func(t *testing.T) {
+		s, mock := newMockService(t, "\x00")
+		mock.ExpectQuery(q("SELECT " + movieColumns + " FROM MOVIE WHERE Slug = ?")).
+			WithArgs("No-Such-Movie").
+			WillReturnRows(sqlmock.NewRows(movieColumnNames))
+		mock.ExpectQuery(q("SELECT " + movieColumns + " FROM MOVIE WHERE Title = ?")).
+			WithArgs("No Such Movie").
+			WillReturnRows(sqlmock.NewRows(movieColumnNames))
+
+		if _, err := s.GetMovie(ctx, "No-Such-Movie"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetMovie: got %v; want %v", err, ErrNotFound)
+		}
+	})
+
+	t.Run("movie by ID", func(t *testing.T) {
+		s, mock := newMockService(t, "\x00")
+		mock.ExpectQuery(q("SELECT " + movieColumns + " FROM MOVIE WHERE movie_id = ?")).
+			WithArgs(404).
+			WillReturnRows(sqlmock.NewRows(movieColumnNames))
+
+		if _, err := s.GetMovie(ctx, "404"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetMovie: got %v; want %v", err, ErrNotFound)
+		}
+	})
+
+	t.Run("duplicate username", func(t *testing.T) {
+		s, mock := newMockService(t, "\x00")
+		mock.ExpectExec(q("INSERT INTO USER")).
+			WillReturnError(&mysql.MySQLError{Number: mysqlDuplicateEntry, Message: "Duplicate entry 'taken' for key 'USER.Username'"})
+
+		_, err := s.RegisterUser(ctx, "taken", "secret", "taken@example.com")
+		if !errors.Is(err, ErrConflict) {
+			t.Errorf("RegisterUser: got %v; want %v", err, ErrConflict)
+		}
+		if strings.Contains(err.Error(), "USER.Username") {
+			t.Errorf("RegisterUser: got %q; want no driver message", err)
+		}
+	})
+
+	t.Run("driver message", func(t *testing.T) {
+		// The driver's message names tables and indexes, so only the logs
+		// get it, through DriverError.Err.
+		cause := &mysql.MySQLError{Number: mysqlNoReferencedRow, Message: "a foreign key constraint fails (`LIKES`, CONSTRAINT `LIKES_ibfk_1`)"}
+		err := fmt.Errorf("liking movie 7: %w", translateError(cause))
+		var driverErr *DriverError
+		if !errors.Is(err, ErrValidation) || !errors.As(err, &driverErr) || driverErr.Err != cause {
+			t.Fatalf("translateError = %v; want a DriverError for ErrValidation", err)
+		}
+		if got, want := err.Error(), "liking movie 7: validation failed"; got != want {
+			t.Errorf("Error() = %q; want %q", got, want)
+		}
+	})
+
+	t.Run("invalid rating", func(t *testing.T) {
+		s, _ := newMockService(t, "\x00")
+
+		if err := s.AddReview(ctx, "Movie", 6, "", 1); !errors.Is(err, ErrValidation) {
+			t.Errorf("AddReview: got %v; want %v", err, ErrValidation)
+		}
+	})
+
+	t.Run("wrong password", func(t *testing.T) {
+		s, mock := newMockService(t, "\x00")
+		mock.ExpectQuery(q("SELECT user_id, Username, Email, Password FROM USER WHERE Username = ?")).
+			WillReturnRows(sqlmock.NewRows([]string{"user_id", "Username", "Email", "Password"}).
+				AddRow(5, "bob", "bob@example.com", "$2a$04$invalidinvalidinvalidinvalidinvalidinvalidinvalidinva"))
+
+		if _, _, err := s.AuthenticateUser(ctx, "bob", "secret"); !errors.Is(err, ErrUnauthorized) {
+			t.Errorf("AuthenticateUser: got %v; want %v", err, ErrUnauthorized)
+		}
+	})
+
+	t.Run("error partway through rows", func(t *testing.T) {
+		s, mock := newMockService(t, "\x00")
+		rows := sqlmock.NewRows([]string{"movie_id", "Title", "ReleaseDate", "Genre", "AvgRating", "RatingCount", "Slug",
+			"actor_id", "DateOfBirth", "ActorName", "Nationality"}).
+			AddRow(1, "Heat", "1995-12-15", "Crime", 4.5, 2, "heat", 7, "1943-08-17", "Robert De Niro", "American").
+			AddRow(2, "Casino", "1995-11-22", "Crime", 4.0, 1, "casino", 7, "1943-08-17", "Robert De Niro", "American").
+			RowError(1, errors.New("connection reset"))
+		mock.ExpectQuery(q("FROM MOVIE M")).WithArgs(7).WillReturnRows(rows)
+
+		if movies, err := s.GetMoviesByActorID(ctx, 7); err == nil {
+			t.Errorf("GetMoviesByActorID = %d movies; want the row error", len(movies))
+		}
+	})
+
+	t.Run("query timeout", func(t *testing.T) {
+		s, mock := newMockService(t, "\x00")
+		s.queryTimeout = 10 * time.Millisecond
+		mock.ExpectQuery(q("SELECT COUNT(*) FROM MOVIE")).
+			WillDelayFor(time.Second).
+			WillReturnRows(sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(0))
+
+		if _, err := s.GetMovies(ctx, MovieFilter{}, PageRequest{}); !errors.Is(err, ErrTimeout) {
+			t.Errorf("GetMovies: got %v; want %v", err, ErrTimeout)
+		}
+	})
+
+	t.Run("caller gave up", func(t *testing.T) {
+		s, mock := newMockService(t, "\x00")
+		mock.ExpectQuery(q("SELECT COUNT(*) FROM MOVIE")).
+			WillDelayFor(time.Second).
+			WillReturnRows(sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(0))
+
+		cancelled, cancel := context.WithCancel(ctx)
+		time.AfterFunc(10*time.Millisecond, cancel)
+
+		if _, err := s.GetMovies(cancelled, MovieFilter{}, PageRequest{}); !errors.Is(err, ErrUnavailable) || errors.Is(err, ErrTimeout) {
+			t.Errorf("GetMovies: got %v; want %v", err, ErrUnavailable)
+		}
+	})
+}
+
+// TestAuthenticateUserAlwaysComparesPasswords checks that an unknown username
+// costs a bcrypt comparison just as a wrong password does, so that how long
+// signing in takes doesn't tell which usernames exist.
+func TestAuthenticateUserAlwaysComparesPasswords(t *testing.T) {
+	ctx := context.Background()
+	var compared []string
+	compareHash = func(hash, password []byte) error {
+		compared = append(compared, string(hash))
+		return bcrypt.CompareHashAndPassword(hash, password)
+	}
+	t.Cleanup(func() { compareHash = bcrypt.CompareHashAndPassword })
+
+	const bobHash = "$2a$04$invalidinvalidinvalidinvalidinvalidinvalidinvalidinva"
+	columns := []string{"user_id", "Username", "Email", "Password"}
+	for _, tc := range []struct {
+		name string
+		rows *sqlmock.Rows
+		hash string
+	}{
+		{"unknown user", sqlmock.NewRows(columns), dummyHash},
+		{"wrong password", sqlmock.NewRows(columns).AddRow(5, "bob", "bob@example.com", bobHash), bobHash},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			compared = nil
+			s, mock := newMockService(t, "\x00")
+			mock.ExpectQuery(q("SELECT user_id, Username, Email, Password FROM USER WHERE Username = ?")).WillReturnRows(tc.rows)
+
+			if _, _, err := s.AuthenticateUser(ctx, "bob", "secret"); err != ErrBadCredentials {
+				t.Errorf("AuthenticateUser: got %v; want %v", err, ErrBadCredentials)
+			}
+			if len(compared) != 1 || compared[0] != tc.hash {
+				t.Errorf("compared %q; want %q", compared, tc.hash)
+			}
+		})
+	}
+}
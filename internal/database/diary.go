@@ -0,0 +1,350 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// The diary records when users watched movies, as opposed to the likes and
+// watchlist, which record what they think of them or mean to watch. Logging
+// a watch takes the movie off the user's watchlist. Diaries are private:
+// users only reach their own entries, and anyone else's are ErrNotFound.
+
+// DiaryEntry is a time a user watched a movie.
+type DiaryEntry struct {
+	ID int `json:"entry_id"`
+	Movie
+	WatchedOn string `json:"watched_on"`
+	Rewatch   bool   `json:"rewatch"`
+	Rating    *int   `json:"rating"`    // null when not rated
+	ReviewID  *int   `json:"review_id"` // null when no review is linked
+	CreatedAt int64  `json:"created_at"`
+}
+
+// DiaryFields are what LogWatch records and UpdateDiaryEntry changes; nil
+// fields stay as they are. LogWatch defaults WatchedOn to today (UTC) and
+// Rewatch to whether the user had watched the movie by then. A Rating or
+// ReviewID of 0 removes it.
+type DiaryFields struct {
+	WatchedOn *string
+	Rewatch   *bool
+	Rating    *int
+	ReviewID  *int // must be the user's review of the movie
+}
+
+// DiaryFilter narrows a diary down to a year, or to a month of a year.
+type DiaryFilter struct {
+	Year  int // 0 for every year
+	Month int // 1 to 12, or 0 for the whole year; needs Year
+}
+
+// DiaryMonth says how many movies a user watched in a month.
+type DiaryMonth struct {
+	Year  int `json:"year"`
+	Month int `json:"month"`
+	Count int `json:"count"`
+}
+
+var diarySorts = map[string]listSort[DiaryEntry]{
+	"watched_on": {"D.WatchedOn", func(e DiaryEntry) interface{} { return e.WatchedOn }},
+}
+
+// diaryTable lets UpdateDiaryEntry share the catalog's update helper.
+var diaryTable = catalogTable{"DIARY_ENTRY", "diary entry", "entry_id", "WatchedOn"}
+
+// clean validates f and returns it with WatchedOn trimmed. A WatchedOn more
+// than a day ahead of UTC is in the future wherever the user is.
+func (f DiaryFields) clean() (DiaryFields, error) {
+	f.WatchedOn = copyString(f.WatchedOn)
+
+	c := fieldChecker{}
+	c.date("WatchedOn", f.WatchedOn)
+	if f.WatchedOn != nil && *f.WatchedOn > time.Now().UTC().AddDate(0, 0, 1).Format("2006-01-02") {
+		c.problems = append(c.problems, fmt.Sprintf("WatchedOn %s is in the future", *f.WatchedOn))
+	}
+	if f.Rating != nil && (*f.Rating < 0 || *f.Rating > 5) {
+		c.problems = append(c.problems, fmt.Sprintf("Rating must be between 1 and 5 stars, or 0 for none, got %d", *f.Rating))
+	}
+	if f.ReviewID != nil && *f.ReviewID < 0 {
+		c.problems = append(c.problems, fmt.Sprintf("invalid ReviewID %d", *f.ReviewID))
+	}
+	return f, c.err()
+}
+
+// dates returns the first date f selects and the first one after them, as
+// text that compares correctly with WatchedOn, or empty strings for the
+// whole diary. Both are real dates, which MySQL's DATE columns need.
+func (f DiaryFilter) dates() (string, string, error) {
+	switch {
+	case f.Year == 0 && f.Month != 0:
+		return "", "", fmt.Errorf("a month needs a year: %w", ErrValidation)
+	case f.Year < 0 || f.Year > 9999:
+		return "", "", fmt.Errorf("invalid year %d: %w", f.Year, ErrValidation)
+	case f.Month < 0 || f.Month > 12:
+		return "", "", fmt.Errorf("month must be between 1 and 12, got %d: %w", f.Month, ErrValidation)
+	case f.Year == 0:
+		return "", "", nil
+	}
+	from := time.Date(f.Year, time.January, 1, 0, 0, 0, 0, time.UTC)
+	until := from.AddDate(1, 0, 0)
+	if f.Month != 0 {
+		from = time.Date(f.Year, time.Month(f.Month), 1, 0, 0, 0, 0, time.UTC)
+		until = time.Date(f.Year, time.Month(f.Month)+1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	return from.Format("2006-01-02"), until.Format("2006-01-02"), nil
+}
+
+// nullIfZero turns an optional number where 0 means "none" into a column
+// value.
+func nullIfZero(v *int) interface{} {
+	if v == nil || *v == 0 {
+		return nil
+	}
+	return *v
+}
+
+// LogWatch records that the user watched the movie and takes it off their
+// watchlist.
+func (s *service) LogWatch(ctx context.Context, userID, movieID int, f DiaryFields) (_ DiaryEntry, err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
+
+	if f, err = f.clean(); err != nil {
+		return DiaryEntry{}, err
+	}
+	watchedOn := time.Now().UTC().Format("2006-01-02")
+	setIfGiven(&watchedOn, f.WatchedOn)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return DiaryEntry{}, err
+	}
+	defer tx.Rollback()
+
+	if err := checkDiaryReview(ctx, tx, userID, movieID, f.ReviewID); err != nil {
+		return DiaryEntry{}, err
+	}
+	var rewatch bool
+	if f.Rewatch != nil {
+		rewatch = *f.Rewatch
+	} else {
+		var earlier int
+		err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM DIARY_ENTRY WHERE user_id = ? AND movie_id = ? AND WatchedOn <= ?",
+			userID, movieID, watchedOn).Scan(&earlier)
+		if err != nil {
+			return DiaryEntry{}, err
+		}
+		rewatch = earlier > 0
+	}
+
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO DIARY_ENTRY (user_id, movie_id, WatchedOn, Rewatch, Rating, review_id, CreatedAt)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		userID, movieID, watchedOn, rewatch, nullIfZero(f.Rating), nullIfZero(f.ReviewID), time.Now().Unix())
+	if err != nil {
+		return DiaryEntry{}, translateError(err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return DiaryEntry{}, err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM ADDS_TO_WATCHLIST WHERE user_id = ? AND movie_id = ?", userID, movieID); err != nil {
+		return DiaryEntry{}, err
+	}
+
+	entry, err := getDiaryEntry(ctx, tx, userID, int(id))
+	if err != nil {
+		return DiaryEntry{}, err
+	}
+	return entry, tx.Commit()
+}
+
+func (s *service) GetDiaryEntry(ctx context.Context, userID, entryID int) (_ DiaryEntry, err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
+
+	return getDiaryEntry(ctx, s.db, userID, entryID)
+}
+
+func (s *service) UpdateDiaryEntry(ctx context.Context, userID, entryID int, f DiaryFields) (_ DiaryEntry, err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
+
+	if f, err = f.clean(); err != nil {
+		return DiaryEntry{}, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return DiaryEntry{}, err
+	}
+	defer tx.Rollback()
+
+	entry, err := getDiaryEntry(ctx, tx, userID, entryID)
+	if err != nil {
+		return DiaryEntry{}, err
+	}
+	if err := checkDiaryReview(ctx, tx, userID, entry.Id, f.ReviewID); err != nil {
+		return DiaryEntry{}, err
+	}
+
+	values := assignments([]string{"WatchedOn"}, f.WatchedOn)
+	if f.Rewatch != nil {
+		values = append(values, assignment{"Rewatch", *f.Rewatch})
+	}
+	if f.Rating != nil {
+		values = append(values, assignment{"Rating", nullIfZero(f.Rating)})
+	}
+	if f.ReviewID != nil {
+		values = append(values, assignment{"review_id", nullIfZero(f.ReviewID)})
+	}
+	if err := s.update(ctx, tx, diaryTable, entryID, values); err != nil {
+		return DiaryEntry{}, err
+	}
+
+	if entry, err = getDiaryEntry(ctx, tx, userID, entryID); err != nil {
+		return DiaryEntry{}, err
+	}
+	return entry, tx.Commit()
+}
+
+func (s *service) DeleteDiaryEntry(ctx context.Context, userID, entryID int) (err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
+
+	result, err := s.db.ExecContext(ctx, "DELETE FROM DIARY_ENTRY WHERE entry_id = ? AND user_id = ?", entryID, userID)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("diary entry %d: %w", entryID, ErrNotFound)
+	}
+	return nil
+}
+
+// GetDiary lists the user's diary entries in the year or month f selects,
+// sorted by "watched_on".
+func (s *service) GetDiary(ctx context.Context, userID int, f DiaryFilter, req PageRequest) (_ Page[DiaryEntry], err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
+
+	from, until, err := f.dates()
+	if err != nil {
+		return Page[DiaryEntry]{}, err
+	}
+	p, err := planPage(req, diarySorts)
+	if err != nil {
+		return Page[DiaryEntry]{}, err
+	}
+
+	conds, args := []string{"D.user_id = ?"}, []interface{}{userID}
+	if from != "" {
+		conds = append(conds, "D.WatchedOn >= ?", "D.WatchedOn < ?")
+		args = append(args, from, until)
+	}
+	return fetchPage(ctx, s.db, listQuery[DiaryEntry]{
+		columns:  diaryColumns,
+		from:     "DIARY_ENTRY D JOIN MOVIE M ON M.movie_id = D.movie_id",
+		idColumn: "D.entry_id",
+		where:    conds,
+		args:     args,
+		scan: func(rows *sql.Rows) (DiaryEntry, error) {
+			return scanDiaryEntry(rows.Scan)
+		},
+		id: func(e DiaryEntry) int { return e.ID },
+	}, p)
+}
+
+// GetDiaryMonths counts the movies the user watched in each month they
+// logged any, newest month first.
+func (s *service) GetDiaryMonths(ctx context.Context, userID int) (_ []DiaryMonth, err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
+
+	// WatchedOn is a DATE in MySQL and text in SQLite; both give
+	// "2006-01" to SUBSTR.
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT SUBSTR(WatchedOn, 1, 7) AS WatchedMonth, COUNT(*) FROM DIARY_ENTRY
+		WHERE user_id = ?
+		GROUP BY WatchedMonth
+		ORDER BY WatchedMonth DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	months := []DiaryMonth{}
+	for rows.Next() {
+		var (
+			month string
+			m     DiaryMonth
+		)
+		if err := rows.Scan(&month, &m.Count); err != nil {
+			return nil, err
+		}
+		if _, err := fmt.Sscanf(month, "%4d-%2d", &m.Year, &m.Month); err != nil {
+			return nil, fmt.Errorf("diary month %q: %v", month, err)
+		}
+		months = append(months, m)
+	}
+	return months, rows.Err()
+}
+
+const diaryColumns = "D.entry_id, M.movie_id, M.Title, M.ReleaseDate, M.Genre, M.AvgRating, M.RatingCount, M.Slug, D.WatchedOn, D.Rewatch, D.Rating, D.review_id, D.CreatedAt"
+
+func getDiaryEntry(ctx context.Context, q rowQuerier, userID, entryID int) (DiaryEntry, error) {
+	row := q.QueryRowContext(ctx, "SELECT "+diaryColumns+`
+		FROM DIARY_ENTRY D JOIN MOVIE M ON M.movie_id = D.movie_id
+		WHERE D.entry_id = ? AND D.user_id = ?`, entryID, userID)
+	entry, err := scanDiaryEntry(row.Scan)
+	if errors.Is(err, sql.ErrNoRows) {
+		return DiaryEntry{}, fmt.Errorf("diary entry %d: %w", entryID, ErrNotFound)
+	}
+	return entry, err
+}
+
+// scanDiaryEntry reads the diaryColumns of a row with scan, which is the
+// Scan method of a *sql.Row or *sql.Rows.
+func scanDiaryEntry(scan func(dest ...interface{}) error) (DiaryEntry, error) {
+	var (
+		e                DiaryEntry
+		rating, reviewID sql.NullInt64
+	)
+	err := scan(&e.ID, &e.Id, &e.Title, &e.ReleaseDate, &e.Genre, &e.AvgRating, &e.ReviewCount, &e.Slug,
+		&e.WatchedOn, &e.Rewatch, &rating, &reviewID, &e.CreatedAt)
+	if err != nil {
+		return DiaryEntry{}, err
+	}
+	if rating.Valid {
+		v := int(rating.Int64)
+		e.Rating = &v
+	}
+	if reviewID.Valid {
+		v := int(reviewID.Int64)
+		e.ReviewID = &v
+	}
+	return e, nil
+}
+
+// checkDiaryReview makes sure that a review linked to a diary entry is the
+// user's review of the movie.
+func checkDiaryReview(ctx context.Context, q rowQuerier, userID, movieID int, reviewID *int) error {
+	if reviewID == nil || *reviewID == 0 {
+		return nil
+	}
+	var found int
+	err := q.QueryRowContext(ctx, `
+		SELECT R.review_id FROM REVIEW R JOIN WROTE W ON W.review_id = R.review_id
+		WHERE R.review_id = ? AND W.user_id = ? AND R.movie_id = ?`, *reviewID, userID, movieID).Scan(&found)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("review %d is not the user's review of movie %d: %w", *reviewID, movieID, ErrValidation)
+	}
+	return err
+}
@@ -0,0 +1,97 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/go-sql-driver/mysql"
+	"modernc.org/sqlite"
+)
+
+// Every Service method wraps one of these sentinels so that callers can tell
+// failures apart with errors.Is without depending on the SQL driver.
+var (
+	ErrNotFound     = errors.New("not found")
+	ErrConflict     = errors.New("already exists")
+	ErrValidation   = errors.New("validation failed")
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrForbidden    = errors.New("forbidden")
+	ErrUnavailable  = errors.New("service unavailable")
+	ErrTimeout      = errors.New("timed out")
+)
+
+// MySQL server error numbers we translate into sentinels.
+const (
+	mysqlDuplicateEntry    = 1062
+	mysqlRowIsReferenced   = 1451
+	mysqlNoReferencedRow   = 1452
+	mysqlDataTooLong       = 1406
+	mysqlTruncatedWrongVal = 1292
+)
+
+// SQLite extended result codes we translate into sentinels.
+const (
+	sqliteConstraintCheck      = 275
+	sqliteConstraintForeignKey = 787
+	sqliteConstraintNotNull    = 1299
+	sqliteConstraintPrimaryKey = 1555
+	sqliteConstraintUnique     = 2067
+)
+
+// DriverError is a driver error translated into a sentinel. Its message is
+// the sentinel's alone, since the driver's names tables, indexes and
+// columns that clients have no business seeing; Err keeps it for the logs.
+type DriverError struct {
+	Sentinel error
+	Err      error
+}
+
+func (e *DriverError) Error() string   { return e.Sentinel.Error() }
+func (e *DriverError) Unwrap() []error { return []error{e.Sentinel, e.Err} }
+
+// translateError maps driver errors onto the package sentinels, leaving
+// anything it doesn't recognise untouched.
+func translateError(err error) error {
+	var (
+		mysqlErr  *mysql.MySQLError
+		sqliteErr *sqlite.Error
+	)
+
+	switch {
+	case errors.As(err, &mysqlErr):
+		switch mysqlErr.Number {
+		case mysqlDuplicateEntry:
+			return &DriverError{ErrConflict, err}
+		case mysqlRowIsReferenced, mysqlNoReferencedRow, mysqlDataTooLong, mysqlTruncatedWrongVal:
+			return &DriverError{ErrValidation, err}
+		}
+	case errors.As(err, &sqliteErr):
+		switch sqliteErr.Code() {
+		case sqliteConstraintUnique, sqliteConstraintPrimaryKey:
+			return &DriverError{ErrConflict, err}
+		case sqliteConstraintForeignKey, sqliteConstraintNotNull, sqliteConstraintCheck:
+			return &DriverError{ErrValidation, err}
+		}
+	}
+
+	return err
+}
+
+// contextError reports failures caused by ctx ending as ErrTimeout when its
+// deadline passed and as ErrUnavailable when the caller cancelled it, so
+// that they aren't mistaken for internal errors.
+func contextError(ctx context.Context, err error) error {
+	if errors.Is(err, ErrTimeout) || errors.Is(err, ErrUnavailable) {
+		return err
+	}
+
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return fmt.Errorf("%w: %w", ErrTimeout, err)
+	case ctx.Err() != nil:
+		return fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+
+	return err
+}
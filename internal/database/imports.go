@@ -0,0 +1,394 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"lab2324omada7/internal/slug"
+)
+
+// Imports bring a user's history over from other sites. The parsing and the
+// work itself live in package imports, which records its progress here: an
+// import job per upload, with the rows that matched no movie, or several, so
+// that the user can resolve them by hand. Like the diary, imports are
+// private to the user who started them.
+
+// The states of an import job.
+const (
+	ImportRunning = "running"
+	ImportDone    = "done"
+	ImportFailed  = "failed"
+)
+
+// The kinds of ImportItem, after what they create.
+const (
+	ImportReview    = "review"
+	ImportLike      = "like"
+	ImportWatchlist = "watchlist"
+	ImportDiary     = "diary"
+)
+
+// ImportTimeout bounds how long an import may run. GetImport reports a job
+// still running after that as failed, since the server must have stopped
+// while it ran.
+const ImportTimeout = 30 * time.Minute
+
+// ImportJob is an import and how it went. Rows are those left for the user
+// to resolve, and those they resolved.
+type ImportJob struct {
+	ID         int         `json:"import_id"`
+	Source     string      `json:"source"`
+	Status     string      `json:"status"`
+	Error      string      `json:"error,omitempty"`
+	Imported   int         `json:"imported"`
+	Skipped    int         `json:"skipped"`
+	CreatedAt  int64       `json:"created_at"`
+	FinishedAt *int64      `json:"finished_at"` // null while running
+	Rows       []ImportRow `json:"rows"`
+}
+
+// ImportRow is a row of an uploaded file that couldn't be imported because
+// it matched no movie, or several. It is resolved when the user imports it
+// as a movie they picked, which becomes MovieID, or dismisses it.
+type ImportRow struct {
+	ID       int        `json:"row_id"`
+	File     string     `json:"file"`
+	Line     int        `json:"line"`
+	Item     ImportItem `json:"item"`
+	Reason   string     `json:"reason"`
+	Resolved bool       `json:"resolved"`
+	MovieID  *int       `json:"movie_id"` // null unless imported
+}
+
+// ImportItem is what a row of an uploaded file says about a movie.
+type ImportItem struct {
+	Kind      string `json:"kind"`
+	Title     string `json:"title"`
+	Year      int    `json:"year,omitempty"`
+	Stars     int    `json:"stars,omitempty"`      // 1 to 5; for a diary entry, 0 is no rating
+	Text      string `json:"text,omitempty"`       // reviews only
+	WatchedOn string `json:"watched_on,omitempty"` // diary entries only
+	Rewatch   bool   `json:"rewatch,omitempty"`    // diary entries only
+}
+
+// ImportResult is what FinishImport records at the end of an import. A
+// non-empty Error fails the job, but keeps what was imported up to then.
+type ImportResult struct {
+	Imported  int
+	Skipped   int
+	Unmatched []ImportRow // their IDs are assigned by FinishImport
+	Error     string
+}
+
+// maxImportError is how much of an error message FinishImport keeps.
+const maxImportError = 500
+
+// FindMovie looks up the movie a title and year from another site mean.
+// Titles match ignoring case and punctuation, as their slugs do. Of the
+// movies with the title, the one released in the year wins, then one
+// released a year before or after, since release dates differ between
+// countries; with no year, the title must be unique. No match is
+// ErrNotFound, and more than one ErrConflict.
+func (s *service) FindMovie(ctx context.Context, title string, year int) (_ Movie, err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
+
+	key := slug.Make(title)
+	if key == "" {
+		return Movie{}, fmt.Errorf("a title is required: %w", ErrValidation)
+	}
+
+	// The slugs of movies whose titles slug to key start with it, unless an
+	// editor picked another, which the title comparison catches.
+	rows, err := s.db.QueryContext(ctx, "SELECT "+movieColumns+` FROM MOVIE
+		WHERE Slug = ? OR Slug LIKE ? OR LOWER(Title) = LOWER(?)
+		ORDER BY movie_id`, key, key+"-%", strings.TrimSpace(title))
+	if err != nil {
+		return Movie{}, err
+	}
+	defer rows.Close()
+
+	var candidates []Movie
+	for rows.Next() {
+		var m Movie
+		if err := rows.Scan(&m.Id, &m.Title, &m.ReleaseDate, &m.Genre, &m.AvgRating, &m.ReviewCount, &m.Slug); err != nil {
+			return Movie{}, err
+		}
+		candidates = append(candidates, m)
+	}
+	if err := rows.Err(); err != nil {
+		return Movie{}, err
+	}
+	return pickMovie(candidates, title, year)
+}
+
+// pickMovie picks the movie FindMovie returns out of candidates, which
+// include every movie whose title matches.
+func pickMovie(candidates []Movie, title string, year int) (Movie, error) {
+	key := slug.Make(title)
+	var matches []Movie
+	for _, m := range candidates {
+		if slug.Make(m.Title) == key || strings.EqualFold(m.Title, strings.TrimSpace(title)) {
+			matches = append(matches, m)
+		}
+	}
+
+	if year != 0 {
+		if exact := releasedNear(matches, year, 0); len(exact) > 0 {
+			matches = exact
+		} else {
+			matches = releasedNear(matches, year, 1)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return Movie{}, fmt.Errorf("movie %q (%d): %w", title, year, ErrNotFound)
+	case 1:
+		return matches[0], nil
+	}
+	return Movie{}, fmt.Errorf("%d movies match %q (%d): %w", len(matches), title, year, ErrConflict)
+}
+
+// releasedNear returns the movies released at most within years from year.
+func releasedNear(movies []Movie, year, within int) []Movie {
+	var near []Movie
+	for _, m := range movies {
+		if released := releaseYear(m); released != 0 && released-year <= within && year-released <= within {
+			near = append(near, m)
+		}
+	}
+	return near
+}
+
+// releaseYear returns the year m came out, or 0 if it isn't known.
+func releaseYear(m Movie) int {
+	if len(m.ReleaseDate) < 4 {
+		return 0
+	}
+	year, err := strconv.Atoi(m.ReleaseDate[:4])
+	if err != nil {
+		return 0
+	}
+	return year
+}
+
+// CreateImport records that the user started an import from source.
+func (s *service) CreateImport(ctx context.Context, userID int, source string) (_ ImportJob, err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
+
+	result, err := s.db.ExecContext(ctx,
+		"INSERT INTO IMPORT_JOB (user_id, Source, Status, CreatedAt) VALUES (?, ?, ?, ?)",
+		userID, source, ImportRunning, time.Now().Unix())
+	if err != nil {
+		return ImportJob{}, translateError(err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return ImportJob{}, err
+	}
+	return getImport(ctx, s.db, userID, int(id))
+}
+
+// FinishImport records the outcome of a running import.
+func (s *service) FinishImport(ctx context.Context, jobID int, r ImportResult) (err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
+
+	status := ImportDone
+	if r.Error != "" {
+		status = ImportFailed
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE IMPORT_JOB SET Status = ?, Error = ?, Imported = ?, Skipped = ?, FinishedAt = ?
+		WHERE import_id = ? AND Status = ?`,
+		status, truncate(r.Error, maxImportError), r.Imported, r.Skipped, time.Now().Unix(), jobID, ImportRunning)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("running import %d: %w", jobID, ErrNotFound)
+	}
+
+	for _, row := range r.Unmatched {
+		item, err := json.Marshal(row.Item)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "INSERT INTO IMPORT_ROW (import_id, File, Line, Item, Reason) VALUES (?, ?, ?, ?, ?)",
+			jobID, truncate(row.File, 255), row.Line, string(item), truncate(row.Reason, 255))
+		if err != nil {
+			return translateError(err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *service) GetImport(ctx context.Context, userID, jobID int) (_ ImportJob, err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
+
+	return getImport(ctx, s.db, userID, jobID)
+}
+
+// ResolveImportRow marks a row of a finished import as resolved: imported
+// as the movie, or dismissed if movieID is 0. Importing it is up to the
+// caller, which resolves the row first, to claim it, and reopens it with
+// ReopenImportRow if the import fails. A row can only be resolved once, and
+// a second time, concurrent ones included, is ErrConflict.
+func (s *service) ResolveImportRow(ctx context.Context, userID, jobID, rowID, movieID int) (_ ImportRow, err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return ImportRow{}, err
+	}
+	defer tx.Rollback()
+
+	row, err := getImportRow(ctx, tx, userID, jobID, rowID, s.forUpdate())
+	if err != nil {
+		return ImportRow{}, err
+	}
+	if row.Resolved {
+		return ImportRow{}, fmt.Errorf("import row %d is already resolved: %w", rowID, ErrConflict)
+	}
+
+	result, err := tx.ExecContext(ctx, "UPDATE IMPORT_ROW SET Resolved = ?, movie_id = ? WHERE row_id = ? AND Resolved = ?",
+		true, nullIfZero(&movieID), rowID, false)
+	if err != nil {
+		return ImportRow{}, translateError(err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return ImportRow{}, err
+	} else if n == 0 {
+		return ImportRow{}, fmt.Errorf("import row %d is already resolved: %w", rowID, ErrConflict)
+	}
+	if row, err = getImportRow(ctx, tx, userID, jobID, rowID, ""); err != nil {
+		return ImportRow{}, err
+	}
+	return row, tx.Commit()
+}
+
+// ReopenImportRow undoes ResolveImportRow, for a row whose import failed.
+func (s *service) ReopenImportRow(ctx context.Context, userID, jobID, rowID int) (err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
+
+	if _, err := getImportRow(ctx, s.db, userID, jobID, rowID, ""); err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, "UPDATE IMPORT_ROW SET Resolved = ?, movie_id = NULL WHERE row_id = ?", false, rowID)
+	return err
+}
+
+// truncate shortens s to at most n bytes, without splitting a character.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// interrupted returns job as GetImport reports it: failed, if it has been
+// running for longer than ImportTimeout.
+func (job ImportJob) interrupted(now time.Time) ImportJob {
+	if job.Status == ImportRunning && now.Sub(time.Unix(job.CreatedAt, 0)) > ImportTimeout {
+		job.Status, job.Error = ImportFailed, "the import was interrupted"
+	}
+	return job
+}
+
+const importRowColumns = "row_id, File, Line, Item, Reason, Resolved, movie_id"
+
+func getImport(ctx context.Context, db *sql.DB, userID, jobID int) (ImportJob, error) {
+	var (
+		job        ImportJob
+		finishedAt sql.NullInt64
+	)
+	err := db.QueryRowContext(ctx, `
+		SELECT import_id, Source, Status, Error, Imported, Skipped, CreatedAt, FinishedAt FROM IMPORT_JOB
+		WHERE import_id = ? AND user_id = ?`, jobID, userID).
+		Scan(&job.ID, &job.Source, &job.Status, &job.Error, &job.Imported, &job.Skipped, &job.CreatedAt, &finishedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ImportJob{}, fmt.Errorf("import %d: %w", jobID, ErrNotFound)
+	}
+	if err != nil {
+		return ImportJob{}, err
+	}
+	if finishedAt.Valid {
+		job.FinishedAt = &finishedAt.Int64
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT "+importRowColumns+" FROM IMPORT_ROW WHERE import_id = ? ORDER BY row_id", jobID)
+	if err != nil {
+		return ImportJob{}, err
+	}
+	defer rows.Close()
+
+	job.Rows = []ImportRow{}
+	for rows.Next() {
+		row, err := scanImportRow(rows.Scan)
+		if err != nil {
+			return ImportJob{}, err
+		}
+		job.Rows = append(job.Rows, row)
+	}
+	if err := rows.Err(); err != nil {
+		return ImportJob{}, err
+	}
+	return job.interrupted(time.Now()), nil
+}
+
+// getImportRow returns a row of one of the user's finished imports.
+func getImportRow(ctx context.Context, q rowQuerier, userID, jobID, rowID int, suffix string) (ImportRow, error) {
+	row := q.QueryRowContext(ctx, "SELECT "+importRowColumns+` FROM IMPORT_ROW
+		WHERE row_id = ? AND import_id = (SELECT import_id FROM IMPORT_JOB WHERE import_id = ? AND user_id = ?)`+suffix,
+		rowID, jobID, userID)
+	r, err := scanImportRow(row.Scan)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ImportRow{}, fmt.Errorf("import row %d: %w", rowID, ErrNotFound)
+	}
+	return r, err
+}
+
+func scanImportRow(scan func(dest ...interface{}) error) (ImportRow, error) {
+	var (
+		r       ImportRow
+		item    string
+		movieID sql.NullInt64
+	)
+	if err := scan(&r.ID, &r.File, &r.Line, &item, &r.Reason, &r.Resolved, &movieID); err != nil {
+		return ImportRow{}, err
+	}
+	if err := json.Unmarshal([]byte(item), &r.Item); err != nil {
+		return ImportRow{}, fmt.Errorf("import row %d: %v", r.ID, err)
+	}
+	if movieID.Valid {
+		v := int(movieID.Int64)
+		r.MovieID = &v
+	}
+	return r, nil
+}
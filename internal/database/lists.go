@@ -0,0 +1,592 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"lab2324omada7/internal/config"
+)
+
+// Lists are named, ordered selections of movies that users curate, with a
+// note on each entry. A list is public, unlisted (shown only to whoever has
+// its link) or private (shown only to its owner and collaborators). The owner
+// may invite other users to collaborate on a list, which lets them add, move,
+// annotate and remove its entries; only the owner changes its title,
+// description and visibility, manages its collaborators or deletes it.
+//
+// Lists are known outside by a random key rather than by their row ID, so
+// that the link of an unlisted list can't be guessed. To users who may not
+// see a list, it doesn't exist: they get ErrNotFound, while those who may
+// see it but not make a change get ErrForbidden.
+
+// Visibilities of a list.
+const (
+	ListPublic   = "public"
+	ListUnlisted = "unlisted"
+	ListPrivate  = "private"
+)
+
+// MaxListEntries is how many movies a list holds.
+const MaxListEntries = 1000
+
+// ListSummary is a list without its entries, as lists of lists show it.
+type ListSummary struct {
+	ID          string `json:"list_id"`
+	Owner       string `json:"owner"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Visibility  string `json:"visibility"`
+	EntryCount  int    `json:"entry_count"`
+	CreatedAt   int64  `json:"created_at"`
+	UpdatedAt   int64  `json:"updated_at"`
+
+	rowID   int
+	ownerID int
+}
+
+// MovieList is a list with its entries, in order, and the usernames of its
+// collaborators.
+type MovieList struct {
+	ListSummary
+	Collaborators []string    `json:"collaborators"`
+	Entries       []ListEntry `json:"entries"`
+}
+
+// ListEntry is a movie on a list. Position counts from 1.
+type ListEntry struct {
+	Movie
+	Position int    `json:"position"`
+	Note     string `json:"note"`
+	AddedBy  string `json:"added_by"` // empty once the user is gone
+	AddedAt  int64  `json:"added_at"`
+}
+
+// ListFields are the details of a list that CreateList sets and UpdateList
+// changes; nil fields stay as they are. CreateList requires a Title and
+// makes lists public unless told otherwise.
+type ListFields struct {
+	Title       *string
+	Description *string
+	Visibility  *string
+}
+
+// EntryFields are what PutListEntry sets on an entry. A nil Note stays as it
+// is. A nil Position leaves an entry where it is, and puts a new one last; a
+// Position past the end also means last.
+type EntryFields struct {
+	Note     *string
+	Position *int
+}
+
+// ListFilter selects the lists GetLists returns. Without a MemberID, those
+// are public lists only.
+type ListFilter struct {
+	OwnerID int // 0 for lists of any owner
+	// MemberID selects the lists the user owns or collaborates on, whatever
+	// their visibility.
+	MemberID int
+}
+
+var listSorts = map[string]listSort[ListSummary]{
+	"title":   {"L.Title", func(l ListSummary) interface{} { return l.Title }},
+	"created": {"L.CreatedAt", func(l ListSummary) interface{} { return l.CreatedAt }},
+	"updated": {"L.UpdatedAt", func(l ListSummary) interface{} { return l.UpdatedAt }},
+}
+
+// listAccess is what a user may do with a list; each level allows what the
+// ones below it do.
+type listAccess int
+
+const (
+	listHidden listAccess = iota
+	listViewer
+	listEditor // collaborators
+	listOwner
+)
+
+func (l ListSummary) access(userID int, collaborator bool) listAccess {
+	switch {
+	case l.ownerID == userID:
+		return listOwner
+	case collaborator:
+		return listEditor
+	case l.Visibility != ListPrivate:
+		return listViewer
+	}
+	return listHidden
+}
+
+// allows returns nil if access is at least need, and otherwise the error
+// for the user who has it.
+func (l ListSummary) allows(access, need listAccess) error {
+	switch {
+	case access >= need:
+		return nil
+	case access == listHidden:
+		return fmt.Errorf("list %q: %w", l.ID, ErrNotFound)
+	case need == listOwner:
+		return fmt.Errorf("only the owner of list %q may do that: %w", l.ID, ErrForbidden)
+	}
+	return fmt.Errorf("only the owner and collaborators of list %q may change it: %w", l.ID, ErrForbidden)
+}
+
+// clean validates f and returns it with its text trimmed. required is set
+// when creating a list.
+func (f ListFields) clean(required bool) (ListFields, error) {
+	f = ListFields{Title: copyString(f.Title), Description: copyString(f.Description), Visibility: copyString(f.Visibility)}
+
+	c := fieldChecker{required: required}
+	c.text("Title", f.Title, 200)
+	c.optionalText("Description", f.Description, 2000)
+	if v := f.Visibility; v != nil && *v != ListPublic && *v != ListUnlisted && *v != ListPrivate {
+		c.problems = append(c.problems, fmt.Sprintf("Visibility must be %s, %s or %s, got %q", ListPublic, ListUnlisted, ListPrivate, *v))
+	}
+	return f, c.err()
+}
+
+func (f EntryFields) clean() (EntryFields, error) {
+	f.Note = copyString(f.Note)
+
+	c := fieldChecker{}
+	c.optionalText("Note", f.Note, 1000)
+	if f.Position != nil && *f.Position < 1 {
+		c.problems = append(c.problems, fmt.Sprintf("Position must be at least 1, got %d", *f.Position))
+	}
+	return f, c.err()
+}
+
+// GetLists lists the lists f selects, sorted by "title", "created" or
+// "updated".
+func (s *service) GetLists(ctx context.Context, f ListFilter, req PageRequest) (_ Page[ListSummary], err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
+
+	p, err := planPage(req, listSorts)
+	if err != nil {
+		return Page[ListSummary]{}, err
+	}
+
+	var (
+		conds []string
+		args  []interface{}
+	)
+	if f.OwnerID != 0 {
+		conds = append(conds, "L.owner_id = ?")
+		args = append(args, f.OwnerID)
+	}
+	if f.MemberID != 0 {
+		conds = append(conds, "(L.owner_id = ? OR EXISTS (SELECT 1 FROM LIST_COLLABORATOR C WHERE C.list_id = L.list_id AND C.user_id = ?))")
+		args = append(args, f.MemberID, f.MemberID)
+	} else {
+		conds = append(conds, "L.Visibility = ?")
+		args = append(args, ListPublic)
+	}
+	conds = append(conds, "U.DeleteAfter IS NULL")
+
+	return fetchPage(ctx, s.db, listQuery[ListSummary]{
+		columns:  listColumns,
+		from:     "MOVIE_LIST L JOIN USER U ON U.user_id = L.owner_id",
+		idColumn: "L.list_id",
+		where:    conds,
+		args:     args,
+		scan: func(rows *sql.Rows) (l ListSummary, err error) {
+			err = rows.Scan(&l.rowID, &l.ID, &l.ownerID, &l.Owner, &l.Title, &l.Description, &l.Visibility, &l.CreatedAt, &l.UpdatedAt, &l.EntryCount)
+			return l, err
+		},
+		id: func(l ListSummary) int { return l.rowID },
+	}, p)
+}
+
+const listColumns = `L.list_id, L.ListKey, L.owner_id, U.Username, L.Title, L.Description, L.Visibility, L.CreatedAt, L.UpdatedAt,
+	(SELECT COUNT(*) FROM LIST_ENTRY E WHERE E.list_id = L.list_id)`
+
+// GetList returns the list with the key, if userID may see it. userID is 0
+// for anonymous requests.
+func (s *service) GetList(ctx context.Context, userID int, key string) (_ MovieList, err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
+
+	l, access, err := getListSummary(ctx, s.db, key, userID)
+	if err != nil {
+		return MovieList{}, err
+	}
+	if err := l.allows(access, listViewer); err != nil {
+		return MovieList{}, err
+	}
+	return getListDetails(ctx, s.db, l)
+}
+
+func (s *service) CreateList(ctx context.Context, ownerID int, f ListFields) (_ MovieList, err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
+
+	if f, err = f.clean(true); err != nil {
+		return MovieList{}, err
+	}
+	visibility := ListPublic
+	setIfGiven(&visibility, f.Visibility)
+	description := ""
+	setIfGiven(&description, f.Description)
+
+	key, err := randomToken(16)
+	if err != nil {
+		return MovieList{}, err
+	}
+	now := time.Now().Unix()
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO MOVIE_LIST (ListKey, owner_id, Title, Description, Visibility, CreatedAt, UpdatedAt)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`, key, ownerID, *f.Title, description, visibility, now, now)
+	if err != nil {
+		return MovieList{}, translateError(err)
+	}
+
+	l, _, err := getListSummary(ctx, s.db, key, ownerID)
+	if err != nil {
+		return MovieList{}, err
+	}
+	return getListDetails(ctx, s.db, l)
+}
+
+// UpdateList changes the list's title, description or visibility. Only its
+// owner may.
+func (s *service) UpdateList(ctx context.Context, userID int, key string, f ListFields) (_ MovieList, err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
+
+	if f, err = f.clean(false); err != nil {
+		return MovieList{}, err
+	}
+
+	return s.changeList(ctx, userID, key, listOwner, func(tx *sql.Tx, l ListSummary) error {
+		values := assignments([]string{"Title", "Description", "Visibility"}, f.Title, f.Description, f.Visibility)
+		if len(values) == 0 {
+			return nil
+		}
+		return s.update(ctx, tx, listTable, l.rowID, append(values, assignment{"UpdatedAt", time.Now().Unix()}))
+	})
+}
+
+// DeleteList deletes the list with its entries. Only its owner may.
+func (s *service) DeleteList(ctx context.Context, userID int, key string) (err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	l, err := s.lockList(ctx, tx, key, userID, listOwner)
+	if err != nil {
+		return err
+	}
+	// LIST_ENTRY and LIST_COLLABORATOR rows go with the list through their
+	// ON DELETE CASCADE foreign keys.
+	if err := s.delete(ctx, tx, listTable, l.rowID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// PutListEntry adds the movie to the list, or changes its entry if it's
+// there, and returns the list. The owner and collaborators may.
+func (s *service) PutListEntry(ctx context.Context, userID int, key string, movieID int, f EntryFields) (_ MovieList, err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
+
+	if f, err = f.clean(); err != nil {
+		return MovieList{}, err
+	}
+
+	return s.changeList(ctx, userID, key, listEditor, func(tx *sql.Tx, l ListSummary) error {
+		order, err := listOrder(ctx, tx, l.rowID)
+		if err != nil {
+			return err
+		}
+
+		i := order.index(movieID)
+		switch {
+		case i >= 0 && f.Note != nil:
+			_, err = tx.ExecContext(ctx, "UPDATE LIST_ENTRY SET Note = ? WHERE list_id = ? AND movie_id = ?", *f.Note, l.rowID, movieID)
+		case i < 0 && len(order) >= MaxListEntries:
+			return fmt.Errorf("list %q already has %d movies, the most a list may have: %w", l.ID, len(order), ErrConflict)
+		case i < 0:
+			note := ""
+			setIfGiven(&note, f.Note)
+			position := len(order) + 1
+			if len(order) > 0 {
+				position = order[len(order)-1].position + 1
+			}
+			_, err = tx.ExecContext(ctx, `
+				INSERT INTO LIST_ENTRY (list_id, movie_id, Position, Note, added_by, AddedAt)
+				VALUES (?, ?, ?, ?, ?, ?)`, l.rowID, movieID, position, note, userID, time.Now().Unix())
+			err = translateError(err)
+			order = append(order, listPosition{movieID, position})
+			i = len(order) - 1
+		}
+		if err != nil {
+			return err
+		}
+
+		if f.Position != nil {
+			order.move(i, *f.Position-1)
+			if err := order.renumber(ctx, tx, l.rowID); err != nil {
+				return err
+			}
+		}
+		return touchList(ctx, tx, l.rowID)
+	})
+}
+
+// RemoveListEntry takes the movie off the list, if it's on it, and returns
+// the list. The owner and collaborators may.
+func (s *service) RemoveListEntry(ctx context.Context, userID int, key string, movieID int) (_ MovieList, err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
+
+	return s.changeList(ctx, userID, key, listEditor, func(tx *sql.Tx, l ListSummary) error {
+		result, err := tx.ExecContext(ctx, "DELETE FROM LIST_ENTRY WHERE list_id = ? AND movie_id = ?", l.rowID, movieID)
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err != nil || n == 0 {
+			return err
+		}
+		return touchList(ctx, tx, l.rowID)
+	})
+}
+
+// AddCollaborator lets another user edit the list's entries. Only the owner
+// may invite collaborators; inviting one twice changes nothing.
+func (s *service) AddCollaborator(ctx context.Context, userID int, key string, collaboratorID int) (err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
+
+	_, err = s.changeList(ctx, userID, key, listOwner, func(tx *sql.Tx, l ListSummary) error {
+		if collaboratorID == l.ownerID {
+			return fmt.Errorf("the owner of list %q can't be a collaborator on it: %w", l.ID, ErrValidation)
+		}
+		onConflict := " ON DUPLICATE KEY UPDATE user_id = user_id"
+		if s.driver == config.DriverSQLite {
+			onConflict = " ON CONFLICT DO NOTHING"
+		}
+		_, err := tx.ExecContext(ctx, "INSERT INTO LIST_COLLABORATOR (list_id, user_id, AddedAt) VALUES (?, ?, ?)"+onConflict,
+			l.rowID, collaboratorID, time.Now().Unix())
+		return translateError(err)
+	})
+	return err
+}
+
+// RemoveCollaborator stops a collaborator from editing the list. The owner
+// may remove anyone, and collaborators themselves.
+func (s *service) RemoveCollaborator(ctx context.Context, userID int, key string, collaboratorID int) (err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
+
+	need := listOwner
+	if userID == collaboratorID {
+		need = listEditor
+	}
+	_, err = s.changeList(ctx, userID, key, need, func(tx *sql.Tx, l ListSummary) error {
+		_, err := tx.ExecContext(ctx, "DELETE FROM LIST_COLLABORATOR WHERE list_id = ? AND user_id = ?", l.rowID, collaboratorID)
+		return err
+	})
+	return err
+}
+
+// listTable lets the list methods share the catalog's update and delete
+// helpers.
+var listTable = catalogTable{"MOVIE_LIST", "list", "list_id", "Title"}
+
+// changeList runs change in a transaction holding the list's lock, if userID
+// has the access it needs, and returns the list as it then is.
+func (s *service) changeList(ctx context.Context, userID int, key string, need listAccess, change func(*sql.Tx, ListSummary) error) (MovieList, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return MovieList{}, err
+	}
+	defer tx.Rollback()
+
+	l, err := s.lockList(ctx, tx, key, userID, need)
+	if err != nil {
+		return MovieList{}, err
+	}
+	if err := change(tx, l); err != nil {
+		return MovieList{}, err
+	}
+
+	l, access, err := getListSummary(ctx, tx, key, userID)
+	if err != nil {
+		return MovieList{}, err
+	}
+	// A collaborator who left a private list may no longer see it.
+	if access == listHidden {
+		return MovieList{}, tx.Commit()
+	}
+	list, err := getListDetails(ctx, tx, l)
+	if err != nil {
+		return MovieList{}, err
+	}
+	return list, tx.Commit()
+}
+
+// lockList locks the list with the key for the rest of tx and returns it, if
+// userID has the access they need.
+func (s *service) lockList(ctx context.Context, tx *sql.Tx, key string, userID int, need listAccess) (ListSummary, error) {
+	var rowID int
+	err := tx.QueryRowContext(ctx, "SELECT list_id FROM MOVIE_LIST WHERE ListKey = ?"+s.forUpdate(), key).Scan(&rowID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ListSummary{}, fmt.Errorf("list %q: %w", key, ErrNotFound)
+	}
+	if err != nil {
+		return ListSummary{}, err
+	}
+
+	l, access, err := getListSummary(ctx, tx, key, userID)
+	if err != nil {
+		return ListSummary{}, err
+	}
+	return l, l.allows(access, need)
+}
+
+// getListSummary returns the list with the key and the access userID has to
+// it.
+func getListSummary(ctx context.Context, q rowQuerier, key string, userID int) (ListSummary, listAccess, error) {
+	var (
+		l            ListSummary
+		collaborator bool
+	)
+	err := q.QueryRowContext(ctx, `
+		SELECT `+listColumns+`,
+			EXISTS (SELECT 1 FROM LIST_COLLABORATOR C WHERE C.list_id = L.list_id AND C.user_id = ?)
+		FROM MOVIE_LIST L JOIN USER U ON U.user_id = L.owner_id
+		WHERE L.ListKey = ? AND U.DeleteAfter IS NULL`, userID, key).
+		Scan(&l.rowID, &l.ID, &l.ownerID, &l.Owner, &l.Title, &l.Description, &l.Visibility, &l.CreatedAt, &l.UpdatedAt, &l.EntryCount, &collaborator)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ListSummary{}, listHidden, fmt.Errorf("list %q: %w", key, ErrNotFound)
+	}
+	if err != nil {
+		return ListSummary{}, listHidden, err
+	}
+	return l, l.access(userID, collaborator), nil
+}
+
+// getListDetails adds the entries and collaborators of the list to it.
+func getListDetails(ctx context.Context, q querier, l ListSummary) (MovieList, error) {
+	list := MovieList{ListSummary: l, Collaborators: []string{}, Entries: []ListEntry{}}
+
+	rows, err := q.QueryContext(ctx, `
+		SELECT M.movie_id, M.Title, M.ReleaseDate, M.Genre, M.AvgRating, M.RatingCount, M.Slug, E.Note, COALESCE(U.Username, ''), E.AddedAt
+		FROM LIST_ENTRY E JOIN MOVIE M ON M.movie_id = E.movie_id LEFT JOIN USER U ON U.user_id = E.added_by AND U.DeleteAfter IS NULL
+		WHERE E.list_id = ?
+		ORDER BY E.Position, E.movie_id`, l.rowID)
+	if err != nil {
+		return MovieList{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		e := ListEntry{Position: len(list.Entries) + 1}
+		if err := rows.Scan(&e.Id, &e.Title, &e.ReleaseDate, &e.Genre, &e.AvgRating, &e.ReviewCount, &e.Slug, &e.Note, &e.AddedBy, &e.AddedAt); err != nil {
+			return MovieList{}, err
+		}
+		list.Entries = append(list.Entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return MovieList{}, err
+	}
+
+	rows, err = q.QueryContext(ctx, `
+		SELECT U.Username FROM LIST_COLLABORATOR C JOIN USER U ON U.user_id = C.user_id
+		WHERE C.list_id = ? AND U.DeleteAfter IS NULL
+		ORDER BY C.AddedAt, C.user_id`, l.rowID)
+	if err != nil {
+		return MovieList{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var username string
+		if err := rows.Scan(&username); err != nil {
+			return MovieList{}, err
+		}
+		list.Collaborators = append(list.Collaborators, username)
+	}
+	if err := rows.Err(); err != nil {
+		return MovieList{}, err
+	}
+
+	return list, nil
+}
+
+func touchList(ctx context.Context, tx *sql.Tx, rowID int) error {
+	_, err := tx.ExecContext(ctx, "UPDATE MOVIE_LIST SET UpdatedAt = ? WHERE list_id = ?", time.Now().Unix(), rowID)
+	return err
+}
+
+// listPosition is where an entry is stored in its list's order. Entries are
+// shown numbered from 1, but the stored positions may have gaps, as entries
+// are removed without moving the others.
+type listPosition struct {
+	movieID  int
+	position int
+}
+
+type listOrdering []listPosition
+
+func listOrder(ctx context.Context, tx *sql.Tx, rowID int) (listOrdering, error) {
+	rows, err := tx.QueryContext(ctx, "SELECT movie_id, Position FROM LIST_ENTRY WHERE list_id = ? ORDER BY Position, movie_id", rowID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var order listOrdering
+	for rows.Next() {
+		var p listPosition
+		if err := rows.Scan(&p.movieID, &p.position); err != nil {
+			return nil, err
+		}
+		order = append(order, p)
+	}
+	return order, rows.Err()
+}
+
+func (o listOrdering) index(movieID int) int {
+	for i, p := range o {
+		if p.movieID == movieID {
+			return i
+		}
+	}
+	return -1
+}
+
+// move moves the entry at index from to index to, or to the end if to is
+// past it.
+func (o listOrdering) move(from, to int) {
+	to = min(to, len(o)-1)
+	p := o[from]
+	if from < to {
+		copy(o[from:to], o[from+1:to+1])
+	} else {
+		copy(o[to+1:from+1], o[to:from])
+	}
+	o[to] = p
+}
+
+// renumber stores positions 1, 2, ... in the order of o, updating only the
+// entries whose position changes.
+func (o listOrdering) renumber(ctx context.Context, tx *sql.Tx, rowID int) error {
+	for i, p := range o {
+		if p.position == i+1 {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE LIST_ENTRY SET Position = ? WHERE list_id = ? AND movie_id = ?", i+1, rowID, p.movieID); err != nil {
+			return err
+		}
+		o[i].position = i + 1
+	}
+	return nil
+}
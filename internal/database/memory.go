@@ -0,0 +1,2088 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"lab2324omada7/internal/seed"
+	"lab2324omada7/internal/slug"
+)
+
+var _ Service = (*Memory)(nil)
+
+// Memory is an in-memory Service. It behaves like the SQL implementation,
+// including its errors, and is meant for handler tests that shouldn't depend
+// on a database. It is safe for concurrent use. Nothing it does blocks, so the
+// contexts its methods take are ignored.
+type Memory struct {
+	mu     sync.RWMutex
+	jwtKey []byte
+
+	movies    map[int]Movie
+	directors map[int]Director
+	actors    map[int]Actor
+	directed  map[int][]int   // movie ID -> director IDs
+	acted     map[int][]int   // movie ID -> actor IDs
+	users     map[int]User    // Password holds the bcrypt hash
+	profiles  map[int]Profile // user ID -> profile; users without one have the defaults
+	reviews   map[int]memoryReview
+	helpful   map[memoryVote]time.Time
+	likes     map[memoryEntry]time.Time
+	watchlist map[memoryEntry]time.Time
+	tokens    map[string]*memoryRefreshToken // keyed by token hash
+	roles     map[int]map[string]bool        // user ID -> granted roles
+	audit     []RoleChange                   // oldest first
+	lists     map[int]*memoryList            // keyed by row ID
+	diary     map[int]memoryDiaryEntry       // keyed by entry ID
+	imports   map[int]*memoryImport
+	deletions map[int]time.Time // user ID -> when DeleteAccount scheduled the account to go
+
+	nextMovieID    int
+	nextActorID    int
+	nextDirectorID int
+	nextUserID     int
+	nextReviewID   int
+	nextListID     int
+	nextDiaryID    int
+	nextImportID   int
+	nextImportRow  int
+}
+
+type memoryReview struct {
+	Review
+	userID int
+}
+
+type memoryEntry struct {
+	userID  int
+	movieID int
+}
+
+// memoryVote is a user finding a review helpful.
+type memoryVote struct {
+	userID   int
+	reviewID int
+}
+
+// memoryList is a list with its entries in order and its collaborators in
+// the order they were invited. Its EntryCount and Owner are left empty.
+type memoryList struct {
+	ListSummary
+	entries       []memoryListEntry
+	collaborators []int
+}
+
+type memoryListEntry struct {
+	movieID int
+	note    string
+	addedBy int
+	addedAt int64
+}
+
+// memoryDiaryEntry is a DiaryEntry; a rating or review ID of 0 is none.
+type memoryDiaryEntry struct {
+	userID    int
+	movieID   int
+	watchedOn string
+	rewatch   bool
+	rating    int
+	reviewID  int
+	createdAt int64
+}
+
+// set applies the Rating and ReviewID of f to e.
+func (e *memoryDiaryEntry) set(f DiaryFields) {
+	if f.Rating != nil {
+		e.rating = *f.Rating
+	}
+	if f.ReviewID != nil {
+		e.reviewID = *f.ReviewID
+	}
+}
+
+// memoryImport is an ImportJob, which keeps its rows, and whose it is.
+type memoryImport struct {
+	ImportJob
+	userID int
+}
+
+type memoryRefreshToken struct {
+	userID    int
+	familyID  string
+	expiresAt time.Time
+	revoked   bool
+}
+
+// NewMemory returns an empty Memory that signs access tokens with jwtKey.
+func NewMemory(jwtKey []byte) *Memory {
+	return &Memory{
+		jwtKey:         jwtKey,
+		movies:         make(map[int]Movie),
+		directors:      make(map[int]Director),
+		actors:         make(map[int]Actor),
+		directed:       make(map[int][]int),
+		acted:          make(map[int][]int),
+		users:          make(map[int]User),
+		profiles:       make(map[int]Profile),
+		reviews:        make(map[int]memoryReview),
+		helpful:        make(map[memoryVote]time.Time),
+		likes:          make(map[memoryEntry]time.Time),
+		watchlist:      make(map[memoryEntry]time.Time),
+		tokens:         make(map[string]*memoryRefreshToken),
+		roles:          make(map[int]map[string]bool),
+		lists:          make(map[int]*memoryList),
+		diary:          make(map[int]memoryDiaryEntry),
+		imports:        make(map[int]*memoryImport),
+		deletions:      make(map[int]time.Time),
+		nextMovieID:    1,
+		nextActorID:    1,
+		nextDirectorID: 1,
+		nextUserID:     1,
+		nextReviewID:   1,
+		nextListID:     1,
+		nextDiaryID:    1,
+		nextImportID:   1,
+		nextImportRow:  1,
+	}
+}
+
+// Load adds the fixtures to m, replacing rows with the same IDs, just like
+// seed.Apply does for a real database.
+func (m *Memory) Load(f *seed.Fixtures) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, d := range f.Directors {
+		m.directors[d.ID] = Director{ID: d.ID, Name: d.Name, Dob: d.DateOfBirth, Nationality: d.Nationality, Slug: d.Slug}
+		m.nextDirectorID = max(m.nextDirectorID, d.ID+1)
+	}
+	for _, a := range f.Actors {
+		m.actors[a.ID] = Actor{ID: a.ID, Name: a.Name, Dob: a.DateOfBirth, Nationality: a.Nationality, Slug: a.Slug}
+		m.nextActorID = max(m.nextActorID, a.ID+1)
+	}
+	for _, mv := range f.Movies {
+		m.movies[mv.ID] = Movie{Id: mv.ID, Title: mv.Title, ReleaseDate: mv.ReleaseDate, Genre: mv.Genre, Slug: mv.Slug}
+		m.directed[mv.ID] = append([]int(nil), mv.Directors...)
+		m.acted[mv.ID] = append([]int(nil), mv.Actors...)
+		m.nextMovieID = max(m.nextMovieID, mv.ID+1)
+	}
+	for _, u := range f.Users {
+		// The minimum cost keeps tests fast; nothing here is a real secret.
+		hash, err := bcrypt.GenerateFromPassword([]byte(u.Password), bcrypt.MinCost)
+		if err != nil {
+			return err
+		}
+		m.users[u.ID] = User{ID: u.ID, Username: u.Username, Email: u.Email, Password: string(hash)}
+		for _, role := range u.Roles {
+			m.grant(u.ID, role)
+		}
+		if u.ID >= m.nextUserID {
+			m.nextUserID = u.ID + 1
+		}
+	}
+	for _, r := range f.Reviews {
+		m.reviews[r.ID] = memoryReview{
+			Review: Review{Id: r.ID, Stars: r.Stars, Review: r.Text, DatePosted: r.DatePosted, MovieId: strconv.Itoa(r.MovieID)},
+			userID: r.UserID,
+		}
+		if r.ID >= m.nextReviewID {
+			m.nextReviewID = r.ID + 1
+		}
+	}
+	for _, e := range f.Likes {
+		m.likes[memoryEntry{e.UserID, e.MovieID}] = parseDateAdded(e.DateAdded)
+	}
+	for _, e := range f.Watchlist {
+		m.watchlist[memoryEntry{e.UserID, e.MovieID}] = parseDateAdded(e.DateAdded)
+	}
+
+	for id := range m.movies {
+		m.updateAvgRating(id)
+	}
+	return nil
+}
+
+func (m *Memory) Health(ctx context.Context) (map[string]string, error) {
+	return map[string]string{
+		"message": "It's healthy",
+	}, nil
+}
+
+func (m *Memory) GetUserID(ctx context.Context, username string) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	user, ok := m.userByName(username)
+	if !ok || m.leaving(user.ID) {
+		return -1, fmt.Errorf("user %q: %w", username, ErrNotFound)
+	}
+	return user.ID, nil
+}
+
+func (m *Memory) GetMovies(ctx context.Context, f MovieFilter, req PageRequest) (Page[Movie], error) {
+	p, err := planPage(req, movieSorts)
+	if err != nil {
+		return Page[Movie]{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	movies := []Movie{}
+	for _, movie := range m.movies {
+		if f.match(movie) {
+			movies = append(movies, movie)
+		}
+	}
+	return pageIn(movies, func(m Movie) int { return m.Id }, p), nil
+}
+
+func (m *Memory) GetMovie(ctx context.Context, ref string) (Movie, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.movieByRef(ref)
+}
+
+func (m *Memory) GetDirectors(ctx context.Context, f PersonFilter, req PageRequest) (Page[Director], error) {
+	p, err := planPage(req, directorSorts)
+	if err != nil {
+		return Page[Director]{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	directors := []Director{}
+	for _, director := range m.directors {
+		if f.match(director.Nationality) {
+			directors = append(directors, director)
+		}
+	}
+	return pageIn(directors, func(d Director) int { return d.ID }, p), nil
+}
+
+func (m *Memory) GetDirector(ctx context.Context, ref string) (Director, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	director, ok := findByRefIn(m.directors, ref, func(p Director) (string, string) { return p.Slug, p.Name })
+	if !ok {
+		return Director{}, fmt.Errorf("director %q: %w", ref, ErrNotFound)
+	}
+	return director, nil
+}
+
+func (m *Memory) GetActors(ctx context.Context, f PersonFilter, req PageRequest) (Page[Actor], error) {
+	p, err := planPage(req, actorSorts)
+	if err != nil {
+		return Page[Actor]{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	actors := []Actor{}
+	for _, actor := range m.actors {
+		if f.match(actor.Nationality) {
+			actors = append(actors, actor)
+		}
+	}
+	return pageIn(actors, func(a Actor) int { return a.ID }, p), nil
+}
+
+func (m *Memory) GetActor(ctx context.Context, ref string) (Actor, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	actor, ok := findByRefIn(m.actors, ref, func(p Actor) (string, string) { return p.Slug, p.Name })
+	if !ok {
+		return Actor{}, fmt.Errorf("actor %q: %w", ref, ErrNotFound)
+	}
+	return actor, nil
+}
+
+func (m *Memory) GetStaffByMovieID(ctx context.Context, movieID int) ([]StaffMember, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	movie, ok := m.movies[movieID]
+	if !ok {
+		return nil, nil
+	}
+
+	var staff []StaffMember
+	for _, id := range m.acted[movieID] {
+		staff = append(staff, StaffMember{ID: movieID, MTitle: movie.Title, TypeID: id, Name: m.actors[id].Name, Slug: m.actors[id].Slug, Role: "Actor"})
+	}
+	for _, id := range m.directed[movieID] {
+		staff = append(staff, StaffMember{ID: movieID, MTitle: movie.Title, TypeID: id, Name: m.directors[id].Name, Slug: m.directors[id].Slug, Role: "Director"})
+	}
+	return staff, nil
+}
+
+func (m *Memory) GetMoviesByDirectorID(ctx context.Context, directorID int) ([]DirectedMovie, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	director, ok := m.directors[directorID]
+	if !ok {
+		return nil, nil
+	}
+
+	var movies []DirectedMovie
+	for _, id := range sortedKeys(m.movies) {
+		if containsInt(m.directed[id], directorID) {
+			movies = append(movies, DirectedMovie{
+				Movie:        m.movies[id],
+				DirectorID:   director.ID,
+				DirectorName: director.Name,
+				DirectorDob:  director.Dob,
+				Nationality:  director.Nationality,
+			})
+		}
+	}
+	return movies, nil
+}
+
+func (m *Memory) GetMoviesByActorID(ctx context.Context, actorID int) ([]ActedMovie, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	actor, ok := m.actors[actorID]
+	if !ok {
+		return nil, nil
+	}
+
+	var movies []ActedMovie
+	for _, id := range sortedKeys(m.movies) {
+		if containsInt(m.acted[id], actorID) {
+			movies = append(movies, ActedMovie{
+				Movie:       m.movies[id],
+				ActorID:     actor.ID,
+				ActorName:   actor.Name,
+				ActorDob:    actor.Dob,
+				Nationality: actor.Nationality,
+			})
+		}
+	}
+	return movies, nil
+}
+
+func (m *Memory) ShowReview(ctx context.Context, ref string, f ReviewFilter, req PageRequest) (Page[MovieReview], error) {
+	if err := f.check(); err != nil {
+		return Page[MovieReview]{}, err
+	}
+	p, err := planPage(req, reviewSorts)
+	if err != nil {
+		return Page[MovieReview]{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	movie, err := m.movieByRef(ref)
+	if err != nil {
+		return Page[MovieReview]{}, err
+	}
+
+	reviews := []MovieReview{}
+	for _, r := range m.reviews {
+		if r.MovieId == strconv.Itoa(movie.Id) && f.match(r) && !m.leaving(r.userID) {
+			reviews = append(reviews, m.movieReview(r))
+		}
+	}
+	return pageIn(reviews, func(r MovieReview) int { return r.Id }, p), nil
+}
+
+func (m *Memory) GetMovieReview(ctx context.Context, userID, movieID int) (MovieReview, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, r := range m.reviews {
+		if r.userID == userID && r.MovieId == strconv.Itoa(movieID) {
+			return m.movieReview(r), nil
+		}
+	}
+	return MovieReview{}, fmt.Errorf("review of movie %d by user %d: %w", movieID, userID, ErrNotFound)
+}
+
+func (m *Memory) SetHelpful(ctx context.Context, userID, reviewID int, helpful bool) (ReviewVote, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r, ok := m.reviews[reviewID]
+	if !ok {
+		return ReviewVote{}, fmt.Errorf("review %d: %w", reviewID, ErrNotFound)
+	}
+	if r.userID == userID {
+		return ReviewVote{}, fmt.Errorf("you can't mark your own review helpful: %w", ErrValidation)
+	}
+
+	vote := memoryVote{userID: userID, reviewID: reviewID}
+	if _, ok := m.helpful[vote]; helpful && !ok {
+		m.helpful[vote] = time.Now()
+	} else if !helpful {
+		delete(m.helpful, vote)
+	}
+	return ReviewVote{ReviewID: reviewID, Helpful: helpful, HelpfulCount: m.movieReview(r).HelpfulCount}, nil
+}
+
+// movieReview returns r with its author and helpful votes.
+func (m *Memory) movieReview(r memoryReview) MovieReview {
+	author, _ := m.profile(r.userID)
+	review := MovieReview{
+		Review: r.Review,
+		Author: ReviewAuthor{Username: author.Username, DisplayName: author.DisplayName, AvatarURL: author.AvatarURL},
+	}
+	for vote := range m.helpful {
+		if vote.reviewID == r.Id {
+			review.HelpfulCount++
+		}
+	}
+	return review
+}
+
+// dropVotes removes the helpful votes on reviews that are gone, and those of
+// the user, if userID isn't 0.
+func (m *Memory) dropVotes(userID int) {
+	for vote := range m.helpful {
+		if _, ok := m.reviews[vote.reviewID]; !ok || vote.userID == userID {
+			delete(m.helpful, vote)
+		}
+	}
+}
+
+func (m *Memory) Search(ctx context.Context, query string, limit int) ([]SearchResult, error) {
+	terms, limit, err := checkSearch(query, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var texts []searchable
+	for _, id := range sortedKeys(m.movies) {
+		movie := m.movies[id]
+		texts = append(texts, searchable{SearchResult{Type: SearchMovie, ID: id, Slug: movie.Slug, Title: movie.Title}, movie.Title})
+	}
+	for _, id := range sortedKeys(m.actors) {
+		actor := m.actors[id]
+		texts = append(texts, searchable{SearchResult{Type: SearchActor, ID: id, Slug: actor.Slug, Title: actor.Name}, actor.Name})
+	}
+	for _, id := range sortedKeys(m.directors) {
+		director := m.directors[id]
+		texts = append(texts, searchable{SearchResult{Type: SearchDirector, ID: id, Slug: director.Slug, Title: director.Name}, director.Name})
+	}
+	for _, id := range sortedKeys(m.reviews) {
+		r := m.reviews[id]
+		movieID, _ := strconv.Atoi(r.MovieId)
+		movie := m.movies[movieID]
+		texts = append(texts, searchable{SearchResult{Type: SearchReview, ID: id, Slug: movie.Slug, Title: movie.Title}, r.Review.Review})
+	}
+	return searchIn(texts, terms, limit), nil
+}
+
+func (m *Memory) AddReview(ctx context.Context, ref string, stars int, reviewText string, userID int) error {
+	if stars < 1 || stars > 5 {
+		return fmt.Errorf("rating must be between 1 and 5 stars, got %d: %w", stars, ErrValidation)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	movie, err := m.movieByRef(ref)
+	if err != nil {
+		return err
+	}
+	if _, ok := m.users[userID]; !ok {
+		return fmt.Errorf("user %d: %w", userID, ErrValidation)
+	}
+
+	review := memoryReview{
+		Review: Review{Stars: stars, Review: reviewText, DatePosted: time.Now().Format("2006-01-02"), MovieId: strconv.Itoa(movie.Id)},
+		userID: userID,
+	}
+
+	// Like the SQL implementation, a second review of the same movie
+	// replaces the user's latest one.
+	review.Id = -1
+	for _, id := range sortedKeys(m.reviews) {
+		if r := m.reviews[id]; r.userID == userID && r.MovieId == review.MovieId {
+			review.Id = id
+		}
+	}
+	if review.Id < 0 {
+		review.Id = m.nextReviewID
+		m.nextReviewID++
+	}
+
+	m.reviews[review.Id] = review
+	m.updateAvgRating(movie.Id)
+	return nil
+}
+
+func (m *Memory) DeleteReview(ctx context.Context, reviewID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	review, ok := m.reviews[reviewID]
+	if !ok {
+		return fmt.Errorf("review %d: %w", reviewID, ErrNotFound)
+	}
+
+	delete(m.reviews, reviewID)
+	m.dropVotes(0)
+	for id, e := range m.diary {
+		if e.reviewID == reviewID {
+			e.reviewID = 0
+			m.diary[id] = e
+		}
+	}
+	movieID, _ := strconv.Atoi(review.MovieId)
+	m.updateAvgRating(movieID)
+	return nil
+}
+
+func (m *Memory) RegisterUser(ctx context.Context, username string, password string, email string) (TokenPair, error) {
+	if strings.TrimSpace(username) == "" || password == "" {
+		return TokenPair{}, fmt.Errorf("username and password are required: %w", ErrValidation)
+	}
+	if !strings.Contains(email, "@") {
+		return TokenPair{}, fmt.Errorf("invalid email address %q: %w", email, ErrValidation)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	m.mu.Lock()
+	if _, ok := m.userByName(username); ok {
+		m.mu.Unlock()
+		return TokenPair{}, fmt.Errorf("user %q: %w", username, ErrConflict)
+	}
+	user := User{ID: m.nextUserID, Username: username, Email: email, Password: string(hash)}
+	m.users[user.ID] = user
+	m.nextUserID++
+	m.mu.Unlock()
+
+	return m.IssueTokens(ctx, user.ID)
+}
+
+func (m *Memory) AuthenticateUser(ctx context.Context, username string, password string) (User, TokenPair, error) {
+	m.mu.RLock()
+	user, ok := m.userByName(username)
+	m.mu.RUnlock()
+	if !ok {
+		comparePasswords(dummyHash, password)
+		return User{}, TokenPair{}, ErrBadCredentials
+	}
+	if !comparePasswords(user.Password, password) {
+		return User{}, TokenPair{}, ErrBadCredentials
+	}
+
+	m.mu.Lock()
+	delete(m.deletions, user.ID)
+	m.mu.Unlock()
+
+	tokens, err := m.IssueTokens(ctx, user.ID)
+	if err != nil {
+		return User{}, TokenPair{}, fmt.Errorf("creating token: %w", err)
+	}
+
+	return user, tokens, nil
+}
+
+func (m *Memory) IssueTokens(ctx context.Context, userID int) (TokenPair, error) {
+	familyID, err := randomToken(16)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.issueTokens(userID, familyID)
+}
+
+func (m *Memory) RefreshTokens(ctx context.Context, refreshToken string) (TokenPair, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	token, ok := m.tokens[hashToken(refreshToken)]
+	if !ok {
+		return TokenPair{}, ErrInvalidRefreshToken
+	}
+
+	if token.revoked {
+		m.revokeFamily(token.familyID)
+		return TokenPair{}, ErrRefreshTokenReused
+	}
+	if !time.Now().Before(token.expiresAt) {
+		return TokenPair{}, ErrInvalidRefreshToken
+	}
+
+	token.revoked = true
+	return m.issueTokens(token.userID, token.familyID)
+}
+
+func (m *Memory) RevokeRefreshToken(ctx context.Context, refreshToken string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if token, ok := m.tokens[hashToken(refreshToken)]; ok {
+		m.revokeFamily(token.familyID)
+	}
+	return nil
+}
+
+func (m *Memory) SessionActive(ctx context.Context, familyID string) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := time.Now()
+	for _, token := range m.tokens {
+		if token.familyID == familyID && !token.revoked && now.Before(token.expiresAt) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *Memory) GetUserRoles(ctx context.Context, userID int) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if _, ok := m.users[userID]; !ok {
+		return nil, fmt.Errorf("user %d: %w", userID, ErrNotFound)
+	}
+	return m.userRoles(userID), nil
+}
+
+func (m *Memory) GrantRole(ctx context.Context, actorID, userID int, role string) error {
+	if err := checkGrantable(role); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[userID]; !ok {
+		return fmt.Errorf("user %d: %w", userID, ErrNotFound)
+	}
+	if m.roles[userID][role] {
+		return nil
+	}
+
+	m.grant(userID, role)
+	m.logRoleChange(userID, role, RoleGranted, actorID)
+	return nil
+}
+
+func (m *Memory) RevokeRole(ctx context.Context, actorID, userID int, role string) error {
+	if err := checkGrantable(role); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[userID]; !ok {
+		return fmt.Errorf("user %d: %w", userID, ErrNotFound)
+	}
+	if !m.roles[userID][role] {
+		return fmt.Errorf("user %d doesn't have the %q role: %w", userID, role, ErrNotFound)
+	}
+	if role == RoleAdmin && m.lastAdmin(userID) {
+		return fmt.Errorf("user %d is the last admin: %w", userID, ErrConflict)
+	}
+
+	delete(m.roles[userID], role)
+	m.logRoleChange(userID, role, RoleRevoked, actorID)
+	for _, token := range m.tokens {
+		if token.userID == userID {
+			token.revoked = true
+		}
+	}
+	return nil
+}
+
+func (m *Memory) GetRoleChanges(ctx context.Context, userID int) ([]RoleChange, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	changes := []RoleChange{}
+	for i := len(m.audit) - 1; i >= 0; i-- {
+		if m.audit[i].UserID == userID {
+			changes = append(changes, m.audit[i])
+		}
+	}
+	return changes, nil
+}
+
+func (m *Memory) GetProfile(ctx context.Context, userID int) (Profile, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.leaving(userID) {
+		return Profile{}, fmt.Errorf("user %d: %w", userID, ErrNotFound)
+	}
+	return m.profile(userID)
+}
+
+func (m *Memory) UpdateProfile(ctx context.Context, userID int, f ProfileFields) (Profile, error) {
+	f, err := f.clean()
+	if err != nil {
+		return Profile{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, err := m.profile(userID)
+	if err != nil {
+		return Profile{}, err
+	}
+	setIfGiven(&p.DisplayName, f.DisplayName)
+	setIfGiven(&p.Bio, f.Bio)
+	setIfGiven(&p.AvatarURL, f.AvatarURL)
+	setIfGiven(&p.Email, f.Email)
+	setBoolIfGiven(&p.Privacy.Reviews, f.ReviewsPublic)
+	setBoolIfGiven(&p.Privacy.Likes, f.LikesPublic)
+	setBoolIfGiven(&p.Privacy.Watchlist, f.WatchlistPublic)
+
+	user := m.users[userID]
+	user.Email = p.Email
+	m.users[userID] = user
+	m.profiles[userID] = p
+	return p, nil
+}
+
+func (m *Memory) ChangePassword(ctx context.Context, userID int, current, password string) (TokenPair, error) {
+	if password == "" {
+		return TokenPair{}, fmt.Errorf("new password is required: %w", ErrValidation)
+	}
+	familyID, err := randomToken(16)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[userID]
+	if !ok {
+		return TokenPair{}, fmt.Errorf("user %d: %w", userID, ErrNotFound)
+	}
+	if !comparePasswords(user.Password, current) {
+		return TokenPair{}, ErrWrongPassword
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	user.Password = string(hash)
+	m.users[userID] = user
+	for _, token := range m.tokens {
+		if token.userID == userID {
+			token.revoked = true
+		}
+	}
+	return m.issueTokens(userID, familyID)
+}
+
+func (m *Memory) DeleteAccount(ctx context.Context, userID int, password string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[userID]
+	if !ok {
+		return fmt.Errorf("user %d: %w", userID, ErrNotFound)
+	}
+	if !comparePasswords(user.Password, password) {
+		return ErrWrongPassword
+	}
+	if m.lastAdmin(userID) {
+		return fmt.Errorf("user %d is the last admin: %w", userID, ErrConflict)
+	}
+
+	if !at.After(time.Now()) {
+		m.deleteAccount(userID)
+		return nil
+	}
+	m.deletions[userID] = at
+	for _, token := range m.tokens {
+		if token.userID == userID {
+			token.revoked = true
+		}
+	}
+	return nil
+}
+
+func (m *Memory) PurgeAccounts(ctx context.Context, now time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	purged := 0
+	for userID, at := range m.deletions {
+		if !at.After(now) && !m.lastAdmin(userID) {
+			m.deleteAccount(userID)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// leaving reports whether the user's account is waiting to be deleted, which
+// hides it as the SQL queries do with DeleteAfter IS NULL.
+func (m *Memory) leaving(userID int) bool {
+	_, ok := m.deletions[userID]
+	return ok
+}
+
+// lastAdmin reports whether the user is an admin and every other admin, if
+// any, is leaving too.
+func (m *Memory) lastAdmin(userID int) bool {
+	if !m.roles[userID][RoleAdmin] {
+		return false
+	}
+	for id, roles := range m.roles {
+		if _, leaving := m.deletions[id]; id != userID && roles[RoleAdmin] && !leaving {
+			return false
+		}
+	}
+	return true
+}
+
+// deleteAccount removes the user and everything the foreign keys of the
+// SQL schema would take with them.
+func (m *Memory) deleteAccount(userID int) {
+	var movieIDs []int
+	for id, r := range m.reviews {
+		if r.userID == userID {
+			delete(m.reviews, id)
+			movieID, _ := strconv.Atoi(r.MovieId)
+			movieIDs = append(movieIDs, movieID)
+		}
+	}
+	for _, movieID := range movieIDs {
+		m.updateAvgRating(movieID)
+	}
+	m.dropVotes(userID)
+	for _, set := range []map[memoryEntry]time.Time{m.likes, m.watchlist} {
+		for entry := range set {
+			if entry.userID == userID {
+				delete(set, entry)
+			}
+		}
+	}
+	for hash, token := range m.tokens {
+		if token.userID == userID {
+			delete(m.tokens, hash)
+		}
+	}
+	for id, l := range m.lists {
+		if l.ownerID == userID {
+			delete(m.lists, id)
+			continue
+		}
+		l.collaborators = withoutInt(l.collaborators, userID)
+		for i := range l.entries {
+			if l.entries[i].addedBy == userID {
+				l.entries[i].addedBy = 0
+			}
+		}
+	}
+	for id, e := range m.diary {
+		if e.userID == userID {
+			delete(m.diary, id)
+		}
+	}
+	for id, job := range m.imports {
+		if job.userID == userID {
+			delete(m.imports, id)
+		}
+	}
+	delete(m.roles, userID)
+	delete(m.profiles, userID)
+	delete(m.deletions, userID)
+	delete(m.users, userID)
+}
+
+func (m *Memory) GetUserReviews(ctx context.Context, userID int, req PageRequest) (Page[UserReview], error) {
+	p, err := planPage(req, userReviewSorts)
+	if err != nil {
+		return Page[UserReview]{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	reviews := []UserReview{}
+	for _, r := range m.reviews {
+		if r.userID != userID {
+			continue
+		}
+		movieID, _ := strconv.Atoi(r.MovieId)
+		movie := m.movies[movieID]
+		reviews = append(reviews, UserReview{Review: r.Review, MovieTitle: movie.Title, MovieSlug: movie.Slug})
+	}
+	return pageIn(reviews, func(r UserReview) int { return r.Id }, p), nil
+}
+
+func (m *Memory) GetSavedMovies(ctx context.Context, userID int, collection string, req PageRequest) (Page[SavedMovie], error) {
+	if _, err := collectionTable(collection); err != nil {
+		return Page[SavedMovie]{}, err
+	}
+	p, err := planPage(req, savedMovieSorts)
+	if err != nil {
+		return Page[SavedMovie]{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	movies := []SavedMovie{}
+	for entry, added := range m.collection(collection) {
+		if entry.userID == userID {
+			movies = append(movies, SavedMovie{Movie: m.movies[entry.movieID], DateAdded: added.Format(dateAddedLayout)})
+		}
+	}
+	return pageIn(movies, func(m SavedMovie) int { return m.Id }, p), nil
+}
+
+func (m *Memory) GetMovieStatuses(ctx context.Context, userID int, movieIDs []int) (map[int]MovieStatus, error) {
+	if err := checkStatusLookup(movieIDs); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	statuses := make(map[int]MovieStatus, len(movieIDs))
+	for _, id := range movieIDs {
+		statuses[id] = m.movieStatus(userID, id)
+	}
+	return statuses, nil
+}
+
+func (m *Memory) AddToCollection(ctx context.Context, userID int, collection string, movieID int) (MovieStatus, error) {
+	if _, err := collectionTable(collection); err != nil {
+		return MovieStatus{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	set := m.collection(collection)
+	if _, ok := set[memoryEntry{userID: userID, movieID: movieID}]; !ok {
+		if err := m.toggle(set, movieID, userID); err != nil {
+			return MovieStatus{}, err
+		}
+	}
+	return m.movieStatus(userID, movieID), nil
+}
+
+func (m *Memory) RemoveFromCollection(ctx context.Context, userID int, collection string, movieID int) (MovieStatus, error) {
+	if _, err := collectionTable(collection); err != nil {
+		return MovieStatus{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.collection(collection), memoryEntry{userID: userID, movieID: movieID})
+	return m.movieStatus(userID, movieID), nil
+}
+
+func (m *Memory) ToggleWatchlist(ctx context.Context, movieID, userID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.toggle(m.watchlist, movieID, userID)
+}
+
+func (m *Memory) ToggleLiked(ctx context.Context, movieID, userID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.toggle(m.likes, movieID, userID)
+}
+
+func (m *Memory) GetWatchlistStatus(ctx context.Context, movieID int, username string) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.status(m.watchlist, movieID, username)
+}
+
+func (m *Memory) GetLikedStatus(ctx context.Context, movieID int, username string) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.status(m.likes, movieID, username)
+}
+
+func (m *Memory) GetLists(ctx context.Context, f ListFilter, req PageRequest) (Page[ListSummary], error) {
+	p, err := planPage(req, listSorts)
+	if err != nil {
+		return Page[ListSummary]{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	lists := []ListSummary{}
+	for _, l := range m.lists {
+		if f.OwnerID != 0 && l.ownerID != f.OwnerID {
+			continue
+		}
+		if f.MemberID != 0 && l.ownerID != f.MemberID && !containsInt(l.collaborators, f.MemberID) {
+			continue
+		}
+		if f.MemberID == 0 && l.Visibility != ListPublic || m.leaving(l.ownerID) {
+			continue
+		}
+		lists = append(lists, m.listSummary(l))
+	}
+	return pageIn(lists, func(l ListSummary) int { return l.rowID }, p), nil
+}
+
+func (m *Memory) GetList(ctx context.Context, userID int, key string) (MovieList, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	l, err := m.listFor(key, userID, listViewer)
+	if err != nil {
+		return MovieList{}, err
+	}
+	return m.listDetails(l), nil
+}
+
+func (m *Memory) CreateList(ctx context.Context, ownerID int, f ListFields) (MovieList, error) {
+	f, err := f.clean(true)
+	if err != nil {
+		return MovieList{}, err
+	}
+	key, err := randomToken(16)
+	if err != nil {
+		return MovieList{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[ownerID]; !ok {
+		return MovieList{}, fmt.Errorf("user %d: %w", ownerID, ErrValidation)
+	}
+	now := time.Now().Unix()
+	l := &memoryList{ListSummary: ListSummary{ID: key, Title: *f.Title, Visibility: ListPublic, CreatedAt: now, UpdatedAt: now, rowID: m.nextListID, ownerID: ownerID}}
+	setIfGiven(&l.Description, f.Description)
+	setIfGiven(&l.Visibility, f.Visibility)
+	m.lists[l.rowID] = l
+	m.nextListID++
+	return m.listDetails(l), nil
+}
+
+func (m *Memory) UpdateList(ctx context.Context, userID int, key string, f ListFields) (MovieList, error) {
+	f, err := f.clean(false)
+	if err != nil {
+		return MovieList{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	l, err := m.listFor(key, userID, listOwner)
+	if err != nil {
+		return MovieList{}, err
+	}
+	if f != (ListFields{}) {
+		setIfGiven(&l.Title, f.Title)
+		setIfGiven(&l.Description, f.Description)
+		setIfGiven(&l.Visibility, f.Visibility)
+		l.UpdatedAt = time.Now().Unix()
+	}
+	return m.listDetails(l), nil
+}
+
+func (m *Memory) DeleteList(ctx context.Context, userID int, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	l, err := m.listFor(key, userID, listOwner)
+	if err != nil {
+		return err
+	}
+	delete(m.lists, l.rowID)
+	return nil
+}
+
+func (m *Memory) PutListEntry(ctx context.Context, userID int, key string, movieID int, f EntryFields) (MovieList, error) {
+	f, err := f.clean()
+	if err != nil {
+		return MovieList{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	l, err := m.listFor(key, userID, listEditor)
+	if err != nil {
+		return MovieList{}, err
+	}
+
+	i := l.entryIndex(movieID)
+	switch {
+	case i >= 0:
+		setIfGiven(&l.entries[i].note, f.Note)
+	case len(l.entries) >= MaxListEntries:
+		return MovieList{}, fmt.Errorf("list %q already has %d movies, the most a list may have: %w", l.ID, len(l.entries), ErrConflict)
+	default:
+		if _, ok := m.movies[movieID]; !ok {
+			return MovieList{}, fmt.Errorf("movie %d: %w", movieID, ErrValidation)
+		}
+		e := memoryListEntry{movieID: movieID, addedBy: userID, addedAt: time.Now().Unix()}
+		setIfGiven(&e.note, f.Note)
+		l.entries = append(l.entries, e)
+		i = len(l.entries) - 1
+	}
+
+	if f.Position != nil {
+		to := min(*f.Position-1, len(l.entries)-1)
+		e := l.entries[i]
+		l.entries = append(l.entries[:i], l.entries[i+1:]...)
+		l.entries = append(l.entries[:to], append([]memoryListEntry{e}, l.entries[to:]...)...)
+	}
+	l.UpdatedAt = time.Now().Unix()
+	return m.listDetails(l), nil
+}
+
+func (m *Memory) RemoveListEntry(ctx context.Context, userID int, key string, movieID int) (MovieList, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	l, err := m.listFor(key, userID, listEditor)
+	if err != nil {
+		return MovieList{}, err
+	}
+	if i := l.entryIndex(movieID); i >= 0 {
+		l.entries = append(l.entries[:i], l.entries[i+1:]...)
+		l.UpdatedAt = time.Now().Unix()
+	}
+	return m.listDetails(l), nil
+}
+
+func (m *Memory) AddCollaborator(ctx context.Context, userID int, key string, collaboratorID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	l, err := m.listFor(key, userID, listOwner)
+	if err != nil {
+		return err
+	}
+	if collaboratorID == l.ownerID {
+		return fmt.Errorf("the owner of list %q can't be a collaborator on it: %w", l.ID, ErrValidation)
+	}
+	if _, ok := m.users[collaboratorID]; !ok {
+		return fmt.Errorf("user %d: %w", collaboratorID, ErrValidation)
+	}
+	if !containsInt(l.collaborators, collaboratorID) {
+		l.collaborators = append(l.collaborators, collaboratorID)
+	}
+	return nil
+}
+
+func (m *Memory) RemoveCollaborator(ctx context.Context, userID int, key string, collaboratorID int) error {
+	need := listOwner
+	if userID == collaboratorID {
+		need = listEditor
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	l, err := m.listFor(key, userID, need)
+	if err != nil {
+		return err
+	}
+	l.collaborators = withoutInt(l.collaborators, collaboratorID)
+	return nil
+}
+
+func (m *Memory) LogWatch(ctx context.Context, userID, movieID int, f DiaryFields) (DiaryEntry, error) {
+	f, err := f.clean()
+	if err != nil {
+		return DiaryEntry{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.movies[movieID]; !ok {
+		return DiaryEntry{}, fmt.Errorf("movie %d: %w", movieID, ErrValidation)
+	}
+	if err := m.checkDiaryReview(userID, movieID, f.ReviewID); err != nil {
+		return DiaryEntry{}, err
+	}
+
+	e := memoryDiaryEntry{userID: userID, movieID: movieID, watchedOn: time.Now().UTC().Format("2006-01-02"), createdAt: time.Now().Unix()}
+	setIfGiven(&e.watchedOn, f.WatchedOn)
+	if f.Rewatch != nil {
+		e.rewatch = *f.Rewatch
+	} else {
+		for _, other := range m.diary {
+			if other.userID == userID && other.movieID == movieID && other.watchedOn <= e.watchedOn {
+				e.rewatch = true
+			}
+		}
+	}
+	e.set(f)
+
+	id := m.nextDiaryID
+	m.nextDiaryID++
+	m.diary[id] = e
+	delete(m.watchlist, memoryEntry{userID: userID, movieID: movieID})
+	return m.diaryEntry(id), nil
+}
+
+func (m *Memory) GetDiaryEntry(ctx context.Context, userID, entryID int) (DiaryEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if e, ok := m.diary[entryID]; !ok || e.userID != userID {
+		return DiaryEntry{}, fmt.Errorf("diary entry %d: %w", entryID, ErrNotFound)
+	}
+	return m.diaryEntry(entryID), nil
+}
+
+func (m *Memory) UpdateDiaryEntry(ctx context.Context, userID, entryID int, f DiaryFields) (DiaryEntry, error) {
+	f, err := f.clean()
+	if err != nil {
+		return DiaryEntry{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.diary[entryID]
+	if !ok || e.userID != userID {
+		return DiaryEntry{}, fmt.Errorf("diary entry %d: %w", entryID, ErrNotFound)
+	}
+	if err := m.checkDiaryReview(userID, e.movieID, f.ReviewID); err != nil {
+		return DiaryEntry{}, err
+	}
+	setIfGiven(&e.watchedOn, f.WatchedOn)
+	setBoolIfGiven(&e.rewatch, f.Rewatch)
+	e.set(f)
+	m.diary[entryID] = e
+	return m.diaryEntry(entryID), nil
+}
+
+func (m *Memory) DeleteDiaryEntry(ctx context.Context, userID, entryID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if e, ok := m.diary[entryID]; !ok || e.userID != userID {
+		return fmt.Errorf("diary entry %d: %w", entryID, ErrNotFound)
+	}
+	delete(m.diary, entryID)
+	return nil
+}
+
+func (m *Memory) GetDiary(ctx context.Context, userID int, f DiaryFilter, req PageRequest) (Page[DiaryEntry], error) {
+	from, until, err := f.dates()
+	if err != nil {
+		return Page[DiaryEntry]{}, err
+	}
+	p, err := planPage(req, diarySorts)
+	if err != nil {
+		return Page[DiaryEntry]{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	entries := []DiaryEntry{}
+	for id, e := range m.diary {
+		if e.userID == userID && (from == "" || (e.watchedOn >= from && e.watchedOn < until)) {
+			entries = append(entries, m.diaryEntry(id))
+		}
+	}
+	return pageIn(entries, func(e DiaryEntry) int { return e.ID }, p), nil
+}
+
+func (m *Memory) GetDiaryMonths(ctx context.Context, userID int) ([]DiaryMonth, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	counts := make(map[string]int)
+	for _, e := range m.diary {
+		if e.userID == userID {
+			counts[e.watchedOn[:7]]++
+		}
+	}
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(keys)))
+
+	months := []DiaryMonth{}
+	for _, k := range keys {
+		month := DiaryMonth{Count: counts[k]}
+		fmt.Sscanf(k, "%4d-%2d", &month.Year, &month.Month)
+		months = append(months, month)
+	}
+	return months, nil
+}
+
+func (m *Memory) FindMovie(ctx context.Context, title string, year int) (Movie, error) {
+	if slug.Make(title) == "" {
+		return Movie{}, fmt.Errorf("a title is required: %w", ErrValidation)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var candidates []Movie
+	for _, id := range sortedKeys(m.movies) {
+		candidates = append(candidates, m.movies[id])
+	}
+	return pickMovie(candidates, title, year)
+}
+
+func (m *Memory) CreateImport(ctx context.Context, userID int, source string) (ImportJob, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[userID]; !ok {
+		return ImportJob{}, fmt.Errorf("user %d: %w", userID, ErrValidation)
+	}
+	job := &memoryImport{userID: userID, ImportJob: ImportJob{
+		ID:        m.nextImportID,
+		Source:    source,
+		Status:    ImportRunning,
+		CreatedAt: time.Now().Unix(),
+		Rows:      []ImportRow{},
+	}}
+	m.nextImportID++
+	m.imports[job.ID] = job
+	return job.copy(), nil
+}
+
+func (m *Memory) FinishImport(ctx context.Context, jobID int, r ImportResult) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job, ok := m.imports[jobID]
+	if !ok || job.Status != ImportRunning {
+		return fmt.Errorf("running import %d: %w", jobID, ErrNotFound)
+	}
+	job.Status = ImportDone
+	if r.Error != "" {
+		job.Status = ImportFailed
+	}
+	finishedAt := time.Now().Unix()
+	job.Error, job.Imported, job.Skipped, job.FinishedAt = truncate(r.Error, maxImportError), r.Imported, r.Skipped, &finishedAt
+	for _, row := range r.Unmatched {
+		row.ID, row.Resolved, row.MovieID = m.nextImportRow, false, nil
+		m.nextImportRow++
+		job.Rows = append(job.Rows, row)
+	}
+	return nil
+}
+
+func (m *Memory) GetImport(ctx context.Context, userID, jobID int) (ImportJob, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	job, ok := m.imports[jobID]
+	if !ok || job.userID != userID {
+		return ImportJob{}, fmt.Errorf("import %d: %w", jobID, ErrNotFound)
+	}
+	return job.copy().interrupted(time.Now()), nil
+}
+
+func (m *Memory) ResolveImportRow(ctx context.Context, userID, jobID, rowID, movieID int) (ImportRow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job, ok := m.imports[jobID]
+	if !ok || job.userID != userID {
+		return ImportRow{}, fmt.Errorf("import row %d: %w", rowID, ErrNotFound)
+	}
+	for i := range job.Rows {
+		row := &job.Rows[i]
+		if row.ID != rowID {
+			continue
+		}
+		if row.Resolved {
+			return ImportRow{}, fmt.Errorf("import row %d is already resolved: %w", rowID, ErrConflict)
+		}
+		if movieID != 0 {
+			if _, ok := m.movies[movieID]; !ok {
+				return ImportRow{}, fmt.Errorf("movie %d: %w", movieID, ErrValidation)
+			}
+			id := movieID
+			row.MovieID = &id
+		}
+		row.Resolved = true
+		return *row, nil
+	}
+	return ImportRow{}, fmt.Errorf("import row %d: %w", rowID, ErrNotFound)
+}
+
+func (m *Memory) ReopenImportRow(ctx context.Context, userID, jobID, rowID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if job, ok := m.imports[jobID]; ok && job.userID == userID {
+		for i := range job.Rows {
+			if row := &job.Rows[i]; row.ID == rowID {
+				row.Resolved, row.MovieID = false, nil
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("import row %d: %w", rowID, ErrNotFound)
+}
+
+// copy returns the job with its own slice of rows.
+func (job *memoryImport) copy() ImportJob {
+	c := job.ImportJob
+	c.Rows = append([]ImportRow{}, job.Rows...)
+	return c
+}
+
+func (m *Memory) CreateMovie(ctx context.Context, f MovieFields) (Movie, error) {
+	f, err := f.clean(true)
+	if err != nil {
+		return Movie{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	movie := Movie{Id: m.nextMovieID}
+	movie.Slug, err = newSlug(m.movies, movie.Id, *f.Title, f.Slug, "movie", func(mv Movie) string { return mv.Slug })
+	if err != nil {
+		return Movie{}, err
+	}
+	setIfGiven(&movie.Title, f.Title)
+	setIfGiven(&movie.ReleaseDate, f.ReleaseDate)
+	setIfGiven(&movie.Genre, f.Genre)
+
+	m.nextMovieID++
+	m.movies[movie.Id] = movie
+	return movie, nil
+}
+
+func (m *Memory) UpdateMovie(ctx context.Context, movieID int, f MovieFields) (Movie, error) {
+	f, err := f.clean(false)
+	if err != nil {
+		return Movie{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	movie, ok := m.movies[movieID]
+	if !ok {
+		return Movie{}, fmt.Errorf("movie %d: %w", movieID, ErrNotFound)
+	}
+	if err := checkSlugFree(m.movies, movieID, f.Slug, "movie", func(mv Movie) string { return mv.Slug }); err != nil {
+		return Movie{}, err
+	}
+	setIfGiven(&movie.Title, f.Title)
+	setIfGiven(&movie.ReleaseDate, f.ReleaseDate)
+	setIfGiven(&movie.Genre, f.Genre)
+	setIfGiven(&movie.Slug, f.Slug)
+
+	m.movies[movieID] = movie
+	return movie, nil
+}
+
+func (m *Memory) DeleteMovie(ctx context.Context, movieID int, cascade bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.movies[movieID]; !ok {
+		return fmt.Errorf("movie %d: %w", movieID, ErrNotFound)
+	}
+
+	var reviewIDs []int
+	for id, r := range m.reviews {
+		if r.MovieId == strconv.Itoa(movieID) {
+			reviewIDs = append(reviewIDs, id)
+		}
+	}
+	likes, listed := countMovie(m.likes, movieID), countMovie(m.watchlist, movieID)
+	curated := 0
+	for _, l := range m.lists {
+		if l.entryIndex(movieID) >= 0 {
+			curated++
+		}
+	}
+	watched := 0
+	for _, e := range m.diary {
+		if e.movieID == movieID {
+			watched++
+		}
+	}
+	if !cascade && len(reviewIDs)+likes+listed+curated+watched > 0 {
+		return fmt.Errorf("movie %d has %d reviews, %d likes, %d watchlist entries, %d list entries and %d diary entries; delete it with cascade to remove them too: %w",
+			movieID, len(reviewIDs), likes, listed, curated, watched, ErrConflict)
+	}
+
+	for _, id := range reviewIDs {
+		delete(m.reviews, id)
+	}
+	m.dropVotes(0)
+	for _, set := range []map[memoryEntry]time.Time{m.likes, m.watchlist} {
+		for entry := range set {
+			if entry.movieID == movieID {
+				delete(set, entry)
+			}
+		}
+	}
+	for id, e := range m.diary {
+		if e.movieID == movieID {
+			delete(m.diary, id)
+		}
+	}
+	for _, l := range m.lists {
+		if i := l.entryIndex(movieID); i >= 0 {
+			l.entries = append(l.entries[:i], l.entries[i+1:]...)
+		}
+	}
+	for _, job := range m.imports {
+		for i, row := range job.Rows {
+			if row.MovieID != nil && *row.MovieID == movieID {
+				job.Rows[i].MovieID = nil
+			}
+		}
+	}
+	delete(m.movies, movieID)
+	delete(m.directed, movieID)
+	delete(m.acted, movieID)
+	return nil
+}
+
+func (m *Memory) CreateActor(ctx context.Context, f PersonFields) (Actor, error) {
+	f, err := f.clean(true)
+	if err != nil {
+		return Actor{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	actor := Actor{ID: m.nextActorID}
+	actor.Slug, err = newSlug(m.actors, actor.ID, *f.Name, f.Slug, "actor", func(a Actor) string { return a.Slug })
+	if err != nil {
+		return Actor{}, err
+	}
+	setIfGiven(&actor.Name, f.Name)
+	setIfGiven(&actor.Dob, f.DateOfBirth)
+	setIfGiven(&actor.Nationality, f.Nationality)
+
+	m.nextActorID++
+	m.actors[actor.ID] = actor
+	return actor, nil
+}
+
+func (m *Memory) UpdateActor(ctx context.Context, actorID int, f PersonFields) (Actor, error) {
+	f, err := f.clean(false)
+	if err != nil {
+		return Actor{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	actor, ok := m.actors[actorID]
+	if !ok {
+		return Actor{}, fmt.Errorf("actor %d: %w", actorID, ErrNotFound)
+	}
+	if err := checkSlugFree(m.actors, actorID, f.Slug, "actor", func(a Actor) string { return a.Slug }); err != nil {
+		return Actor{}, err
+	}
+	setIfGiven(&actor.Name, f.Name)
+	setIfGiven(&actor.Dob, f.DateOfBirth)
+	setIfGiven(&actor.Nationality, f.Nationality)
+	setIfGiven(&actor.Slug, f.Slug)
+
+	m.actors[actorID] = actor
+	return actor, nil
+}
+
+func (m *Memory) DeleteActor(ctx context.Context, actorID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.actors[actorID]; !ok {
+		return fmt.Errorf("actor %d: %w", actorID, ErrNotFound)
+	}
+	delete(m.actors, actorID)
+	removeCredits(m.acted, actorID)
+	return nil
+}
+
+func (m *Memory) CreateDirector(ctx context.Context, f PersonFields) (Director, error) {
+	f, err := f.clean(true)
+	if err != nil {
+		return Director{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	director := Director{ID: m.nextDirectorID}
+	director.Slug, err = newSlug(m.directors, director.ID, *f.Name, f.Slug, "director", func(d Director) string { return d.Slug })
+	if err != nil {
+		return Director{}, err
+	}
+	setIfGiven(&director.Name, f.Name)
+	setIfGiven(&director.Dob, f.DateOfBirth)
+	setIfGiven(&director.Nationality, f.Nationality)
+
+	m.nextDirectorID++
+	m.directors[director.ID] = director
+	return director, nil
+}
+
+func (m *Memory) UpdateDirector(ctx context.Context, directorID int, f PersonFields) (Director, error) {
+	f, err := f.clean(false)
+	if err != nil {
+		return Director{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	director, ok := m.directors[directorID]
+	if !ok {
+		return Director{}, fmt.Errorf("director %d: %w", directorID, ErrNotFound)
+	}
+	if err := checkSlugFree(m.directors, directorID, f.Slug, "director", func(d Director) string { return d.Slug }); err != nil {
+		return Director{}, err
+	}
+	setIfGiven(&director.Name, f.Name)
+	setIfGiven(&director.Dob, f.DateOfBirth)
+	setIfGiven(&director.Nationality, f.Nationality)
+	setIfGiven(&director.Slug, f.Slug)
+
+	m.directors[directorID] = director
+	return director, nil
+}
+
+func (m *Memory) DeleteDirector(ctx context.Context, directorID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.directors[directorID]; !ok {
+		return fmt.Errorf("director %d: %w", directorID, ErrNotFound)
+	}
+	delete(m.directors, directorID)
+	removeCredits(m.directed, directorID)
+	return nil
+}
+
+func (m *Memory) AddCredit(ctx context.Context, movieID int, role string, personID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	credits, personExists, err := m.credits(role, personID)
+	if err != nil {
+		return err
+	}
+	if _, ok := m.movies[movieID]; !ok || !personExists {
+		return fmt.Errorf("movie %d or %s %d doesn't exist: %w", movieID, role, personID, ErrValidation)
+	}
+	if !containsInt(credits[movieID], personID) {
+		credits[movieID] = append(credits[movieID], personID)
+	}
+	return nil
+}
+
+func (m *Memory) RemoveCredit(ctx context.Context, movieID int, role string, personID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	credits, _, err := m.credits(role, personID)
+	if err != nil {
+		return err
+	}
+	if !containsInt(credits[movieID], personID) {
+		return fmt.Errorf("%s %d isn't credited on movie %d: %w", role, personID, movieID, ErrNotFound)
+	}
+	credits[movieID] = withoutInt(credits[movieID], personID)
+	return nil
+}
+
+// The helpers below expect the caller to hold m.mu.
+
+// credits returns the credit lists for role and whether the person exists.
+func (m *Memory) credits(role string, personID int) (map[int][]int, bool, error) {
+	switch role {
+	case CreditActor:
+		_, ok := m.actors[personID]
+		return m.acted, ok, nil
+	case CreditDirector:
+		_, ok := m.directors[personID]
+		return m.directed, ok, nil
+	}
+	return nil, false, fmt.Errorf("unknown credit role %q: %w", role, ErrValidation)
+}
+
+func (m *Memory) userByName(username string) (User, bool) {
+	for _, user := range m.users {
+		// Usernames compare case-insensitively, as under MySQL's default
+		// collation.
+		if strings.EqualFold(user.Username, username) {
+			return user, true
+		}
+	}
+	return User{}, false
+}
+
+// profile returns the user's profile, with the defaults for what they
+// haven't set.
+func (m *Memory) profile(userID int) (Profile, error) {
+	user, ok := m.users[userID]
+	if !ok {
+		return Profile{}, fmt.Errorf("user %d: %w", userID, ErrNotFound)
+	}
+	p, ok := m.profiles[userID]
+	if !ok {
+		p.Privacy = defaultPrivacy
+	}
+	p.UserID, p.Username, p.Email = user.ID, user.Username, user.Email
+	return p, nil
+}
+
+// listFor returns the list with the key, if userID has the access they need.
+func (m *Memory) listFor(key string, userID int, need listAccess) (*memoryList, error) {
+	for _, l := range m.lists {
+		if l.ID == key && !m.leaving(l.ownerID) {
+			return l, l.allows(l.access(userID, containsInt(l.collaborators, userID)), need)
+		}
+	}
+	return nil, fmt.Errorf("list %q: %w", key, ErrNotFound)
+}
+
+func (m *Memory) listSummary(l *memoryList) ListSummary {
+	s := l.ListSummary
+	s.Owner = m.users[l.ownerID].Username
+	s.EntryCount = len(l.entries)
+	return s
+}
+
+func (m *Memory) listDetails(l *memoryList) MovieList {
+	list := MovieList{ListSummary: m.listSummary(l), Collaborators: []string{}, Entries: []ListEntry{}}
+	for _, id := range l.collaborators {
+		if !m.leaving(id) {
+			list.Collaborators = append(list.Collaborators, m.users[id].Username)
+		}
+	}
+	for i, e := range l.entries {
+		entry := ListEntry{Movie: m.movies[e.movieID], Position: i + 1, Note: e.note, AddedAt: e.addedAt}
+		if !m.leaving(e.addedBy) {
+			entry.AddedBy = m.users[e.addedBy].Username
+		}
+		list.Entries = append(list.Entries, entry)
+	}
+	return list
+}
+
+func (l *memoryList) entryIndex(movieID int) int {
+	for i, e := range l.entries {
+		if e.movieID == movieID {
+			return i
+		}
+	}
+	return -1
+}
+
+func (m *Memory) diaryEntry(id int) DiaryEntry {
+	e := m.diary[id]
+	entry := DiaryEntry{ID: id, Movie: m.movies[e.movieID], WatchedOn: e.watchedOn, Rewatch: e.rewatch, CreatedAt: e.createdAt}
+	if e.rating != 0 {
+		rating := e.rating
+		entry.Rating = &rating
+	}
+	if e.reviewID != 0 {
+		reviewID := e.reviewID
+		entry.ReviewID = &reviewID
+	}
+	return entry
+}
+
+func (m *Memory) checkDiaryReview(userID, movieID int, reviewID *int) error {
+	if reviewID == nil || *reviewID == 0 {
+		return nil
+	}
+	if r, ok := m.reviews[*reviewID]; !ok || r.userID != userID || r.MovieId != strconv.Itoa(movieID) {
+		return fmt.Errorf("review %d is not the user's review of movie %d: %w", *reviewID, movieID, ErrValidation)
+	}
+	return nil
+}
+
+func (m *Memory) movieByRef(ref string) (Movie, error) {
+	movie, ok := findByRefIn(m.movies, ref, func(mv Movie) (string, string) { return mv.Slug, mv.Title })
+	if !ok {
+		return Movie{}, fmt.Errorf("movie %q: %w", ref, ErrNotFound)
+	}
+	return movie, nil
+}
+
+func (m *Memory) updateAvgRating(movieID int) {
+	var sum, count int
+	for _, r := range m.reviews {
+		if r.MovieId == strconv.Itoa(movieID) {
+			sum += r.Stars
+			count++
+		}
+	}
+
+	movie := m.movies[movieID]
+	movie.AvgRating = 0
+	movie.ReviewCount = count
+	if count > 0 {
+		movie.AvgRating = float64(sum) / float64(count)
+	}
+	m.movies[movieID] = movie
+}
+
+// collection returns the set holding a collection checked by
+// collectionTable.
+func (m *Memory) collection(name string) map[memoryEntry]time.Time {
+	if name == CollectionWatchlist {
+		return m.watchlist
+	}
+	return m.likes
+}
+
+func (m *Memory) movieStatus(userID, movieID int) MovieStatus {
+	entry := memoryEntry{userID: userID, movieID: movieID}
+	_, liked := m.likes[entry]
+	_, listed := m.watchlist[entry]
+	return MovieStatus{Liked: liked, Watchlisted: listed}
+}
+
+func (m *Memory) toggle(set map[memoryEntry]time.Time, movieID, userID int) error {
+	entry := memoryEntry{userID: userID, movieID: movieID}
+	if _, ok := set[entry]; ok {
+		delete(set, entry)
+		return nil
+	}
+
+	if _, ok := m.movies[movieID]; !ok {
+		return fmt.Errorf("movie %d: %w", movieID, ErrValidation)
+	}
+	if _, ok := m.users[userID]; !ok {
+		return fmt.Errorf("user %d: %w", userID, ErrValidation)
+	}
+	set[entry] = time.Now().UTC().Truncate(time.Second)
+	return nil
+}
+
+func (m *Memory) status(set map[memoryEntry]time.Time, movieID int, username string) (bool, error) {
+	user, ok := m.userByName(username)
+	if !ok {
+		return false, fmt.Errorf("user %q: %w", username, ErrNotFound)
+	}
+
+	_, ok = set[memoryEntry{userID: user.ID, movieID: movieID}]
+	return ok, nil
+}
+
+func (m *Memory) issueTokens(userID int, familyID string) (TokenPair, error) {
+	now := time.Now()
+
+	accessToken, expiresAt, err := createToken(m.jwtKey, userID, familyID, m.userRoles(userID), now)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	refreshToken, err := randomToken(32)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	m.tokens[hashToken(refreshToken)] = &memoryRefreshToken{
+		userID:    userID,
+		familyID:  familyID,
+		expiresAt: now.Add(refreshTokenTTL),
+	}
+
+	return TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresAt:    expiresAt.Unix(),
+	}, nil
+}
+
+func (m *Memory) userRoles(userID int) []string {
+	var stored []string
+	for role := range m.roles[userID] {
+		stored = append(stored, role)
+	}
+	return withUserRole(stored)
+}
+
+func (m *Memory) grant(userID int, role string) {
+	if m.roles[userID] == nil {
+		m.roles[userID] = make(map[string]bool)
+	}
+	m.roles[userID][role] = true
+}
+
+func (m *Memory) logRoleChange(userID int, role, action string, actorID int) {
+	change := RoleChange{UserID: userID, Role: role, Action: action, At: time.Now().Unix()}
+	if actorID != 0 {
+		change.ActorID = &actorID
+	}
+	m.audit = append(m.audit, change)
+}
+
+func (m *Memory) revokeFamily(familyID string) {
+	for _, token := range m.tokens {
+		if token.familyID == familyID {
+			token.revoked = true
+		}
+	}
+}
+
+// newSlug returns the slug for a new row of rows: explicit if it was given
+// and is free, or else one derived from name like the SQL implementation
+// does.
+func newSlug[V any](rows map[int]V, id int, name string, explicit *string, kind string, slugOf func(V) string) (string, error) {
+	if explicit != nil {
+		return *explicit, checkSlugFree(rows, id, explicit, kind, slugOf)
+	}
+
+	taken := false
+	base := slug.Make(name)
+	for _, row := range rows {
+		taken = taken || strings.EqualFold(slugOf(row), base)
+	}
+	return slug.For(name, id, func(string) bool { return taken }), nil
+}
+
+// checkSlugFree reports ErrConflict if any row other than id already uses
+// the slug s. A nil s is always free.
+func checkSlugFree[V any](rows map[int]V, id int, s *string, kind string, slugOf func(V) string) error {
+	if s == nil {
+		return nil
+	}
+	for otherID, row := range rows {
+		if otherID != id && strings.EqualFold(slugOf(row), *s) {
+			return fmt.Errorf("%s slug %q is taken: %w", kind, *s, ErrConflict)
+		}
+	}
+	return nil
+}
+
+func setIfGiven(dst *string, v *string) {
+	if v != nil {
+		*dst = *v
+	}
+}
+
+func setBoolIfGiven(dst *bool, v *bool) {
+	if v != nil {
+		*dst = *v
+	}
+}
+
+// parseDateAdded reads the DateAdded of a fixture, which the SQL
+// implementation stores as given.
+func parseDateAdded(s string) time.Time {
+	t, err := time.Parse(dateAddedLayout, s)
+	if err != nil {
+		return time.Now().UTC().Truncate(time.Second)
+	}
+	return t
+}
+
+func countMovie(set map[memoryEntry]time.Time, movieID int) int {
+	n := 0
+	for entry := range set {
+		if entry.movieID == movieID {
+			n++
+		}
+	}
+	return n
+}
+
+// removeCredits drops personID from every movie's credit list.
+func removeCredits(credits map[int][]int, personID int) {
+	for movieID, ids := range credits {
+		credits[movieID] = withoutInt(ids, personID)
+	}
+}
+
+func withoutInt(ids []int, id int) []int {
+	var kept []int
+	for _, v := range ids {
+		if v != id {
+			kept = append(kept, v)
+		}
+	}
+	return kept
+}
+
+func sortedKeys[V any](m map[int]V) []int {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
+
+// findByRefIn resolves ref the way the SQL findByRef does. keys returns a
+// row's slug and its title or name; both compare case-insensitively.
+func findByRefIn[V any](rows map[int]V, ref string, keys func(V) (string, string)) (V, bool) {
+	if slug.IsID(ref) {
+		id, err := strconv.Atoi(ref)
+		row, ok := rows[id]
+		return row, ok && err == nil
+	}
+
+	ids := sortedKeys(rows)
+	for _, id := range ids {
+		if s, _ := keys(rows[id]); strings.EqualFold(s, ref) {
+			return rows[id], true
+		}
+	}
+	name := strings.ReplaceAll(ref, "-", " ")
+	for _, id := range ids {
+		if _, n := keys(rows[id]); strings.EqualFold(n, name) {
+			return rows[id], true
+		}
+	}
+
+	var zero V
+	return zero, false
+}
+
+func containsInt(ids []int, id int) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,382 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Lists are paged by cursor rather than by offset: a page ends with the sort
+// value and ID of its last row, and the next page starts right after them.
+// That stays fast deep into a large table, and rows added or removed between
+// requests don't make a client skip or repeat others. The ID breaks ties, so
+// every order is total.
+
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 200
+)
+
+var ErrInvalidCursor = fmt.Errorf("invalid cursor: %w", ErrValidation)
+
+// PageRequest selects a page of a list.
+type PageRequest struct {
+	// Sort names the order, such as "title"; "-title" reverses it. Empty or
+	// "id" sorts by ID.
+	Sort string
+	// Cursor is the Next of the previous page, or empty for the first page.
+	Cursor string
+	// Limit is the page size; 0 means DefaultPageSize.
+	Limit int
+}
+
+type Page[T any] struct {
+	Items []T
+	Total int    // rows matching the filters, over all pages
+	Next  string // cursor of the next page; empty on the last
+}
+
+type MovieFilter struct {
+	Genre     string // matched ignoring case
+	YearFrom  int    // 0 for no lower bound
+	YearTo    int    // 0 for no upper bound
+	MinRating float64
+}
+
+type PersonFilter struct {
+	Nationality string // matched ignoring case
+}
+
+// listSort is an order a list can be sorted in: by a column, whose value
+// for an item goes into cursors.
+type listSort[T any] struct {
+	column string
+	value  func(T) interface{}
+}
+
+var movieSorts = map[string]listSort[Movie]{
+	"title":        {"Title", func(m Movie) interface{} { return m.Title }},
+	"release_date": {"ReleaseDate", func(m Movie) interface{} { return m.ReleaseDate }},
+	"avg_rating":   {"AvgRating", func(m Movie) interface{} { return m.AvgRating }},
+	"review_count": {"RatingCount", func(m Movie) interface{} { return m.ReviewCount }},
+}
+
+var actorSorts = map[string]listSort[Actor]{
+	"name":          {"ActorName", func(a Actor) interface{} { return a.Name }},
+	"date_of_birth": {"DateOfBirth", func(a Actor) interface{} { return a.Dob }},
+}
+
+var directorSorts = map[string]listSort[Director]{
+	"name":          {"DirectorName", func(d Director) interface{} { return d.Name }},
+	"date_of_birth": {"DateOfBirth", func(d Director) interface{} { return d.Dob }},
+}
+
+var reviewSorts = map[string]listSort[MovieReview]{
+	"date":    {"R.DatePosted", func(r MovieReview) interface{} { return r.DatePosted }},
+	"rating":  {"R.RatingStars", func(r MovieReview) interface{} { return r.Stars }},
+	"helpful": {"R.HelpfulCount", func(r MovieReview) interface{} { return r.HelpfulCount }},
+}
+
+// cursor is what Page.Next encodes. Value is nil when sorting by ID.
+type cursor struct {
+	Sort  string      `json:"s"`
+	Value interface{} `json:"v"`
+	ID    int         `json:"id"`
+}
+
+// pagePlan is a PageRequest checked against the orders a list allows.
+type pagePlan[T any] struct {
+	sortName string       // as requested, e.g. "-title"
+	sort     *listSort[T] // nil when sorting by ID
+	desc     bool
+	limit    int
+	after    *cursor // nil on the first page
+}
+
+func planPage[T any](req PageRequest, sorts map[string]listSort[T]) (pagePlan[T], error) {
+	p := pagePlan[T]{sortName: req.Sort, limit: req.Limit}
+
+	name := strings.TrimPrefix(req.Sort, "-")
+	p.desc = name != req.Sort
+	if name != "" && name != "id" {
+		s, ok := sorts[name]
+		if !ok {
+			names := []string{"id"}
+			for n := range sorts {
+				names = append(names, n)
+			}
+			sort.Strings(names)
+			return p, fmt.Errorf("can't sort by %q, only by %s: %w", name, strings.Join(names, ", "), ErrValidation)
+		}
+		p.sort = &s
+	}
+
+	if p.limit == 0 {
+		p.limit = DefaultPageSize
+	}
+	if p.limit < 0 || p.limit > MaxPageSize {
+		return p, fmt.Errorf("limit must be between 1 and %d, got %d: %w", MaxPageSize, p.limit, ErrValidation)
+	}
+
+	if req.Cursor != "" {
+		c, err := decodeCursor(req.Cursor)
+		if err != nil || c.Sort != req.Sort || !p.fits(c.Value) {
+			return p, ErrInvalidCursor
+		}
+		p.after = &c
+	}
+
+	return p, nil
+}
+
+// fits reports whether a cursor value has the type the sort's values have.
+func (p pagePlan[T]) fits(value interface{}) bool {
+	if p.sort == nil {
+		return value == nil
+	}
+	var zero T
+	_, wantString := p.sort.value(zero).(string)
+	_, isString := value.(string)
+	_, isNumber := value.(float64)
+	return isString == wantString && isNumber != wantString
+}
+
+func decodeCursor(s string) (cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor{}, err
+	}
+	var c cursor
+	err = json.Unmarshal(data, &c)
+	return c, err
+}
+
+// cursorAfter returns the cursor of the page starting after item.
+func (p pagePlan[T]) cursorAfter(item T, id int) string {
+	c := cursor{Sort: p.sortName, ID: id}
+	if p.sort != nil {
+		c.Value = p.sort.value(item)
+	}
+
+	data, err := json.Marshal(c)
+	if err != nil {
+		// Cursors hold only strings and numbers.
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// listQuery describes a list to page through with SQL.
+type listQuery[T any] struct {
+	columns  string // scanned by scan, in order
+	from     string
+	idColumn string
+	where    []string // filters, joined with AND
+	args     []interface{}
+	scan     func(*sql.Rows) (T, error)
+	id       func(T) int
+}
+
+// pageQuerier is satisfied by both *sql.DB and *sql.Tx.
+type pageQuerier interface {
+	querier
+	rowQuerier
+}
+
+// fetchPage runs q for the page p selects, and counts the rows matching the
+// filters for Page.Total.
+func fetchPage[T any](ctx context.Context, db pageQuerier, q listQuery[T], p pagePlan[T]) (Page[T], error) {
+	var page Page[T]
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+q.from+whereClause(q.where), q.args...).Scan(&page.Total); err != nil {
+		return Page[T]{}, err
+	}
+
+	conds := append([]string{}, q.where...)
+	args := append([]interface{}{}, q.args...)
+	if p.after != nil {
+		cond, seekArgs := p.seek(q.idColumn)
+		conds = append(conds, cond)
+		args = append(args, seekArgs...)
+	}
+	args = append(args, p.limit+1)
+
+	query := "SELECT " + q.columns + " FROM " + q.from + whereClause(conds) + p.orderBy(q.idColumn) + " LIMIT ?"
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return Page[T]{}, err
+	}
+	defer rows.Close()
+
+	page.Items = []T{}
+	for rows.Next() {
+		item, err := q.scan(rows)
+		if err != nil {
+			return Page[T]{}, err
+		}
+		page.Items = append(page.Items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return Page[T]{}, err
+	}
+
+	// One row more than a page was asked for, to tell whether there's
+	// another page.
+	if len(page.Items) > p.limit {
+		page.Items = page.Items[:p.limit]
+		last := page.Items[p.limit-1]
+		page.Next = p.cursorAfter(last, q.id(last))
+	}
+	return page, nil
+}
+
+func whereClause(conds []string) string {
+	if len(conds) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(conds, " AND ")
+}
+
+// orderBy returns the ORDER BY clause for the plan.
+func (p pagePlan[T]) orderBy(idColumn string) string {
+	dir := " ASC"
+	if p.desc {
+		dir = " DESC"
+	}
+	if p.sort == nil {
+		return " ORDER BY " + idColumn + dir
+	}
+	return " ORDER BY " + p.sort.column + dir + ", " + idColumn + dir
+}
+
+// seek returns the condition that selects the rows after the cursor.
+func (p pagePlan[T]) seek(idColumn string) (string, []interface{}) {
+	op := " > ?"
+	if p.desc {
+		op = " < ?"
+	}
+	if p.sort == nil {
+		return idColumn + op, []interface{}{p.after.ID}
+	}
+
+	column := p.sort.column
+	return "(" + column + op + " OR (" + column + " = ? AND " + idColumn + op + "))",
+		[]interface{}{p.after.Value, p.after.Value, p.after.ID}
+}
+
+func (f MovieFilter) where() ([]string, []interface{}) {
+	var (
+		conds []string
+		args  []interface{}
+	)
+	if f.Genre != "" {
+		conds = append(conds, "LOWER(Genre) = LOWER(?)")
+		args = append(args, f.Genre)
+	}
+	// ReleaseDate is a DATE in MySQL and ISO 8601 text in SQLite; both
+	// compare correctly with these strings.
+	if f.YearFrom != 0 {
+		conds = append(conds, "ReleaseDate >= ?")
+		args = append(args, fmt.Sprintf("%04d-01-01", f.YearFrom))
+	}
+	if f.YearTo != 0 {
+		conds = append(conds, "ReleaseDate <= ?")
+		args = append(args, fmt.Sprintf("%04d-12-31", f.YearTo))
+	}
+	if f.MinRating > 0 {
+		conds = append(conds, "AvgRating >= ?")
+		args = append(args, f.MinRating)
+	}
+	return conds, args
+}
+
+func (f MovieFilter) match(m Movie) bool {
+	return (f.Genre == "" || strings.EqualFold(m.Genre, f.Genre)) &&
+		(f.YearFrom == 0 || m.ReleaseDate >= fmt.Sprintf("%04d-01-01", f.YearFrom)) &&
+		(f.YearTo == 0 || m.ReleaseDate <= fmt.Sprintf("%04d-12-31", f.YearTo)) &&
+		m.AvgRating >= f.MinRating
+}
+
+func (f PersonFilter) where() ([]string, []interface{}) {
+	if f.Nationality == "" {
+		return nil, nil
+	}
+	return []string{"LOWER(Nationality) = LOWER(?)"}, []interface{}{f.Nationality}
+}
+
+func (f PersonFilter) match(nationality string) bool {
+	return f.Nationality == "" || strings.EqualFold(nationality, f.Nationality)
+}
+
+// pageIn is fetchPage for the in-memory fake: it sorts, seeks and cuts items
+// the way the SQL does.
+func pageIn[T any](items []T, id func(T) int, p pagePlan[T]) Page[T] {
+	key := func(item T) interface{} {
+		if p.sort == nil {
+			return nil
+		}
+		return p.sort.value(item)
+	}
+	// after reports whether a comes after b in the plan's order.
+	after := func(a interface{}, aID int, b interface{}, bID int) bool {
+		c := compareValues(a, b)
+		if c == 0 {
+			c = aID - bID
+		}
+		if p.desc {
+			return c < 0
+		}
+		return c > 0
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		return after(key(items[j]), id(items[j]), key(items[i]), id(items[i]))
+	})
+
+	page := Page[T]{Items: []T{}, Total: len(items)}
+	for _, item := range items {
+		if p.after != nil && !after(key(item), id(item), p.after.Value, p.after.ID) {
+			continue
+		}
+		if len(page.Items) == p.limit {
+			last := page.Items[p.limit-1]
+			page.Next = p.cursorAfter(last, id(last))
+			break
+		}
+		page.Items = append(page.Items, item)
+	}
+	return page
+}
+
+// compareValues compares two sort values, both strings or both numbers.
+// Cursors decode every number as a float64.
+func compareValues(a, b interface{}) int {
+	if as, ok := a.(string); ok {
+		bs, _ := b.(string)
+		return strings.Compare(as, bs)
+	}
+
+	af, bf := toFloat(a), toFloat(b)
+	switch {
+	case af < bf:
+		return -1
+	case af > bf:
+		return 1
+	}
+	return 0
+}
+
+func toFloat(v interface{}) float64 {
+	switch v := v.(type) {
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case float64:
+		return v
+	}
+	return 0
+}
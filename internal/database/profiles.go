@@ -0,0 +1,249 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Profiles. Next to the username and email they signed up with, users have a
+// display name, a bio and an avatar, all optional, and privacy settings that
+// say which of their reviews, likes and watchlist others may see. The
+// settings are enforced by whoever shows a profile to others; the methods
+// here return everything to the user's own requests.
+
+// ErrWrongPassword is returned by ChangePassword when the current password
+// given doesn't match. It isn't ErrUnauthorized, as the request itself is
+// authenticated.
+var ErrWrongPassword = fmt.Errorf("current password does not match: %w", ErrForbidden)
+
+// Privacy says which parts of a user's activity their public profile shows.
+type Privacy struct {
+	Reviews   bool `json:"reviews"`
+	Likes     bool `json:"likes"`
+	Watchlist bool `json:"watchlist"`
+}
+
+// defaultPrivacy matches the column defaults of migration 0008_profiles.
+var defaultPrivacy = Privacy{Reviews: true, Likes: true}
+
+type Profile struct {
+	UserID      int     `json:"user_id"`
+	Username    string  `json:"username"`
+	Email       string  `json:"email"`
+	DisplayName string  `json:"display_name"`
+	Bio         string  `json:"bio"`
+	AvatarURL   string  `json:"avatar_url"`
+	Privacy     Privacy `json:"privacy"`
+}
+
+// ProfileFields are the changes UpdateProfile makes; nil fields stay as
+// they are. An empty DisplayName, Bio or AvatarURL clears it.
+type ProfileFields struct {
+	DisplayName *string
+	Bio         *string
+	AvatarURL   *string
+	Email       *string
+
+	ReviewsPublic   *bool
+	LikesPublic     *bool
+	WatchlistPublic *bool
+}
+
+// UserReview is a review as listed on its author's profile, with the movie
+// it is about.
+type UserReview struct {
+	Review
+	MovieTitle string `json:"movie_title"`
+	MovieSlug  string `json:"movie_slug"`
+}
+
+var userReviewSorts = map[string]listSort[UserReview]{
+	"date":   {"R.DatePosted", func(r UserReview) interface{} { return r.DatePosted }},
+	"rating": {"R.RatingStars", func(r UserReview) interface{} { return r.Stars }},
+}
+
+// userTable lets UpdateProfile share the catalog's update helper.
+var userTable = catalogTable{"USER", "user", "user_id", "Username"}
+
+// clean validates f and returns it with its text trimmed.
+func (f ProfileFields) clean() (ProfileFields, error) {
+	f.DisplayName, f.Bio, f.AvatarURL, f.Email = copyString(f.DisplayName), copyString(f.Bio), copyString(f.AvatarURL), copyString(f.Email)
+
+	c := fieldChecker{}
+	c.optionalText("DisplayName", f.DisplayName, 100)
+	c.optionalText("Bio", f.Bio, 1000)
+	c.optionalText("AvatarURL", f.AvatarURL, 500)
+	if f.AvatarURL != nil && *f.AvatarURL != "" {
+		u, err := url.Parse(*f.AvatarURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			c.problems = append(c.problems, fmt.Sprintf("AvatarURL must be an http or https URL, got %q", *f.AvatarURL))
+		}
+	}
+	if f.Email != nil {
+		*f.Email = strings.TrimSpace(*f.Email)
+		if !strings.Contains(*f.Email, "@") {
+			c.problems = append(c.problems, fmt.Sprintf("invalid email address %q", *f.Email))
+		}
+	}
+	return f, c.err()
+}
+
+// optionalText trims *v in place and checks that it is at most max
+// characters long. Unlike text, it allows empty values.
+func (c *fieldChecker) optionalText(name string, v *string, max int) {
+	if v == nil {
+		return
+	}
+	*v = strings.TrimSpace(*v)
+	if utf8.RuneCountInString(*v) > max {
+		c.problems = append(c.problems, fmt.Sprintf("%s must be at most %d characters", name, max))
+	}
+}
+
+func (f ProfileFields) assignments() []assignment {
+	values := assignments([]string{"DisplayName", "Bio", "AvatarURL", "Email"}, f.DisplayName, f.Bio, f.AvatarURL, f.Email)
+	for _, b := range []struct {
+		column string
+		value  *bool
+	}{
+		{"ReviewsPublic", f.ReviewsPublic},
+		{"LikesPublic", f.LikesPublic},
+		{"WatchlistPublic", f.WatchlistPublic},
+	} {
+		if b.value != nil {
+			values = append(values, assignment{b.column, *b.value})
+		}
+	}
+	return values
+}
+
+func (s *service) GetProfile(ctx context.Context, userID int) (_ Profile, err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
+
+	return getProfile(ctx, s.db, userID)
+}
+
+func getProfile(ctx context.Context, q rowQuerier, userID int) (Profile, error) {
+	var p Profile
+	err := q.QueryRowContext(ctx, `
+		SELECT user_id, Username, Email, DisplayName, Bio, AvatarURL, ReviewsPublic, LikesPublic, WatchlistPublic
+		FROM USER WHERE user_id = ? AND DeleteAfter IS NULL`, userID).
+		Scan(&p.UserID, &p.Username, &p.Email, &p.DisplayName, &p.Bio, &p.AvatarURL, &p.Privacy.Reviews, &p.Privacy.Likes, &p.Privacy.Watchlist)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Profile{}, fmt.Errorf("user %d: %w", userID, ErrNotFound)
+	}
+	if err != nil {
+		return Profile{}, err
+	}
+	return p, nil
+}
+
+func (s *service) UpdateProfile(ctx context.Context, userID int, f ProfileFields) (_ Profile, err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
+
+	if f, err = f.clean(); err != nil {
+		return Profile{}, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Profile{}, err
+	}
+	defer tx.Rollback()
+
+	if err := s.update(ctx, tx, userTable, userID, f.assignments()); err != nil {
+		return Profile{}, err
+	}
+	profile, err := getProfile(ctx, tx, userID)
+	if err != nil {
+		return Profile{}, err
+	}
+
+	return profile, tx.Commit()
+}
+
+// ChangePassword replaces the user's password if current is the one they
+// have now. Every session they have ends, as whoever knew the old password
+// may have started one, and the tokens of a new session are returned in
+// place of the one the request came from.
+func (s *service) ChangePassword(ctx context.Context, userID int, current, password string) (_ TokenPair, err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
+
+	if password == "" {
+		return TokenPair{}, fmt.Errorf("new password is required: %w", ErrValidation)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	defer tx.Rollback()
+
+	var hash string
+	err = tx.QueryRowContext(ctx, "SELECT Password FROM USER WHERE user_id = ?"+s.forUpdate(), userID).Scan(&hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return TokenPair{}, fmt.Errorf("user %d: %w", userID, ErrNotFound)
+	}
+	if err != nil {
+		return TokenPair{}, err
+	}
+	if !comparePasswords(hash, current) {
+		return TokenPair{}, ErrWrongPassword
+	}
+
+	newHash, err := hashPassword(password)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE USER SET Password = ? WHERE user_id = ?", newHash, userID); err != nil {
+		return TokenPair{}, err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE REFRESH_TOKEN SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL", time.Now().Unix(), userID); err != nil {
+		return TokenPair{}, err
+	}
+
+	familyID, err := randomToken(16)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	tokens, err := s.issueTokens(ctx, tx, userID, familyID)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	return tokens, tx.Commit()
+}
+
+// GetUserReviews lists the reviews the user wrote, sorted by "date" or
+// "rating".
+func (s *service) GetUserReviews(ctx context.Context, userID int, req PageRequest) (_ Page[UserReview], err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
+
+	p, err := planPage(req, userReviewSorts)
+	if err != nil {
+		return Page[UserReview]{}, err
+	}
+
+	return fetchPage(ctx, s.db, listQuery[UserReview]{
+		columns:  "R.review_id, R.ReviewText, R.RatingStars, R.DatePosted, R.movie_id, M.Title, M.Slug",
+		from:     "REVIEW R JOIN WROTE W ON W.review_id = R.review_id JOIN MOVIE M ON M.movie_id = R.movie_id",
+		idColumn: "R.review_id",
+		where:    []string{"W.user_id = ?"},
+		args:     []interface{}{userID},
+		scan: func(rows *sql.Rows) (r UserReview, err error) {
+			err = rows.Scan(&r.Id, &r.Review.Review, &r.Stars, &r.DatePosted, &r.MovieId, &r.MovieTitle, &r.MovieSlug)
+			return r, err
+		},
+		id: func(r UserReview) int { return r.Id },
+	}, p)
+}
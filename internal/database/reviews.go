@@ -0,0 +1,166 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"lab2324omada7/internal/config"
+)
+
+// Reviews are listed with their authors, and users can mark the reviews of
+// others as helpful, so readers can sort the most useful to the top. The
+// votes are counted in REVIEW.HelpfulCount as they come and go, just as
+// reviews are in MOVIE.RatingCount.
+
+// MovieReview is a review as a movie's page shows it: with who wrote it and
+// how many found it helpful.
+type MovieReview struct {
+	Review
+	Author       ReviewAuthor `json:"author"`
+	HelpfulCount int          `json:"helpful_count"`
+}
+
+type ReviewAuthor struct {
+	Username    string `json:"username"`
+	DisplayName string `json:"display_name"`
+	AvatarURL   string `json:"avatar_url"`
+}
+
+// ReviewFilter selects the reviews of a movie ShowReview lists.
+type ReviewFilter struct {
+	Stars int // 0 for any
+	// ExcludeUserID leaves out the review of a user who sees their own
+	// apart, through GetMovieReview; 0 leaves out none.
+	ExcludeUserID int
+}
+
+// ReviewVote is whether a user finds a review helpful, and how many do.
+type ReviewVote struct {
+	ReviewID     int  `json:"review_id"`
+	Helpful      bool `json:"helpful"`
+	HelpfulCount int  `json:"helpful_count"`
+}
+
+// The columns scanned into a MovieReview, and the tables they come from.
+const (
+	movieReviewColumns = "R.review_id, R.ReviewText, R.RatingStars, R.DatePosted, R.movie_id, R.HelpfulCount, U.Username, U.DisplayName, U.AvatarURL"
+	movieReviewTables  = "REVIEW R JOIN WROTE W ON W.review_id = R.review_id JOIN USER U ON U.user_id = W.user_id"
+)
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanMovieReview(row rowScanner) (r MovieReview, err error) {
+	err = row.Scan(&r.Id, &r.Review.Review, &r.Stars, &r.DatePosted, &r.MovieId, &r.HelpfulCount,
+		&r.Author.Username, &r.Author.DisplayName, &r.Author.AvatarURL)
+	return r, err
+}
+
+func (f ReviewFilter) check() error {
+	if f.Stars < 0 || f.Stars > 5 {
+		return fmt.Errorf("stars must be between 1 and 5, got %d: %w", f.Stars, ErrValidation)
+	}
+	return nil
+}
+
+func (f ReviewFilter) where() ([]string, []interface{}) {
+	var (
+		conds []string
+		args  []interface{}
+	)
+	if f.Stars != 0 {
+		conds = append(conds, "R.RatingStars = ?")
+		args = append(args, f.Stars)
+	}
+	if f.ExcludeUserID != 0 {
+		conds = append(conds, "W.user_id <> ?")
+		args = append(args, f.ExcludeUserID)
+	}
+	return conds, args
+}
+
+func (f ReviewFilter) match(r memoryReview) bool {
+	return (f.Stars == 0 || r.Stars == f.Stars) && (f.ExcludeUserID == 0 || r.userID != f.ExcludeUserID)
+}
+
+// GetMovieReview returns the user's review of the movie.
+func (s *service) GetMovieReview(ctx context.Context, userID, movieID int) (_ MovieReview, err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
+
+	r, err := scanMovieReview(s.db.QueryRowContext(ctx,
+		"SELECT "+movieReviewColumns+" FROM "+movieReviewTables+" WHERE W.user_id = ? AND R.movie_id = ?", userID, movieID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return MovieReview{}, fmt.Errorf("review of movie %d by user %d: %w", movieID, userID, ErrNotFound)
+	}
+	return r, err
+}
+
+// SetHelpful records whether the user finds the review helpful. Marking a
+// review twice, or unmarking one that isn't marked, changes nothing. Users
+// can't mark their own reviews.
+func (s *service) SetHelpful(ctx context.Context, userID, reviewID int, helpful bool) (_ ReviewVote, err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return ReviewVote{}, err
+	}
+	defer tx.Rollback()
+
+	// The review stays locked until the vote and the count change together.
+	var (
+		authorID int
+		vote     = ReviewVote{ReviewID: reviewID, Helpful: helpful}
+	)
+	err = tx.QueryRowContext(ctx, `
+		SELECT W.user_id, R.HelpfulCount FROM REVIEW R JOIN WROTE W ON W.review_id = R.review_id
+		WHERE R.review_id = ?`+s.forUpdate(), reviewID).Scan(&authorID, &vote.HelpfulCount)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ReviewVote{}, fmt.Errorf("review %d: %w", reviewID, ErrNotFound)
+	}
+	if err != nil {
+		return ReviewVote{}, err
+	}
+	if authorID == userID {
+		return ReviewVote{}, fmt.Errorf("you can't mark your own review helpful: %w", ErrValidation)
+	}
+
+	var (
+		result sql.Result
+		delta  = 1
+	)
+	if helpful {
+		onConflict := " ON DUPLICATE KEY UPDATE user_id = user_id"
+		if s.driver == config.DriverSQLite {
+			onConflict = " ON CONFLICT DO NOTHING"
+		}
+		result, err = tx.ExecContext(ctx, "INSERT INTO REVIEW_HELPFUL (review_id, user_id, CreatedAt) VALUES (?, ?, ?)"+onConflict,
+			reviewID, userID, time.Now().Unix())
+	} else {
+		delta = -1
+		result, err = tx.ExecContext(ctx, "DELETE FROM REVIEW_HELPFUL WHERE review_id = ? AND user_id = ?", reviewID, userID)
+	}
+	if err != nil {
+		return ReviewVote{}, translateError(err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return ReviewVote{}, err
+	}
+	if n == 0 {
+		return vote, nil
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE REVIEW SET HelpfulCount = HelpfulCount + ? WHERE review_id = ?", delta, reviewID); err != nil {
+		return ReviewVote{}, err
+	}
+	vote.HelpfulCount += delta
+	return vote, tx.Commit()
+}
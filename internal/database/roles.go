@@ -0,0 +1,268 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Every account has the "user" role. Moderator and admin are granted on top
+// of it and stored in USER_ROLE (see migration 0005_roles); ROLE_AUDIT logs
+// each grant and revocation. What a role allows is fixed in code as a list
+// of permissions. Access tokens carry both, so handlers can check them
+// without a query.
+
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+const (
+	PermModerateReviews = "reviews:moderate"
+	PermEditCatalog     = "catalog:edit"
+	PermManageRoles     = "roles:manage"
+)
+
+// The actions recorded in ROLE_AUDIT.
+const (
+	RoleGranted = "grant"
+	RoleRevoked = "revoke"
+)
+
+// rolePermissions lists what each role allows. Roles don't inherit from one
+// another: a user's permissions are the union over their roles.
+var rolePermissions = map[string][]string{
+	RoleUser:      nil,
+	RoleModerator: {PermModerateReviews},
+	RoleAdmin:     {PermModerateReviews, PermEditCatalog, PermManageRoles},
+}
+
+// RoleChange is one entry of the role audit trail.
+type RoleChange struct {
+	UserID  int    `json:"user_id"`
+	Role    string `json:"role"`
+	Action  string `json:"action"`
+	ActorID *int   `json:"actor_id"` // nil for changes made from the command line
+	At      int64  `json:"at"`
+}
+
+// Permissions returns, sorted, the permissions that roles grant together.
+func Permissions(roles []string) []string {
+	seen := make(map[string]bool)
+	perms := []string{}
+	for _, role := range roles {
+		for _, perm := range rolePermissions[role] {
+			if !seen[perm] {
+				seen[perm] = true
+				perms = append(perms, perm)
+			}
+		}
+	}
+	sort.Strings(perms)
+	return perms
+}
+
+// checkGrantable rejects roles that can't be granted or revoked: unknown
+// ones, and "user", which every account has.
+func checkGrantable(role string) error {
+	switch role {
+	case RoleModerator, RoleAdmin:
+		return nil
+	case RoleUser:
+		return fmt.Errorf("every account has the %q role: %w", role, ErrValidation)
+	}
+	return fmt.Errorf("unknown role %q: %w", role, ErrValidation)
+}
+
+// withUserRole returns the stored roles, sorted, with "user" added.
+func withUserRole(stored []string) []string {
+	roles := append([]string{RoleUser}, stored...)
+	sort.Strings(roles)
+	return roles
+}
+
+func userRoles(ctx context.Context, q querier, userID int) ([]string, error) {
+	rows, err := q.QueryContext(ctx, "SELECT role FROM USER_ROLE WHERE user_id = ?", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stored []string
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			return nil, err
+		}
+		stored = append(stored, role)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return withUserRole(stored), nil
+}
+
+func (s *service) GetUserRoles(ctx context.Context, userID int) (_ []string, err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
+
+	var exists bool
+	if err := s.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM USER WHERE user_id = ?)", userID).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("user %d: %w", userID, ErrNotFound)
+	}
+
+	return userRoles(ctx, s.db, userID)
+}
+
+// GrantRole gives the user a role. actorID is the admin doing it, or 0 from
+// the command line. Granting a role the user already has changes nothing and
+// isn't logged. The user's access tokens pick the role up when refreshed.
+func (s *service) GrantRole(ctx context.Context, actorID, userID int, role string) (err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
+
+	if err := checkGrantable(role); err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := s.lockUser(ctx, tx, userID); err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	_, err = tx.ExecContext(ctx, "INSERT INTO USER_ROLE (user_id, role, granted_by, granted_at) VALUES (?, ?, ?, ?)",
+		userID, role, nullableID(actorID), now)
+	if err = translateError(err); errors.Is(err, ErrConflict) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := logRoleChange(ctx, tx, userID, role, RoleGranted, actorID, now); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RevokeRole takes a role away from the user and ends all their sessions, so
+// that access tokens still claiming the role stop working at once. The last
+// admin can't be revoked, so there's always someone left to grant roles;
+// admins whose accounts are to be deleted don't count, as for
+// DeleteAccount.
+func (s *service) RevokeRole(ctx context.Context, actorID, userID int, role string) (err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
+
+	if err := checkGrantable(role); err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := s.lockUser(ctx, tx, userID); err != nil {
+		return err
+	}
+
+	if role == RoleAdmin {
+		last, err := s.lastAdmin(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+		if last {
+			return fmt.Errorf("user %d is the last admin: %w", userID, ErrConflict)
+		}
+	}
+
+	result, err := tx.ExecContext(ctx, "DELETE FROM USER_ROLE WHERE user_id = ? AND role = ?", userID, role)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("user %d doesn't have the %q role: %w", userID, role, ErrNotFound)
+	}
+
+	now := time.Now()
+	if err := logRoleChange(ctx, tx, userID, role, RoleRevoked, actorID, now.Unix()); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE REFRESH_TOKEN SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL", now.Unix(), userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetRoleChanges returns the audit trail of the user's roles, newest first.
+func (s *service) GetRoleChanges(ctx context.Context, userID int) (_ []RoleChange, err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
+
+	rows, err := s.db.QueryContext(ctx, "SELECT user_id, role, action, actor_id, created_at FROM ROLE_AUDIT WHERE user_id = ? ORDER BY audit_id DESC", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := []RoleChange{}
+	for rows.Next() {
+		var (
+			change  RoleChange
+			actorID sql.NullInt64
+		)
+		if err := rows.Scan(&change.UserID, &change.Role, &change.Action, &actorID, &change.At); err != nil {
+			return nil, err
+		}
+		if actorID.Valid {
+			id := int(actorID.Int64)
+			change.ActorID = &id
+		}
+		changes = append(changes, change)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return changes, nil
+}
+
+func (s *service) lockUser(ctx context.Context, tx *sql.Tx, userID int) error {
+	var locked int
+	err := tx.QueryRowContext(ctx, "SELECT user_id FROM USER WHERE user_id = ?"+s.forUpdate(), userID).Scan(&locked)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("user %d: %w", userID, ErrNotFound)
+	}
+	return err
+}
+
+func logRoleChange(ctx context.Context, db execer, userID int, role, action string, actorID int, at int64) error {
+	_, err := db.ExecContext(ctx, "INSERT INTO ROLE_AUDIT (user_id, role, action, actor_id, created_at) VALUES (?, ?, ?, ?, ?)",
+		userID, role, action, nullableID(actorID), at)
+	return err
+}
+
+// nullableID stores the ID 0 as NULL.
+func nullableID(id int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(id), Valid: id != 0}
+}
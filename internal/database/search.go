@@ -0,0 +1,187 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"lab2324omada7/internal/config"
+	"lab2324omada7/internal/search"
+)
+
+// Search looks through movie titles, actor and director names and review
+// text at once. On MySQL the FULLTEXT indexes of migration 0007_search find
+// and rank the matches, relying on the columns' case and accent insensitive
+// collation for folding. SQLite and Memory have no such index, so they build
+// a search.Index of the catalog for each query, which is fine for the
+// development and test databases they hold.
+
+// The types of search results.
+const (
+	SearchMovie    = "movie"
+	SearchActor    = "actor"
+	SearchDirector = "director"
+	SearchReview   = "review"
+)
+
+const (
+	DefaultSearchLimit = 20
+	MaxSearchLimit     = 100
+)
+
+// searchWeights makes a title or name match outrank a mention in a review.
+var searchWeights = map[string]float64{
+	SearchMovie:    3,
+	SearchActor:    3,
+	SearchDirector: 3,
+	SearchReview:   1,
+}
+
+type SearchResult struct {
+	Type string `json:"type"`
+	ID   int    `json:"id"`
+	// Slug and Title are the movie's, actor's or director's; for a review,
+	// those of the movie reviewed.
+	Slug  string `json:"slug"`
+	Title string `json:"title"`
+	// Highlight is the matched title, name or review text as HTML, with the
+	// matching words in <mark> elements. Long reviews are cut down to a
+	// snippet around the first match.
+	Highlight string  `json:"highlight"`
+	Score     float64 `json:"score"`
+}
+
+// checkSearch returns the terms of query and the number of results to
+// return, or ErrValidation.
+func checkSearch(query string, limit int) ([]string, int, error) {
+	terms := search.Terms(query)
+	if len(terms) == 0 {
+		return nil, 0, fmt.Errorf("search query %q has no words: %w", query, ErrValidation)
+	}
+	if limit == 0 {
+		limit = DefaultSearchLimit
+	}
+	if limit < 0 || limit > MaxSearchLimit {
+		return nil, 0, fmt.Errorf("limit must be between 1 and %d, got %d: %w", MaxSearchLimit, limit, ErrValidation)
+	}
+	return terms, limit, nil
+}
+
+// searchable is a text to search, and the result it gives when it matches.
+type searchable struct {
+	result SearchResult
+	text   string
+}
+
+// searchIn searches texts with a search.Index.
+func searchIn(texts []searchable, terms []string, limit int) []SearchResult {
+	ix := search.NewIndex()
+	for _, t := range texts {
+		ix.Add(t.text, searchWeights[t.result.Type])
+	}
+
+	results := []SearchResult{}
+	for _, hit := range ix.Search(terms, limit) {
+		t := texts[hit.Doc]
+		t.result.Highlight = search.Highlight(t.text, terms)
+		t.result.Score = hit.Score
+		results = append(results, t.result)
+	}
+	return results
+}
+
+func (s *service) Search(ctx context.Context, query string, limit int) (_ []SearchResult, err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
+
+	terms, limit, err := checkSearch(query, limit)
+	if err != nil {
+		return nil, err
+	}
+	if s.driver == config.DriverSQLite {
+		texts, err := s.searchables(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return searchIn(texts, terms, limit), nil
+	}
+
+	// Every term is required, and matches words starting with it. Terms
+	// hold only letters and digits, so none of them is read as an operator.
+	against := "+" + strings.Join(terms, "* +") + "*"
+
+	// Each SELECT weights its relevance so that the union can be ranked
+	// as a whole.
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT 'movie', movie_id, Slug, Title, Title, MATCH (Title) AGAINST (? IN BOOLEAN MODE) * ? AS score
+		FROM MOVIE WHERE MATCH (Title) AGAINST (? IN BOOLEAN MODE)
+		UNION ALL
+		SELECT 'actor', actor_id, Slug, ActorName, ActorName, MATCH (ActorName) AGAINST (? IN BOOLEAN MODE) * ?
+		FROM ACTOR WHERE MATCH (ActorName) AGAINST (? IN BOOLEAN MODE)
+		UNION ALL
+		SELECT 'director', director_id, Slug, DirectorName, DirectorName, MATCH (DirectorName) AGAINST (? IN BOOLEAN MODE) * ?
+		FROM DIRECTOR WHERE MATCH (DirectorName) AGAINST (? IN BOOLEAN MODE)
+		UNION ALL
+		SELECT 'review', R.review_id, M.Slug, M.Title, R.ReviewText, MATCH (R.ReviewText) AGAINST (? IN BOOLEAN MODE) * ?
+		FROM REVIEW R JOIN MOVIE M ON M.movie_id = R.movie_id WHERE MATCH (R.ReviewText) AGAINST (? IN BOOLEAN MODE)
+		ORDER BY score DESC
+		LIMIT ?`,
+		against, searchWeights[SearchMovie], against,
+		against, searchWeights[SearchActor], against,
+		against, searchWeights[SearchDirector], against,
+		against, searchWeights[SearchReview], against,
+		limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []SearchResult{}
+	for rows.Next() {
+		var (
+			result SearchResult
+			text   string
+		)
+		if err := rows.Scan(&result.Type, &result.ID, &result.Slug, &result.Title, &text, &result.Score); err != nil {
+			return nil, err
+		}
+		result.Highlight = search.Highlight(text, terms)
+		results = append(results, result)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// searchables reads every title, name and review for searchIn.
+func (s *service) searchables(ctx context.Context) ([]searchable, error) {
+	var texts []searchable
+	for _, q := range []struct {
+		kind, query string
+	}{
+		{SearchMovie, "SELECT movie_id, Slug, Title, Title FROM MOVIE ORDER BY movie_id"},
+		{SearchActor, "SELECT actor_id, Slug, ActorName, ActorName FROM ACTOR ORDER BY actor_id"},
+		{SearchDirector, "SELECT director_id, Slug, DirectorName, DirectorName FROM DIRECTOR ORDER BY director_id"},
+		{SearchReview, "SELECT R.review_id, M.Slug, M.Title, R.ReviewText FROM REVIEW R JOIN MOVIE M ON M.movie_id = R.movie_id ORDER BY R.review_id"},
+	} {
+		rows, err := s.db.QueryContext(ctx, q.query)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			t := searchable{result: SearchResult{Type: q.kind}}
+			if err := rows.Scan(&t.result.ID, &t.result.Slug, &t.result.Title, &t.text); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			texts = append(texts, t)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return texts, nil
+}
@@ -0,0 +1,1674 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"lab2324omada7/internal/config"
+	"lab2324omada7/internal/migrations"
+	"lab2324omada7/internal/seed"
+)
+
+// backends lists every Service implementation. The tests below run against
+// each of them, so the in-memory fake can't drift from the SQL behaviour.
+var backends = []struct {
+	name string
+	new  func(t *testing.T) Service
+}{
+	{"sqlite", newSQLiteService},
+	{"memory", newMemoryService},
+}
+
+func forEachBackend(t *testing.T, test func(t *testing.T, s Service)) {
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) { test(t, b.new(t)) })
+	}
+}
+
+// newSQLiteService returns a Service backed by a fresh SQLite file with every
+// migration applied and the sample catalog seeded, so the tests below run the
+// real queries without needing a database server.
+func newSQLiteService(t *testing.T) Service {
+	t.Helper()
+
+	cfg := &config.Config{
+		JWTKey: []byte("test-key"),
+		Database: config.Database{
+			Driver: config.DriverSQLite,
+			Path:   filepath.Join(t.TempDir(), "test.db"),
+		},
+	}
+
+	svc, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	s := svc.(*service)
+	t.Cleanup(func() { s.db.Close() })
+
+	ctx := context.Background()
+
+	m, err := migrations.New(s.db, config.DriverSQLite)
+	if err != nil {
+		t.Fatalf("migrations.New: %v", err)
+	}
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatalf("migrating: %v", err)
+	}
+
+	fixtures, err := seed.Default()
+	if err != nil {
+		t.Fatalf("seed.Default: %v", err)
+	}
+	// Seeding twice must leave the database as a single run would.
+	for i := 0; i < 2; i++ {
+		if err := seed.Apply(ctx, s.db, fixtures); err != nil {
+			t.Fatalf("seeding (run %d): %v", i+1, err)
+		}
+	}
+
+	return s
+}
+
+func newMemoryService(t *testing.T) Service {
+	t.Helper()
+
+	fixtures, err := seed.Default()
+	if err != nil {
+		t.Fatalf("seed.Default: %v", err)
+	}
+
+	m := NewMemory([]byte("test-key"))
+	if err := m.Load(fixtures); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	return m
+}
+
+func TestServiceCatalog(t *testing.T) {
+	forEachBackend(t, testCatalog)
+}
+
+func TestServicePagination(t *testing.T) {
+	forEachBackend(t, testPagination)
+}
+
+func TestServiceSearch(t *testing.T) {
+	forEachBackend(t, testSearch)
+}
+
+func TestServiceLookupByRef(t *testing.T) {
+	forEachBackend(t, testLookupByRef)
+}
+
+func TestServiceCatalogEditing(t *testing.T) {
+	forEachBackend(t, testCatalogEditing)
+}
+
+func TestServiceRoles(t *testing.T) {
+	forEachBackend(t, testRoles)
+}
+
+func TestServiceSessions(t *testing.T) {
+	forEachBackend(t, testSessions)
+}
+
+func TestServiceUsersAndReviews(t *testing.T) {
+	forEachBackend(t, testUsersAndReviews)
+}
+
+func TestServiceProfiles(t *testing.T) {
+	forEachBackend(t, testProfiles)
+}
+
+func TestServiceMovieStatuses(t *testing.T) {
+	forEachBackend(t, testMovieStatuses)
+}
+
+func TestServiceCollectionEditing(t *testing.T) {
+	forEachBackend(t, testCollectionEditing)
+}
+
+func TestServiceLists(t *testing.T) {
+	forEachBackend(t, testLists)
+}
+
+func TestServiceDiary(t *testing.T) {
+	forEachBackend(t, testDiary)
+}
+
+func TestServiceImports(t *testing.T) {
+	forEachBackend(t, testImports)
+}
+
+func TestServiceAccounts(t *testing.T) {
+	forEachBackend(t, testAccounts)
+}
+
+func TestServiceReviews(t *testing.T) {
+	forEachBackend(t, testReviews)
+}
+
+func testCatalog(t *testing.T, s Service) {
+	ctx := context.Background()
+
+	if _, err := s.Health(ctx); err != nil {
+		t.Errorf("Health: %v", err)
+	}
+
+	movies, err := s.GetMovies(ctx, MovieFilter{}, PageRequest{})
+	if err != nil || len(movies.Items) != 13 || movies.Total != 13 || movies.Next != "" {
+		t.Fatalf("GetMovies returned %d of %d movies, %v; want 13", len(movies.Items), movies.Total, err)
+	}
+
+	movie, err := s.GetMovie(ctx, "the-godfather")
+	if err != nil {
+		t.Fatalf("GetMovie: %v", err)
+	}
+	if movie.Id != 1 || movie.ReleaseDate != "1972-03-24" || movie.AvgRating == 0 {
+		t.Errorf("GetMovie = %+v", movie)
+	}
+
+	if _, err := s.GetMovie(ctx, "no-such-movie"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetMovie(unknown): got %v; want %v", err, ErrNotFound)
+	}
+
+	if director, err := s.GetDirector(ctx, "7"); err != nil || director.Name != "Γιώργος Λάνθιμος" {
+		t.Errorf("GetDirector = %+v, %v", director, err)
+	}
+	if _, err := s.GetActor(ctx, "abc"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetActor(abc): got %v; want %v", err, ErrNotFound)
+	}
+
+	directed, err := s.GetMoviesByDirectorID(ctx, 7)
+	if err != nil || len(directed) != 3 {
+		t.Errorf("GetMoviesByDirectorID returned %d movies, %v; want 3", len(directed), err)
+	}
+	staff, err := s.GetStaffByMovieID(ctx, 5)
+	if err != nil || len(staff) != 4 {
+		t.Errorf("GetStaffByMovieID returned %d members, %v; want 4", len(staff), err)
+	}
+}
+
+// testPagination walks the lists page by page in every order, and checks
+// the filters and the errors for bad requests.
+func testPagination(t *testing.T, s Service) {
+	ctx := context.Background()
+
+	movieSorted := map[string]func(a, b Movie) bool{
+		"":              func(a, b Movie) bool { return a.Id < b.Id },
+		"-id":           func(a, b Movie) bool { return a.Id > b.Id },
+		"title":         func(a, b Movie) bool { return a.Title <= b.Title },
+		"release_date":  func(a, b Movie) bool { return a.ReleaseDate <= b.ReleaseDate },
+		"-avg_rating":   func(a, b Movie) bool { return a.AvgRating >= b.AvgRating },
+		"-review_count": func(a, b Movie) bool { return a.ReviewCount >= b.ReviewCount },
+	}
+	for sort, inOrder := range movieSorted {
+		movies := walkPages(t, func(req PageRequest) (Page[Movie], error) {
+			req.Sort = sort
+			return s.GetMovies(ctx, MovieFilter{}, req)
+		}, func(m Movie) int { return m.Id })
+		if len(movies) != 13 {
+			t.Errorf("sort %q: got %d movies; want 13", sort, len(movies))
+		}
+		for i := 1; i < len(movies); i++ {
+			if !inOrder(movies[i-1], movies[i]) {
+				t.Errorf("sort %q: %q comes before %q", sort, movies[i-1].Title, movies[i].Title)
+			}
+		}
+	}
+
+	actors := walkPages(t, func(req PageRequest) (Page[Actor], error) {
+		req.Sort = "-date_of_birth"
+		return s.GetActors(ctx, PersonFilter{}, req)
+	}, func(a Actor) int { return a.ID })
+	if len(actors) != 19 || actors[0].Name != "Emma Stone" {
+		t.Errorf("GetActors by -date_of_birth: got %d actors, first %+v", len(actors), actors[0])
+	}
+	reviews := walkPages(t, func(req PageRequest) (Page[MovieReview], error) {
+		req.Sort = "-rating"
+		return s.ShowReview(ctx, "the-godfather", ReviewFilter{}, req)
+	}, func(r MovieReview) int { return r.Id })
+	if len(reviews) != 2 || reviews[0].Stars != 5 {
+		t.Errorf("ShowReview by -rating = %+v", reviews)
+	}
+
+	movieFilters := map[MovieFilter]int{
+		{Genre: "crime"}:                 4,
+		{YearFrom: 1990, YearTo: 2009}:   6,
+		{YearFrom: 2015}:                 2,
+		{MinRating: 4.5}:                 3,
+		{Genre: "Crime", MinRating: 4.5}: 2,
+		{Genre: "Western"}:               0,
+	}
+	for f, want := range movieFilters {
+		page, err := s.GetMovies(ctx, f, PageRequest{Limit: 1})
+		if err != nil || page.Total != want || len(page.Items) != min(want, 1) {
+			t.Errorf("GetMovies(%+v) = %d of %d, %v; want %d", f, len(page.Items), page.Total, err, want)
+		}
+	}
+	if page, err := s.GetActors(ctx, PersonFilter{Nationality: "greek"}, PageRequest{}); err != nil || page.Total != 4 {
+		t.Errorf("GetActors(greek) = %d, %v; want 4", page.Total, err)
+	}
+	if page, err := s.GetDirectors(ctx, PersonFilter{Nationality: "Greek"}, PageRequest{}); err != nil || page.Total != 2 {
+		t.Errorf("GetDirectors(Greek) = %d, %v; want 2", page.Total, err)
+	}
+
+	first, err := s.GetMovies(ctx, MovieFilter{}, PageRequest{Sort: "title", Limit: 5})
+	if err != nil {
+		t.Fatalf("GetMovies: %v", err)
+	}
+	bad := []PageRequest{
+		{Sort: "budget"},
+		{Limit: -1},
+		{Limit: MaxPageSize + 1},
+		{Cursor: "not a cursor"},
+		// A cursor only goes with the sort it was made for.
+		{Sort: "release_date", Cursor: first.Next},
+	}
+	for _, req := range bad {
+		if _, err := s.GetMovies(ctx, MovieFilter{}, req); !errors.Is(err, ErrValidation) {
+			t.Errorf("GetMovies(%+v): got %v; want %v", req, err, ErrValidation)
+		}
+	}
+}
+
+// walkPages fetches every page of a list, three items at a time, and fails
+// the test if an item shows up twice or the totals disagree.
+func walkPages[T any](t *testing.T, fetch func(PageRequest) (Page[T], error), id func(T) int) []T {
+	t.Helper()
+
+	var (
+		items []T
+		seen  = make(map[int]bool)
+		req   = PageRequest{Limit: 3}
+	)
+	for {
+		page, err := fetch(req)
+		if err != nil {
+			t.Fatalf("fetching page after %q: %v", req.Cursor, err)
+		}
+		for _, item := range page.Items {
+			if seen[id(item)] {
+				t.Errorf("item %d on two pages", id(item))
+			}
+			seen[id(item)] = true
+		}
+		items = append(items, page.Items...)
+		if page.Next == "" {
+			if len(items) != page.Total {
+				t.Errorf("got %d items; Total says %d", len(items), page.Total)
+			}
+			return items
+		}
+		req.Cursor = page.Next
+	}
+}
+
+// testSearch checks that search finds movies, people and reviews whatever
+// the case and accents of the query, and sees new reviews at once.
+func testSearch(t *testing.T, s Service) {
+	ctx := context.Background()
+
+	cases := []struct {
+		query string
+		want  []SearchResult
+	}{
+		{"godfather", []SearchResult{
+			{Type: SearchMovie, ID: 1, Slug: "the-godfather", Title: "The Godfather", Highlight: "The <mark>Godfather</mark>"},
+			{Type: SearchMovie, ID: 2, Slug: "the-godfather-part-ii", Title: "The Godfather Part II", Highlight: "The <mark>Godfather</mark> Part II"},
+		}},
+		{"ΚΥΝΟΔΟΝΤΑΣ", []SearchResult{
+			{Type: SearchMovie, ID: 10, Slug: "κυνόδοντας", Title: "Κυνόδοντας", Highlight: "<mark>Κυνόδοντας</mark>"},
+		}},
+		{"λανθ γιωργ", []SearchResult{
+			{Type: SearchDirector, ID: 7, Slug: "γιώργος-λάνθιμος", Title: "Γιώργος Λάνθιμος", Highlight: "<mark>Γιώργος</mark> <mark>Λάνθιμος</mark>"},
+		}},
+		{"Royale", []SearchResult{
+			{Type: SearchReview, ID: 4, Slug: "pulp-fiction", Title: "Pulp Fiction", Highlight: "<mark>Royale</mark> with cheese."},
+		}},
+		{"no such thing", nil},
+	}
+	for _, c := range cases {
+		results, err := s.Search(ctx, c.query, 0)
+		if err != nil {
+			t.Errorf("Search(%q): %v", c.query, err)
+			continue
+		}
+		if len(results) != len(c.want) {
+			t.Errorf("Search(%q) = %+v; want %d results", c.query, results, len(c.want))
+			continue
+		}
+		for i, r := range results {
+			if r.Score <= 0 {
+				t.Errorf("Search(%q)[%d] has score %v", c.query, i, r.Score)
+			}
+			r.Score = 0
+			if r != c.want[i] {
+				t.Errorf("Search(%q)[%d] = %+v; want %+v", c.query, i, r, c.want[i])
+			}
+		}
+	}
+
+	// Titles and names outrank reviews.
+	if err := s.AddReview(ctx, "spider-man", 4, "Better than the godfather, honestly.", 3); err != nil {
+		t.Fatalf("AddReview: %v", err)
+	}
+	results, err := s.Search(ctx, "godfather", 0)
+	if err != nil || len(results) != 3 || results[2].Type != SearchReview || results[2].Slug != "spider-man" {
+		t.Errorf("Search(godfather) after AddReview = %+v, %v; want the new review last", results, err)
+	}
+	if results, err := s.Search(ctx, "the", 2); err != nil || len(results) != 2 || results[0].Score < results[1].Score {
+		t.Errorf("Search(the, 2) = %+v, %v", results, err)
+	}
+
+	for _, bad := range []struct {
+		query string
+		limit int
+	}{{"", 0}, {"?!", 0}, {"godfather", -1}, {"godfather", MaxSearchLimit + 1}} {
+		if _, err := s.Search(ctx, bad.query, bad.limit); !errors.Is(err, ErrValidation) {
+			t.Errorf("Search(%q, %d): got %v; want %v", bad.query, bad.limit, err, ErrValidation)
+		}
+	}
+}
+
+// testLookupByRef checks that movies and people are found by ID, by slug and
+// by the hyphenated titles and names older URLs used.
+func testLookupByRef(t *testing.T, s Service) {
+	ctx := context.Background()
+
+	movies := map[string]int{
+		"7":                 7,
+		"spider-man":        7,
+		"Spider-Man":        7,
+		"kill-bill-vol-1":   6,
+		"Kill-Bill:-Vol.-1": 6,
+		"μια-αιωνιότητα-και-μια-μέρα": 13,
+		"Μια-αιωνιότητα-και-μια-μέρα": 13,
+		"Κυνόδοντας":                  10,
+	}
+	for ref, want := range movies {
+		movie, err := s.GetMovie(ctx, ref)
+		if err != nil || movie.Id != want {
+			t.Errorf("GetMovie(%q) = %d, %v; want %d", ref, movie.Id, err, want)
+		}
+	}
+	if movie, _ := s.GetMovie(ctx, "6"); movie.Slug != "kill-bill-vol-1" {
+		t.Errorf("GetMovie(6).Slug = %q", movie.Slug)
+	}
+	for _, ref := range []string{"99", "0", "99999999999999999999", "spider"} {
+		if _, err := s.GetMovie(ctx, ref); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetMovie(%q): got %v; want %v", ref, err, ErrNotFound)
+		}
+	}
+
+	if director, err := s.GetDirector(ctx, "γιώργος-λάνθιμος"); err != nil || director.ID != 7 {
+		t.Errorf("GetDirector(slug) = %+v, %v", director, err)
+	}
+	if actor, err := s.GetActor(ctx, "samuel-l-jackson"); err != nil || actor.ID != 7 {
+		t.Errorf("GetActor(slug) = %+v, %v", actor, err)
+	}
+	if actor, err := s.GetActor(ctx, "Robert-De-Niro"); err != nil || actor.Slug != "robert-de-niro" {
+		t.Errorf("GetActor(name) = %+v, %v", actor, err)
+	}
+
+	staff, err := s.GetStaffByMovieID(ctx, 11)
+	if err != nil || len(staff) == 0 {
+		t.Fatalf("GetStaffByMovieID = %v, %v", staff, err)
+	}
+	for _, member := range staff {
+		if member.Slug == "" {
+			t.Errorf("staff member %+v has no slug", member)
+		}
+	}
+}
+
+func str(s string) *string { return &s }
+
+// testCatalogEditing runs through what the admin endpoints do: creating,
+// changing and deleting movies and people, and crediting them.
+func testCatalogEditing(t *testing.T, s Service) {
+	ctx := context.Background()
+
+	movie, err := s.CreateMovie(ctx, MovieFields{Title: str(" Taxi Driver "), ReleaseDate: str("2026-01-01"), Genre: str("Drama")})
+	if err != nil {
+		t.Fatalf("CreateMovie: %v", err)
+	}
+	if movie.Id == 0 || movie.Title != "Taxi Driver" || movie.Slug != fmt.Sprintf("taxi-driver-%d", movie.Id) {
+		t.Errorf("CreateMovie = %+v; want a trimmed title and a slug with the ID, the plain one being taken", movie)
+	}
+	if got, err := s.GetMovie(ctx, movie.Slug); err != nil || got.Id != movie.Id {
+		t.Errorf("GetMovie(%q) = %+v, %v", movie.Slug, got, err)
+	}
+
+	if _, err := s.CreateMovie(ctx, MovieFields{Title: str("Heat"), ReleaseDate: str("1995-12-15"), Genre: str("Crime"), Slug: str("goodfellas")}); !errors.Is(err, ErrConflict) {
+		t.Errorf("CreateMovie(taken slug): got %v; want %v", err, ErrConflict)
+	}
+	for name, f := range map[string]MovieFields{
+		"missing genre": {Title: str("Heat"), ReleaseDate: str("1995-12-15")},
+		"blank title":   {Title: str("  "), ReleaseDate: str("1995-12-15"), Genre: str("Crime")},
+		"bad date":      {Title: str("Heat"), ReleaseDate: str("15/12/1995"), Genre: str("Crime")},
+		"bad slug":      {Title: str("Heat"), ReleaseDate: str("1995-12-15"), Genre: str("Crime"), Slug: str("Heat!")},
+		"numeric slug":  {Title: str("Heat"), ReleaseDate: str("1995-12-15"), Genre: str("Crime"), Slug: str("1995")},
+	} {
+		if _, err := s.CreateMovie(ctx, f); !errors.Is(err, ErrValidation) {
+			t.Errorf("CreateMovie(%s): got %v; want %v", name, err, ErrValidation)
+		}
+	}
+
+	// Renaming keeps the slug, so links keep working, unless one is given.
+	updated, err := s.UpdateMovie(ctx, movie.Id, MovieFields{Title: str("Taxi Driver (2026)")})
+	if err != nil || updated.Title != "Taxi Driver (2026)" || updated.Slug != movie.Slug || updated.Genre != "Drama" {
+		t.Errorf("UpdateMovie(title) = %+v, %v", updated, err)
+	}
+	updated, err = s.UpdateMovie(ctx, movie.Id, MovieFields{Slug: str("taxi-driver-2026")})
+	if err != nil || updated.Slug != "taxi-driver-2026" {
+		t.Errorf("UpdateMovie(slug) = %+v, %v", updated, err)
+	}
+	if _, err := s.UpdateMovie(ctx, movie.Id, MovieFields{Slug: str("the-godfather")}); !errors.Is(err, ErrConflict) {
+		t.Errorf("UpdateMovie(taken slug): got %v; want %v", err, ErrConflict)
+	}
+	if _, err := s.UpdateMovie(ctx, 999, MovieFields{Genre: str("Drama")}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("UpdateMovie(unknown): got %v; want %v", err, ErrNotFound)
+	}
+
+	actor, err := s.CreateActor(ctx, PersonFields{Name: str("Cybill Shepherd"), DateOfBirth: str("1950-02-18"), Nationality: str("American")})
+	if err != nil || actor.Slug != "cybill-shepherd" {
+		t.Fatalf("CreateActor = %+v, %v", actor, err)
+	}
+	director, err := s.CreateDirector(ctx, PersonFields{Name: str("Paul Schrader"), DateOfBirth: str("1946-07-22"), Nationality: str("American")})
+	if err != nil || director.Slug != "paul-schrader" {
+		t.Fatalf("CreateDirector = %+v, %v", director, err)
+	}
+	if updated, err := s.UpdateActor(ctx, actor.ID, PersonFields{Nationality: str("USA")}); err != nil || updated.Nationality != "USA" || updated.Name != "Cybill Shepherd" {
+		t.Errorf("UpdateActor = %+v, %v", updated, err)
+	}
+	if _, err := s.UpdateDirector(ctx, director.ID, PersonFields{Slug: str("martin-scorsese")}); !errors.Is(err, ErrConflict) {
+		t.Errorf("UpdateDirector(taken slug): got %v; want %v", err, ErrConflict)
+	}
+
+	// Crediting is idempotent; removing a credit that isn't there is not.
+	for i := 0; i < 2; i++ {
+		if err := s.AddCredit(ctx, movie.Id, CreditActor, actor.ID); err != nil {
+			t.Errorf("AddCredit(actor, run %d): %v", i+1, err)
+		}
+	}
+	if err := s.AddCredit(ctx, movie.Id, CreditDirector, director.ID); err != nil {
+		t.Errorf("AddCredit(director): %v", err)
+	}
+	if err := s.AddCredit(ctx, movie.Id, CreditActor, 999); !errors.Is(err, ErrValidation) {
+		t.Errorf("AddCredit(unknown actor): got %v; want %v", err, ErrValidation)
+	}
+	if err := s.AddCredit(ctx, movie.Id, "writer", actor.ID); !errors.Is(err, ErrValidation) {
+		t.Errorf("AddCredit(writer): got %v; want %v", err, ErrValidation)
+	}
+	if staff, err := s.GetStaffByMovieID(ctx, movie.Id); err != nil || len(staff) != 2 {
+		t.Errorf("GetStaffByMovieID = %+v, %v; want the actor and the director", staff, err)
+	}
+	if err := s.RemoveCredit(ctx, movie.Id, CreditDirector, director.ID); err != nil {
+		t.Errorf("RemoveCredit: %v", err)
+	}
+	if err := s.RemoveCredit(ctx, movie.Id, CreditDirector, director.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("RemoveCredit(again): got %v; want %v", err, ErrNotFound)
+	}
+
+	// Deleting a person removes their credits with them.
+	if err := s.DeleteActor(ctx, actor.ID); err != nil {
+		t.Errorf("DeleteActor: %v", err)
+	}
+	if staff, err := s.GetStaffByMovieID(ctx, movie.Id); err != nil || len(staff) != 0 {
+		t.Errorf("GetStaffByMovieID after DeleteActor = %+v, %v", staff, err)
+	}
+	if err := s.DeleteActor(ctx, actor.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("DeleteActor(again): got %v; want %v", err, ErrNotFound)
+	}
+	if err := s.DeleteDirector(ctx, director.ID); err != nil {
+		t.Errorf("DeleteDirector: %v", err)
+	}
+
+	if err := s.DeleteMovie(ctx, movie.Id, false); err != nil {
+		t.Errorf("DeleteMovie(unused): %v", err)
+	}
+	if _, err := s.GetMovie(ctx, "taxi-driver-2026"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetMovie after DeleteMovie: got %v; want %v", err, ErrNotFound)
+	}
+
+	// Taxi Driver is only on a list, which is enough to keep it.
+	title := "Seventies"
+	list, err := s.CreateList(ctx, 2, ListFields{Title: &title})
+	if err != nil {
+		t.Fatalf("CreateList: %v", err)
+	}
+	if _, err := s.PutListEntry(ctx, 2, list.ID, 3, EntryFields{}); err != nil {
+		t.Fatalf("PutListEntry: %v", err)
+	}
+	if err := s.DeleteMovie(ctx, 3, false); !errors.Is(err, ErrConflict) {
+		t.Errorf("DeleteMovie(listed): got %v; want %v", err, ErrConflict)
+	}
+
+	// So is a diary entry for Goodfellas.
+	if _, err := s.LogWatch(ctx, 3, 4, DiaryFields{WatchedOn: str("2024-06-01")}); err != nil {
+		t.Fatalf("LogWatch: %v", err)
+	}
+	if err := s.DeleteMovie(ctx, 4, false); !errors.Is(err, ErrConflict) {
+		t.Errorf("DeleteMovie(watched): got %v; want %v", err, ErrConflict)
+	}
+
+	// The Godfather has reviews and likes, so it only goes with cascade.
+	if err := s.DeleteMovie(ctx, 1, false); !errors.Is(err, ErrConflict) {
+		t.Errorf("DeleteMovie(reviewed): got %v; want %v", err, ErrConflict)
+	}
+	if err := s.DeleteMovie(ctx, 1, true); err != nil {
+		t.Errorf("DeleteMovie(cascade): %v", err)
+	}
+	if reviews, err := s.ShowReview(ctx, "1", ReviewFilter{}, PageRequest{}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("ShowReview after cascade = %+v, %v; want %v", reviews, err, ErrNotFound)
+	}
+	if err := s.DeleteMovie(ctx, 1, true); !errors.Is(err, ErrNotFound) {
+		t.Errorf("DeleteMovie(again): got %v; want %v", err, ErrNotFound)
+	}
+}
+
+// testRoles checks granting and revoking roles, and the audit trail it
+// leaves. The fixtures make alice (1) an admin and nikos (3) a moderator.
+func testRoles(t *testing.T, s Service) {
+	ctx := context.Background()
+
+	roles := func(userID int) string {
+		t.Helper()
+		roles, err := s.GetUserRoles(ctx, userID)
+		if err != nil {
+			t.Fatalf("GetUserRoles(%d): %v", userID, err)
+		}
+		return fmt.Sprint(roles)
+	}
+
+	for userID, want := range map[int]string{1: "[admin user]", 2: "[user]", 3: "[moderator user]"} {
+		if got := roles(userID); got != want {
+			t.Errorf("GetUserRoles(%d) = %s; want %s", userID, got, want)
+		}
+	}
+	if _, err := s.GetUserRoles(ctx, 99); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetUserRoles(unknown): got %v; want %v", err, ErrNotFound)
+	}
+	if got := fmt.Sprint(Permissions([]string{RoleAdmin, RoleModerator, RoleUser})); got != "[catalog:edit reviews:moderate roles:manage]" {
+		t.Errorf("Permissions = %s", got)
+	}
+
+	// Granting twice is one grant.
+	for i := 0; i < 2; i++ {
+		if err := s.GrantRole(ctx, 1, 2, RoleModerator); err != nil {
+			t.Fatalf("GrantRole (run %d): %v", i+1, err)
+		}
+	}
+	if got := roles(2); got != "[moderator user]" {
+		t.Errorf("roles after grant = %s", got)
+	}
+
+	for name, role := range map[string]string{"user": RoleUser, "unknown": "owner", "empty": ""} {
+		if err := s.GrantRole(ctx, 1, 2, role); !errors.Is(err, ErrValidation) {
+			t.Errorf("GrantRole(%s): got %v; want %v", name, err, ErrValidation)
+		}
+	}
+	if err := s.GrantRole(ctx, 1, 99, RoleAdmin); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GrantRole(unknown user): got %v; want %v", err, ErrNotFound)
+	}
+
+	// Revoking ends the user's sessions.
+	tokens, err := s.IssueTokens(ctx, 2)
+	if err != nil {
+		t.Fatalf("IssueTokens: %v", err)
+	}
+	if err := s.RevokeRole(ctx, 0, 2, RoleModerator); err != nil {
+		t.Fatalf("RevokeRole: %v", err)
+	}
+	if got := roles(2); got != "[user]" {
+		t.Errorf("roles after revoke = %s", got)
+	}
+	if _, err := s.RefreshTokens(ctx, tokens.RefreshToken); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("RefreshTokens after revoke: got %v; want %v", err, ErrUnauthorized)
+	}
+	if err := s.RevokeRole(ctx, 0, 2, RoleModerator); !errors.Is(err, ErrNotFound) {
+		t.Errorf("RevokeRole(again): got %v; want %v", err, ErrNotFound)
+	}
+
+	if err := s.RevokeRole(ctx, 1, 1, RoleAdmin); !errors.Is(err, ErrConflict) {
+		t.Errorf("RevokeRole(last admin): got %v; want %v", err, ErrConflict)
+	}
+	if got := roles(1); got != "[admin user]" {
+		t.Errorf("roles of the last admin after a refused revoke = %s", got)
+	}
+
+	changes, err := s.GetRoleChanges(ctx, 2)
+	if err != nil {
+		t.Fatalf("GetRoleChanges: %v", err)
+	}
+	if len(changes) != 2 {
+		t.Fatalf("GetRoleChanges = %+v; want a grant and a revocation", changes)
+	}
+	revoked, granted := changes[0], changes[1]
+	if revoked.Action != RoleRevoked || revoked.Role != RoleModerator || revoked.ActorID != nil || revoked.At == 0 {
+		t.Errorf("newest change = %+v", revoked)
+	}
+	if granted.Action != RoleGranted || granted.UserID != 2 || granted.ActorID == nil || *granted.ActorID != 1 {
+		t.Errorf("oldest change = %+v", granted)
+	}
+	if changes, err := s.GetRoleChanges(ctx, 1); err != nil || len(changes) != 0 {
+		t.Errorf("GetRoleChanges(1) = %+v, %v; want none", changes, err)
+	}
+}
+
+// sessionOf returns the token family ("sid") an access token belongs to.
+func sessionOf(t *testing.T, accessToken string) string {
+	t.Helper()
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(accessToken, claims); err != nil {
+		t.Fatal(err)
+	}
+	sid, _ := claims["sid"].(string)
+	return sid
+}
+
+func testSessions(t *testing.T, s Service) {
+	ctx := context.Background()
+
+	active := func(tokens TokenPair) bool {
+		t.Helper()
+		ok, err := s.SessionActive(ctx, sessionOf(t, tokens.AccessToken))
+		if err != nil {
+			t.Fatalf("SessionActive: %v", err)
+		}
+		return ok
+	}
+
+	tokens, err := s.IssueTokens(ctx, 2)
+	if err != nil {
+		t.Fatalf("IssueTokens: %v", err)
+	}
+	if !active(tokens) {
+		t.Error("new session is not active")
+	}
+
+	// Refreshing rotates the refresh token within the same session.
+	rotated, err := s.RefreshTokens(ctx, tokens.RefreshToken)
+	if err != nil {
+		t.Fatalf("RefreshTokens: %v", err)
+	}
+	if rotated.RefreshToken == tokens.RefreshToken || sessionOf(t, rotated.AccessToken) != sessionOf(t, tokens.AccessToken) {
+		t.Errorf("RefreshTokens = %+v; want a new refresh token for the same session", rotated)
+	}
+	again, err := s.RefreshTokens(ctx, rotated.RefreshToken)
+	if err != nil {
+		t.Fatalf("RefreshTokens(rotated): %v", err)
+	}
+
+	// Replaying a rotated token ends the session, later tokens included.
+	if _, err := s.RefreshTokens(ctx, tokens.RefreshToken); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Errorf("RefreshTokens(reused): got %v; want %v", err, ErrRefreshTokenReused)
+	}
+	if _, err := s.RefreshTokens(ctx, again.RefreshToken); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("RefreshTokens after reuse: got %v; want %v", err, ErrUnauthorized)
+	}
+	if active(again) {
+		t.Error("session still active after a reused refresh token")
+	}
+
+	if _, err := s.RefreshTokens(ctx, "bogus"); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("RefreshTokens(unknown): got %v; want %v", err, ErrInvalidRefreshToken)
+	}
+
+	// Logging out ends only that session.
+	mine, err := s.IssueTokens(ctx, 2)
+	if err != nil {
+		t.Fatalf("IssueTokens: %v", err)
+	}
+	other, err := s.IssueTokens(ctx, 2)
+	if err != nil {
+		t.Fatalf("IssueTokens: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := s.RevokeRefreshToken(ctx, mine.RefreshToken); err != nil {
+			t.Fatalf("RevokeRefreshToken (run %d): %v", i+1, err)
+		}
+	}
+	if err := s.RevokeRefreshToken(ctx, "bogus"); err != nil {
+		t.Errorf("RevokeRefreshToken(unknown): %v", err)
+	}
+	if active(mine) || !active(other) {
+		t.Errorf("after logout: session active = %v, other session active = %v", active(mine), active(other))
+	}
+	if _, err := s.RefreshTokens(ctx, mine.RefreshToken); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("RefreshTokens after logout: got %v; want %v", err, ErrUnauthorized)
+	}
+	if _, err := s.RefreshTokens(ctx, other.RefreshToken); err != nil {
+		t.Errorf("RefreshTokens(other session): %v", err)
+	}
+}
+
+func testUsersAndReviews(t *testing.T, s Service) {
+	ctx := context.Background()
+
+	if _, _, err := s.AuthenticateUser(ctx, "alice", "wrong"); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("AuthenticateUser(wrong password): got %v; want %v", err, ErrUnauthorized)
+	}
+	user, tokens, err := s.AuthenticateUser(ctx, "alice", "alice-password")
+	if err != nil || user.ID != 1 {
+		t.Fatalf("AuthenticateUser = %+v, %v", user, err)
+	}
+	if tokens.AccessToken == "" || tokens.RefreshToken == "" {
+		t.Errorf("AuthenticateUser tokens = %+v", tokens)
+	}
+
+	if _, err := s.RegisterUser(ctx, "carol", "carol-password", "carol@example.com"); err != nil {
+		t.Fatalf("RegisterUser: %v", err)
+	}
+	if _, err := s.RegisterUser(ctx, "Carol", "other", "carol@example.org"); !errors.Is(err, ErrConflict) {
+		t.Errorf("RegisterUser(duplicate): got %v; want %v", err, ErrConflict)
+	}
+	carol, err := s.GetUserID(ctx, "carol")
+	if err != nil {
+		t.Fatalf("GetUserID: %v", err)
+	}
+
+	if err := s.AddReview(ctx, "the-lobster", 4, "Deadpan and strange.", carol); err != nil {
+		t.Fatalf("AddReview: %v", err)
+	}
+	if err := s.AddReview(ctx, "the-lobster", 6, "Too many stars.", carol); !errors.Is(err, ErrValidation) {
+		t.Errorf("AddReview(6 stars): got %v; want %v", err, ErrValidation)
+	}
+	reviews, err := s.ShowReview(ctx, "the-lobster", ReviewFilter{}, PageRequest{})
+	if err != nil {
+		t.Fatalf("ShowReview: %v", err)
+	}
+	found := false
+	for _, r := range reviews.Items {
+		found = found || r.Review.Review == "Deadpan and strange."
+	}
+	if !found {
+		t.Errorf("ShowReview = %+v; want the new review", reviews)
+	}
+
+	if err := s.ToggleLiked(ctx, 11, carol); err != nil {
+		t.Fatalf("ToggleLiked: %v", err)
+	}
+	if liked, err := s.GetLikedStatus(ctx, 11, "carol"); err != nil || !liked {
+		t.Errorf("GetLikedStatus = %v, %v; want true", liked, err)
+	}
+	if err := s.ToggleWatchlist(ctx, 11, carol); err != nil {
+		t.Fatalf("ToggleWatchlist: %v", err)
+	}
+	if err := s.ToggleWatchlist(ctx, 11, carol); err != nil {
+		t.Fatalf("ToggleWatchlist: %v", err)
+	}
+	if listed, err := s.GetWatchlistStatus(ctx, 11, "carol"); err != nil || listed {
+		t.Errorf("GetWatchlistStatus = %v, %v; want false", listed, err)
+	}
+	if err := s.ToggleLiked(ctx, 999, carol); !errors.Is(err, ErrValidation) {
+		t.Errorf("ToggleLiked(unknown movie): got %v; want %v", err, ErrValidation)
+	}
+	// Bob's four stars for The Godfather leave Alice's five.
+	if err := s.DeleteReview(ctx, 2); err != nil {
+		t.Fatalf("DeleteReview: %v", err)
+	}
+	if movie, err := s.GetMovie(ctx, "1"); err != nil || movie.AvgRating != 5 {
+		t.Errorf("AvgRating after DeleteReview = %v, %v; want 5", movie.AvgRating, err)
+	}
+	if err := s.DeleteReview(ctx, 2); !errors.Is(err, ErrNotFound) {
+		t.Errorf("DeleteReview(again): got %v; want %v", err, ErrNotFound)
+	}
+}
+
+func testProfiles(t *testing.T, s Service) {
+	ctx := context.Background()
+
+	profile, err := s.GetProfile(ctx, 2)
+	if err != nil {
+		t.Fatalf("GetProfile: %v", err)
+	}
+	if profile.Username != "bob" || profile.Email != "bob@example.com" || profile.Privacy != (Privacy{Reviews: true, Likes: true}) {
+		t.Errorf("GetProfile = %+v; want bob with the default privacy", profile)
+	}
+	if _, err := s.GetProfile(ctx, 999); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetProfile(unknown): got %v; want %v", err, ErrNotFound)
+	}
+
+	name, bio, avatar, public := "  Bob  ", "Likes long films.", "https://example.com/bob.png", true
+	profile, err = s.UpdateProfile(ctx, 2, ProfileFields{DisplayName: &name, Bio: &bio, AvatarURL: &avatar, WatchlistPublic: &public})
+	if err != nil {
+		t.Fatalf("UpdateProfile: %v", err)
+	}
+	if profile.DisplayName != "Bob" || profile.Bio != bio || profile.AvatarURL != avatar || !profile.Privacy.Watchlist || profile.Email != "bob@example.com" {
+		t.Errorf("UpdateProfile = %+v", profile)
+	}
+	empty := ""
+	if profile, err = s.UpdateProfile(ctx, 2, ProfileFields{Bio: &empty}); err != nil || profile.Bio != "" || profile.DisplayName != "Bob" {
+		t.Errorf("UpdateProfile(clear bio) = %+v, %v", profile, err)
+	}
+	for name, f := range map[string]ProfileFields{
+		"avatar":       {AvatarURL: str("javascript:alert(1)")},
+		"email":        {Email: str("bob")},
+		"display name": {DisplayName: str(fmt.Sprintf("%0101d", 0))},
+	} {
+		if _, err := s.UpdateProfile(ctx, 2, f); !errors.Is(err, ErrValidation) {
+			t.Errorf("UpdateProfile(bad %s): got %v; want %v", name, err, ErrValidation)
+		}
+	}
+	if _, err := s.UpdateProfile(ctx, 999, ProfileFields{Bio: &bio}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("UpdateProfile(unknown): got %v; want %v", err, ErrNotFound)
+	}
+
+	_, old, err := s.AuthenticateUser(ctx, "bob", "bob-password")
+	if err != nil {
+		t.Fatalf("AuthenticateUser: %v", err)
+	}
+	if _, err := s.ChangePassword(ctx, 2, "wrong", "new-password"); !errors.Is(err, ErrWrongPassword) {
+		t.Errorf("ChangePassword(wrong current): got %v; want %v", err, ErrWrongPassword)
+	}
+	tokens, err := s.ChangePassword(ctx, 2, "bob-password", "new-password")
+	if err != nil {
+		t.Fatalf("ChangePassword: %v", err)
+	}
+	if _, err := s.RefreshTokens(ctx, old.RefreshToken); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("RefreshTokens(old session): got %v; want %v", err, ErrUnauthorized)
+	}
+	if _, err := s.RefreshTokens(ctx, tokens.RefreshToken); err != nil {
+		t.Errorf("RefreshTokens(new session): %v", err)
+	}
+	if _, _, err := s.AuthenticateUser(ctx, "bob", "new-password"); err != nil {
+		t.Errorf("AuthenticateUser(new password): %v", err)
+	}
+
+	reviews, err := s.GetUserReviews(ctx, 1, PageRequest{Sort: "-date"})
+	if err != nil {
+		t.Fatalf("GetUserReviews: %v", err)
+	}
+	if reviews.Total != 3 || len(reviews.Items) != 3 || reviews.Items[0].Id != 7 || reviews.Items[0].MovieSlug == "" {
+		t.Errorf("GetUserReviews(alice) = %+v; want her three reviews, newest first", reviews)
+	}
+
+	likes, err := s.GetSavedMovies(ctx, 1, CollectionLikes, PageRequest{Sort: "-date_added"})
+	if err != nil {
+		t.Fatalf("GetSavedMovies(likes): %v", err)
+	}
+	if len(likes.Items) != 2 || likes.Items[0].Id != 5 || likes.Items[0].DateAdded != "2024-03-01 21:30:00" {
+		t.Errorf("GetSavedMovies(likes) = %+v; want Pulp Fiction, then The Godfather", likes)
+	}
+	watchlist := walkPages(t, func(req PageRequest) (Page[SavedMovie], error) {
+		req.Limit = 1
+		return s.GetSavedMovies(ctx, 2, CollectionWatchlist, req)
+	}, func(m SavedMovie) int { return m.Id })
+	if len(watchlist) != 2 {
+		t.Errorf("GetSavedMovies(watchlist) = %+v; want bob's two movies", watchlist)
+	}
+	if _, err := s.GetSavedMovies(ctx, 1, "favourites", PageRequest{}); !errors.Is(err, ErrValidation) {
+		t.Errorf("GetSavedMovies(unknown collection): got %v; want %v", err, ErrValidation)
+	}
+}
+
+func testMovieStatuses(t *testing.T, s Service) {
+	ctx := context.Background()
+
+	// Alice likes 1 and 5 and has 13 on her watchlist; 999 doesn't exist.
+	statuses, err := s.GetMovieStatuses(ctx, 1, []int{1, 2, 5, 13, 999})
+	if err != nil {
+		t.Fatalf("GetMovieStatuses: %v", err)
+	}
+	want := map[int]MovieStatus{1: {Liked: true}, 2: {}, 5: {Liked: true}, 13: {Watchlisted: true}, 999: {}}
+	if fmt.Sprint(statuses) != fmt.Sprint(want) {
+		t.Errorf("GetMovieStatuses = %v; want %v", statuses, want)
+	}
+
+	if statuses, err := s.GetMovieStatuses(ctx, 1, nil); err != nil || len(statuses) != 0 {
+		t.Errorf("GetMovieStatuses(none) = %v, %v", statuses, err)
+	}
+	if _, err := s.GetMovieStatuses(ctx, 1, make([]int, MaxStatusLookup+1)); !errors.Is(err, ErrValidation) {
+		t.Errorf("GetMovieStatuses(too many): got %v; want %v", err, ErrValidation)
+	}
+}
+
+// testCollectionEditing adds and removes movies more than once, and from
+// several goroutines at once; each movie must end up in a collection once.
+func testCollectionEditing(t *testing.T, s Service) {
+	ctx := context.Background()
+
+	// Bob has 2 and 11 on his watchlist and likes 7.
+	status, err := s.AddToCollection(ctx, 2, CollectionWatchlist, 2)
+	if err != nil || status != (MovieStatus{Watchlisted: true}) {
+		t.Errorf("AddToCollection(already listed) = %+v, %v", status, err)
+	}
+	status, err = s.AddToCollection(ctx, 2, CollectionLikes, 11)
+	if err != nil || status != (MovieStatus{Liked: true, Watchlisted: true}) {
+		t.Errorf("AddToCollection(likes) = %+v, %v", status, err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.AddToCollection(ctx, 2, CollectionLikes, 1); err != nil {
+				t.Errorf("AddToCollection(concurrently): %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	likes, err := s.GetSavedMovies(ctx, 2, CollectionLikes, PageRequest{})
+	if err != nil {
+		t.Fatalf("GetSavedMovies: %v", err)
+	}
+	if likes.Total != 3 || len(likes.Items) != 3 {
+		t.Errorf("likes = %+v; want 1, 7 and 11 once each", likes.Items)
+	}
+	watchlist, err := s.GetSavedMovies(ctx, 2, CollectionWatchlist, PageRequest{Sort: "date_added"})
+	if err != nil || len(watchlist.Items) != 2 || watchlist.Items[0].DateAdded != "2024-05-02 11:15:00" {
+		t.Errorf("watchlist = %+v, %v; want the date 2 was first added kept", watchlist.Items, err)
+	}
+
+	for i := 0; i < 2; i++ {
+		status, err = s.RemoveFromCollection(ctx, 2, CollectionWatchlist, 11)
+		if err != nil || status != (MovieStatus{Liked: true}) {
+			t.Errorf("RemoveFromCollection (%d) = %+v, %v", i+1, status, err)
+		}
+	}
+
+	if _, err := s.AddToCollection(ctx, 2, CollectionLikes, 999); !errors.Is(err, ErrValidation) {
+		t.Errorf("AddToCollection(unknown movie): got %v; want %v", err, ErrValidation)
+	}
+	if _, err := s.RemoveFromCollection(ctx, 2, "favourites", 1); !errors.Is(err, ErrValidation) {
+		t.Errorf("RemoveFromCollection(unknown collection): got %v; want %v", err, ErrValidation)
+	}
+}
+
+func testLists(t *testing.T, s Service) {
+	ctx := context.Background()
+	const alice, bob, nikos = 1, 2, 3
+
+	entries := func(l MovieList) []int {
+		var ids []int
+		for _, e := range l.Entries {
+			ids = append(ids, e.Id)
+		}
+		return ids
+	}
+
+	list, err := s.CreateList(ctx, alice, ListFields{Title: str(" Best of Greek cinema "), Visibility: str(ListPrivate)})
+	if err != nil {
+		t.Fatalf("CreateList: %v", err)
+	}
+	key := list.ID
+	if list.Title != "Best of Greek cinema" || list.Owner != "alice" || list.Visibility != ListPrivate || len(key) < 16 || list.Entries == nil {
+		t.Errorf("CreateList = %+v", list)
+	}
+	if _, err := s.CreateList(ctx, alice, ListFields{Visibility: str("secret")}); !errors.Is(err, ErrValidation) {
+		t.Errorf("CreateList(no title, bad visibility): got %v; want %v", err, ErrValidation)
+	}
+
+	for _, id := range []int{1, 5, 9} {
+		if list, err = s.PutListEntry(ctx, alice, key, id, EntryFields{}); err != nil {
+			t.Fatalf("PutListEntry(%d): %v", id, err)
+		}
+	}
+	first := 1
+	list, err = s.PutListEntry(ctx, alice, key, 9, EntryFields{Note: str("Start here."), Position: &first})
+	if err != nil {
+		t.Fatalf("PutListEntry(move): %v", err)
+	}
+	if got := fmt.Sprint(entries(list)); got != "[9 1 5]" {
+		t.Errorf("entries after moving 9 first = %s; want [9 1 5]", got)
+	}
+	if e := list.Entries[0]; e.Position != 1 || e.Note != "Start here." || e.AddedBy != "alice" || e.Title == "" {
+		t.Errorf("first entry = %+v", e)
+	}
+	if _, err := s.PutListEntry(ctx, alice, key, 999, EntryFields{}); !errors.Is(err, ErrValidation) {
+		t.Errorf("PutListEntry(unknown movie): got %v; want %v", err, ErrValidation)
+	}
+	zero := 0
+	if _, err := s.PutListEntry(ctx, alice, key, 1, EntryFields{Position: &zero}); !errors.Is(err, ErrValidation) {
+		t.Errorf("PutListEntry(position 0): got %v; want %v", err, ErrValidation)
+	}
+
+	// A private list is hidden from everyone but its owner and collaborators.
+	for _, userID := range []int{0, bob} {
+		if _, err := s.GetList(ctx, userID, key); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetList(private, user %d): got %v; want %v", userID, err, ErrNotFound)
+		}
+	}
+	if _, err := s.PutListEntry(ctx, bob, key, 7, EntryFields{}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("PutListEntry(private, not invited): got %v; want %v", err, ErrNotFound)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := s.AddCollaborator(ctx, alice, key, bob); err != nil {
+			t.Fatalf("AddCollaborator (%d): %v", i+1, err)
+		}
+	}
+	if err := s.AddCollaborator(ctx, alice, key, alice); !errors.Is(err, ErrValidation) {
+		t.Errorf("AddCollaborator(owner): got %v; want %v", err, ErrValidation)
+	}
+	if err := s.AddCollaborator(ctx, bob, key, nikos); !errors.Is(err, ErrForbidden) {
+		t.Errorf("AddCollaborator(by collaborator): got %v; want %v", err, ErrForbidden)
+	}
+	last := 100
+	list, err = s.PutListEntry(ctx, bob, key, 7, EntryFields{Note: str("Not Greek, but still."), Position: &last})
+	if err != nil {
+		t.Fatalf("PutListEntry(collaborator): %v", err)
+	}
+	if got := fmt.Sprint(entries(list)); got != "[9 1 5 7]" || list.Entries[3].AddedBy != "bob" || fmt.Sprint(list.Collaborators) != "[bob]" {
+		t.Errorf("PutListEntry(collaborator) = %s, %+v", got, list)
+	}
+	if _, err := s.UpdateList(ctx, bob, key, ListFields{Title: str("Bob's now")}); !errors.Is(err, ErrForbidden) {
+		t.Errorf("UpdateList(by collaborator): got %v; want %v", err, ErrForbidden)
+	}
+
+	for i := 0; i < 2; i++ {
+		if list, err = s.RemoveListEntry(ctx, bob, key, 1); err != nil {
+			t.Fatalf("RemoveListEntry (%d): %v", i+1, err)
+		}
+	}
+	second := 2
+	if list, err = s.PutListEntry(ctx, alice, key, 9, EntryFields{Position: &second}); err != nil {
+		t.Fatalf("PutListEntry(move down): %v", err)
+	}
+	if got := fmt.Sprint(entries(list)); got != "[5 9 7]" || list.EntryCount != 3 || list.Entries[1].Note != "Start here." {
+		t.Errorf("entries = %s, %+v; want [5 9 7] with 9's note kept", got, list)
+	}
+
+	mine, err := s.GetLists(ctx, ListFilter{MemberID: bob}, PageRequest{})
+	if err != nil || mine.Total != 1 || mine.Items[0].ID != key || mine.Items[0].EntryCount != 3 {
+		t.Errorf("GetLists(bob's) = %+v, %v; want the list he collaborates on", mine, err)
+	}
+	if public, err := s.GetLists(ctx, ListFilter{}, PageRequest{}); err != nil || public.Total != 0 {
+		t.Errorf("GetLists(public) = %+v, %v; want none", public, err)
+	}
+
+	if list, err = s.UpdateList(ctx, alice, key, ListFields{Visibility: str(ListUnlisted), Description: str("Mostly.")}); err != nil {
+		t.Fatalf("UpdateList: %v", err)
+	}
+	if list.Visibility != ListUnlisted || list.Description != "Mostly." || list.Title != "Best of Greek cinema" {
+		t.Errorf("UpdateList = %+v", list)
+	}
+	if _, err := s.GetList(ctx, 0, key); err != nil {
+		t.Errorf("GetList(unlisted, anonymous): %v", err)
+	}
+	if public, err := s.GetLists(ctx, ListFilter{}, PageRequest{}); err != nil || public.Total != 0 {
+		t.Errorf("GetLists(public) = %+v, %v; want unlisted lists left out", public, err)
+	}
+	if _, err := s.PutListEntry(ctx, nikos, key, 8, EntryFields{}); !errors.Is(err, ErrForbidden) {
+		t.Errorf("PutListEntry(viewer): got %v; want %v", err, ErrForbidden)
+	}
+
+	if _, err := s.CreateList(ctx, nikos, ListFields{Title: str("Halloween 2026")}); err != nil {
+		t.Fatalf("CreateList(public by default): %v", err)
+	}
+	if public, err := s.GetLists(ctx, ListFilter{}, PageRequest{Sort: "-created"}); err != nil || public.Total != 1 || public.Items[0].Owner != "nikos" {
+		t.Errorf("GetLists(public) = %+v, %v; want nikos's list", public, err)
+	}
+	if public, err := s.GetLists(ctx, ListFilter{OwnerID: alice}, PageRequest{}); err != nil || public.Total != 0 {
+		t.Errorf("GetLists(alice's public) = %+v, %v; want none", public, err)
+	}
+
+	if err := s.RemoveCollaborator(ctx, bob, key, bob); err != nil {
+		t.Fatalf("RemoveCollaborator(self): %v", err)
+	}
+	if _, err := s.RemoveListEntry(ctx, bob, key, 7); !errors.Is(err, ErrForbidden) {
+		t.Errorf("RemoveListEntry(former collaborator): got %v; want %v", err, ErrForbidden)
+	}
+
+	if err := s.DeleteList(ctx, bob, key); !errors.Is(err, ErrForbidden) {
+		t.Errorf("DeleteList(not owner): got %v; want %v", err, ErrForbidden)
+	}
+	if err := s.DeleteList(ctx, alice, key); err != nil {
+		t.Fatalf("DeleteList: %v", err)
+	}
+	if _, err := s.GetList(ctx, alice, key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetList(deleted): got %v; want %v", err, ErrNotFound)
+	}
+}
+
+func testDiary(t *testing.T, s Service) {
+	ctx := context.Background()
+
+	// Bob has 2 and 11 on his watchlist and wrote review 2, of movie 1.
+	entry, err := s.LogWatch(ctx, 2, 2, DiaryFields{WatchedOn: str("2024-06-01")})
+	if err != nil {
+		t.Fatalf("LogWatch: %v", err)
+	}
+	if entry.Id != 2 || entry.WatchedOn != "2024-06-01" || entry.Rewatch || entry.Rating != nil || entry.ReviewID != nil || entry.Title == "" {
+		t.Errorf("LogWatch = %+v", entry)
+	}
+	if statuses, err := s.GetMovieStatuses(ctx, 2, []int{2}); err != nil || statuses[2].Watchlisted {
+		t.Errorf("status of a watched movie = %+v, %v; want it off the watchlist", statuses, err)
+	}
+
+	four, review := 4, 2
+	again, err := s.LogWatch(ctx, 2, 1, DiaryFields{WatchedOn: str("2024-06-20"), Rating: &four, ReviewID: &review})
+	if err != nil || *again.Rating != 4 || *again.ReviewID != 2 || again.Rewatch {
+		t.Errorf("LogWatch(rated) = %+v, %v", again, err)
+	}
+	if again, err = s.LogWatch(ctx, 2, 2, DiaryFields{WatchedOn: str("2024-07-03")}); err != nil || !again.Rewatch {
+		t.Errorf("LogWatch(second time) = %+v, %v; want a rewatch", again, err)
+	}
+	today, err := s.LogWatch(ctx, 2, 7, DiaryFields{})
+	if err != nil || today.WatchedOn != time.Now().UTC().Format("2006-01-02") {
+		t.Errorf("LogWatch(no date) = %+v, %v; want today", today, err)
+	}
+
+	six, other := 6, 1
+	for name, f := range map[string]DiaryFields{
+		"date":                    {WatchedOn: str("yesterday")},
+		"future date":             {WatchedOn: str(time.Now().AddDate(0, 0, 3).Format("2006-01-02"))},
+		"rating":                  {Rating: &six},
+		"review of another movie": {ReviewID: &review},
+	} {
+		if _, err := s.LogWatch(ctx, 2, 7, f); !errors.Is(err, ErrValidation) {
+			t.Errorf("LogWatch(bad %s): got %v; want %v", name, err, ErrValidation)
+		}
+	}
+	if _, err := s.LogWatch(ctx, 2, 1, DiaryFields{ReviewID: &other}); !errors.Is(err, ErrValidation) {
+		t.Errorf("LogWatch(alice's review): got %v; want %v", err, ErrValidation)
+	}
+	if _, err := s.LogWatch(ctx, 2, 999, DiaryFields{}); !errors.Is(err, ErrValidation) {
+		t.Errorf("LogWatch(unknown movie): got %v; want %v", err, ErrValidation)
+	}
+
+	zero, rewatch := 0, true
+	updated, err := s.UpdateDiaryEntry(ctx, 2, entry.ID, DiaryFields{WatchedOn: str("2024-05-31"), Rewatch: &rewatch, Rating: &four})
+	if err != nil || updated.WatchedOn != "2024-05-31" || !updated.Rewatch || *updated.Rating != 4 {
+		t.Errorf("UpdateDiaryEntry = %+v, %v", updated, err)
+	}
+	if updated, err = s.UpdateDiaryEntry(ctx, 2, entry.ID, DiaryFields{Rating: &zero}); err != nil || updated.Rating != nil || updated.WatchedOn != "2024-05-31" {
+		t.Errorf("UpdateDiaryEntry(clear rating) = %+v, %v", updated, err)
+	}
+	if _, err := s.UpdateDiaryEntry(ctx, 1, entry.ID, DiaryFields{Rating: &four}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("UpdateDiaryEntry(someone else's): got %v; want %v", err, ErrNotFound)
+	}
+
+	june, err := s.GetDiary(ctx, 2, DiaryFilter{Year: 2024, Month: 6}, PageRequest{Sort: "-watched_on"})
+	if err != nil || june.Total != 1 || june.Items[0].Id != 1 {
+		t.Errorf("GetDiary(June 2024) = %+v, %v; want movie 1", june, err)
+	}
+	if may, err := s.GetDiary(ctx, 2, DiaryFilter{Year: 2024, Month: 5}, PageRequest{}); err != nil || may.Total != 1 || may.Items[0].WatchedOn != "2024-05-31" {
+		t.Errorf("GetDiary(May 2024) = %+v, %v; want the entry of the 31st", may, err)
+	}
+	year := walkPages(t, func(req PageRequest) (Page[DiaryEntry], error) {
+		req.Limit, req.Sort = 1, "watched_on"
+		return s.GetDiary(ctx, 2, DiaryFilter{Year: 2024}, req)
+	}, func(e DiaryEntry) int { return e.ID })
+	if len(year) != 3 || year[0].WatchedOn != "2024-05-31" || year[2].WatchedOn != "2024-07-03" {
+		t.Errorf("GetDiary(2024) = %+v; want three entries in date order", year)
+	}
+	if _, err := s.GetDiary(ctx, 2, DiaryFilter{Month: 6}, PageRequest{}); !errors.Is(err, ErrValidation) {
+		t.Errorf("GetDiary(month without year): got %v; want %v", err, ErrValidation)
+	}
+	if other, err := s.GetDiary(ctx, 1, DiaryFilter{}, PageRequest{}); err != nil || other.Total != 0 {
+		t.Errorf("GetDiary(alice) = %+v, %v; want nothing of bob's", other, err)
+	}
+
+	months, err := s.GetDiaryMonths(ctx, 2)
+	if err != nil {
+		t.Fatalf("GetDiaryMonths: %v", err)
+	}
+	if got := fmt.Sprint(months[1:]); got != "[{2024 7 1} {2024 6 1} {2024 5 1}]" || months[0].Count != 1 {
+		t.Errorf("GetDiaryMonths = %v; want this month, then July, June and May 2024", months)
+	}
+
+	if err := s.DeleteDiaryEntry(ctx, 1, today.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("DeleteDiaryEntry(someone else's): got %v; want %v", err, ErrNotFound)
+	}
+	if err := s.DeleteDiaryEntry(ctx, 2, today.ID); err != nil {
+		t.Errorf("DeleteDiaryEntry: %v", err)
+	}
+	if err := s.DeleteDiaryEntry(ctx, 2, today.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("DeleteDiaryEntry(again): got %v; want %v", err, ErrNotFound)
+	}
+
+	// Deleting the linked review leaves the entry without it.
+	if err := s.DeleteReview(ctx, 2); err != nil {
+		t.Fatalf("DeleteReview: %v", err)
+	}
+	june, err = s.GetDiary(ctx, 2, DiaryFilter{Year: 2024, Month: 6}, PageRequest{})
+	if err != nil || june.Total != 1 || june.Items[0].ReviewID != nil || *june.Items[0].Rating != 4 {
+		t.Errorf("GetDiary after deleting the review = %+v, %v", june, err)
+	}
+}
+
+// The bounds of a month must be real dates, as MySQL won't compare a DATE
+// with the 31st of a shorter month.
+func TestDiaryFilterDates(t *testing.T) {
+	for f, want := range map[DiaryFilter][2]string{
+		{}:                      {"", ""},
+		{Year: 2024}:            {"2024-01-01", "2025-01-01"},
+		{Year: 2024, Month: 2}:  {"2024-02-01", "2024-03-01"},
+		{Year: 2023, Month: 4}:  {"2023-04-01", "2023-05-01"},
+		{Year: 2024, Month: 12}: {"2024-12-01", "2025-01-01"},
+	} {
+		from, until, err := f.dates()
+		if err != nil || from != want[0] || until != want[1] {
+			t.Errorf("%+v.dates() = %q, %q, %v; want %q, %q", f, from, until, err, want[0], want[1])
+		}
+	}
+}
+
+func testImports(t *testing.T, s Service) {
+	ctx := context.Background()
+
+	found := map[string]struct {
+		title string
+		year  int
+		want  int
+	}{
+		"exact":          {"The Godfather", 1972, 1},
+		"case":           {"the godfather", 1972, 1},
+		"a year off":     {"The Godfather", 1973, 1},
+		"no year":        {"Goodfellas", 0, 4},
+		"punctuation":    {"Kill Bill Vol. 1", 2003, 6},
+		"greek":          {"κυνόδοντας", 2009, 10},
+		"greek accented": {"Αλέξης Ζορμπάς", 1964, 9},
+	}
+	for name, c := range found {
+		if movie, err := s.FindMovie(ctx, c.title, c.year); err != nil || movie.Id != c.want {
+			t.Errorf("FindMovie(%s: %q, %d) = %d, %v; want %d", name, c.title, c.year, movie.Id, err, c.want)
+		}
+	}
+	if _, err := s.FindMovie(ctx, "The Godfather", 1980); !errors.Is(err, ErrNotFound) {
+		t.Errorf("FindMovie(wrong year) = %v; want ErrNotFound", err)
+	}
+	if _, err := s.FindMovie(ctx, "The Godfather Part", 1974); !errors.Is(err, ErrNotFound) {
+		t.Errorf("FindMovie(part of a title) = %v; want ErrNotFound", err)
+	}
+	if _, err := s.FindMovie(ctx, " ", 0); !errors.Is(err, ErrValidation) {
+		t.Errorf("FindMovie(no title) = %v; want ErrValidation", err)
+	}
+
+	remake, err := s.CreateMovie(ctx, MovieFields{Title: str("The Lobster"), ReleaseDate: str("2016-03-01"), Genre: str("Comedy")})
+	if err != nil {
+		t.Fatalf("CreateMovie: %v", err)
+	}
+	if _, err := s.FindMovie(ctx, "The Lobster", 0); !errors.Is(err, ErrConflict) {
+		t.Errorf("FindMovie(two movies, no year) = %v; want ErrConflict", err)
+	}
+	if movie, err := s.FindMovie(ctx, "The Lobster", 2015); err != nil || movie.Id != 11 {
+		t.Errorf("FindMovie(two movies, the year of one) = %d, %v; want the exact year to win", movie.Id, err)
+	}
+	if movie, err := s.FindMovie(ctx, "The Lobster", 2017); err != nil || movie.Id != remake.Id {
+		t.Errorf("FindMovie(two movies, a year off one) = %d, %v; want %d", movie.Id, err, remake.Id)
+	}
+
+	job, err := s.CreateImport(ctx, 2, "letterboxd")
+	if err != nil || job.Status != ImportRunning || job.FinishedAt != nil || len(job.Rows) != 0 {
+		t.Fatalf("CreateImport = %+v, %v", job, err)
+	}
+	unmatched := ImportRow{File: "ratings.csv", Line: 3, Item: ImportItem{Kind: ImportReview, Title: "Lobster", Year: 2015, Stars: 4}, Reason: "no movie matches"}
+	err = s.FinishImport(ctx, job.ID, ImportResult{Imported: 5, Skipped: 1, Unmatched: []ImportRow{unmatched, unmatched}})
+	if err != nil {
+		t.Fatalf("FinishImport: %v", err)
+	}
+	if err := s.FinishImport(ctx, job.ID, ImportResult{}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("FinishImport(twice) = %v; want ErrNotFound", err)
+	}
+
+	job, err = s.GetImport(ctx, 2, job.ID)
+	if err != nil || job.Status != ImportDone || job.Imported != 5 || job.Skipped != 1 || job.FinishedAt == nil || len(job.Rows) != 2 {
+		t.Fatalf("GetImport = %+v, %v", job, err)
+	}
+	row := job.Rows[0]
+	if row.Item != unmatched.Item || row.File != "ratings.csv" || row.Line != 3 || row.Resolved || row.MovieID != nil {
+		t.Errorf("unmatched row = %+v", row)
+	}
+	if _, err := s.GetImport(ctx, 3, job.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetImport(someone else's) = %v; want ErrNotFound", err)
+	}
+
+	if row, err = s.ResolveImportRow(ctx, 2, job.ID, row.ID, 11); err != nil || !row.Resolved || row.MovieID == nil || *row.MovieID != 11 {
+		t.Errorf("ResolveImportRow = %+v, %v", row, err)
+	}
+	if _, err := s.ResolveImportRow(ctx, 2, job.ID, row.ID, 11); !errors.Is(err, ErrConflict) {
+		t.Errorf("ResolveImportRow(twice) = %v; want ErrConflict", err)
+	}
+	// A row whose import failed is reopened, to be resolved again.
+	if err := s.ReopenImportRow(ctx, 3, job.ID, row.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("ReopenImportRow(someone else's) = %v; want ErrNotFound", err)
+	}
+	if err := s.ReopenImportRow(ctx, 2, job.ID, row.ID); err != nil {
+		t.Fatalf("ReopenImportRow: %v", err)
+	}
+	if row, err = s.ResolveImportRow(ctx, 2, job.ID, row.ID, 12); err != nil || row.MovieID == nil || *row.MovieID != 12 {
+		t.Errorf("ResolveImportRow(reopened) = %+v, %v", row, err)
+	}
+	if _, err := s.ResolveImportRow(ctx, 3, job.ID, job.Rows[1].ID, 0); !errors.Is(err, ErrNotFound) {
+		t.Errorf("ResolveImportRow(someone else's) = %v; want ErrNotFound", err)
+	}
+	if row, err = s.ResolveImportRow(ctx, 2, job.ID, job.Rows[1].ID, 0); err != nil || !row.Resolved || row.MovieID != nil {
+		t.Errorf("ResolveImportRow(dismissed) = %+v, %v", row, err)
+	}
+
+	failed, err := s.CreateImport(ctx, 3, "imdb")
+	if err != nil {
+		t.Fatalf("CreateImport: %v", err)
+	}
+	if err := s.FinishImport(ctx, failed.ID, ImportResult{Imported: 1, Error: "database is down"}); err != nil {
+		t.Fatalf("FinishImport(failed): %v", err)
+	}
+	if failed, err = s.GetImport(ctx, 3, failed.ID); err != nil || failed.Status != ImportFailed || failed.Error != "database is down" || failed.Imported != 1 {
+		t.Errorf("GetImport(failed) = %+v, %v", failed, err)
+	}
+}
+
+func testAccounts(t *testing.T, s Service) {
+	ctx := context.Background()
+	now := time.Now()
+
+	// Alice is the only admin, so she can't leave until there's another.
+	if err := s.DeleteAccount(ctx, 1, "alice-password", now.Add(time.Hour)); !errors.Is(err, ErrConflict) {
+		t.Errorf("DeleteAccount(last admin) = %v; want ErrConflict", err)
+	}
+	if err := s.GrantRole(ctx, 1, 2, RoleAdmin); err != nil {
+		t.Fatalf("GrantRole: %v", err)
+	}
+	if err := s.DeleteAccount(ctx, 1, "alice-password", now.Add(time.Hour)); err != nil {
+		t.Fatalf("DeleteAccount(with another admin): %v", err)
+	}
+	if err := s.DeleteAccount(ctx, 2, "bob-password", now.Add(time.Hour)); !errors.Is(err, ErrConflict) {
+		t.Errorf("DeleteAccount(admin left when Alice goes) = %v; want ErrConflict", err)
+	}
+	// Nor can Bob lose the role while she is leaving.
+	if err := s.RevokeRole(ctx, 1, 2, RoleAdmin); !errors.Is(err, ErrConflict) {
+		t.Errorf("RevokeRole(admin left when Alice goes) = %v; want ErrConflict", err)
+	}
+	if _, _, err := s.AuthenticateUser(ctx, "alice", "alice-password"); err != nil {
+		t.Fatalf("AuthenticateUser: %v", err)
+	}
+	if err := s.RevokeRole(ctx, 1, 2, RoleAdmin); err != nil {
+		t.Fatalf("RevokeRole(Alice staying): %v", err)
+	}
+
+	// Bob wrote review 2, of the Godfather, and review 6, of Spider-Man.
+	if err := s.DeleteAccount(ctx, 2, "wrong", now.Add(time.Hour)); !errors.Is(err, ErrWrongPassword) {
+		t.Errorf("DeleteAccount(wrong password) = %v; want ErrWrongPassword", err)
+	}
+	_, tokens, err := s.AuthenticateUser(ctx, "bob", "bob-password")
+	if err != nil {
+		t.Fatalf("AuthenticateUser: %v", err)
+	}
+	title := "Bob's picks"
+	list, err := s.CreateList(ctx, 2, ListFields{Title: &title})
+	if err != nil {
+		t.Fatalf("CreateList: %v", err)
+	}
+	if err := s.DeleteAccount(ctx, 2, "bob-password", now.Add(time.Hour)); err != nil {
+		t.Fatalf("DeleteAccount: %v", err)
+	}
+	// Until it goes, the account is hidden as if it were gone.
+	hidden := func() (profile, review, listed bool) {
+		t.Helper()
+		_, err := s.GetProfile(ctx, 2)
+		profile = !errors.Is(err, ErrNotFound)
+		if _, err := s.GetUserID(ctx, "bob"); profile != !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetUserID = %v, GetProfile = %v; want both found or neither", err, profile)
+		}
+		reviews, err := s.ShowReview(ctx, "1", ReviewFilter{}, PageRequest{})
+		if err != nil {
+			t.Fatalf("ShowReview: %v", err)
+		}
+		for _, r := range reviews.Items {
+			review = review || r.Author.Username == "bob"
+		}
+		_, err = s.GetList(ctx, 3, list.ID)
+		listed = !errors.Is(err, ErrNotFound)
+		lists, err := s.GetLists(ctx, ListFilter{}, PageRequest{})
+		if err != nil {
+			t.Fatalf("GetLists: %v", err)
+		}
+		found := false
+		for _, l := range lists.Items {
+			found = found || l.ID == list.ID
+		}
+		if found != listed {
+			t.Errorf("GetLists has Bob's list %v, GetList %v; want the same", found, listed)
+		}
+		return profile, review, listed
+	}
+	if profile, review, listed := hidden(); profile || review || listed {
+		t.Errorf("while deleting: profile %v, review %v, list %v; want none shown", profile, review, listed)
+	}
+	if _, err := s.RefreshTokens(ctx, tokens.RefreshToken); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("RefreshTokens after DeleteAccount = %v; want ErrUnauthorized", err)
+	}
+	if n, err := s.PurgeAccounts(ctx, now); err != nil || n != 0 {
+		t.Errorf("PurgeAccounts(in the grace period) = %d, %v; want 0", n, err)
+	}
+
+	// Signing in again keeps the account.
+	if _, _, err := s.AuthenticateUser(ctx, "bob", "bob-password"); err != nil {
+		t.Fatalf("AuthenticateUser: %v", err)
+	}
+	if profile, review, listed := hidden(); !profile || !review || !listed {
+		t.Errorf("after signing in: profile %v, review %v, list %v; want all shown", profile, review, listed)
+	}
+	if n, err := s.PurgeAccounts(ctx, now.Add(2*time.Hour)); err != nil || n != 0 {
+		t.Errorf("PurgeAccounts(after signing in) = %d, %v; want 0", n, err)
+	}
+
+	godfather, err := s.GetMovie(ctx, "1")
+	if err != nil {
+		t.Fatalf("GetMovie: %v", err)
+	}
+	if err := s.DeleteAccount(ctx, 2, "bob-password", now.Add(time.Hour)); err != nil {
+		t.Fatalf("DeleteAccount: %v", err)
+	}
+	if n, err := s.PurgeAccounts(ctx, now.Add(2*time.Hour)); err != nil || n != 1 {
+		t.Errorf("PurgeAccounts = %d, %v; want 1", n, err)
+	}
+	if _, err := s.GetProfile(ctx, 2); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetProfile(deleted) = %v; want ErrNotFound", err)
+	}
+	if _, _, err := s.AuthenticateUser(ctx, "bob", "bob-password"); err == nil {
+		t.Error("AuthenticateUser(deleted) succeeded")
+	}
+	if movie, err := s.GetMovie(ctx, "1"); err != nil || movie.ReviewCount != godfather.ReviewCount-1 {
+		t.Errorf("Godfather after purge = %+v, %v; want one review fewer", movie, err)
+	}
+	if reviews, err := s.ShowReview(ctx, "7", ReviewFilter{}, PageRequest{}); err != nil || len(reviews.Items) != 0 {
+		t.Errorf("Spider-Man reviews = %+v, %v; want none", reviews.Items, err)
+	}
+
+	// With no grace period the account goes at once.
+	if err := s.DeleteAccount(ctx, 3, "nikos-password", now); err != nil {
+		t.Fatalf("DeleteAccount(now): %v", err)
+	}
+	if _, err := s.GetProfile(ctx, 3); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetProfile(deleted now) = %v; want ErrNotFound", err)
+	}
+	if movie, err := s.GetMovie(ctx, "10"); err != nil || movie.ReviewCount != 0 || movie.AvgRating != 0 {
+		t.Errorf("Κυνόδοντας after deletion = %+v, %v; want no rating", movie, err)
+	}
+	if err := s.DeleteAccount(ctx, 3, "nikos-password", now); !errors.Is(err, ErrNotFound) {
+		t.Errorf("DeleteAccount(twice) = %v; want ErrNotFound", err)
+	}
+}
+
+func testReviews(t *testing.T, s Service) {
+	ctx := context.Background()
+
+	// Alice gave the Godfather 5 stars in review 1, Bob 4 in review 2.
+	reviews, err := s.ShowReview(ctx, "the-godfather", ReviewFilter{}, PageRequest{})
+	if err != nil || len(reviews.Items) != 2 || reviews.Items[0].Author.Username != "alice" || reviews.Items[1].Author.Username != "bob" {
+		t.Fatalf("ShowReview = %+v, %v", reviews, err)
+	}
+
+	for _, v := range []struct {
+		userID, reviewID int
+		helpful          bool
+		want             int
+	}{
+		{3, 2, true, 1},
+		{3, 2, true, 1}, // twice counts once
+		{1, 2, true, 2},
+		{3, 1, true, 1},
+		{1, 2, false, 1},
+		{1, 2, false, 1},
+	} {
+		vote, err := s.SetHelpful(ctx, v.userID, v.reviewID, v.helpful)
+		if err != nil || vote != (ReviewVote{ReviewID: v.reviewID, Helpful: v.helpful, HelpfulCount: v.want}) {
+			t.Errorf("SetHelpful(%d, %d, %v) = %+v, %v; want %d helpful", v.userID, v.reviewID, v.helpful, vote, err, v.want)
+		}
+	}
+	if _, err := s.SetHelpful(ctx, 2, 2, true); !errors.Is(err, ErrValidation) {
+		t.Errorf("SetHelpful(own review) = %v; want ErrValidation", err)
+	}
+	if _, err := s.SetHelpful(ctx, 2, 99, true); !errors.Is(err, ErrNotFound) {
+		t.Errorf("SetHelpful(no such review) = %v; want ErrNotFound", err)
+	}
+
+	// Bob finds Alice's review helpful too, which puts it ahead of his.
+	if _, err := s.SetHelpful(ctx, 2, 1, true); err != nil {
+		t.Fatalf("SetHelpful: %v", err)
+	}
+	reviews, err = s.ShowReview(ctx, "the-godfather", ReviewFilter{}, PageRequest{Sort: "-helpful"})
+	if err != nil || len(reviews.Items) != 2 || reviews.Items[0].Id != 1 || reviews.Items[0].HelpfulCount != 2 || reviews.Items[1].HelpfulCount != 1 {
+		t.Errorf("ShowReview by -helpful = %+v, %v", reviews.Items, err)
+	}
+
+	if reviews, err := s.ShowReview(ctx, "the-godfather", ReviewFilter{Stars: 4}, PageRequest{}); err != nil || reviews.Total != 1 || reviews.Items[0].Id != 2 {
+		t.Errorf("ShowReview(4 stars) = %+v, %v", reviews, err)
+	}
+	if reviews, err := s.ShowReview(ctx, "the-godfather", ReviewFilter{ExcludeUserID: 1}, PageRequest{}); err != nil || reviews.Total != 1 || reviews.Items[0].Id != 2 {
+		t.Errorf("ShowReview(without Alice's) = %+v, %v", reviews, err)
+	}
+	if _, err := s.ShowReview(ctx, "the-godfather", ReviewFilter{Stars: 6}, PageRequest{}); !errors.Is(err, ErrValidation) {
+		t.Errorf("ShowReview(6 stars) = %v; want ErrValidation", err)
+	}
+
+	mine, err := s.GetMovieReview(ctx, 2, 1)
+	if err != nil || mine.Id != 2 || mine.Author.Username != "bob" || mine.HelpfulCount != 1 || mine.Review.Review == "" {
+		t.Errorf("GetMovieReview = %+v, %v", mine, err)
+	}
+	if _, err := s.GetMovieReview(ctx, 3, 1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetMovieReview(none) = %v; want ErrNotFound", err)
+	}
+
+	// The votes of a deleted account stop counting.
+	if err := s.DeleteAccount(ctx, 3, "nikos-password", time.Now()); err != nil {
+		t.Fatalf("DeleteAccount: %v", err)
+	}
+	reviews, err = s.ShowReview(ctx, "the-godfather", ReviewFilter{}, PageRequest{})
+	if err != nil || len(reviews.Items) != 2 || reviews.Items[0].HelpfulCount != 1 || reviews.Items[1].HelpfulCount != 0 {
+		t.Errorf("ShowReview after deleting a voter = %+v, %v", reviews.Items, err)
+	}
+}
+
+func TestMemoryConcurrentUse(t *testing.T) {
+	ctx := context.Background()
+
+	m := newMemoryService(t)
+
+	const n = 20
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := fmt.Sprintf("user%d", i)
+			if _, err := m.RegisterUser(ctx, name, "password", name+"@example.com"); err != nil {
+				t.Errorf("RegisterUser(%s): %v", name, err)
+				return
+			}
+			id, err := m.GetUserID(ctx, name)
+			if err != nil {
+				t.Errorf("GetUserID(%s): %v", name, err)
+				return
+			}
+			if err := m.ToggleLiked(ctx, 1, id); err != nil {
+				t.Errorf("ToggleLiked: %v", err)
+			}
+			if _, err := m.GetMovies(ctx, MovieFilter{}, PageRequest{}); err != nil {
+				t.Errorf("GetMovies: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	ids := make(map[int]bool)
+	for i := 0; i < n; i++ {
+		id, _ := m.GetUserID(ctx, fmt.Sprintf("user%d", i))
+		if ids[id] {
+			t.Errorf("user ID %d handed out twice", id)
+		}
+		ids[id] = true
+	}
+}
+
+func TestServiceConcurrentReviews(t *testing.T) {
+	forEachBackend(t, testConcurrentReviews)
+}
+
+// testConcurrentReviews has every user post several reviews of the same movie
+// from more than one goroutine at once. However they interleave, each user
+// must end up with a single review and the movie's rating must be the mean
+// of those reviews.
+func testConcurrentReviews(t *testing.T, s Service) {
+	ctx := context.Background()
+
+	const url = "the-lobster"
+	usernames := []string{"alice", "bob", "nikos"}
+	for i := 0; i < 3; i++ {
+		name := fmt.Sprintf("reviewer%d", i)
+		if _, err := s.RegisterUser(ctx, name, "password", name+"@example.com"); err != nil {
+			t.Fatalf("RegisterUser(%s): %v", name, err)
+		}
+		usernames = append(usernames, name)
+	}
+
+	var wg sync.WaitGroup
+	for _, name := range usernames {
+		userID, err := s.GetUserID(ctx, name)
+		if err != nil {
+			t.Fatalf("GetUserID(%s): %v", name, err)
+		}
+		for g := 0; g < 3; g++ {
+			wg.Add(1)
+			go func(userID, g int) {
+				defer wg.Done()
+				for i := 0; i < 5; i++ {
+					stars := (userID+g+i)%5 + 1
+					text := fmt.Sprintf("take %d from goroutine %d", i, g)
+					if err := s.AddReview(ctx, url, stars, text, userID); err != nil {
+						t.Errorf("AddReview(user %d): %v", userID, err)
+					}
+				}
+			}(userID, g)
+		}
+	}
+	wg.Wait()
+
+	reviews, err := s.ShowReview(ctx, url, ReviewFilter{}, PageRequest{})
+	if err != nil {
+		t.Fatalf("ShowReview: %v", err)
+	}
+	if len(reviews.Items) != len(usernames) {
+		t.Fatalf("got %d reviews; want one per user (%d)", len(reviews.Items), len(usernames))
+	}
+
+	sum := 0
+	for _, r := range reviews.Items {
+		sum += r.Stars
+	}
+	want := float64(sum) / float64(len(reviews.Items))
+
+	movie, err := s.GetMovie(ctx, url)
+	if err != nil {
+		t.Fatalf("GetMovie: %v", err)
+	}
+	if math.Abs(movie.AvgRating-want) > 0.01 {
+		t.Errorf("AvgRating = %v; want %v", movie.AvgRating, want)
+	}
+}
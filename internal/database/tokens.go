@@ -0,0 +1,229 @@
+package database
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Refresh tokens are stored hashed in REFRESH_TOKEN (see migration
+// 0002_refresh_tokens). Every login starts a new family. Refreshing revokes
+// the presented token and issues its successor in the same family, so at most
+// one token per family is ever live. Timestamps are unix seconds.
+
+const (
+	accessTokenTTL  = 15 * time.Minute
+	refreshTokenTTL = 30 * 24 * time.Hour
+)
+
+var (
+	ErrInvalidRefreshToken = fmt.Errorf("invalid refresh token: %w", ErrUnauthorized)
+	ErrRefreshTokenReused  = fmt.Errorf("refresh token reused: %w", ErrUnauthorized)
+)
+
+type TokenPair struct {
+	AccessToken  string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresAt    int64  `json:"expires_at"`
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// querier is satisfied by both *sql.DB and *sql.Tx.
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// dbtx is satisfied by both *sql.DB and *sql.Tx.
+type dbtx interface {
+	execer
+	querier
+}
+
+func (s *service) IssueTokens(ctx context.Context, userID int) (_ TokenPair, err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
+
+	familyID, err := randomToken(16)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	return s.issueTokens(ctx, s.db, userID, familyID)
+}
+
+func (s *service) RefreshTokens(ctx context.Context, refreshToken string) (_ TokenPair, err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	defer tx.Rollback()
+
+	var (
+		tokenID   int64
+		userID    int
+		familyID  string
+		expiresAt int64
+		revokedAt sql.NullInt64
+	)
+	err = tx.QueryRowContext(ctx, "SELECT token_id, user_id, family_id, expires_at, revoked_at FROM REFRESH_TOKEN WHERE token_hash = ?"+s.forUpdate(), hashToken(refreshToken)).
+		Scan(&tokenID, &userID, &familyID, &expiresAt, &revokedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return TokenPair{}, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	now := time.Now()
+
+	if revokedAt.Valid {
+		// The token was already rotated or logged out. Someone is replaying
+		// it, so assume it leaked and end the whole session.
+		if err := revokeFamily(ctx, tx, familyID, now); err != nil {
+			return TokenPair{}, err
+		}
+		if err := tx.Commit(); err != nil {
+			return TokenPair{}, err
+		}
+		return TokenPair{}, ErrRefreshTokenReused
+	}
+
+	if now.Unix() >= expiresAt {
+		return TokenPair{}, ErrInvalidRefreshToken
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE REFRESH_TOKEN SET revoked_at = ? WHERE token_id = ?", now.Unix(), tokenID); err != nil {
+		return TokenPair{}, err
+	}
+
+	pair, err := s.issueTokens(ctx, tx, userID, familyID)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return TokenPair{}, err
+	}
+
+	return pair, nil
+}
+
+// RevokeRefreshToken ends the session the refresh token belongs to. Unknown
+// tokens are ignored so that logging out twice is harmless.
+func (s *service) RevokeRefreshToken(ctx context.Context, refreshToken string) (err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
+
+	var familyID string
+	err = s.db.QueryRowContext(ctx, "SELECT family_id FROM REFRESH_TOKEN WHERE token_hash = ?", hashToken(refreshToken)).Scan(&familyID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return revokeFamily(ctx, s.db, familyID, time.Now())
+}
+
+// SessionActive reports whether the token family an access token was issued
+// for still has a live refresh token, i.e. it wasn't logged out or revoked
+// after a reuse.
+func (s *service) SessionActive(ctx context.Context, familyID string) (_ bool, err error) {
+	ctx, done := s.withTimeout(ctx, &err)
+	defer done()
+
+	var active bool
+	err = s.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM REFRESH_TOKEN WHERE family_id = ? AND revoked_at IS NULL AND expires_at > ?)", familyID, time.Now().Unix()).Scan(&active)
+	if err != nil {
+		return false, err
+	}
+
+	return active, nil
+}
+
+func (s *service) issueTokens(ctx context.Context, db dbtx, userID int, familyID string) (TokenPair, error) {
+	now := time.Now()
+
+	roles, err := userRoles(ctx, db, userID)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	accessToken, expiresAt, err := createToken(s.jwtKey, userID, familyID, roles, now)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	refreshToken, err := randomToken(32)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	_, err = db.ExecContext(ctx, "INSERT INTO REFRESH_TOKEN (user_id, family_id, token_hash, issued_at, expires_at) VALUES (?, ?, ?, ?, ?)",
+		userID, familyID, hashToken(refreshToken), now.Unix(), now.Add(refreshTokenTTL).Unix())
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	return TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresAt:    expiresAt.Unix(),
+	}, nil
+}
+
+func revokeFamily(ctx context.Context, db execer, familyID string, now time.Time) error {
+	_, err := db.ExecContext(ctx, "UPDATE REFRESH_TOKEN SET revoked_at = ? WHERE family_id = ? AND revoked_at IS NULL", now.Unix(), familyID)
+	return err
+}
+
+// createToken signs a short-lived access token. The "sid" claim ties it to
+// its refresh token family so that logging out invalidates it immediately;
+// "roles" and "perms" carry the user's roles and the permissions they grant.
+func createToken(key []byte, userID int, familyID string, roles []string, now time.Time) (string, time.Time, error) {
+	expiresAt := now.Add(accessTokenTTL)
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub":   strconv.Itoa(userID),
+		"sid":   familyID,
+		"roles": roles,
+		"perms": Permissions(roles),
+		"iat":   now.Unix(),
+		"exp":   expiresAt.Unix(),
+	})
+
+	tokenString, err := token.SignedString(key)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return tokenString, expiresAt, nil
+}
+
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,188 @@
+// Package export gathers what the API keeps about a user, for them to
+// download as a ZIP archive.
+//
+// The archive holds profile.json, with the user's roles and the changes to
+// them, and lists.json, and, as both JSON and CSV, reviews, likes,
+// watchlist and diary. The JSON files have the fields of the API's own
+// responses; the CSV files, one row per item, are for spreadsheets.
+package export
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+
+	"lab2324omada7/internal/database"
+)
+
+// Data is everything about a user that goes into their export.
+type Data struct {
+	Profile     database.Profile
+	Roles       []string
+	RoleChanges []database.RoleChange
+	Reviews     []database.UserReview
+	Likes       []database.SavedMovie
+	Watchlist   []database.SavedMovie
+	Lists       []database.MovieList // the lists they own or collaborate on
+	Diary       []database.DiaryEntry
+}
+
+// Collect reads the user's data through db.
+func Collect(ctx context.Context, db database.Service, userID int) (Data, error) {
+	var (
+		d   Data
+		err error
+	)
+	if d.Profile, err = db.GetProfile(ctx, userID); err != nil {
+		return Data{}, err
+	}
+	if d.Roles, err = db.GetUserRoles(ctx, userID); err != nil {
+		return Data{}, err
+	}
+	if d.RoleChanges, err = db.GetRoleChanges(ctx, userID); err != nil {
+		return Data{}, err
+	}
+
+	d.Reviews, err = all(func(req database.PageRequest) (database.Page[database.UserReview], error) {
+		return db.GetUserReviews(ctx, userID, req)
+	})
+	if err != nil {
+		return Data{}, err
+	}
+	for _, collection := range []struct {
+		name string
+		dest *[]database.SavedMovie
+	}{
+		{database.CollectionLikes, &d.Likes},
+		{database.CollectionWatchlist, &d.Watchlist},
+	} {
+		*collection.dest, err = all(func(req database.PageRequest) (database.Page[database.SavedMovie], error) {
+			return db.GetSavedMovies(ctx, userID, collection.name, req)
+		})
+		if err != nil {
+			return Data{}, err
+		}
+	}
+	d.Diary, err = all(func(req database.PageRequest) (database.Page[database.DiaryEntry], error) {
+		return db.GetDiary(ctx, userID, database.DiaryFilter{}, req)
+	})
+	if err != nil {
+		return Data{}, err
+	}
+
+	lists, err := all(func(req database.PageRequest) (database.Page[database.ListSummary], error) {
+		return db.GetLists(ctx, database.ListFilter{MemberID: userID}, req)
+	})
+	if err != nil {
+		return Data{}, err
+	}
+	d.Lists = make([]database.MovieList, 0, len(lists))
+	for _, l := range lists {
+		list, err := db.GetList(ctx, userID, l.ID)
+		if err != nil {
+			return Data{}, err
+		}
+		d.Lists = append(d.Lists, list)
+	}
+	return d, nil
+}
+
+// all returns the items of every page fetch returns, oldest first.
+func all[T any](fetch func(database.PageRequest) (database.Page[T], error)) ([]T, error) {
+	items := []T{}
+	req := database.PageRequest{Limit: database.MaxPageSize}
+	for {
+		page, err := fetch(req)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, page.Items...)
+		if page.Next == "" {
+			return items, nil
+		}
+		req.Cursor = page.Next
+	}
+}
+
+// WriteZip writes the archive to w, dating its files at now.
+func (d Data) WriteZip(w io.Writer, now time.Time) error {
+	z := zipWriter{w: zip.NewWriter(w), now: now}
+
+	z.json("profile.json", struct {
+		database.Profile
+		Roles       []string              `json:"roles"`
+		RoleChanges []database.RoleChange `json:"role_changes"`
+	}{d.Profile, d.Roles, d.RoleChanges})
+
+	z.json("reviews.json", d.Reviews)
+	reviews := [][]string{{"movie_id", "title", "stars", "review", "date_posted"}}
+	for _, r := range d.Reviews {
+		reviews = append(reviews, []string{r.MovieId, r.MovieTitle, strconv.Itoa(r.Stars), r.Review.Review, r.DatePosted})
+	}
+	z.csv("reviews.csv", reviews)
+
+	for _, collection := range []struct {
+		name   string
+		movies []database.SavedMovie
+	}{{"likes", d.Likes}, {"watchlist", d.Watchlist}} {
+		z.json(collection.name+".json", collection.movies)
+		records := [][]string{{"movie_id", "title", "release_date", "date_added"}}
+		for _, m := range collection.movies {
+			records = append(records, []string{strconv.Itoa(m.Id), m.Title, m.ReleaseDate, m.DateAdded})
+		}
+		z.csv(collection.name+".csv", records)
+	}
+
+	z.json("diary.json", d.Diary)
+	diary := [][]string{{"movie_id", "title", "watched_on", "rewatch", "rating"}}
+	for _, e := range d.Diary {
+		rating := ""
+		if e.Rating != nil {
+			rating = strconv.Itoa(*e.Rating)
+		}
+		diary = append(diary, []string{strconv.Itoa(e.Id), e.Title, e.WatchedOn, strconv.FormatBool(e.Rewatch), rating})
+	}
+	z.csv("diary.csv", diary)
+
+	z.json("lists.json", d.Lists)
+
+	if z.err != nil {
+		return z.err
+	}
+	return z.w.Close()
+}
+
+// zipWriter adds files to a ZIP archive until one fails, and keeps the
+// error.
+type zipWriter struct {
+	w   *zip.Writer
+	now time.Time
+	err error
+}
+
+func (z *zipWriter) create(name string) io.Writer {
+	if z.err != nil {
+		return nil
+	}
+	f, err := z.w.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: z.now})
+	z.err = err
+	return f
+}
+
+func (z *zipWriter) json(name string, v interface{}) {
+	if f := z.create(name); f != nil {
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		z.err = enc.Encode(v)
+	}
+}
+
+func (z *zipWriter) csv(name string, records [][]string) {
+	if f := z.create(name); f != nil {
+		z.err = csv.NewWriter(f).WriteAll(records)
+	}
+}
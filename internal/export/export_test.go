@@ -0,0 +1,108 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+
+	"lab2324omada7/internal/database"
+	"lab2324omada7/internal/seed"
+)
+
+func newTestService(t *testing.T) *database.Memory {
+	t.Helper()
+
+	fixtures, err := seed.Default()
+	if err != nil {
+		t.Fatalf("seed.Default: %v", err)
+	}
+	db := database.NewMemory([]byte("test-key"))
+	if err := db.Load(fixtures); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	return db
+}
+
+// readZip returns the files of a ZIP archive by name.
+func readZip(t *testing.T, data []byte) map[string][]byte {
+	t.Helper()
+
+	r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("zip.NewReader: %v", err)
+	}
+	files := make(map[string][]byte)
+	for _, f := range r.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name], err = io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return files
+}
+
+func TestExport(t *testing.T) {
+	ctx := context.Background()
+	db := newTestService(t)
+
+	// Alice reviewed 1, 5 and 12, likes 1 and 5 and has 13 on her watchlist.
+	const alice = 1
+	if _, err := db.AddToCollection(ctx, alice, database.CollectionLikes, 4); err != nil {
+		t.Fatalf("AddToCollection: %v", err)
+	}
+	title := "Scorsese"
+	list, err := db.CreateList(ctx, alice, database.ListFields{Title: &title})
+	if err != nil {
+		t.Fatalf("CreateList: %v", err)
+	}
+
+	data, err := Collect(ctx, db, alice)
+	if err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	if data.Profile.Username != "alice" || len(data.Roles) == 0 || len(data.Reviews) != 3 ||
+		len(data.Likes) != 3 || len(data.Watchlist) != 1 || len(data.Lists) != 1 || data.Lists[0].ID != list.ID {
+		t.Errorf("Collect = %+v", data)
+	}
+
+	var buf bytes.Buffer
+	if err := data.WriteZip(&buf, time.Now()); err != nil {
+		t.Fatalf("WriteZip: %v", err)
+	}
+	files := readZip(t, buf.Bytes())
+	for _, name := range []string{
+		"profile.json", "lists.json",
+		"reviews.json", "reviews.csv", "likes.json", "likes.csv",
+		"watchlist.json", "watchlist.csv", "diary.json", "diary.csv",
+	} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("archive has no %s", name)
+		}
+	}
+
+	var profile struct {
+		Username string   `json:"username"`
+		Roles    []string `json:"roles"`
+	}
+	if err := json.Unmarshal(files["profile.json"], &profile); err != nil || profile.Username != "alice" || len(profile.Roles) == 0 {
+		t.Errorf("profile.json = %s, %v", files["profile.json"], err)
+	}
+	reviews, err := csv.NewReader(bytes.NewReader(files["reviews.csv"])).ReadAll()
+	if err != nil || len(reviews) != 4 || reviews[0][0] != "movie_id" {
+		t.Errorf("reviews.csv = %q, %v; want a header and 3 reviews", reviews, err)
+	}
+	watchlist, err := csv.NewReader(bytes.NewReader(files["watchlist.csv"])).ReadAll()
+	if err != nil || len(watchlist) != 2 || watchlist[1][0] != "13" {
+		t.Errorf("watchlist.csv = %q, %v; want movie 13", watchlist, err)
+	}
+}
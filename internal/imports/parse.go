@@ -0,0 +1,306 @@
+// Package imports brings a user's history over from Letterboxd and IMDb.
+//
+// Parse reads what those sites export: the ZIP archive Letterboxd's data
+// export downloads as, or CSV files out of it, and the ratings CSV of IMDb.
+// Each row becomes a database.ImportItem. Run then matches the items to
+// movies by title and year and creates them through database.Service, as
+// reviews, likes, watchlist entries and diary entries, leaving the rows it
+// couldn't match in the import job for the user to resolve.
+package imports
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"lab2324omada7/internal/database"
+)
+
+// The sites Parse reads exports of, as the Source of an import names them.
+const (
+	SourceLetterboxd = "letterboxd"
+	SourceIMDb       = "imdb"
+)
+
+// MaxFileSize bounds each uploaded file, and each file inside a ZIP
+// archive, and MaxRows the rows of an import.
+const (
+	MaxFileSize = 10 << 20
+	MaxRows     = 50000
+)
+
+// File is an uploaded file.
+type File struct {
+	Name string
+	Data []byte
+}
+
+// Row is an item read from line Line of File.
+type Row struct {
+	File string
+	Line int
+	Item database.ImportItem
+}
+
+// Batch is what Parse read: the rows to import, and how many rows it
+// skipped, such as reviews without a rating or IMDb's TV series.
+type Batch struct {
+	Source  string // the sites the files came from, joined by "+"
+	Rows    []Row
+	Skipped int
+}
+
+// Parse reads the files. A ZIP archive is taken for a Letterboxd export:
+// its ratings, reviews, diary, watchlist and liked films are read, and
+// everything else in it ignored. A CSV file is recognised by its columns,
+// and, for the Letterboxd watchlist and likes, whose columns are the same,
+// by its name, watchlist.csv or films.csv. Files that are neither are
+// ErrValidation.
+func Parse(files []File) (Batch, error) {
+	var (
+		b       Batch
+		sources = make(map[string]bool)
+	)
+	for _, f := range files {
+		if bytes.HasPrefix(f.Data, []byte("PK\x03\x04")) {
+			if err := b.readZip(f, sources); err != nil {
+				return Batch{}, err
+			}
+			continue
+		}
+		source, err := b.readCSV(f.Name, f.Data)
+		if err != nil {
+			return Batch{}, err
+		}
+		if source == "" {
+			return Batch{}, fmt.Errorf("%s is neither a Letterboxd nor an IMDb export: %w", f.Name, database.ErrValidation)
+		}
+		sources[source] = true
+	}
+
+	if len(sources) == 0 {
+		return Batch{}, fmt.Errorf("no Letterboxd or IMDb export found: %w", database.ErrValidation)
+	}
+	if len(b.Rows) > MaxRows {
+		return Batch{}, fmt.Errorf("an import takes at most %d rows, got %d: %w", MaxRows, len(b.Rows), database.ErrValidation)
+	}
+	names := make([]string, 0, len(sources))
+	for s := range sources {
+		names = append(names, s)
+	}
+	sort.Strings(names)
+	b.Source = strings.Join(names, "+")
+	return b, nil
+}
+
+// readZip reads the CSV files of a Letterboxd export: those at the top of
+// the archive, and likes/films.csv. The deleted/ and orphaned/ folders hold
+// entries the user no longer has, and lists/ is for another day.
+func (b *Batch) readZip(f File, sources map[string]bool) error {
+	archive, err := zip.NewReader(bytes.NewReader(f.Data), int64(len(f.Data)))
+	if err != nil {
+		return fmt.Errorf("%s: %v: %w", f.Name, err, database.ErrValidation)
+	}
+	for _, entry := range archive.File {
+		name := path.Clean(entry.Name)
+		if path.Ext(name) != ".csv" || (path.Dir(name) != "." && name != "likes/films.csv") {
+			continue
+		}
+		data, err := readEntry(entry)
+		if err != nil {
+			return fmt.Errorf("%s: %s: %v: %w", f.Name, name, err, database.ErrValidation)
+		}
+		source, err := b.readCSV(name, data)
+		if err != nil {
+			return err
+		}
+		if source != "" {
+			sources[source] = true
+		}
+	}
+	return nil
+}
+
+// readEntry reads a file out of a ZIP archive, up to MaxFileSize.
+func readEntry(entry *zip.File) ([]byte, error) {
+	rc, err := entry.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(io.LimitReader(rc, MaxFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxFileSize {
+		return nil, fmt.Errorf("larger than %d MB", MaxFileSize>>20)
+	}
+	return data, nil
+}
+
+// columns maps the names in the header of a CSV file to their positions.
+type columns map[string]int
+
+func (c columns) has(names ...string) bool {
+	for _, name := range names {
+		if _, ok := c[name]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// get returns the named field of record, or "" if there's no such column.
+func (c columns) get(record []string, name string) string {
+	i, ok := c[name]
+	if !ok || i >= len(record) {
+		return ""
+	}
+	return strings.TrimSpace(record[i])
+}
+
+// A reader turns a record of a CSV file into an item, or reports that the
+// row is to be skipped by returning false. Rows without a title are skipped
+// whatever it returns.
+type reader func(c columns, record []string) (database.ImportItem, bool)
+
+// readCSV adds the rows of a CSV file to b, and returns which site it came
+// from, or "" if it doesn't look like an export of either.
+func (b *Batch) readCSV(name string, data []byte) (string, error) {
+	r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+
+	header, err := r.Read()
+	if errors.Is(err, io.EOF) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("%s: %v: %w", name, err, database.ErrValidation)
+	}
+	c := make(columns, len(header))
+	for i, h := range header {
+		c[strings.TrimSpace(h)] = i
+	}
+
+	source, read := recognize(name, c)
+	if read == nil {
+		return "", nil
+	}
+	for {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return source, nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("%s: %v: %w", name, err, database.ErrValidation)
+		}
+		line, _ := r.FieldPos(0)
+
+		item, ok := read(c, record)
+		if !ok || item.Title == "" {
+			b.Skipped++
+			continue
+		}
+		b.Rows = append(b.Rows, Row{File: name, Line: line, Item: item})
+	}
+}
+
+// recognize tells which export a CSV file with the columns c is, and how
+// to read it.
+func recognize(name string, c columns) (string, reader) {
+	switch {
+	case c.has("Const", "Your Rating", "Title"):
+		return SourceIMDb, readIMDbRating
+	case !c.has("Letterboxd URI", "Name"):
+		return "", nil
+	case c.has("Review"):
+		return SourceLetterboxd, readLetterboxdReview
+	case c.has("Watched Date"):
+		return SourceLetterboxd, readLetterboxdDiary
+	case c.has("Rating"):
+		return SourceLetterboxd, readLetterboxdReview
+	case path.Base(name) == "watchlist.csv":
+		return SourceLetterboxd, readLetterboxd(database.ImportWatchlist)
+	case path.Base(name) == "films.csv":
+		return SourceLetterboxd, readLetterboxd(database.ImportLike)
+	}
+	return "", nil
+}
+
+// readIMDbRating reads a row of IMDb's ratings.csv. IMDb rates out of 10,
+// so a 7 becomes 4 stars. Series and their episodes, which IMDb rates too,
+// aren't movies and are skipped.
+func readIMDbRating(c columns, record []string) (database.ImportItem, bool) {
+	if kind := c.get(record, "Title Type"); strings.Contains(kind, "Series") || strings.Contains(kind, "Episode") {
+		return database.ImportItem{}, false
+	}
+	rating, err := strconv.Atoi(c.get(record, "Your Rating"))
+	if err != nil || rating < 1 || rating > 10 {
+		return database.ImportItem{}, false
+	}
+	return database.ImportItem{
+		Kind:  database.ImportReview,
+		Title: c.get(record, "Title"),
+		Year:  year(c.get(record, "Year")),
+		Stars: (rating + 1) / 2,
+	}, true
+}
+
+// readLetterboxd returns a reader for the Letterboxd files that only list
+// films, into items of the kind.
+func readLetterboxd(kind string) reader {
+	return func(c columns, record []string) (database.ImportItem, bool) {
+		return letterboxdItem(kind, c, record), true
+	}
+}
+
+// readLetterboxdReview reads a row of ratings.csv or reviews.csv. Reviews
+// here need a rating, so rows without one are skipped.
+func readLetterboxdReview(c columns, record []string) (database.ImportItem, bool) {
+	item := letterboxdItem(database.ImportReview, c, record)
+	item.Stars = stars(c.get(record, "Rating"))
+	item.Text = c.get(record, "Review")
+	return item, item.Stars != 0
+}
+
+// readLetterboxdDiary reads a row of diary.csv.
+func readLetterboxdDiary(c columns, record []string) (database.ImportItem, bool) {
+	item := letterboxdItem(database.ImportDiary, c, record)
+	item.Stars = stars(c.get(record, "Rating"))
+	item.WatchedOn = c.get(record, "Watched Date")
+	item.Rewatch = strings.EqualFold(c.get(record, "Rewatch"), "Yes")
+	return item, item.WatchedOn != ""
+}
+
+func letterboxdItem(kind string, c columns, record []string) database.ImportItem {
+	return database.ImportItem{Kind: kind, Title: c.get(record, "Name"), Year: year(c.get(record, "Year"))}
+}
+
+// stars turns a Letterboxd rating, from half a star to five in halves, into
+// whole stars, rounding halves up. It returns 0 for no rating.
+func stars(rating string) int {
+	r, err := strconv.ParseFloat(rating, 64)
+	if err != nil || r <= 0 {
+		return 0
+	}
+	return int(math.Min(5, math.Round(r)))
+}
+
+// year returns the year in a Year column, or 0 if it's empty or invalid.
+func year(s string) int {
+	y, err := strconv.Atoi(s)
+	if err != nil || y < 1800 || y > 9999 {
+		return 0
+	}
+	return y
+}
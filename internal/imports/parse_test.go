@@ -0,0 +1,152 @@
+package imports
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"testing"
+
+	"lab2324omada7/internal/database"
+)
+
+const (
+	letterboxdRatings = `Date,Name,Year,Letterboxd URI,Rating
+2024-01-02,The Godfather,1972,https://boxd.it/1,4.5
+2024-01-03,Goodfellas,1990,https://boxd.it/2,0.5
+2024-01-04,Taxi Driver,1976,https://boxd.it/3,
+`
+	letterboxdReviews = `Date,Name,Year,Letterboxd URI,Rating,Rewatch,Review,Tags,Watched Date
+2024-01-05,Poor Things,2023,https://boxd.it/4,4,,"Weird, and wonderful.",,2024-01-05
+`
+	letterboxdDiary = `Date,Name,Year,Letterboxd URI,Rating,Rewatch,Tags,Watched Date
+2024-01-05,Poor Things,2023,https://boxd.it/4,4,,,2024-01-05
+2024-02-10,The Lobster,2015,https://boxd.it/5,,Yes,,2024-02-09
+`
+	letterboxdWatchlist = `Date,Name,Year,Letterboxd URI
+2024-03-01,Κυνόδοντας,2009,https://boxd.it/6
+`
+	letterboxdLikes = `Date,Name,Year,Letterboxd URI
+2024-03-02,Spider-Man,2002,https://boxd.it/7
+`
+	imdbRatings = "\ufeffConst,Your Rating,Date Rated,Title,URL,Title Type,IMDb Rating,Runtime (mins),Year,Genres,Num Votes,Release Date,Directors\n" +
+		"tt0068646,10,2024-01-01,The Godfather,https://www.imdb.com/title/tt0068646/,Movie,9.2,175,1972,\"Crime, Drama\",2000000,1972-03-14,Francis Ford Coppola\n" +
+		"tt0075314,7,2024-01-02,Taxi Driver,https://www.imdb.com/title/tt0075314/,Movie,8.2,114,1976,\"Crime, Drama\",900000,1976-02-08,Martin Scorsese\n" +
+		"tt0903747,10,2024-01-03,Breaking Bad,https://www.imdb.com/title/tt0903747/,TV Series,9.5,49,2008,Drama,2000000,2008-01-20,\n"
+)
+
+// zipFile returns a ZIP archive holding the files.
+func zipFile(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, content := range files {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// byFile groups the rows of b by file.
+func byFile(b Batch) map[string][]Row {
+	rows := make(map[string][]Row)
+	for _, row := range b.Rows {
+		rows[row.File] = append(rows[row.File], row)
+	}
+	return rows
+}
+
+func TestParseLetterboxdExport(t *testing.T) {
+	export := zipFile(t, map[string]string{
+		"ratings.csv":               letterboxdRatings,
+		"reviews.csv":               letterboxdReviews,
+		"diary.csv":                 letterboxdDiary,
+		"watchlist.csv":             letterboxdWatchlist,
+		"likes/films.csv":           letterboxdLikes,
+		"deleted/ratings.csv":       letterboxdRatings,
+		"lists/favourites.csv":      "Letterboxd list export v7\n",
+		"profile.csv":               "Date Joined,Username\n2020-01-01,bob\n",
+		"watched.csv":               "Date,Name,Year,Letterboxd URI\n2024-01-01,Goodfellas,1990,https://boxd.it/2\n",
+		"orphaned/watchlist.csv":    letterboxdWatchlist,
+		"letterboxd-bob-readme.txt": "",
+	})
+
+	b, err := Parse([]File{{Name: "letterboxd-bob.zip", Data: export}})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if b.Source != SourceLetterboxd || b.Skipped != 1 {
+		t.Errorf("Parse = source %q, %d skipped; want letterboxd and the unrated rating skipped", b.Source, b.Skipped)
+	}
+
+	rows := byFile(b)
+	want := map[string][]database.ImportItem{
+		"ratings.csv": {
+			{Kind: database.ImportReview, Title: "The Godfather", Year: 1972, Stars: 5},
+			{Kind: database.ImportReview, Title: "Goodfellas", Year: 1990, Stars: 1},
+		},
+		"reviews.csv": {{Kind: database.ImportReview, Title: "Poor Things", Year: 2023, Stars: 4, Text: "Weird, and wonderful."}},
+		"diary.csv": {
+			{Kind: database.ImportDiary, Title: "Poor Things", Year: 2023, Stars: 4, WatchedOn: "2024-01-05"},
+			{Kind: database.ImportDiary, Title: "The Lobster", Year: 2015, WatchedOn: "2024-02-09", Rewatch: true},
+		},
+		"watchlist.csv":   {{Kind: database.ImportWatchlist, Title: "Κυνόδοντας", Year: 2009}},
+		"likes/films.csv": {{Kind: database.ImportLike, Title: "Spider-Man", Year: 2002}},
+	}
+	if len(rows) != len(want) {
+		t.Errorf("Parse read %d files; want %d", len(rows), len(want))
+	}
+	for file, items := range want {
+		if len(rows[file]) != len(items) {
+			t.Errorf("%s: %d rows; want %d", file, len(rows[file]), len(items))
+			continue
+		}
+		for i, item := range items {
+			if rows[file][i].Item != item {
+				t.Errorf("%s row %d = %+v; want %+v", file, i, rows[file][i].Item, item)
+			}
+		}
+	}
+	if line := rows["ratings.csv"][1].Line; line != 3 {
+		t.Errorf("line of the second rating = %d; want 3", line)
+	}
+}
+
+func TestParseCSV(t *testing.T) {
+	b, err := Parse([]File{{Name: "ratings.csv", Data: []byte(imdbRatings)}, {Name: "films.csv", Data: []byte(letterboxdLikes)}})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if b.Source != "imdb+letterboxd" || b.Skipped != 1 || len(b.Rows) != 3 {
+		t.Fatalf("Parse = %+v; want the TV series skipped", b)
+	}
+	if item := b.Rows[0].Item; item != (database.ImportItem{Kind: database.ImportReview, Title: "The Godfather", Year: 1972, Stars: 5}) {
+		t.Errorf("IMDb 10 = %+v; want 5 stars", item)
+	}
+	if item := b.Rows[1].Item; item.Stars != 4 {
+		t.Errorf("IMDb 7 = %+v; want 4 stars", item)
+	}
+	if item := b.Rows[2].Item; item.Kind != database.ImportLike {
+		t.Errorf("films.csv = %+v; want a like", item)
+	}
+
+	for name, files := range map[string][]File{
+		"no files":      nil,
+		"unknown CSV":   {{Name: "ratings.csv", Data: []byte("a,b\n1,2\n")}},
+		"broken ZIP":    {{Name: "export.zip", Data: []byte("PK\x03\x04broken")}},
+		"empty ZIP":     {{Name: "export.zip", Data: zipFile(t, map[string]string{"readme.txt": "hi"})}},
+		"ambiguous CSV": {{Name: "list.csv", Data: []byte(letterboxdLikes)}},
+	} {
+		if _, err := Parse(files); !errors.Is(err, database.ErrValidation) {
+			t.Errorf("Parse(%s) = %v; want ErrValidation", name, err)
+		}
+	}
+}
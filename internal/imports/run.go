@@ -0,0 +1,213 @@
+package imports
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"lab2324omada7/internal/database"
+)
+
+// order is the order Run applies items in. Diary entries go first: logging
+// a watch takes a movie off the watchlist, and the watchlist to keep is the
+// one in the export. Ratings go before reviews, so that a review's
+// text isn't overwritten by the rating of the same movie.
+func order(item database.ImportItem) int {
+	switch {
+	case item.Kind == database.ImportDiary:
+		return 0
+	case item.Kind == database.ImportReview && item.Text == "":
+		return 1
+	case item.Kind == database.ImportReview:
+		return 2
+	case item.Kind == database.ImportLike:
+		return 3
+	}
+	return 4
+}
+
+// Run imports the rows of b for the user, as the running import job jobID,
+// and records how it went with FinishImport. It leaves alone the reviews the
+// user had already written, and the diary entries they had already
+// logged. Items that fail validation, such as diary entries dated in the
+// future, are skipped; any other error stops the import, which fails. Run
+// returns an error only if it couldn't record the outcome.
+func Run(ctx context.Context, db database.Service, userID, jobID int, b Batch) error {
+	r := runner{db: db, userID: userID, matches: make(map[match]matched)}
+	r.result.Skipped = b.Skipped
+	err := r.run(ctx, b.Rows)
+	if err != nil {
+		r.result.Error = err.Error()
+	}
+
+	// The outcome is recorded even if ctx ran out.
+	return db.FinishImport(context.WithoutCancel(ctx), jobID, r.result)
+}
+
+// Apply imports item as the movie, for the user.
+func Apply(ctx context.Context, db database.Service, userID, movieID int, item database.ImportItem) error {
+	switch item.Kind {
+	case database.ImportReview:
+		return db.AddReview(ctx, strconv.Itoa(movieID), item.Stars, item.Text, userID)
+	case database.ImportLike:
+		_, err := db.AddToCollection(ctx, userID, database.CollectionLikes, movieID)
+		return err
+	case database.ImportWatchlist:
+		_, err := db.AddToCollection(ctx, userID, database.CollectionWatchlist, movieID)
+		return err
+	case database.ImportDiary:
+		f := database.DiaryFields{WatchedOn: &item.WatchedOn, Rewatch: &item.Rewatch}
+		if item.Stars != 0 {
+			f.Rating = &item.Stars
+		}
+		_, err := db.LogWatch(ctx, userID, movieID, f)
+		return err
+	}
+	return fmt.Errorf("unknown kind of import item %q: %w", item.Kind, database.ErrValidation)
+}
+
+type runner struct {
+	db     database.Service
+	userID int
+	result database.ImportResult
+
+	reviewed map[int]bool      // movies the user had reviewed
+	watched  map[watch]bool    // diary entries the user has
+	matches  map[match]matched // FindMovie's answers so far
+}
+
+type watch struct {
+	movieID   int
+	watchedOn string
+}
+
+type match struct {
+	title string
+	year  int
+}
+
+type matched struct {
+	movieID int
+	err     error
+}
+
+func (r *runner) run(ctx context.Context, rows []Row) error {
+	if err := r.load(ctx); err != nil {
+		return err
+	}
+
+	rows = append([]Row(nil), rows...)
+	sort.SliceStable(rows, func(i, j int) bool { return order(rows[i].Item) < order(rows[j].Item) })
+	for _, row := range rows {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := r.apply(ctx, row); err != nil {
+			return fmt.Errorf("%s, line %d: %w", row.File, row.Line, err)
+		}
+	}
+	return nil
+}
+
+// load finds the reviews and diary entries the user already has.
+func (r *runner) load(ctx context.Context) error {
+	r.reviewed = make(map[int]bool)
+	err := walk(func(req database.PageRequest) (string, error) {
+		page, err := r.db.GetUserReviews(ctx, r.userID, req)
+		for _, review := range page.Items {
+			id, _ := strconv.Atoi(review.MovieId)
+			r.reviewed[id] = true
+		}
+		return page.Next, err
+	})
+	if err != nil {
+		return err
+	}
+
+	r.watched = make(map[watch]bool)
+	return walk(func(req database.PageRequest) (string, error) {
+		page, err := r.db.GetDiary(ctx, r.userID, database.DiaryFilter{}, req)
+		for _, e := range page.Items {
+			r.watched[watch{e.Id, e.WatchedOn}] = true
+		}
+		return page.Next, err
+	})
+}
+
+// walk calls fetch for every page of a list, until it returns an error or
+// the last page.
+func walk(fetch func(database.PageRequest) (string, error)) error {
+	req := database.PageRequest{Limit: database.MaxPageSize}
+	for {
+		next, err := fetch(req)
+		if err != nil || next == "" {
+			return err
+		}
+		req.Cursor = next
+	}
+}
+
+// apply imports a row, or records why it didn't.
+func (r *runner) apply(ctx context.Context, row Row) error {
+	movieID, err := r.find(ctx, row.Item)
+	switch {
+	case errors.Is(err, database.ErrNotFound):
+		r.unmatched(row, "no movie matches")
+		return nil
+	case errors.Is(err, database.ErrConflict):
+		r.unmatched(row, "more than one movie matches")
+		return nil
+	case err != nil:
+		return err
+	}
+
+	switch item := row.Item; {
+	case item.Kind == database.ImportReview && r.reviewed[movieID]:
+		r.result.Skipped++
+		return nil
+	case item.Kind == database.ImportDiary:
+		w := watch{movieID, item.WatchedOn}
+		if r.watched[w] {
+			r.result.Skipped++
+			return nil
+		}
+		r.watched[w] = true
+	}
+
+	err = Apply(ctx, r.db, r.userID, movieID, row.Item)
+	if errors.Is(err, database.ErrValidation) {
+		r.result.Skipped++
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	r.result.Imported++
+	return nil
+}
+
+// find looks up the movie an item is about, asking the database once for
+// each title and year.
+func (r *runner) find(ctx context.Context, item database.ImportItem) (int, error) {
+	key := match{item.Title, item.Year}
+	if m, ok := r.matches[key]; ok {
+		return m.movieID, m.err
+	}
+	movie, err := r.db.FindMovie(ctx, item.Title, item.Year)
+	if err != nil && !errors.Is(err, database.ErrNotFound) && !errors.Is(err, database.ErrConflict) {
+		return 0, err
+	}
+	r.matches[key] = matched{movie.Id, err}
+	return movie.Id, err
+}
+
+func (r *runner) unmatched(row Row, reason string) {
+	r.result.Unmatched = append(r.result.Unmatched, database.ImportRow{
+		File:   row.File,
+		Line:   row.Line,
+		Item:   row.Item,
+		Reason: reason,
+	})
+}
@@ -0,0 +1,137 @@
+package imports
+
+import (
+	"context"
+	"testing"
+
+	"lab2324omada7/internal/database"
+	"lab2324omada7/internal/seed"
+)
+
+func newTestService(t *testing.T) *database.Memory {
+	t.Helper()
+
+	fixtures, err := seed.Default()
+	if err != nil {
+		t.Fatalf("seed.Default: %v", err)
+	}
+	db := database.NewMemory([]byte("test-key"))
+	if err := db.Load(fixtures); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	return db
+}
+
+func TestRun(t *testing.T) {
+	ctx := context.Background()
+	db := newTestService(t)
+
+	// Bob has 2 and 11 on his watchlist and reviewed movie 1.
+	const bob = 2
+	item := func(kind, title string, year int) database.ImportItem {
+		return database.ImportItem{Kind: kind, Title: title, Year: year}
+	}
+	rating := item(database.ImportReview, "The Godfather", 1972)
+	rating.Stars = 5
+	low := item(database.ImportReview, "Goodfellas", 1990)
+	low.Stars = 1
+	review := item(database.ImportReview, "Poor Things", 2023)
+	review.Stars, review.Text = 4, "Weird, and wonderful."
+	watched := item(database.ImportDiary, "Poor Things", 2023)
+	watched.WatchedOn = "2024-01-05"
+	rewatched := item(database.ImportDiary, "The Lobster", 2015)
+	rewatched.WatchedOn, rewatched.Rewatch = "2024-02-09", true
+	future := item(database.ImportDiary, "Taxi Driver", 1976)
+	future.WatchedOn = "2999-01-01"
+	unknown := item(database.ImportReview, "Amélie", 2001)
+	unknown.Stars = 5
+
+	var rows []Row
+	for i, item := range []database.ImportItem{
+		review, rating, low, unknown,
+		item(database.ImportWatchlist, "Κυνόδοντας", 2009),
+		item(database.ImportLike, "Spider-Man", 2002),
+		watched, rewatched, rewatched, future,
+	} {
+		rows = append(rows, Row{File: "export.csv", Line: i + 2, Item: item})
+	}
+
+	job, err := db.CreateImport(ctx, bob, SourceLetterboxd)
+	if err != nil {
+		t.Fatalf("CreateImport: %v", err)
+	}
+	if err := Run(ctx, db, bob, job.ID, Batch{Rows: rows, Skipped: 1}); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	job, err = db.GetImport(ctx, bob, job.ID)
+	if err != nil {
+		t.Fatalf("GetImport: %v", err)
+	}
+	// Skipped: one while parsing, the rating of a movie Bob had reviewed, the
+	// second watch on the same day and the one in the future.
+	if job.Status != database.ImportDone || job.Imported != 6 || job.Skipped != 4 {
+		t.Errorf("import = %+v; want 6 imported and 4 skipped", job)
+	}
+	if len(job.Rows) != 1 || job.Rows[0].Item != unknown || job.Rows[0].Line != 5 || job.Rows[0].Reason == "" {
+		t.Errorf("unmatched rows = %+v; want the unknown movie", job.Rows)
+	}
+
+	reviews, err := db.GetUserReviews(ctx, bob, database.PageRequest{})
+	if err != nil {
+		t.Fatalf("GetUserReviews: %v", err)
+	}
+	stars := make(map[string]database.Review)
+	for _, r := range reviews.Items {
+		stars[r.MovieId] = r.Review
+	}
+	if len(stars) != 4 || stars["1"].Stars == 5 || stars["4"].Stars != 1 || stars["12"].Review != review.Text {
+		t.Errorf("reviews = %+v; want Goodfellas and Poor Things added, and the Godfather left alone", stars)
+	}
+
+	statuses, err := db.GetMovieStatuses(ctx, bob, []int{2, 7, 10, 11})
+	if err != nil {
+		t.Fatalf("GetMovieStatuses: %v", err)
+	}
+	if !statuses[2].Watchlisted || !statuses[10].Watchlisted || statuses[11].Watchlisted || !statuses[7].Liked {
+		t.Errorf("statuses = %+v; want 10 on the watchlist, 11 watched and off it, and 7 liked", statuses)
+	}
+
+	diary, err := db.GetDiary(ctx, bob, database.DiaryFilter{}, database.PageRequest{Sort: "watched_on"})
+	if err != nil {
+		t.Fatalf("GetDiary: %v", err)
+	}
+	if len(diary.Items) != 2 || diary.Items[0].Id != 12 || diary.Items[1].Id != 11 || !diary.Items[1].Rewatch {
+		t.Errorf("diary = %+v", diary.Items)
+	}
+
+	// Importing the same rows again adds nothing.
+	again, err := db.CreateImport(ctx, bob, SourceLetterboxd)
+	if err != nil {
+		t.Fatalf("CreateImport: %v", err)
+	}
+	if err := Run(ctx, db, bob, again.ID, Batch{Rows: rows[:3]}); err != nil {
+		t.Fatalf("Run(again): %v", err)
+	}
+	if again, _ = db.GetImport(ctx, bob, again.ID); again.Imported != 0 || again.Skipped != 3 {
+		t.Errorf("import again = %+v; want the reviews skipped", again)
+	}
+}
+
+func TestRunCancelled(t *testing.T) {
+	db := newTestService(t)
+	job, err := db.CreateImport(context.Background(), 3, SourceIMDb)
+	if err != nil {
+		t.Fatalf("CreateImport: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rows := []Row{{File: "ratings.csv", Line: 2, Item: database.ImportItem{Kind: database.ImportLike, Title: "Goodfellas"}}}
+	if err := Run(ctx, db, 3, job.ID, Batch{Rows: rows}); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if job, _ = db.GetImport(context.Background(), 3, job.ID); job.Status != database.ImportFailed || job.Error == "" || job.FinishedAt == nil {
+		t.Errorf("cancelled import = %+v; want it failed", job)
+	}
+}
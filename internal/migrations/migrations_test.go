@@ -0,0 +1,140 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/fstest"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	_ "modernc.org/sqlite"
+
+	"lab2324omada7/internal/config"
+)
+
+func TestEmbeddedMigrationsLoad(t *testing.T) {
+	for _, driver := range []string{config.DriverMySQL, config.DriverSQLite} {
+		migrations, err := load(files, driver)
+		if err != nil {
+			t.Fatalf("load(%s): %v", driver, err)
+		}
+		if len(migrations) == 0 {
+			t.Fatalf("no migrations embedded for %s", driver)
+		}
+
+		for i, mig := range migrations {
+			if mig.Version != int64(i+1) {
+				t.Errorf("%s: migration %d has version %d; versions must be consecutive", driver, i, mig.Version)
+			}
+			if len(splitStatements(mig.Up)) == 0 || len(splitStatements(mig.Down)) == 0 {
+				t.Errorf("%s: migration %04d_%s has an empty script", driver, mig.Version, mig.Name)
+			}
+		}
+	}
+}
+
+func TestLoadPrefersDriverVariant(t *testing.T) {
+	fsys := fstest.MapFS{
+		"sql/0001_init.up.sql":        {Data: []byte("generic up")},
+		"sql/0001_init.down.sql":      {Data: []byte("generic down")},
+		"sql/0001_init.sqlite.up.sql": {Data: []byte("sqlite up")},
+	}
+
+	for driver, wantUp := range map[string]string{
+		config.DriverMySQL:  "generic up",
+		config.DriverSQLite: "sqlite up",
+	} {
+		migrations, err := load(fsys, driver)
+		if err != nil {
+			t.Fatalf("load(%s): %v", driver, err)
+		}
+		if len(migrations) != 1 || migrations[0].Up != wantUp || migrations[0].Down != "generic down" {
+			t.Errorf("load(%s) = %+v; want up %q and the generic down", driver, migrations, wantUp)
+		}
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	script := `-- comment; not a statement
+CREATE TABLE a (x TEXT DEFAULT 'semi;colon');
+INSERT INTO a VALUES ("it's");
+
+DROP TABLE b`
+
+	got := splitStatements(script)
+	want := []string{
+		"CREATE TABLE a (x TEXT DEFAULT 'semi;colon')",
+		`INSERT INTO a VALUES ("it's")`,
+		"DROP TABLE b",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("splitStatements = %q; want %q", got, want)
+	}
+}
+
+func TestUpRefusesWhenLocked(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	m, err := New(db, config.DriverMySQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery(`SELECT GET_LOCK\(\?, \?\)`).
+		WithArgs(LockName, 10).
+		WillReturnRows(sqlmock.NewRows([]string{"lock"}).AddRow(0))
+
+	if _, err := m.Up(context.Background()); !errors.Is(err, ErrLocked) {
+		t.Errorf("Up: got %v; want %v", err, ErrLocked)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSlugBackfillAvoidsCollisions(t *testing.T) {
+	db, err := sql.Open(config.DriverSQLite, filepath.Join(t.TempDir(), "slugs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	m, err := New(db, config.DriverSQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if _, err := m.To(ctx, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	// Movie 14's title makes the slug that movie 12 gets once the clash
+	// between the two "Heat"s is settled.
+	titles := map[int]string{12: "Heat", 13: "Heat", 14: "Heat 12", 15: "1917", 16: "Alien"}
+	for id, title := range titles {
+		if _, err := db.Exec(`INSERT INTO MOVIE (movie_id, Title, ReleaseDate, Genre) VALUES (?, ?, '1995-12-15', 'Crime')`, id, title); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := m.To(ctx, 4); err != nil {
+		t.Fatalf("To(4): %v", err)
+	}
+
+	want := map[int]string{12: "heat-12", 13: "heat-13", 14: "heat-12-14", 15: "1917-15", 16: "alien"}
+	for id, slug := range want {
+		var got string
+		if err := db.QueryRow(`SELECT Slug FROM MOVIE WHERE movie_id = ?`, id).Scan(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got != slug {
+			t.Errorf("movie %d (%q): slug %q; want %q", id, titles[id], got, slug)
+		}
+	}
+}
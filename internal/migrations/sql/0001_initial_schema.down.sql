@@ -0,0 +1,10 @@
+DROP TABLE ADDS_TO_WATCHLIST;
+DROP TABLE LIKES;
+DROP TABLE WROTE;
+DROP TABLE REVIEW;
+DROP TABLE USER;
+DROP TABLE DIRECTED;
+DROP TABLE ACTED;
+DROP TABLE DIRECTOR;
+DROP TABLE ACTOR;
+DROP TABLE MOVIE;
@@ -0,0 +1,87 @@
+-- SQLite flavour of 0001_initial_schema.up.sql. Dates are stored as TEXT so
+-- they scan back as "YYYY-MM-DD" strings like they do from MySQL, and titles
+-- and usernames compare case-insensitively to match MySQL's default collation.
+
+CREATE TABLE MOVIE (
+    movie_id    INTEGER PRIMARY KEY AUTOINCREMENT,
+    Title       TEXT NOT NULL COLLATE NOCASE,
+    ReleaseDate TEXT NOT NULL,
+    Genre       TEXT NOT NULL,
+    AvgRating   REAL NOT NULL DEFAULT 0
+);
+
+CREATE INDEX idx_movie_title ON MOVIE (Title);
+
+CREATE TABLE ACTOR (
+    actor_id    INTEGER PRIMARY KEY AUTOINCREMENT,
+    ActorName   TEXT NOT NULL,
+    DateOfBirth TEXT NOT NULL,
+    Nationality TEXT NOT NULL
+);
+
+CREATE TABLE DIRECTOR (
+    director_id  INTEGER PRIMARY KEY AUTOINCREMENT,
+    DirectorName TEXT NOT NULL,
+    DateOfBirth  TEXT NOT NULL,
+    Nationality  TEXT NOT NULL
+);
+
+CREATE TABLE ACTED (
+    actor_id INTEGER NOT NULL,
+    movie_id INTEGER NOT NULL,
+    PRIMARY KEY (actor_id, movie_id),
+    FOREIGN KEY (actor_id) REFERENCES ACTOR (actor_id) ON DELETE CASCADE,
+    FOREIGN KEY (movie_id) REFERENCES MOVIE (movie_id) ON DELETE CASCADE
+);
+
+CREATE TABLE DIRECTED (
+    director_id INTEGER NOT NULL,
+    movie_id    INTEGER NOT NULL,
+    PRIMARY KEY (director_id, movie_id),
+    FOREIGN KEY (director_id) REFERENCES DIRECTOR (director_id) ON DELETE CASCADE,
+    FOREIGN KEY (movie_id) REFERENCES MOVIE (movie_id) ON DELETE CASCADE
+);
+
+CREATE TABLE USER (
+    user_id  INTEGER PRIMARY KEY AUTOINCREMENT,
+    Username TEXT NOT NULL UNIQUE COLLATE NOCASE,
+    Email    TEXT NOT NULL,
+    Password TEXT NOT NULL
+);
+
+CREATE TABLE REVIEW (
+    review_id   INTEGER PRIMARY KEY AUTOINCREMENT,
+    ReviewText  TEXT NOT NULL,
+    RatingStars INTEGER NOT NULL,
+    DatePosted  TEXT NOT NULL,
+    movie_id    INTEGER NOT NULL,
+    FOREIGN KEY (movie_id) REFERENCES MOVIE (movie_id) ON DELETE CASCADE
+);
+
+CREATE TABLE WROTE (
+    review_id INTEGER NOT NULL,
+    user_id   INTEGER NOT NULL,
+    PRIMARY KEY (review_id, user_id),
+    FOREIGN KEY (review_id) REFERENCES REVIEW (review_id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES USER (user_id) ON DELETE CASCADE
+);
+
+CREATE TABLE LIKES (
+    user_id   INTEGER NOT NULL,
+    movie_id  INTEGER NOT NULL,
+    DateAdded TEXT NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES USER (user_id) ON DELETE CASCADE,
+    FOREIGN KEY (movie_id) REFERENCES MOVIE (movie_id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_likes_user_movie ON LIKES (user_id, movie_id);
+
+CREATE TABLE ADDS_TO_WATCHLIST (
+    user_id   INTEGER NOT NULL,
+    movie_id  INTEGER NOT NULL,
+    DateAdded TEXT NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES USER (user_id) ON DELETE CASCADE,
+    FOREIGN KEY (movie_id) REFERENCES MOVIE (movie_id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_watchlist_user_movie ON ADDS_TO_WATCHLIST (user_id, movie_id);
@@ -0,0 +1,83 @@
+-- Catalog, users and their activity as the API has always used them.
+-- Column order matters: the service scans several of these with SELECT *.
+
+CREATE TABLE MOVIE (
+    movie_id    INT AUTO_INCREMENT PRIMARY KEY,
+    Title       VARCHAR(255) NOT NULL,
+    ReleaseDate DATE NOT NULL,
+    Genre       VARCHAR(100) NOT NULL,
+    AvgRating   DOUBLE NOT NULL DEFAULT 0,
+    INDEX idx_movie_title (Title)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE ACTOR (
+    actor_id    INT AUTO_INCREMENT PRIMARY KEY,
+    ActorName   VARCHAR(255) NOT NULL,
+    DateOfBirth DATE NOT NULL,
+    Nationality VARCHAR(100) NOT NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE DIRECTOR (
+    director_id  INT AUTO_INCREMENT PRIMARY KEY,
+    DirectorName VARCHAR(255) NOT NULL,
+    DateOfBirth  DATE NOT NULL,
+    Nationality  VARCHAR(100) NOT NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE ACTED (
+    actor_id INT NOT NULL,
+    movie_id INT NOT NULL,
+    PRIMARY KEY (actor_id, movie_id),
+    FOREIGN KEY (actor_id) REFERENCES ACTOR (actor_id) ON DELETE CASCADE,
+    FOREIGN KEY (movie_id) REFERENCES MOVIE (movie_id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE DIRECTED (
+    director_id INT NOT NULL,
+    movie_id    INT NOT NULL,
+    PRIMARY KEY (director_id, movie_id),
+    FOREIGN KEY (director_id) REFERENCES DIRECTOR (director_id) ON DELETE CASCADE,
+    FOREIGN KEY (movie_id) REFERENCES MOVIE (movie_id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE USER (
+    user_id  INT AUTO_INCREMENT PRIMARY KEY,
+    Username VARCHAR(50) NOT NULL UNIQUE,
+    Email    VARCHAR(255) NOT NULL,
+    Password VARCHAR(255) NOT NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE REVIEW (
+    review_id   INT AUTO_INCREMENT PRIMARY KEY,
+    ReviewText  TEXT NOT NULL,
+    RatingStars INT NOT NULL,
+    DatePosted  DATE NOT NULL,
+    movie_id    INT NOT NULL,
+    FOREIGN KEY (movie_id) REFERENCES MOVIE (movie_id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE WROTE (
+    review_id INT NOT NULL,
+    user_id   INT NOT NULL,
+    PRIMARY KEY (review_id, user_id),
+    FOREIGN KEY (review_id) REFERENCES REVIEW (review_id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES USER (user_id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE LIKES (
+    user_id   INT NOT NULL,
+    movie_id  INT NOT NULL,
+    DateAdded DATETIME NOT NULL,
+    INDEX idx_likes_user_movie (user_id, movie_id),
+    FOREIGN KEY (user_id) REFERENCES USER (user_id) ON DELETE CASCADE,
+    FOREIGN KEY (movie_id) REFERENCES MOVIE (movie_id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE ADDS_TO_WATCHLIST (
+    user_id   INT NOT NULL,
+    movie_id  INT NOT NULL,
+    DateAdded DATETIME NOT NULL,
+    INDEX idx_watchlist_user_movie (user_id, movie_id),
+    FOREIGN KEY (user_id) REFERENCES USER (user_id) ON DELETE CASCADE,
+    FOREIGN KEY (movie_id) REFERENCES MOVIE (movie_id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1 @@
+DROP TABLE REFRESH_TOKEN;
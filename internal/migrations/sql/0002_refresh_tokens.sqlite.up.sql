@@ -0,0 +1,13 @@
+-- SQLite flavour of 0002_refresh_tokens.up.sql.
+CREATE TABLE REFRESH_TOKEN (
+    token_id   INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id    INTEGER NOT NULL,
+    family_id  TEXT NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+    issued_at  INTEGER NOT NULL,
+    expires_at INTEGER NOT NULL,
+    revoked_at INTEGER NULL,
+    FOREIGN KEY (user_id) REFERENCES USER (user_id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_refresh_token_family ON REFRESH_TOKEN (family_id);
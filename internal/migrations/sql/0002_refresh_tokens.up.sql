@@ -0,0 +1,14 @@
+-- Hashed refresh tokens. Every login starts a new family; refreshing
+-- revokes the presented token and issues its successor in the same family.
+-- Timestamps are unix seconds.
+CREATE TABLE REFRESH_TOKEN (
+    token_id   BIGINT AUTO_INCREMENT PRIMARY KEY,
+    user_id    INT NOT NULL,
+    family_id  VARCHAR(64) NOT NULL,
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    issued_at  BIGINT NOT NULL,
+    expires_at BIGINT NOT NULL,
+    revoked_at BIGINT NULL,
+    INDEX idx_refresh_token_family (family_id),
+    FOREIGN KEY (user_id) REFERENCES USER (user_id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
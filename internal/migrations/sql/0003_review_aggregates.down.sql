@@ -0,0 +1,13 @@
+ALTER TABLE MOVIE
+    DROP COLUMN RatingCount,
+    DROP COLUMN RatingSum;
+
+-- The unique key doubles as the index behind WROTE's user_id foreign key, so
+-- give that one its own index before dropping it.
+ALTER TABLE WROTE
+    DROP FOREIGN KEY fk_wrote_movie,
+    ADD INDEX idx_wrote_user (user_id);
+
+ALTER TABLE WROTE
+    DROP INDEX uq_wrote_user_movie,
+    DROP COLUMN movie_id;
@@ -0,0 +1,15 @@
+ALTER TABLE MOVIE DROP COLUMN RatingCount;
+ALTER TABLE MOVIE DROP COLUMN RatingSum;
+
+CREATE TABLE WROTE_OLD (
+    review_id INTEGER NOT NULL,
+    user_id   INTEGER NOT NULL,
+    PRIMARY KEY (review_id, user_id),
+    FOREIGN KEY (review_id) REFERENCES REVIEW (review_id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES USER (user_id) ON DELETE CASCADE
+);
+
+INSERT INTO WROTE_OLD (review_id, user_id) SELECT review_id, user_id FROM WROTE;
+
+DROP TABLE WROTE;
+ALTER TABLE WROTE_OLD RENAME TO WROTE;
@@ -0,0 +1,36 @@
+-- SQLite flavour of 0003_review_aggregates.up.sql. SQLite can't add
+-- constraints to an existing table, so WROTE is rebuilt.
+
+DELETE FROM REVIEW WHERE review_id IN (
+    SELECT W.review_id
+    FROM WROTE W
+    JOIN REVIEW R ON R.review_id = W.review_id
+    JOIN WROTE NEWER ON NEWER.user_id = W.user_id AND NEWER.review_id > W.review_id
+    JOIN REVIEW NEWER_R ON NEWER_R.review_id = NEWER.review_id AND NEWER_R.movie_id = R.movie_id
+);
+
+CREATE TABLE WROTE_NEW (
+    review_id INTEGER NOT NULL,
+    user_id   INTEGER NOT NULL,
+    movie_id  INTEGER NOT NULL,
+    PRIMARY KEY (review_id, user_id),
+    CONSTRAINT uq_wrote_user_movie UNIQUE (user_id, movie_id),
+    FOREIGN KEY (review_id) REFERENCES REVIEW (review_id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES USER (user_id) ON DELETE CASCADE,
+    FOREIGN KEY (movie_id) REFERENCES MOVIE (movie_id) ON DELETE CASCADE
+);
+
+INSERT INTO WROTE_NEW (review_id, user_id, movie_id)
+    SELECT W.review_id, W.user_id, R.movie_id FROM WROTE W JOIN REVIEW R ON R.review_id = W.review_id;
+
+DROP TABLE WROTE;
+ALTER TABLE WROTE_NEW RENAME TO WROTE;
+
+ALTER TABLE MOVIE ADD COLUMN RatingSum INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE MOVIE ADD COLUMN RatingCount INTEGER NOT NULL DEFAULT 0;
+
+UPDATE MOVIE SET
+    RatingSum = COALESCE((SELECT SUM(RatingStars) FROM REVIEW WHERE REVIEW.movie_id = MOVIE.movie_id), 0),
+    RatingCount = (SELECT COUNT(*) FROM REVIEW WHERE REVIEW.movie_id = MOVIE.movie_id);
+
+UPDATE MOVIE SET AvgRating = CASE WHEN RatingCount > 0 THEN RatingSum * 1.0 / RatingCount ELSE 0 END;
@@ -0,0 +1,32 @@
+-- One review per user and movie, enforced by the database, and running
+-- rating totals on MOVIE so that adding a review doesn't recompute the
+-- average over every review of the movie.
+
+ALTER TABLE WROTE ADD COLUMN movie_id INT NULL;
+
+UPDATE WROTE W JOIN REVIEW R ON R.review_id = W.review_id SET W.movie_id = R.movie_id;
+
+-- Earlier versions could store several reviews by the same user for a movie.
+-- Keep the newest one. Deleting the review cascades to WROTE.
+DELETE FROM REVIEW WHERE review_id IN (
+    SELECT review_id FROM (
+        SELECT W.review_id
+        FROM WROTE W
+        JOIN WROTE NEWER ON NEWER.user_id = W.user_id AND NEWER.movie_id = W.movie_id AND NEWER.review_id > W.review_id
+    ) AS superseded
+);
+
+ALTER TABLE WROTE
+    MODIFY movie_id INT NOT NULL,
+    ADD CONSTRAINT uq_wrote_user_movie UNIQUE (user_id, movie_id),
+    ADD CONSTRAINT fk_wrote_movie FOREIGN KEY (movie_id) REFERENCES MOVIE (movie_id) ON DELETE CASCADE;
+
+ALTER TABLE MOVIE
+    ADD COLUMN RatingSum INT NOT NULL DEFAULT 0,
+    ADD COLUMN RatingCount INT NOT NULL DEFAULT 0;
+
+UPDATE MOVIE SET
+    RatingSum = COALESCE((SELECT SUM(RatingStars) FROM REVIEW WHERE REVIEW.movie_id = MOVIE.movie_id), 0),
+    RatingCount = (SELECT COUNT(*) FROM REVIEW WHERE REVIEW.movie_id = MOVIE.movie_id);
+
+UPDATE MOVIE SET AvgRating = CASE WHEN RatingCount > 0 THEN RatingSum * 1.0 / RatingCount ELSE 0 END;
@@ -0,0 +1,3 @@
+ALTER TABLE DIRECTOR DROP INDEX uq_director_slug, DROP COLUMN Slug;
+ALTER TABLE ACTOR DROP INDEX uq_actor_slug, DROP COLUMN Slug;
+ALTER TABLE MOVIE DROP INDEX uq_movie_slug, DROP COLUMN Slug;
@@ -0,0 +1,7 @@
+DROP INDEX uq_director_slug;
+DROP INDEX uq_actor_slug;
+DROP INDEX uq_movie_slug;
+
+ALTER TABLE DIRECTOR DROP COLUMN Slug;
+ALTER TABLE ACTOR DROP COLUMN Slug;
+ALTER TABLE MOVIE DROP COLUMN Slug;
@@ -0,0 +1,27 @@
+-- SQLite flavour of 0004_slugs.up.sql. A column added by ALTER TABLE can't
+-- carry a UNIQUE constraint, so uniqueness comes from an index instead.
+
+ALTER TABLE MOVIE ADD COLUMN Slug TEXT NOT NULL DEFAULT '' COLLATE NOCASE;
+ALTER TABLE ACTOR ADD COLUMN Slug TEXT NOT NULL DEFAULT '' COLLATE NOCASE;
+ALTER TABLE DIRECTOR ADD COLUMN Slug TEXT NOT NULL DEFAULT '' COLLATE NOCASE;
+
+UPDATE MOVIE SET Slug = LOWER(REPLACE(REPLACE(REPLACE(REPLACE(REPLACE(REPLACE(REPLACE(TRIM(Title),
+    ':', ''), '.', ''), ',', ''), '''', ''), '!', ''), '?', ''), ' ', '-'));
+UPDATE ACTOR SET Slug = LOWER(REPLACE(REPLACE(REPLACE(REPLACE(TRIM(ActorName),
+    '.', ''), ',', ''), '''', ''), ' ', '-'));
+UPDATE DIRECTOR SET Slug = LOWER(REPLACE(REPLACE(REPLACE(REPLACE(TRIM(DirectorName),
+    '.', ''), ',', ''), '''', ''), ' ', '-'));
+
+UPDATE MOVIE SET Slug = Slug || '-' || movie_id
+WHERE Slug NOT GLOB '*[^0-9]*' OR EXISTS (
+    SELECT 1 FROM MOVIE Y WHERE Y.movie_id <> MOVIE.movie_id AND (Y.Slug = MOVIE.Slug OR Y.Slug || '-' || Y.movie_id = MOVIE.Slug));
+UPDATE ACTOR SET Slug = Slug || '-' || actor_id
+WHERE Slug NOT GLOB '*[^0-9]*' OR EXISTS (
+    SELECT 1 FROM ACTOR Y WHERE Y.actor_id <> ACTOR.actor_id AND (Y.Slug = ACTOR.Slug OR Y.Slug || '-' || Y.actor_id = ACTOR.Slug));
+UPDATE DIRECTOR SET Slug = Slug || '-' || director_id
+WHERE Slug NOT GLOB '*[^0-9]*' OR EXISTS (
+    SELECT 1 FROM DIRECTOR Y WHERE Y.director_id <> DIRECTOR.director_id AND (Y.Slug = DIRECTOR.Slug OR Y.Slug || '-' || Y.director_id = DIRECTOR.Slug));
+
+CREATE UNIQUE INDEX uq_movie_slug ON MOVIE (Slug);
+CREATE UNIQUE INDEX uq_actor_slug ON ACTOR (Slug);
+CREATE UNIQUE INDEX uq_director_slug ON DIRECTOR (Slug);
@@ -0,0 +1,46 @@
+-- A unique slug per movie and person, used in URLs instead of the title or
+-- name with its spaces turned into hyphens, which couldn't tell apart films
+-- sharing a title or reach titles that contain hyphens.
+--
+-- The backfill below approximates slug.Make for common punctuation; seeding
+-- and later edits store the slugs computed by the application. Clashing and
+-- all-digit slugs get the row's ID appended, like slug.For does, and so does
+-- a slug another row's could become ("heat-12" beside movie 12 "Heat"); a
+-- suffixed slug then ends in its own row's ID and can't clash with any other.
+-- MySQL DDL isn't transactional, so a clash left for the unique constraint
+-- would leave the migration half-applied. DISTINCT keeps MySQL from merging
+-- the derived table into the UPDATE, which it won't allow on the same table.
+
+ALTER TABLE MOVIE ADD COLUMN Slug VARCHAR(255) NULL;
+ALTER TABLE ACTOR ADD COLUMN Slug VARCHAR(255) NULL;
+ALTER TABLE DIRECTOR ADD COLUMN Slug VARCHAR(255) NULL;
+
+UPDATE MOVIE SET Slug = LOWER(REPLACE(REPLACE(REPLACE(REPLACE(REPLACE(REPLACE(REPLACE(TRIM(Title),
+    ':', ''), '.', ''), ',', ''), '''', ''), '!', ''), '?', ''), ' ', '-'));
+UPDATE ACTOR SET Slug = LOWER(REPLACE(REPLACE(REPLACE(REPLACE(TRIM(ActorName),
+    '.', ''), ',', ''), '''', ''), ' ', '-'));
+UPDATE DIRECTOR SET Slug = LOWER(REPLACE(REPLACE(REPLACE(REPLACE(TRIM(DirectorName),
+    '.', ''), ',', ''), '''', ''), ' ', '-'));
+
+UPDATE MOVIE M JOIN (
+    SELECT DISTINCT X.movie_id FROM MOVIE X
+    WHERE X.Slug REGEXP '^[0-9]*$' OR EXISTS (
+        SELECT 1 FROM MOVIE Y WHERE Y.movie_id <> X.movie_id AND (Y.Slug = X.Slug OR CONCAT(Y.Slug, '-', Y.movie_id) = X.Slug))
+) AS suffixed ON suffixed.movie_id = M.movie_id
+SET M.Slug = CONCAT(M.Slug, '-', M.movie_id);
+UPDATE ACTOR A JOIN (
+    SELECT DISTINCT X.actor_id FROM ACTOR X
+    WHERE X.Slug REGEXP '^[0-9]*$' OR EXISTS (
+        SELECT 1 FROM ACTOR Y WHERE Y.actor_id <> X.actor_id AND (Y.Slug = X.Slug OR CONCAT(Y.Slug, '-', Y.actor_id) = X.Slug))
+) AS suffixed ON suffixed.actor_id = A.actor_id
+SET A.Slug = CONCAT(A.Slug, '-', A.actor_id);
+UPDATE DIRECTOR D JOIN (
+    SELECT DISTINCT X.director_id FROM DIRECTOR X
+    WHERE X.Slug REGEXP '^[0-9]*$' OR EXISTS (
+        SELECT 1 FROM DIRECTOR Y WHERE Y.director_id <> X.director_id AND (Y.Slug = X.Slug OR CONCAT(Y.Slug, '-', Y.director_id) = X.Slug))
+) AS suffixed ON suffixed.director_id = D.director_id
+SET D.Slug = CONCAT(D.Slug, '-', D.director_id);
+
+ALTER TABLE MOVIE MODIFY Slug VARCHAR(255) NOT NULL, ADD CONSTRAINT uq_movie_slug UNIQUE (Slug);
+ALTER TABLE ACTOR MODIFY Slug VARCHAR(255) NOT NULL, ADD CONSTRAINT uq_actor_slug UNIQUE (Slug);
+ALTER TABLE DIRECTOR MODIFY Slug VARCHAR(255) NOT NULL, ADD CONSTRAINT uq_director_slug UNIQUE (Slug);
@@ -0,0 +1,2 @@
+DROP TABLE ROLE_AUDIT;
+DROP TABLE USER_ROLE;
@@ -0,0 +1,21 @@
+-- SQLite flavour of 0005_roles.up.sql.
+CREATE TABLE USER_ROLE (
+    user_id    INTEGER NOT NULL,
+    role       TEXT NOT NULL,
+    granted_by INTEGER NULL,
+    granted_at INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (user_id, role),
+    FOREIGN KEY (user_id) REFERENCES USER (user_id) ON DELETE CASCADE,
+    FOREIGN KEY (granted_by) REFERENCES USER (user_id) ON DELETE SET NULL
+);
+
+CREATE TABLE ROLE_AUDIT (
+    audit_id   INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id    INTEGER NOT NULL,
+    role       TEXT NOT NULL,
+    action     TEXT NOT NULL,
+    actor_id   INTEGER NULL,
+    created_at INTEGER NOT NULL
+);
+
+CREATE INDEX idx_role_audit_user ON ROLE_AUDIT (user_id);
@@ -0,0 +1,24 @@
+-- Roles granted to users on top of the "user" role everyone has, and an
+-- append-only log of every grant and revocation. The log keeps plain IDs
+-- rather than foreign keys so that it outlives the accounts it mentions;
+-- actor_id is NULL for changes made from the command line, and granted_at
+-- is 0 for roles loaded from fixtures. Timestamps are unix seconds.
+CREATE TABLE USER_ROLE (
+    user_id    INT NOT NULL,
+    role       VARCHAR(20) NOT NULL,
+    granted_by INT NULL,
+    granted_at BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (user_id, role),
+    FOREIGN KEY (user_id) REFERENCES USER (user_id) ON DELETE CASCADE,
+    FOREIGN KEY (granted_by) REFERENCES USER (user_id) ON DELETE SET NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE ROLE_AUDIT (
+    audit_id   BIGINT AUTO_INCREMENT PRIMARY KEY,
+    user_id    INT NOT NULL,
+    role       VARCHAR(20) NOT NULL,
+    action     VARCHAR(10) NOT NULL,
+    actor_id   INT NULL,
+    created_at BIGINT NOT NULL,
+    INDEX idx_role_audit_user (user_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,7 @@
+DROP INDEX idx_director_date_of_birth ON DIRECTOR;
+DROP INDEX idx_director_name ON DIRECTOR;
+DROP INDEX idx_actor_date_of_birth ON ACTOR;
+DROP INDEX idx_actor_name ON ACTOR;
+DROP INDEX idx_movie_rating_count ON MOVIE;
+DROP INDEX idx_movie_avg_rating ON MOVIE;
+DROP INDEX idx_movie_release_date ON MOVIE;
@@ -0,0 +1,8 @@
+DROP INDEX idx_review_movie;
+DROP INDEX idx_director_date_of_birth;
+DROP INDEX idx_director_name;
+DROP INDEX idx_actor_date_of_birth;
+DROP INDEX idx_actor_name;
+DROP INDEX idx_movie_rating_count;
+DROP INDEX idx_movie_avg_rating;
+DROP INDEX idx_movie_release_date;
@@ -0,0 +1,10 @@
+-- SQLite flavour of 0006_list_indexes.up.sql. SQLite doesn't index foreign
+-- keys by itself, so REVIEW.movie_id gets an index here.
+CREATE INDEX idx_movie_release_date ON MOVIE (ReleaseDate);
+CREATE INDEX idx_movie_avg_rating ON MOVIE (AvgRating);
+CREATE INDEX idx_movie_rating_count ON MOVIE (RatingCount);
+CREATE INDEX idx_actor_name ON ACTOR (ActorName);
+CREATE INDEX idx_actor_date_of_birth ON ACTOR (DateOfBirth);
+CREATE INDEX idx_director_name ON DIRECTOR (DirectorName);
+CREATE INDEX idx_director_date_of_birth ON DIRECTOR (DateOfBirth);
+CREATE INDEX idx_review_movie ON REVIEW (movie_id);
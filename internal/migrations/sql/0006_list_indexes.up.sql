@@ -0,0 +1,10 @@
+-- Indexes for the orders list endpoints can be sorted in, so that paging
+-- through a large catalog reads only the rows of each page. Reviews are
+-- listed per movie, which the foreign key index on REVIEW.movie_id covers.
+CREATE INDEX idx_movie_release_date ON MOVIE (ReleaseDate);
+CREATE INDEX idx_movie_avg_rating ON MOVIE (AvgRating);
+CREATE INDEX idx_movie_rating_count ON MOVIE (RatingCount);
+CREATE INDEX idx_actor_name ON ACTOR (ActorName);
+CREATE INDEX idx_actor_date_of_birth ON ACTOR (DateOfBirth);
+CREATE INDEX idx_director_name ON DIRECTOR (DirectorName);
+CREATE INDEX idx_director_date_of_birth ON DIRECTOR (DateOfBirth);
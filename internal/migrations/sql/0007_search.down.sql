@@ -0,0 +1,4 @@
+DROP INDEX ft_review_text ON REVIEW;
+DROP INDEX ft_director_name ON DIRECTOR;
+DROP INDEX ft_actor_name ON ACTOR;
+DROP INDEX ft_movie_title ON MOVIE;
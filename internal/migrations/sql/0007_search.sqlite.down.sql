@@ -0,0 +1,2 @@
+-- Nothing to undo; see 0007_search.sqlite.up.sql.
+SELECT 1;
@@ -0,0 +1,4 @@
+-- SQLite flavour of 0007_search.up.sql. SQLite databases are searched with
+-- an index built in process (see internal/search), so this version only
+-- keeps the drivers' versions in step.
+SELECT 1;
@@ -0,0 +1,7 @@
+-- FULLTEXT indexes for /api/search. MySQL only indexes words of at least
+-- innodb_ft_min_token_size (3) characters and skips its stopwords, so
+-- shorter query words match nothing here.
+CREATE FULLTEXT INDEX ft_movie_title ON MOVIE (Title);
+CREATE FULLTEXT INDEX ft_actor_name ON ACTOR (ActorName);
+CREATE FULLTEXT INDEX ft_director_name ON DIRECTOR (DirectorName);
+CREATE FULLTEXT INDEX ft_review_text ON REVIEW (ReviewText);
@@ -0,0 +1,7 @@
+ALTER TABLE USER
+    DROP COLUMN WatchlistPublic,
+    DROP COLUMN LikesPublic,
+    DROP COLUMN ReviewsPublic,
+    DROP COLUMN AvatarURL,
+    DROP COLUMN Bio,
+    DROP COLUMN DisplayName;
@@ -0,0 +1,6 @@
+ALTER TABLE USER DROP COLUMN WatchlistPublic;
+ALTER TABLE USER DROP COLUMN LikesPublic;
+ALTER TABLE USER DROP COLUMN ReviewsPublic;
+ALTER TABLE USER DROP COLUMN AvatarURL;
+ALTER TABLE USER DROP COLUMN Bio;
+ALTER TABLE USER DROP COLUMN DisplayName;
@@ -0,0 +1,7 @@
+-- SQLite flavour of 0008_profiles.up.sql, which adds one column at a time.
+ALTER TABLE USER ADD COLUMN DisplayName TEXT NOT NULL DEFAULT '';
+ALTER TABLE USER ADD COLUMN Bio TEXT NOT NULL DEFAULT '';
+ALTER TABLE USER ADD COLUMN AvatarURL TEXT NOT NULL DEFAULT '';
+ALTER TABLE USER ADD COLUMN ReviewsPublic INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE USER ADD COLUMN LikesPublic INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE USER ADD COLUMN WatchlistPublic INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,10 @@
+-- Profile fields users can edit, and which parts of their activity others
+-- may see on their public profile. Reviews and likes are public unless the
+-- user hides them; the watchlist is private unless they share it.
+ALTER TABLE USER
+    ADD COLUMN DisplayName VARCHAR(100) NOT NULL DEFAULT '',
+    ADD COLUMN Bio VARCHAR(1000) NOT NULL DEFAULT '',
+    ADD COLUMN AvatarURL VARCHAR(500) NOT NULL DEFAULT '',
+    ADD COLUMN ReviewsPublic BOOLEAN NOT NULL DEFAULT TRUE,
+    ADD COLUMN LikesPublic BOOLEAN NOT NULL DEFAULT TRUE,
+    ADD COLUMN WatchlistPublic BOOLEAN NOT NULL DEFAULT FALSE;
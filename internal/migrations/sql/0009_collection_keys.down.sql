@@ -0,0 +1,2 @@
+ALTER TABLE ADDS_TO_WATCHLIST ADD INDEX idx_watchlist_user_movie (user_id, movie_id), DROP PRIMARY KEY;
+ALTER TABLE LIKES ADD INDEX idx_likes_user_movie (user_id, movie_id), DROP PRIMARY KEY;
@@ -0,0 +1,27 @@
+CREATE TABLE ADDS_TO_WATCHLIST_OLD (
+    user_id   INTEGER NOT NULL,
+    movie_id  INTEGER NOT NULL,
+    DateAdded TEXT NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES USER (user_id) ON DELETE CASCADE,
+    FOREIGN KEY (movie_id) REFERENCES MOVIE (movie_id) ON DELETE CASCADE
+);
+
+INSERT INTO ADDS_TO_WATCHLIST_OLD (user_id, movie_id, DateAdded) SELECT user_id, movie_id, DateAdded FROM ADDS_TO_WATCHLIST;
+
+DROP TABLE ADDS_TO_WATCHLIST;
+ALTER TABLE ADDS_TO_WATCHLIST_OLD RENAME TO ADDS_TO_WATCHLIST;
+CREATE INDEX idx_watchlist_user_movie ON ADDS_TO_WATCHLIST (user_id, movie_id);
+
+CREATE TABLE LIKES_OLD (
+    user_id   INTEGER NOT NULL,
+    movie_id  INTEGER NOT NULL,
+    DateAdded TEXT NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES USER (user_id) ON DELETE CASCADE,
+    FOREIGN KEY (movie_id) REFERENCES MOVIE (movie_id) ON DELETE CASCADE
+);
+
+INSERT INTO LIKES_OLD (user_id, movie_id, DateAdded) SELECT user_id, movie_id, DateAdded FROM LIKES;
+
+DROP TABLE LIKES;
+ALTER TABLE LIKES_OLD RENAME TO LIKES;
+CREATE INDEX idx_likes_user_movie ON LIKES (user_id, movie_id);
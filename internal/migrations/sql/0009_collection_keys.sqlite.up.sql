@@ -0,0 +1,36 @@
+-- SQLite flavour of 0009_collection_keys.up.sql. SQLite can't add a primary
+-- key to an existing table, so both are rebuilt, keeping the earliest of any
+-- duplicates. Entries toggled on before dates were stored as
+-- "2006-01-02 15:04:05" carry a fraction and zone, which are cut off.
+
+CREATE TABLE LIKES_NEW (
+    user_id   INTEGER NOT NULL,
+    movie_id  INTEGER NOT NULL,
+    DateAdded TEXT NOT NULL,
+    PRIMARY KEY (user_id, movie_id),
+    FOREIGN KEY (user_id) REFERENCES USER (user_id) ON DELETE CASCADE,
+    FOREIGN KEY (movie_id) REFERENCES MOVIE (movie_id) ON DELETE CASCADE
+);
+
+INSERT INTO LIKES_NEW (user_id, movie_id, DateAdded)
+    SELECT user_id, movie_id, substr(MIN(DateAdded), 1, 19) FROM LIKES GROUP BY user_id, movie_id;
+
+DROP TABLE LIKES;
+ALTER TABLE LIKES_NEW RENAME TO LIKES;
+CREATE INDEX idx_likes_movie ON LIKES (movie_id);
+
+CREATE TABLE ADDS_TO_WATCHLIST_NEW (
+    user_id   INTEGER NOT NULL,
+    movie_id  INTEGER NOT NULL,
+    DateAdded TEXT NOT NULL,
+    PRIMARY KEY (user_id, movie_id),
+    FOREIGN KEY (user_id) REFERENCES USER (user_id) ON DELETE CASCADE,
+    FOREIGN KEY (movie_id) REFERENCES MOVIE (movie_id) ON DELETE CASCADE
+);
+
+INSERT INTO ADDS_TO_WATCHLIST_NEW (user_id, movie_id, DateAdded)
+    SELECT user_id, movie_id, substr(MIN(DateAdded), 1, 19) FROM ADDS_TO_WATCHLIST GROUP BY user_id, movie_id;
+
+DROP TABLE ADDS_TO_WATCHLIST;
+ALTER TABLE ADDS_TO_WATCHLIST_NEW RENAME TO ADDS_TO_WATCHLIST;
+CREATE INDEX idx_watchlist_movie ON ADDS_TO_WATCHLIST (movie_id);
@@ -0,0 +1,17 @@
+-- A movie is liked or on a watchlist at most once per user. Toggling used to
+-- check and then insert, so concurrent requests could store an entry twice;
+-- the earliest of each set of duplicates is kept. The temporary column
+-- tells apart rows that are otherwise identical.
+ALTER TABLE LIKES ADD COLUMN dedupe_id INT AUTO_INCREMENT PRIMARY KEY;
+DELETE L FROM LIKES L
+    JOIN LIKES EARLIER ON EARLIER.user_id = L.user_id AND EARLIER.movie_id = L.movie_id
+        AND (EARLIER.DateAdded < L.DateAdded OR (EARLIER.DateAdded = L.DateAdded AND EARLIER.dedupe_id < L.dedupe_id));
+ALTER TABLE LIKES DROP COLUMN dedupe_id, ADD PRIMARY KEY (user_id, movie_id);
+DROP INDEX idx_likes_user_movie ON LIKES;
+
+ALTER TABLE ADDS_TO_WATCHLIST ADD COLUMN dedupe_id INT AUTO_INCREMENT PRIMARY KEY;
+DELETE W FROM ADDS_TO_WATCHLIST W
+    JOIN ADDS_TO_WATCHLIST EARLIER ON EARLIER.user_id = W.user_id AND EARLIER.movie_id = W.movie_id
+        AND (EARLIER.DateAdded < W.DateAdded OR (EARLIER.DateAdded = W.DateAdded AND EARLIER.dedupe_id < W.dedupe_id));
+ALTER TABLE ADDS_TO_WATCHLIST DROP COLUMN dedupe_id, ADD PRIMARY KEY (user_id, movie_id);
+DROP INDEX idx_watchlist_user_movie ON ADDS_TO_WATCHLIST;
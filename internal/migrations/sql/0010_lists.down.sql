@@ -0,0 +1,3 @@
+DROP TABLE LIST_COLLABORATOR;
+DROP TABLE LIST_ENTRY;
+DROP TABLE MOVIE_LIST;
@@ -0,0 +1,41 @@
+-- SQLite flavour of 0010_lists.up.sql.
+CREATE TABLE MOVIE_LIST (
+    list_id     INTEGER PRIMARY KEY AUTOINCREMENT,
+    ListKey     TEXT NOT NULL,
+    owner_id    INTEGER NOT NULL,
+    Title       TEXT NOT NULL,
+    Description TEXT NOT NULL DEFAULT '',
+    Visibility  TEXT NOT NULL DEFAULT 'public',
+    CreatedAt   INTEGER NOT NULL,
+    UpdatedAt   INTEGER NOT NULL,
+    CONSTRAINT uq_movie_list_key UNIQUE (ListKey),
+    FOREIGN KEY (owner_id) REFERENCES USER (user_id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_movie_list_owner ON MOVIE_LIST (owner_id);
+
+CREATE TABLE LIST_ENTRY (
+    list_id  INTEGER NOT NULL,
+    movie_id INTEGER NOT NULL,
+    Position INTEGER NOT NULL,
+    Note     TEXT NOT NULL DEFAULT '',
+    added_by INTEGER NULL,
+    AddedAt  INTEGER NOT NULL,
+    PRIMARY KEY (list_id, movie_id),
+    FOREIGN KEY (list_id) REFERENCES MOVIE_LIST (list_id) ON DELETE CASCADE,
+    FOREIGN KEY (movie_id) REFERENCES MOVIE (movie_id) ON DELETE CASCADE,
+    FOREIGN KEY (added_by) REFERENCES USER (user_id) ON DELETE SET NULL
+);
+
+CREATE INDEX idx_list_entry_movie ON LIST_ENTRY (movie_id);
+
+CREATE TABLE LIST_COLLABORATOR (
+    list_id  INTEGER NOT NULL,
+    user_id  INTEGER NOT NULL,
+    AddedAt  INTEGER NOT NULL,
+    PRIMARY KEY (list_id, user_id),
+    FOREIGN KEY (list_id) REFERENCES MOVIE_LIST (list_id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES USER (user_id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_list_collaborator_user ON LIST_COLLABORATOR (user_id);
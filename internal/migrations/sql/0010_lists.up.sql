@@ -0,0 +1,39 @@
+-- Lists users curate: named, ordered selections of movies with a note per
+-- entry. ListKey is the random key lists are known by outside, so that the
+-- link of an unlisted list can't be guessed from its row ID. Position orders
+-- the entries of a list. Timestamps are unix seconds.
+CREATE TABLE MOVIE_LIST (
+    list_id     INT AUTO_INCREMENT PRIMARY KEY,
+    ListKey     VARCHAR(32) NOT NULL,
+    owner_id    INT NOT NULL,
+    Title       VARCHAR(200) NOT NULL,
+    Description VARCHAR(2000) NOT NULL DEFAULT '',
+    Visibility  VARCHAR(10) NOT NULL DEFAULT 'public',
+    CreatedAt   BIGINT NOT NULL,
+    UpdatedAt   BIGINT NOT NULL,
+    CONSTRAINT uq_movie_list_key UNIQUE (ListKey),
+    INDEX idx_movie_list_owner (owner_id),
+    FOREIGN KEY (owner_id) REFERENCES USER (user_id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE LIST_ENTRY (
+    list_id  INT NOT NULL,
+    movie_id INT NOT NULL,
+    Position INT NOT NULL,
+    Note     VARCHAR(1000) NOT NULL DEFAULT '',
+    added_by INT NULL,
+    AddedAt  BIGINT NOT NULL,
+    PRIMARY KEY (list_id, movie_id),
+    FOREIGN KEY (list_id) REFERENCES MOVIE_LIST (list_id) ON DELETE CASCADE,
+    FOREIGN KEY (movie_id) REFERENCES MOVIE (movie_id) ON DELETE CASCADE,
+    FOREIGN KEY (added_by) REFERENCES USER (user_id) ON DELETE SET NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE LIST_COLLABORATOR (
+    list_id  INT NOT NULL,
+    user_id  INT NOT NULL,
+    AddedAt  BIGINT NOT NULL,
+    PRIMARY KEY (list_id, user_id),
+    FOREIGN KEY (list_id) REFERENCES MOVIE_LIST (list_id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES USER (user_id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
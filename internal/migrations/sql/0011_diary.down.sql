@@ -0,0 +1 @@
+DROP TABLE DIARY_ENTRY;
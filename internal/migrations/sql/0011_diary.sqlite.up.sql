@@ -0,0 +1,17 @@
+-- SQLite flavour of 0011_diary.up.sql. WatchedOn is ISO 8601 text.
+CREATE TABLE DIARY_ENTRY (
+    entry_id  INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id   INTEGER NOT NULL,
+    movie_id  INTEGER NOT NULL,
+    WatchedOn TEXT NOT NULL,
+    Rewatch   INTEGER NOT NULL DEFAULT 0,
+    Rating    INTEGER NULL,
+    review_id INTEGER NULL,
+    CreatedAt INTEGER NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES USER (user_id) ON DELETE CASCADE,
+    FOREIGN KEY (movie_id) REFERENCES MOVIE (movie_id) ON DELETE CASCADE,
+    FOREIGN KEY (review_id) REFERENCES REVIEW (review_id) ON DELETE SET NULL
+);
+
+CREATE INDEX idx_diary_user_watched ON DIARY_ENTRY (user_id, WatchedOn);
+CREATE INDEX idx_diary_movie ON DIARY_ENTRY (movie_id);
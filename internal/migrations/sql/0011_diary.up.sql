@@ -0,0 +1,18 @@
+-- The watch diary: one row per time a user watched a movie. A movie can be
+-- logged any number of times; Rewatch marks the times after the first.
+-- Rating is optional and separate from the user's review of the movie, which
+-- review_id may link to.
+CREATE TABLE DIARY_ENTRY (
+    entry_id  INT AUTO_INCREMENT PRIMARY KEY,
+    user_id   INT NOT NULL,
+    movie_id  INT NOT NULL,
+    WatchedOn DATE NOT NULL,
+    Rewatch   BOOLEAN NOT NULL DEFAULT FALSE,
+    Rating    INT NULL,
+    review_id INT NULL,
+    CreatedAt BIGINT NOT NULL,
+    INDEX idx_diary_user_watched (user_id, WatchedOn),
+    FOREIGN KEY (user_id) REFERENCES USER (user_id) ON DELETE CASCADE,
+    FOREIGN KEY (movie_id) REFERENCES MOVIE (movie_id) ON DELETE CASCADE,
+    FOREIGN KEY (review_id) REFERENCES REVIEW (review_id) ON DELETE SET NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,2 @@
+DROP TABLE IMPORT_ROW;
+DROP TABLE IMPORT_JOB;
@@ -0,0 +1,30 @@
+-- SQLite flavour of 0012_imports.up.sql.
+CREATE TABLE IMPORT_JOB (
+    import_id  INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id    INTEGER NOT NULL,
+    Source     TEXT NOT NULL,
+    Status     TEXT NOT NULL,
+    Error      TEXT NOT NULL DEFAULT '',
+    Imported   INTEGER NOT NULL DEFAULT 0,
+    Skipped    INTEGER NOT NULL DEFAULT 0,
+    CreatedAt  INTEGER NOT NULL,
+    FinishedAt INTEGER NULL,
+    FOREIGN KEY (user_id) REFERENCES USER (user_id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_import_job_user ON IMPORT_JOB (user_id);
+
+CREATE TABLE IMPORT_ROW (
+    row_id    INTEGER PRIMARY KEY AUTOINCREMENT,
+    import_id INTEGER NOT NULL,
+    File      TEXT NOT NULL,
+    Line      INTEGER NOT NULL,
+    Item      TEXT NOT NULL,
+    Reason    TEXT NOT NULL,
+    Resolved  INTEGER NOT NULL DEFAULT 0,
+    movie_id  INTEGER NULL,
+    FOREIGN KEY (import_id) REFERENCES IMPORT_JOB (import_id) ON DELETE CASCADE,
+    FOREIGN KEY (movie_id) REFERENCES MOVIE (movie_id) ON DELETE SET NULL
+);
+
+CREATE INDEX idx_import_row_job ON IMPORT_ROW (import_id);
@@ -0,0 +1,30 @@
+-- Imports of a user's history from other sites. IMPORT_JOB records each
+-- upload and its outcome, and IMPORT_ROW the rows that matched no movie (or
+-- several), for the user to resolve by hand. Item holds the row as JSON.
+-- Timestamps are unix seconds.
+CREATE TABLE IMPORT_JOB (
+    import_id  INT AUTO_INCREMENT PRIMARY KEY,
+    user_id    INT NOT NULL,
+    Source     VARCHAR(50) NOT NULL,
+    Status     VARCHAR(10) NOT NULL,
+    Error      VARCHAR(500) NOT NULL DEFAULT '',
+    Imported   INT NOT NULL DEFAULT 0,
+    Skipped    INT NOT NULL DEFAULT 0,
+    CreatedAt  BIGINT NOT NULL,
+    FinishedAt BIGINT NULL,
+    INDEX idx_import_job_user (user_id),
+    FOREIGN KEY (user_id) REFERENCES USER (user_id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IMPORT_ROW (
+    row_id    INT AUTO_INCREMENT PRIMARY KEY,
+    import_id INT NOT NULL,
+    File      VARCHAR(255) NOT NULL,
+    Line      INT NOT NULL,
+    Item      TEXT NOT NULL,
+    Reason    VARCHAR(255) NOT NULL,
+    Resolved  BOOLEAN NOT NULL DEFAULT FALSE,
+    movie_id  INT NULL,
+    FOREIGN KEY (import_id) REFERENCES IMPORT_JOB (import_id) ON DELETE CASCADE,
+    FOREIGN KEY (movie_id) REFERENCES MOVIE (movie_id) ON DELETE SET NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,3 @@
+ALTER TABLE USER
+    DROP INDEX idx_user_delete_after,
+    DROP COLUMN DeleteAfter;
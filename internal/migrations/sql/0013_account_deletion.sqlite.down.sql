@@ -0,0 +1,2 @@
+DROP INDEX idx_user_delete_after;
+ALTER TABLE USER DROP COLUMN DeleteAfter;
@@ -0,0 +1,3 @@
+-- SQLite flavour of 0013_account_deletion.up.sql.
+ALTER TABLE USER ADD COLUMN DeleteAfter INTEGER NULL;
+CREATE INDEX idx_user_delete_after ON USER (DeleteAfter);
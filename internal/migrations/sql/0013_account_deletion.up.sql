@@ -0,0 +1,6 @@
+-- Accounts their users asked to delete. DeleteAfter is when the account and
+-- everything in it go for good, in unix seconds; until then, signing in again
+-- cancels the deletion.
+ALTER TABLE USER
+    ADD COLUMN DeleteAfter BIGINT NULL,
+    ADD INDEX idx_user_delete_after (DeleteAfter);
@@ -0,0 +1,2 @@
+DROP TABLE REVIEW_HELPFUL;
+ALTER TABLE REVIEW DROP COLUMN HelpfulCount;
@@ -0,0 +1,13 @@
+-- SQLite flavour of 0014_review_helpful.up.sql.
+ALTER TABLE REVIEW ADD COLUMN HelpfulCount INTEGER NOT NULL DEFAULT 0;
+
+CREATE TABLE REVIEW_HELPFUL (
+    review_id INTEGER NOT NULL,
+    user_id   INTEGER NOT NULL,
+    CreatedAt INTEGER NOT NULL,
+    PRIMARY KEY (review_id, user_id),
+    FOREIGN KEY (review_id) REFERENCES REVIEW (review_id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES USER (user_id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_review_helpful_user ON REVIEW_HELPFUL (user_id);
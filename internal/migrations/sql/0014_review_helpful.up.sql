@@ -0,0 +1,15 @@
+-- Users mark the reviews of others as helpful. REVIEW_HELPFUL holds the
+-- votes, and REVIEW.HelpfulCount their number, kept up to date with them as
+-- MOVIE.RatingCount is with the reviews, so reviews sort by it. CreatedAt is
+-- in unix seconds.
+ALTER TABLE REVIEW ADD COLUMN HelpfulCount INT NOT NULL DEFAULT 0;
+
+CREATE TABLE REVIEW_HELPFUL (
+    review_id INT NOT NULL,
+    user_id   INT NOT NULL,
+    CreatedAt BIGINT NOT NULL,
+    PRIMARY KEY (review_id, user_id),
+    INDEX idx_review_helpful_user (user_id),
+    FOREIGN KEY (review_id) REFERENCES REVIEW (review_id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES USER (user_id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,341 @@
+package search
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Completer suggests names for a search box as the user types. It keeps the
+// words of every name in an index of their own, with their trigrams next to
+// them, so a query word is looked up as a prefix in the sorted words and,
+// to allow for typos, compared by edit distance against only the words
+// sharing a trigram with it.
+//
+// Names and queries are folded and written in Latin letters (see Latin), so
+// "lanthimos" finds "Λάνθιμος" and "λανθιμος" finds "Lanthimos". A Completer
+// is safe for concurrent use.
+type Completer struct {
+	mu      sync.RWMutex
+	entries map[entryKey]Entry
+	words   map[string]map[entryKey]bool // word → the entries whose names hold it
+	grams   map[string]map[string]bool   // trigram → the words holding it
+	sorted  []string                     // the keys of words, sorted; nil when stale
+}
+
+// Entry is something a Completer can suggest.
+type Entry struct {
+	Type string `json:"type"`
+	ID   int    `json:"id"`
+	Slug string `json:"slug"`
+	Name string `json:"name"`
+}
+
+type entryKey struct {
+	typ string
+	id  int
+}
+
+func NewCompleter() *Completer {
+	return &Completer{
+		entries: make(map[entryKey]Entry),
+		words:   make(map[string]map[entryKey]bool),
+		grams:   make(map[string]map[string]bool),
+	}
+}
+
+// Put adds e, replacing the entry of the same type and ID if there is one.
+func (c *Completer) Put(e Entry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := entryKey{e.Type, e.ID}
+	c.remove(key)
+	c.entries[key] = e
+	for _, w := range latinWords(e.Name) {
+		if c.words[w] == nil {
+			c.words[w] = make(map[entryKey]bool)
+			for _, g := range trigrams(w) {
+				if c.grams[g] == nil {
+					c.grams[g] = make(map[string]bool)
+				}
+				c.grams[g][w] = true
+			}
+			c.sorted = nil
+		}
+		c.words[w][key] = true
+	}
+}
+
+// Remove drops the entry of the given type and ID, if there is one.
+func (c *Completer) Remove(typ string, id int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.remove(entryKey{typ, id})
+}
+
+func (c *Completer) remove(key entryKey) {
+	e, ok := c.entries[key]
+	if !ok {
+		return
+	}
+	delete(c.entries, key)
+	for _, w := range latinWords(e.Name) {
+		delete(c.words[w], key)
+		if len(c.words[w]) > 0 {
+			continue
+		}
+		delete(c.words, w)
+		for _, g := range trigrams(w) {
+			delete(c.grams[g], w)
+			if len(c.grams[g]) == 0 {
+				delete(c.grams, g)
+			}
+		}
+		c.sorted = nil
+	}
+}
+
+// Len returns the number of entries.
+func (c *Completer) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.entries)
+}
+
+// Complete returns up to limit entries whose names have, for every word of
+// the query, a word starting with it or, allowing for typos, with something
+// close to it. Names matching as typed come first, then shorter names.
+func (c *Completer) Complete(query string, limit int) []Entry {
+	terms := latinWords(query)
+	if len(terms) == 0 || limit <= 0 {
+		return nil
+	}
+
+	c.mu.RLock()
+	for c.sorted == nil {
+		c.mu.RUnlock()
+		c.mu.Lock()
+		c.sortWords()
+		c.mu.Unlock()
+		c.mu.RLock()
+	}
+	defer c.mu.RUnlock()
+
+	var scores map[entryKey]float64
+	for _, term := range terms {
+		termScores := make(map[entryKey]float64)
+		for w, score := range c.matchingWords(term) {
+			for key := range c.words[w] {
+				if score > termScores[key] {
+					termScores[key] = score
+				}
+			}
+		}
+
+		if scores == nil {
+			scores = termScores
+			continue
+		}
+		for key, score := range scores {
+			if termScores[key] == 0 {
+				delete(scores, key)
+			} else {
+				scores[key] = score + termScores[key]
+			}
+		}
+	}
+
+	type match struct {
+		Entry
+		score float64
+	}
+	matches := make([]match, 0, len(scores))
+	for key, score := range scores {
+		matches = append(matches, match{c.entries[key], score})
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if a.score != b.score {
+			return a.score > b.score
+		}
+		if la, lb := utf8.RuneCountInString(a.Name), utf8.RuneCountInString(b.Name); la != lb {
+			return la < lb
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		return a.ID < b.ID
+	})
+
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	entries := make([]Entry, len(matches))
+	for i, m := range matches {
+		entries[i] = m.Entry
+	}
+	return entries
+}
+
+// sortWords fills in c.sorted after Put or Remove changed the words. Doing it
+// then rather than on every change keeps loading many entries fast.
+func (c *Completer) sortWords() {
+	if c.sorted != nil {
+		return
+	}
+	c.sorted = make([]string, 0, len(c.words))
+	for w := range c.words {
+		c.sorted = append(c.sorted, w)
+	}
+	sort.Strings(c.sorted)
+}
+
+// matchingWords scores the indexed words term could be the start of: 1 for
+// those starting with it, less for each typo in the others.
+func (c *Completer) matchingWords(term string) map[string]float64 {
+	matches := make(map[string]float64)
+	for i := sort.SearchStrings(c.sorted, term); i < len(c.sorted) && strings.HasPrefix(c.sorted[i], term); i++ {
+		matches[c.sorted[i]] = 1
+	}
+
+	typos := allowedTypos(term)
+	if typos == 0 {
+		return matches
+	}
+	checked := make(map[string]bool)
+	for _, g := range trigrams(term) {
+		for w := range c.grams[g] {
+			if _, ok := matches[w]; ok || checked[w] {
+				continue
+			}
+			checked[w] = true
+			if d := prefixDistance(term, w); d <= typos {
+				matches[w] = 1 - 0.3*float64(d)
+			}
+		}
+	}
+	return matches
+}
+
+// allowedTypos is how many edits a query word may be away from a match. Short
+// words allow none, as nearly every short word is close to some other.
+func allowedTypos(term string) int {
+	switch n := utf8.RuneCountInString(term); {
+	case n < 4:
+		return 0
+	case n < 8:
+		return 1
+	}
+	return 2
+}
+
+// prefixDistance returns the edit distance between term and the start of w
+// closest to it.
+func prefixDistance(term, w string) int {
+	t, r := []rune(term), []rune(w)
+	best := len(t)
+	for n := len(t) - 1; n <= len(t)+1; n++ {
+		if n < 1 || n > len(r) {
+			continue
+		}
+		best = min(best, levenshtein(t, r[:n]))
+	}
+	return best
+}
+
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// trigrams returns the three-letter sequences of w, with the start of the
+// word marked by "^" so that the first letters count too.
+func trigrams(w string) []string {
+	r := append([]rune{'^'}, []rune(w)...)
+	var grams []string
+	for i := 0; i+3 <= len(r); i++ {
+		grams = append(grams, string(r[i:i+3]))
+	}
+	return grams
+}
+
+// latinWords returns the folded words of s in Latin letters.
+func latinWords(s string) []string {
+	var ws []string
+	for _, w := range words(s) {
+		ws = append(ws, Latin(w.folded))
+	}
+	return ws
+}
+
+// greekDigraphs are the letter pairs written differently from their
+// letters; initial gives the spelling at the start of a word, if different.
+var greekDigraphs = map[string]struct{ initial, other string }{
+	"ου": {"ou", "ou"},
+	"αι": {"ai", "ai"},
+	"ει": {"ei", "ei"},
+	"οι": {"oi", "oi"},
+	"αυ": {"av", "av"},
+	"ευ": {"ev", "ev"},
+	"μπ": {"b", "mb"},
+	"ντ": {"d", "nt"},
+	"γκ": {"g", "gk"},
+	"γγ": {"ng", "ng"},
+	"γχ": {"nch", "nch"},
+	"γξ": {"nx", "nx"},
+}
+
+var greekLetters = map[rune]string{
+	'α': "a", 'β': "v", 'γ': "g", 'δ': "d", 'ε': "e", 'ζ': "z", 'η': "i",
+	'θ': "th", 'ι': "i", 'κ': "k", 'λ': "l", 'μ': "m", 'ν': "n", 'ξ': "x",
+	'ο': "o", 'π': "p", 'ρ': "r", 'σ': "s", 'τ': "t", 'υ': "y", 'φ': "f",
+	'χ': "ch", 'ψ': "ps", 'ω': "o",
+}
+
+// Latin writes the Greek letters of a folded word in Latin ones, roughly as
+// ELOT 743 does but spelling μπ as names usually are in English ("b" first,
+// "mb" after), and leaves other letters as they are: "λανθιμοσ" becomes
+// "lanthimos".
+func Latin(folded string) string {
+	r := []rune(folded)
+	var b strings.Builder
+	for i := 0; i < len(r); i++ {
+		if i+1 < len(r) {
+			if d, ok := greekDigraphs[string(r[i:i+2])]; ok {
+				if i == 0 {
+					b.WriteString(d.initial)
+				} else {
+					b.WriteString(d.other)
+				}
+				i++
+				continue
+			}
+		}
+		if l, ok := greekLetters[r[i]]; ok {
+			b.WriteString(l)
+		} else {
+			b.WriteRune(r[i])
+		}
+	}
+	return b.String()
+}
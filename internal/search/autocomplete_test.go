@@ -0,0 +1,119 @@
+package search
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestLatin(t *testing.T) {
+	cases := map[string]string{
+		"Λάνθιμος":     "lanthimos",
+		"Αγγελόπουλος": "angelopoulos",
+		"Μπουμπουλίνα": "boumboulina",
+		"Ντίνος":       "dinos",
+		"Ευρυδίκη":     "evrydiki",
+		"Ψυχή":         "psychi",
+		"Scorsese":     "scorsese",
+	}
+	for in, want := range cases {
+		if got := Latin(Fold(in)); got != want {
+			t.Errorf("Latin(%q) = %q; want %q", in, got, want)
+		}
+	}
+}
+
+func newTestCompleter() *Completer {
+	c := NewCompleter()
+	for i, name := range []string{"The Godfather", "The Godfather Part II", "Goodfellas", "Κυνόδοντας", "Αλέξης Ζορμπάς", "Poor Things"} {
+		c.Put(Entry{Type: "movie", ID: i + 1, Name: name})
+	}
+	for i, name := range []string{"Γιώργος Λάνθιμος", "Martin Scorsese", "Θόδωρος Αγγελόπουλος"} {
+		c.Put(Entry{Type: "director", ID: i + 1, Name: name})
+	}
+	return c
+}
+
+func TestComplete(t *testing.T) {
+	c := newTestCompleter()
+
+	cases := []struct {
+		query string
+		want  []string
+	}{
+		{"god", []string{"The Godfather", "The Godfather Part II"}},
+		{"the god p", []string{"The Godfather Part II"}},
+		{"κυνο", []string{"Κυνόδοντας"}},
+		// Transliteration, both ways.
+		{"lanthimos", []string{"Γιώργος Λάνθιμος"}},
+		{"σκορσ", []string{"Martin Scorsese"}},
+		// Typos.
+		{"godfahter", []string{"The Godfather", "The Godfather Part II"}},
+		{"scorcese", []string{"Martin Scorsese"}},
+		{"angelopoulos", []string{"Θόδωρος Αγγελόπουλος"}},
+		{"zorbas", []string{"Αλέξης Ζορμπάς"}},
+		// Short words must match as typed.
+		{"gof", nil},
+		{"", nil},
+	}
+	for _, tc := range cases {
+		var got []string
+		for _, e := range c.Complete(tc.query, 10) {
+			got = append(got, e.Name)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tc.want) {
+			t.Errorf("Complete(%q) = %q; want %q", tc.query, got, tc.want)
+		}
+	}
+
+	// Names matching as typed beat those matching with a typo.
+	if got := c.Complete("goodf", 10); len(got) != 3 || got[0].Name != "Goodfellas" {
+		t.Errorf("Complete(goodf) = %+v; want Goodfellas first", got)
+	}
+	if got := c.Complete("the", 1); len(got) != 1 {
+		t.Errorf("Complete(the, 1) returned %d entries", len(got))
+	}
+}
+
+func TestCompleterPutAndRemove(t *testing.T) {
+	c := newTestCompleter()
+
+	c.Put(Entry{Type: "movie", ID: 6, Name: "The Lobster"})
+	if got := c.Complete("poor", 10); len(got) != 0 {
+		t.Errorf("Complete(poor) after renaming = %+v; want none", got)
+	}
+	if got := c.Complete("lobs", 10); len(got) != 1 || got[0].ID != 6 {
+		t.Errorf("Complete(lobs) = %+v; want the renamed movie", got)
+	}
+
+	c.Remove("movie", 6)
+	c.Remove("movie", 99)
+	if got := c.Complete("lobs", 10); len(got) != 0 {
+		t.Errorf("Complete(lobs) after Remove = %+v; want none", got)
+	}
+	if c.Len() != 8 {
+		t.Errorf("Len = %d; want 8", c.Len())
+	}
+}
+
+func TestCompleterConcurrentUse(t *testing.T) {
+	c := newTestCompleter()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				c.Put(Entry{Type: "actor", ID: i*100 + j, Name: fmt.Sprintf("Actor %d", j)})
+				c.Complete("actor god", 5)
+				c.Remove("actor", i*100+j)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if c.Len() != 9 {
+		t.Errorf("Len = %d; want 9", c.Len())
+	}
+}
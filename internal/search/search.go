@@ -0,0 +1,258 @@
+// Package search matches free-text queries against titles, names and review
+// text.
+//
+// Text and queries are compared folded: lower-cased, with accents and other
+// diacritics removed and the final sigma written as σ, so "κυνοδοντας" finds
+// "Κυνόδοντας" and "ZORBA" finds "Zorbá". A query matches the texts holding,
+// for every word of the query, a word that starts with it.
+//
+// Index is an in-process inverted index for backends without a full-text
+// index of their own. Highlight marks up the matched words however the match
+// was found.
+package search
+
+import (
+	"html"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// folds maps letters to the letter they are compared as, after lower-casing.
+// Combining marks are dropped separately.
+var folds = map[rune]rune{
+	'ά': 'α', 'έ': 'ε', 'ή': 'η', 'ί': 'ι', 'ϊ': 'ι', 'ΐ': 'ι', 'ό': 'ο',
+	'ύ': 'υ', 'ϋ': 'υ', 'ΰ': 'υ', 'ώ': 'ω', 'ς': 'σ',
+
+	'à': 'a', 'á': 'a', 'â': 'a', 'ã': 'a', 'ä': 'a', 'å': 'a', 'ā': 'a', 'ă': 'a', 'ą': 'a',
+	'ç': 'c', 'ć': 'c', 'č': 'c', 'ď': 'd', 'đ': 'd',
+	'è': 'e', 'é': 'e', 'ê': 'e', 'ë': 'e', 'ē': 'e', 'ė': 'e', 'ę': 'e', 'ě': 'e',
+	'ğ': 'g', 'ì': 'i', 'í': 'i', 'î': 'i', 'ï': 'i', 'ī': 'i', 'į': 'i', 'ı': 'i',
+	'ł': 'l', 'ñ': 'n', 'ń': 'n', 'ň': 'n',
+	'ò': 'o', 'ó': 'o', 'ô': 'o', 'õ': 'o', 'ö': 'o', 'ø': 'o', 'ō': 'o', 'ő': 'o',
+	'ř': 'r', 'ś': 's', 'š': 's', 'ş': 's', 'ť': 't', 'ţ': 't',
+	'ù': 'u', 'ú': 'u', 'û': 'u', 'ü': 'u', 'ū': 'u', 'ů': 'u', 'ű': 'u', 'ų': 'u',
+	'ý': 'y', 'ÿ': 'y', 'ź': 'z', 'ż': 'z', 'ž': 'z',
+}
+
+// Fold returns s as it is compared: lower-cased and without diacritics.
+func Fold(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		r = unicode.ToLower(r)
+		if f, ok := folds[r]; ok {
+			r = f
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// word is a word of a text, at text[start:end].
+type word struct {
+	start, end int
+	folded     string
+}
+
+// words splits text into runs of letters and digits. Combining marks belong
+// to the word they follow.
+func words(text string) []word {
+	var (
+		ws    []word
+		start = -1
+	)
+	for i, r := range text {
+		inWord := unicode.IsLetter(r) || unicode.IsDigit(r) || (start >= 0 && unicode.Is(unicode.Mn, r))
+		switch {
+		case inWord && start < 0:
+			start = i
+		case !inWord && start >= 0:
+			ws = append(ws, word{start, i, Fold(text[start:i])})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		ws = append(ws, word{start, len(text), Fold(text[start:])})
+	}
+	return ws
+}
+
+// Terms returns the distinct folded words of a query, in order.
+func Terms(query string) []string {
+	seen := make(map[string]bool)
+	var terms []string
+	for _, w := range words(query) {
+		if !seen[w.folded] {
+			seen[w.folded] = true
+			terms = append(terms, w.folded)
+		}
+	}
+	return terms
+}
+
+// Index is an inverted index over folded words. Add every text first, then
+// search; an Index isn't safe for concurrent use.
+type Index struct {
+	docs     []doc
+	postings map[string][]int // word → the docs holding it, ascending
+	sorted   []string         // the keys of postings, sorted; nil after Add
+}
+
+type doc struct {
+	weight float64
+	words  int
+}
+
+// Hit is a text an Index matched.
+type Hit struct {
+	Doc   int // as returned by Add
+	Score float64
+}
+
+func NewIndex() *Index {
+	return &Index{postings: make(map[string][]int)}
+}
+
+// Add indexes text and returns its document number, which hits refer to it
+// by. Matches in texts with a higher weight rank higher.
+func (ix *Index) Add(text string, weight float64) int {
+	n := len(ix.docs)
+	ws := words(text)
+	ix.docs = append(ix.docs, doc{weight: weight, words: len(ws)})
+	for _, w := range ws {
+		p := ix.postings[w.folded]
+		if len(p) == 0 || p[len(p)-1] != n {
+			ix.postings[w.folded] = append(p, n)
+		}
+	}
+	ix.sorted = nil
+	return n
+}
+
+// Search returns up to limit texts matching every term, best first. A word
+// equal to a term counts twice as much as one that only starts with it, and
+// matches in short texts count for more than those in long ones.
+func (ix *Index) Search(terms []string, limit int) []Hit {
+	if len(terms) == 0 {
+		return nil
+	}
+	if ix.sorted == nil {
+		ix.sorted = make([]string, 0, len(ix.postings))
+		for w := range ix.postings {
+			ix.sorted = append(ix.sorted, w)
+		}
+		sort.Strings(ix.sorted)
+	}
+
+	var scores map[int]float64
+	for _, term := range terms {
+		termScores := make(map[int]float64)
+		// The words starting with term sort right after it.
+		for i := sort.SearchStrings(ix.sorted, term); i < len(ix.sorted) && strings.HasPrefix(ix.sorted[i], term); i++ {
+			score := 0.5
+			if ix.sorted[i] == term {
+				score = 1
+			}
+			for _, d := range ix.postings[ix.sorted[i]] {
+				termScores[d] = math.Max(termScores[d], score)
+			}
+		}
+
+		if scores == nil {
+			scores = termScores
+			continue
+		}
+		for d, score := range scores {
+			if termScores[d] == 0 {
+				delete(scores, d)
+			} else {
+				scores[d] = score + termScores[d]
+			}
+		}
+	}
+
+	hits := make([]Hit, 0, len(scores))
+	for d, score := range scores {
+		hits = append(hits, Hit{Doc: d, Score: ix.docs[d].weight * score / math.Sqrt(float64(ix.docs[d].words))})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].Doc < hits[j].Doc
+	})
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits
+}
+
+// SnippetLength is how many characters of a long text Highlight keeps around
+// the first match.
+const SnippetLength = 160
+
+// Highlight returns text as HTML, with the words starting with one of the
+// terms in <mark> elements. Texts longer than SnippetLength characters are cut
+// down to that many around the first match, with "…" marking the cuts.
+func Highlight(text string, terms []string) string {
+	ws := words(text)
+	matched := make([]bool, len(ws))
+	first := -1
+	for i, w := range ws {
+		for _, term := range terms {
+			if strings.HasPrefix(w.folded, term) {
+				matched[i] = true
+				break
+			}
+		}
+		if matched[i] && first < 0 {
+			first = i
+		}
+	}
+
+	start, end := 0, len(text)
+	if n := len([]rune(text)); n > SnippetLength {
+		center := 0
+		if first >= 0 {
+			center = len([]rune(text[:ws[first].start]))
+		}
+		from := max(0, min(center-SnippetLength/4, n-SnippetLength))
+		start, end = runeOffset(text, from), runeOffset(text, from+SnippetLength)
+	}
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	at := start
+	for i, w := range ws {
+		if !matched[i] || w.start < start || w.end > end {
+			continue
+		}
+		b.WriteString(html.EscapeString(text[at:w.start]))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(text[w.start:w.end]))
+		b.WriteString("</mark>")
+		at = w.end
+	}
+	b.WriteString(html.EscapeString(text[at:end]))
+	if end < len(text) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
+
+// runeOffset returns the byte offset of the n-th rune of s.
+func runeOffset(s string, n int) int {
+	for i := range s {
+		if n == 0 {
+			return i
+		}
+		n--
+	}
+	return len(s)
+}
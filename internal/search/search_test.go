@@ -0,0 +1,95 @@
+package search
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFold(t *testing.T) {
+	cases := map[string]string{
+		"Κυνόδοντας":        "κυνοδοντασ",
+		"ΑΛΈΞΗΣ Ζορμπάς":    "αλεξησ ζορμπασ",
+		"Διϋλιστήριο":       "διυλιστηριο",
+		"Amélie Poulain":    "amelie poulain",
+		"Dvořák":            "dvorak",
+		"Café":             "cafe",
+		"Kill Bill: Vol. 1": "kill bill: vol. 1",
+	}
+	for in, want := range cases {
+		if got := Fold(in); got != want {
+			t.Errorf("Fold(%q) = %q; want %q", in, got, want)
+		}
+	}
+}
+
+func TestTerms(t *testing.T) {
+	got := Terms("  Kill-BILL: vol. 1 kill ")
+	if strings.Join(got, " ") != "kill bill vol 1" {
+		t.Errorf("Terms = %q", got)
+	}
+	if got := Terms("?!"); len(got) != 0 {
+		t.Errorf("Terms(?!) = %q; want none", got)
+	}
+}
+
+func TestIndexSearch(t *testing.T) {
+	ix := NewIndex()
+	godfather := ix.Add("The Godfather", 3)
+	partII := ix.Add("The Godfather Part II", 3)
+	review := ix.Add("Not as good as The Godfather, but what is?", 1)
+	dogtooth := ix.Add("Κυνόδοντας", 3)
+
+	hits := ix.Search(Terms("godfather"), 10)
+	if len(hits) != 3 || hits[0].Doc != godfather || hits[1].Doc != partII || hits[2].Doc != review {
+		t.Errorf("Search(godfather) = %+v; want the titles, shortest first, then the review", hits)
+	}
+
+	// Every term has to match, as a prefix.
+	if hits := ix.Search(Terms("god par"), 10); len(hits) != 1 || hits[0].Doc != partII {
+		t.Errorf("Search(god par) = %+v; want Part II", hits)
+	}
+	if hits := ix.Search(Terms("ΚΥΝΟΔ"), 10); len(hits) != 1 || hits[0].Doc != dogtooth {
+		t.Errorf("Search(ΚΥΝΟΔ) = %+v; want Κυνόδοντας", hits)
+	}
+	// An exact word beats a longer one starting with it.
+	ix2 := NewIndex()
+	ix2.Add("Goodfellas", 1)
+	hunting := ix2.Add("Good Will Hunting", 1)
+	if hits := ix2.Search(Terms("good"), 10); len(hits) != 2 || hits[0].Doc != hunting {
+		t.Errorf("Search(good) = %+v; want Good Will Hunting first", hits)
+	}
+
+	if hits := ix.Search(Terms("godfather"), 1); len(hits) != 1 {
+		t.Errorf("Search with limit 1 returned %d hits", len(hits))
+	}
+	if hits := ix.Search(Terms("scorsese"), 10); len(hits) != 0 {
+		t.Errorf("Search(scorsese) = %+v; want none", hits)
+	}
+	if hits := ix.Search(nil, 10); len(hits) != 0 {
+		t.Errorf("Search(no terms) = %+v; want none", hits)
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	cases := []struct {
+		text, query, want string
+	}{
+		{"Κυνόδοντας", "κυνο", "<mark>Κυνόδοντας</mark>"},
+		{"Tom & Jerry's <great> chase", "jer gre", "Tom &amp; <mark>Jerry</mark>&#39;s &lt;<mark>great</mark>&gt; chase"},
+		{"The Godfather", "lobster", "The Godfather"},
+	}
+	for _, c := range cases {
+		if got := Highlight(c.text, Terms(c.query)); got != c.want {
+			t.Errorf("Highlight(%q, %q) = %q; want %q", c.text, c.query, got, c.want)
+		}
+	}
+
+	long := strings.Repeat("word ", 100) + "needle " + strings.Repeat("word ", 100)
+	got := Highlight(long, Terms("needle"))
+	if !strings.HasPrefix(got, "…") || !strings.HasSuffix(got, "…") || !strings.Contains(got, "<mark>needle</mark>") {
+		t.Errorf("Highlight(long) = %q; want a snippet around the match", got)
+	}
+	if n := len([]rune(strings.NewReplacer("<mark>", "", "</mark>", "", "…", "").Replace(got))); n != SnippetLength {
+		t.Errorf("snippet is %d characters; want %d", n, SnippetLength)
+	}
+}
@@ -0,0 +1,245 @@
+// Package seed loads a deterministic sample catalog into the database so that
+// local development and integration tests start from a known state.
+package seed
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+	"lab2324omada7/internal/slug"
+)
+
+//go:embed fixtures/catalog.json
+var catalog []byte
+
+type Person struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	DateOfBirth string `json:"date_of_birth"`
+	Nationality string `json:"nationality"`
+	Slug        string `json:"slug,omitempty"`
+}
+
+type Movie struct {
+	ID          int    `json:"id"`
+	Title       string `json:"title"`
+	ReleaseDate string `json:"release_date"`
+	Genre       string `json:"genre"`
+	Slug        string `json:"slug,omitempty"`
+	Directors   []int  `json:"directors"`
+	Actors      []int  `json:"actors"`
+}
+
+type User struct {
+	ID       int      `json:"id"`
+	Username string   `json:"username"`
+	Email    string   `json:"email"`
+	Password string   `json:"password"`
+	Roles    []string `json:"roles,omitempty"` // on top of "user", which everyone has
+}
+
+type Review struct {
+	ID         int    `json:"id"`
+	UserID     int    `json:"user_id"`
+	MovieID    int    `json:"movie_id"`
+	Stars      int    `json:"stars"`
+	Text       string `json:"text"`
+	DatePosted string `json:"date_posted"`
+}
+
+type Entry struct {
+	UserID    int    `json:"user_id"`
+	MovieID   int    `json:"movie_id"`
+	DateAdded string `json:"date_added"`
+}
+
+type Fixtures struct {
+	Directors []Person `json:"directors"`
+	Actors    []Person `json:"actors"`
+	Movies    []Movie  `json:"movies"`
+	Users     []User   `json:"users"`
+	Reviews   []Review `json:"reviews"`
+	Likes     []Entry  `json:"likes"`
+	Watchlist []Entry  `json:"watchlist"`
+}
+
+// Default returns the sample catalog embedded in the binary.
+func Default() (*Fixtures, error) {
+	return Load(bytes.NewReader(catalog))
+}
+
+// Load decodes fixtures from JSON, rejecting unknown fields so that typos in
+// hand written fixture files don't silently drop data. Movies and people
+// without a slug get one derived from their title or name.
+func Load(r io.Reader) (*Fixtures, error) {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+
+	var f Fixtures
+	if err := dec.Decode(&f); err != nil {
+		return nil, fmt.Errorf("seed: decoding fixtures: %w", err)
+	}
+
+	f.Directors = withPersonSlugs(f.Directors)
+	f.Actors = withPersonSlugs(f.Actors)
+	taken := make(map[string]bool)
+	for i, m := range f.Movies {
+		if m.Slug == "" {
+			f.Movies[i].Slug = slug.For(m.Title, m.ID, func(s string) bool { return taken[s] })
+		}
+		taken[f.Movies[i].Slug] = true
+	}
+	return &f, nil
+}
+
+func withPersonSlugs(people []Person) []Person {
+	taken := make(map[string]bool)
+	for i, p := range people {
+		if p.Slug == "" {
+			people[i].Slug = slug.For(p.Name, p.ID, func(s string) bool { return taken[s] })
+		}
+		taken[people[i].Slug] = true
+	}
+	return people
+}
+
+// Apply writes the fixtures in a single transaction. Rows are keyed by their
+// fixture IDs and updated in place when they already exist, so applying the
+// same fixtures again leaves the database unchanged.
+func Apply(ctx context.Context, db *sql.DB, f *Fixtures) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	u := upserter{ctx: ctx, tx: tx}
+
+	for _, d := range f.Directors {
+		u.upsert("DIRECTOR", []string{"director_id"}, []string{"DirectorName", "DateOfBirth", "Nationality", "Slug"},
+			d.ID, d.Name, d.DateOfBirth, d.Nationality, d.Slug)
+	}
+	for _, a := range f.Actors {
+		u.upsert("ACTOR", []string{"actor_id"}, []string{"ActorName", "DateOfBirth", "Nationality", "Slug"},
+			a.ID, a.Name, a.DateOfBirth, a.Nationality, a.Slug)
+	}
+	for _, m := range f.Movies {
+		u.upsert("MOVIE", []string{"movie_id"}, []string{"Title", "ReleaseDate", "Genre", "Slug"},
+			m.ID, m.Title, m.ReleaseDate, m.Genre, m.Slug)
+		for _, id := range m.Directors {
+			u.upsert("DIRECTED", []string{"director_id", "movie_id"}, nil, id, m.ID)
+		}
+		for _, id := range m.Actors {
+			u.upsert("ACTED", []string{"actor_id", "movie_id"}, nil, id, m.ID)
+		}
+	}
+	for _, user := range f.Users {
+		u.user(user)
+		for _, role := range user.Roles {
+			u.upsert("USER_ROLE", []string{"user_id", "role"}, nil, user.ID, role)
+		}
+	}
+	for _, r := range f.Reviews {
+		u.upsert("REVIEW", []string{"review_id"}, []string{"ReviewText", "RatingStars", "DatePosted", "movie_id"},
+			r.ID, r.Text, r.Stars, r.DatePosted, r.MovieID)
+		u.upsert("WROTE", []string{"review_id", "user_id"}, []string{"movie_id"}, r.ID, r.UserID, r.MovieID)
+	}
+	for _, e := range f.Likes {
+		u.upsert("LIKES", []string{"user_id", "movie_id"}, []string{"DateAdded"}, e.UserID, e.MovieID, e.DateAdded)
+	}
+	for _, e := range f.Watchlist {
+		u.upsert("ADDS_TO_WATCHLIST", []string{"user_id", "movie_id"}, []string{"DateAdded"}, e.UserID, e.MovieID, e.DateAdded)
+	}
+
+	u.exec(`UPDATE MOVIE SET
+		RatingSum = COALESCE((SELECT SUM(RatingStars) FROM REVIEW WHERE REVIEW.movie_id = MOVIE.movie_id), 0),
+		RatingCount = (SELECT COUNT(*) FROM REVIEW WHERE REVIEW.movie_id = MOVIE.movie_id)`)
+	u.exec("UPDATE MOVIE SET AvgRating = CASE WHEN RatingCount > 0 THEN RatingSum * 1.0 / RatingCount ELSE 0 END")
+
+	if u.err != nil {
+		return u.err
+	}
+	return tx.Commit()
+}
+
+// upserter runs statements inside the seeding transaction and remembers the
+// first error, after which every further call is a no-op.
+type upserter struct {
+	ctx context.Context
+	tx  *sql.Tx
+	err error
+}
+
+func (u *upserter) exec(query string, args ...interface{}) {
+	if u.err != nil {
+		return
+	}
+	if _, err := u.tx.ExecContext(u.ctx, query, args...); err != nil {
+		u.err = fmt.Errorf("seed: %s: %w", query, err)
+	}
+}
+
+// upsert inserts a row or updates its non-key columns. values holds the key
+// values followed by the column values. A plain SELECT-then-write keeps it
+// portable across SQL dialects; it runs inside the seeding transaction.
+func (u *upserter) upsert(table string, keys, cols []string, values ...interface{}) {
+	if u.err != nil {
+		return
+	}
+
+	where := strings.Join(keys, " = ? AND ") + " = ?"
+	keyValues := values[:len(keys)]
+
+	var exists bool
+	err := u.tx.QueryRowContext(u.ctx, "SELECT EXISTS (SELECT 1 FROM "+table+" WHERE "+where+")", keyValues...).Scan(&exists)
+	if err != nil {
+		u.err = fmt.Errorf("seed: %s: %w", table, err)
+		return
+	}
+
+	switch {
+	case !exists:
+		all := append(append([]string{}, keys...), cols...)
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(all)), ", ")
+		u.exec("INSERT INTO "+table+" ("+strings.Join(all, ", ")+") VALUES ("+placeholders+")", values...)
+	case len(cols) > 0:
+		set := strings.Join(cols, " = ?, ") + " = ?"
+		args := append(append([]interface{}{}, values[len(keys):]...), keyValues...)
+		u.exec("UPDATE "+table+" SET "+set+" WHERE "+where, args...)
+	}
+}
+
+// user upserts a user, re-hashing the password only when the stored hash no
+// longer matches so that repeated runs don't churn the row.
+func (u *upserter) user(user User) {
+	if u.err != nil {
+		return
+	}
+
+	var hash string
+	err := u.tx.QueryRowContext(u.ctx, "SELECT Password FROM USER WHERE user_id = ?", user.ID).Scan(&hash)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		u.err = fmt.Errorf("seed: USER: %w", err)
+		return
+	}
+	if err == nil && bcrypt.CompareHashAndPassword([]byte(hash), []byte(user.Password)) == nil {
+		u.exec("UPDATE USER SET Username = ?, Email = ? WHERE user_id = ?", user.Username, user.Email, user.ID)
+		return
+	}
+
+	newHash, herr := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+	if herr != nil {
+		u.err = herr
+		return
+	}
+	u.upsert("USER", []string{"user_id"}, []string{"Username", "Email", "Password"},
+		user.ID, user.Username, user.Email, string(newHash))
+}
@@ -0,0 +1,99 @@
+package seed
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDefaultFixturesAreConsistent(t *testing.T) {
+	f, err := Default()
+	if err != nil {
+		t.Fatalf("Default: %v", err)
+	}
+
+	ids := func(people []Person) map[int]bool {
+		m := make(map[int]bool)
+		for _, p := range people {
+			m[p.ID] = true
+		}
+		return m
+	}
+	directors, actors := ids(f.Directors), ids(f.Actors)
+
+	movies := make(map[int]bool)
+	for _, m := range f.Movies {
+		movies[m.ID] = true
+		for _, id := range m.Directors {
+			if !directors[id] {
+				t.Errorf("movie %d references unknown director %d", m.ID, id)
+			}
+		}
+		for _, id := range m.Actors {
+			if !actors[id] {
+				t.Errorf("movie %d references unknown actor %d", m.ID, id)
+			}
+		}
+	}
+
+	users := make(map[int]bool)
+	for _, u := range f.Users {
+		users[u.ID] = true
+		for _, role := range u.Roles {
+			if role != "moderator" && role != "admin" {
+				t.Errorf("user %d has role %q; only moderator and admin are granted", u.ID, role)
+			}
+		}
+	}
+
+	type userMovie struct{ user, movie int }
+	reviewed := make(map[userMovie]bool)
+	for _, r := range f.Reviews {
+		if !users[r.UserID] || !movies[r.MovieID] {
+			t.Errorf("review %d references unknown user or movie", r.ID)
+		}
+		if key := (userMovie{r.UserID, r.MovieID}); reviewed[key] {
+			t.Errorf("review %d is the second by user %d of movie %d", r.ID, r.UserID, r.MovieID)
+		} else {
+			reviewed[key] = true
+		}
+		if r.Stars < 1 || r.Stars > 5 {
+			t.Errorf("review %d has %d stars", r.ID, r.Stars)
+		}
+	}
+	for _, e := range append(append([]Entry{}, f.Likes...), f.Watchlist...) {
+		if !users[e.UserID] || !movies[e.MovieID] {
+			t.Errorf("entry %+v references unknown user or movie", e)
+		}
+	}
+}
+
+func TestLoadRejectsUnknownFields(t *testing.T) {
+	if _, err := Load(strings.NewReader(`{"movie": []}`)); err == nil {
+		t.Error("Load: expected an error for a misspelled section")
+	}
+}
+
+func TestLoadAssignsSlugs(t *testing.T) {
+	f, err := Load(strings.NewReader(`{
+		"movies": [
+			{"id": 1, "title": "Spider-Man"},
+			{"id": 2, "title": "Spider Man"},
+			{"id": 3, "title": "Kill Bill: Vol. 1", "slug": "kill-bill"}
+		],
+		"actors": [{"id": 4, "name": "Samuel L. Jackson"}]
+	}`))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	var got []string
+	for _, m := range f.Movies {
+		got = append(got, m.Slug)
+	}
+	if want := "spider-man spider-man-2 kill-bill"; strings.Join(got, " ") != want {
+		t.Errorf("movie slugs = %v; want %s", got, want)
+	}
+	if got := f.Actors[0].Slug; got != "samuel-l-jackson" {
+		t.Errorf("actor slug = %q", got)
+	}
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"time"
+
+	"lab2324omada7/internal/export"
+)
+
+type DeleteAccountPayload struct {
+	Password string `json:"password"`
+}
+
+// AccountDeletion says when a deleted account goes for good. Signing in
+// before then keeps it.
+type AccountDeletion struct {
+	DeleteAfter int64 `json:"delete_after"`
+}
+
+// ExportHandler sends the user a ZIP archive of their data.
+func (s *Server) ExportHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.currentUser(w, r, "")
+	if !ok {
+		return
+	}
+	data, err := export.Collect(r.Context(), s.db, userID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// Build the archive first, so a failure can still be an error response.
+	now := time.Now()
+	var buf bytes.Buffer
+	if err := data.WriteZip(&buf, now); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	filename := fmt.Sprintf("%s-%s.zip", data.Profile.Username, now.UTC().Format("2006-01-02"))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+// DeleteAccountHandler deletes the user's account once the grace period
+// is over, and signs them out everywhere now.
+func (s *Server) DeleteAccountHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.currentUser(w, r, "")
+	if !ok {
+		return
+	}
+
+	var payload DeleteAccountPayload
+	if err := decodeStrictJSON(r, &payload); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	at := time.Now().Add(s.deletionGrace)
+	if err := s.db.DeleteAccount(r.Context(), userID, payload.Password, at); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeUpdated(w, AccountDeletion{DeleteAfter: at.Unix()})
+}
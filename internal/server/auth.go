@@ -0,0 +1,234 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"lab2324omada7/internal/database"
+)
+
+type contextKey int
+
+const (
+	userIDKey contextKey = iota
+	grantsKey
+)
+
+// grants holds the roles and permissions an access token carries.
+type grants struct {
+	roles       []string
+	permissions []string
+}
+
+var (
+	errMissingToken = fmt.Errorf("missing bearer token: %w", database.ErrUnauthorized)
+	errInvalidToken = fmt.Errorf("invalid token: %w", database.ErrUnauthorized)
+	errRevokedToken = fmt.Errorf("token has been revoked: %w", database.ErrUnauthorized)
+)
+
+// RequireAuth rejects requests that don't carry a valid bearer token and
+// stores the authenticated user ID (the token's "sub" claim), roles and
+// permissions in the request context for the handlers behind it.
+func (s *Server) RequireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, sessionID, granted, err := s.authenticateRequest(r)
+		if err == nil {
+			err = s.checkSession(r.Context(), sessionID)
+		}
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), userIDKey, userID)
+		ctx = context.WithValue(ctx, grantsKey, granted)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// IdentifyUser is RequireAuth for endpoints anyone may call, but whose
+// answer depends on who asks: requests without an Authorization header go
+// through anonymously, while those with an invalid token are still rejected.
+func (s *Server) IdentifyUser(next http.Handler) http.Handler {
+	authenticated := s.RequireAuth(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		authenticated.ServeHTTP(w, r)
+	})
+}
+
+// RequireRole lets through users that have at least one of the roles. Like
+// RequirePermission it must run after RequireAuth, and it trusts the roles
+// in the access token, so a newly granted role counts once the user's token
+// is refreshed.
+func (s *Server) RequireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			granted, ok := r.Context().Value(grantsKey).(grants)
+			if !ok {
+				writeError(w, r, errMissingToken)
+				return
+			}
+			for _, role := range roles {
+				if contains(granted.roles, role) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			writeError(w, r, fmt.Errorf("requires the %s role: %w", strings.Join(roles, " or "), database.ErrForbidden))
+		})
+	}
+}
+
+// RequirePermission lets through users whose roles grant the permission.
+func (s *Server) RequirePermission(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			granted, ok := r.Context().Value(grantsKey).(grants)
+			if !ok {
+				writeError(w, r, errMissingToken)
+				return
+			}
+			if !contains(granted.permissions, permission) {
+				writeError(w, r, fmt.Errorf("requires the %q permission: %w", permission, database.ErrForbidden))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// UserIDFromContext returns the user ID stored by RequireAuth.
+func UserIDFromContext(ctx context.Context) (int, bool) {
+	userID, ok := ctx.Value(userIDKey).(int)
+	return userID, ok
+}
+
+func (s *Server) authenticateRequest(r *http.Request) (int, string, grants, error) {
+	header := r.Header.Get("Authorization")
+	scheme, tokenString, found := strings.Cut(header, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") || tokenString == "" {
+		return 0, "", grants{}, errMissingToken
+	}
+
+	return s.parseToken(strings.TrimSpace(tokenString))
+}
+
+// parseToken validates an access token and returns its subject, the refresh
+// token family ("sid") it belongs to, and the roles and permissions it
+// carries. Tokens from before roles existed carry none, which grants nothing.
+func (s *Server) parseToken(tokenString string) (int, string, grants, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return s.jwtKey, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil {
+		return 0, "", grants{}, errInvalidToken
+	}
+
+	sub, err := claims.GetSubject()
+	if err != nil {
+		return 0, "", grants{}, errInvalidToken
+	}
+
+	userID, err := strconv.Atoi(sub)
+	if err != nil {
+		return 0, "", grants{}, errInvalidToken
+	}
+
+	sessionID, ok := claims["sid"].(string)
+	if !ok || sessionID == "" {
+		return 0, "", grants{}, errInvalidToken
+	}
+
+	roles, ok := stringsClaim(claims, "roles")
+	if !ok {
+		return 0, "", grants{}, errInvalidToken
+	}
+	permissions, ok := stringsClaim(claims, "perms")
+	if !ok {
+		return 0, "", grants{}, errInvalidToken
+	}
+
+	return userID, sessionID, grants{roles: roles, permissions: permissions}, nil
+}
+
+// stringsClaim returns a claim holding a list of strings. A missing claim is
+// an empty list; anything else that isn't a list of strings is invalid.
+func stringsClaim(claims jwt.MapClaims, name string) ([]string, bool) {
+	raw, ok := claims[name]
+	if !ok {
+		return nil, true
+	}
+	list, ok := raw.([]interface{})
+	if !ok {
+		return nil, false
+	}
+
+	values := make([]string, 0, len(list))
+	for _, v := range list {
+		s, ok := v.(string)
+		if !ok {
+			return nil, false
+		}
+		values = append(values, s)
+	}
+	return values, true
+}
+
+// checkSession rejects access tokens whose session was logged out or revoked
+// before the token itself expired.
+func (s *Server) checkSession(ctx context.Context, sessionID string) error {
+	active, err := s.db.SessionActive(ctx, sessionID)
+	if errors.Is(err, database.ErrTimeout) || errors.Is(err, database.ErrUnavailable) {
+		return err
+	}
+	if err != nil {
+		log.Printf("Failed to check session. Err: %v", err)
+		return errInvalidToken
+	}
+	if !active {
+		return errRevokedToken
+	}
+
+	return nil
+}
+
+// currentUser returns the authenticated user's ID. Clients used to send the
+// acting username in the request body; if one is still supplied it must
+// belong to the token's subject, otherwise the request is forbidden.
+func (s *Server) currentUser(w http.ResponseWriter, r *http.Request, claimedUsername string) (int, bool) {
+	userID, ok := UserIDFromContext(r.Context())
+	if !ok {
+		writeError(w, r, errMissingToken)
+		return 0, false
+	}
+
+	if claimedUsername != "" {
+		claimedID, err := s.db.GetUserID(r.Context(), claimedUsername)
+		if err != nil || claimedID != userID {
+			writeError(w, r, fmt.Errorf("token does not belong to %q: %w", claimedUsername, database.ErrForbidden))
+			return 0, false
+		}
+	}
+
+	return userID, true
+}
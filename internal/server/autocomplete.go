@@ -0,0 +1,216 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"lab2324omada7/internal/database"
+	"lab2324omada7/internal/search"
+)
+
+// Autocomplete answers from a search.Completer of every movie, actor and
+// director, loaded when the server starts and kept up to date by the catalog
+// handlers. Changes made around this server, by another instance or the seed
+// command, show up when it's reloaded in the background, which happens once
+// it's older than completionsMaxAge.
+
+const (
+	completionsMaxAge = 10 * time.Minute
+	loadTimeout       = 30 * time.Second
+
+	defaultCompletions = 10
+	maxCompletions     = 50
+)
+
+type completions struct {
+	mu       sync.Mutex
+	c        *search.Completer // nil until loaded
+	loadedAt time.Time
+	// loading is closed when the load under way, if any, ends, with loadErr
+	// set if it failed. Catalog changes made meanwhile wait in pending, to
+	// be made again to what it loaded, which may have been read before them.
+	loading chan struct{}
+	loadErr error
+	pending []func(*search.Completer)
+}
+
+// completer returns the completer, waiting for it to be loaded if it hasn't
+// been yet, and starting a reload if it's old. Loads run in the background,
+// so a request that gives up waiting doesn't fail the load for the others.
+func (s *Server) completer(ctx context.Context) (*search.Completer, error) {
+	s.completions.mu.Lock()
+	if c := s.completions.c; c != nil {
+		if time.Since(s.completions.loadedAt) > completionsMaxAge {
+			s.startLoad()
+		}
+		s.completions.mu.Unlock()
+		return c, nil
+	}
+	loading := s.startLoad()
+	s.completions.mu.Unlock()
+
+	select {
+	case <-loading:
+	case <-ctx.Done():
+		return nil, fmt.Errorf("%w: waiting for autocomplete: %v", database.ErrUnavailable, ctx.Err())
+	}
+
+	s.completions.mu.Lock()
+	defer s.completions.mu.Unlock()
+	if s.completions.c == nil {
+		return nil, s.completions.loadErr
+	}
+	return s.completions.c, nil
+}
+
+// startLoad starts loading the completer, unless a load is under way, and
+// returns the channel closed when it ends. s.completions.mu must be held.
+func (s *Server) startLoad() chan struct{} {
+	if s.completions.loading == nil {
+		s.completions.loading = make(chan struct{})
+		go s.loadCompletions(s.completions.loading)
+	}
+	return s.completions.loading
+}
+
+func (s *Server) loadCompletions(done chan struct{}) {
+	ctx, cancel := context.WithTimeout(context.Background(), loadTimeout)
+	defer cancel()
+
+	c, err := s.loadCompleter(ctx)
+
+	s.completions.mu.Lock()
+	defer s.completions.mu.Unlock()
+	if err != nil {
+		log.Printf("loading autocomplete: %v", err)
+	} else {
+		for _, change := range s.completions.pending {
+			change(c)
+		}
+		s.completions.c, s.completions.loadedAt = c, time.Now()
+	}
+	s.completions.loadErr, s.completions.pending, s.completions.loading = err, nil, nil
+	close(done)
+}
+
+// loadCompleter reads the whole catalog into a new Completer.
+func (s *Server) loadCompleter(ctx context.Context) (*search.Completer, error) {
+	c := search.NewCompleter()
+
+	movies, err := allPages(func(req database.PageRequest) (database.Page[database.Movie], error) {
+		return s.db.GetMovies(ctx, database.MovieFilter{}, req)
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range movies {
+		c.Put(movieEntry(m))
+	}
+
+	actors, err := allPages(func(req database.PageRequest) (database.Page[database.Actor], error) {
+		return s.db.GetActors(ctx, database.PersonFilter{}, req)
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range actors {
+		c.Put(actorEntry(a))
+	}
+
+	directors, err := allPages(func(req database.PageRequest) (database.Page[database.Director], error) {
+		return s.db.GetDirectors(ctx, database.PersonFilter{}, req)
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range directors {
+		c.Put(directorEntry(d))
+	}
+
+	return c, nil
+}
+
+func allPages[T any](fetch func(database.PageRequest) (database.Page[T], error)) ([]T, error) {
+	var items []T
+	req := database.PageRequest{Limit: database.MaxPageSize}
+	for {
+		page, err := fetch(req)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, page.Items...)
+		if page.Next == "" {
+			return items, nil
+		}
+		req.Cursor = page.Next
+	}
+}
+
+func movieEntry(m database.Movie) search.Entry {
+	return search.Entry{Type: database.SearchMovie, ID: m.Id, Slug: m.Slug, Name: m.Title}
+}
+
+func actorEntry(a database.Actor) search.Entry {
+	return search.Entry{Type: database.SearchActor, ID: a.ID, Slug: a.Slug, Name: a.Name}
+}
+
+func directorEntry(d database.Director) search.Entry {
+	return search.Entry{Type: database.SearchDirector, ID: d.ID, Slug: d.Slug, Name: d.Name}
+}
+
+// putCompletion and removeCompletion keep the completer in step with the
+// catalog.
+func (s *Server) putCompletion(e search.Entry) {
+	s.changeCompletions(func(c *search.Completer) { c.Put(e) })
+}
+
+func (s *Server) removeCompletion(typ string, id int) {
+	s.changeCompletions(func(c *search.Completer) { c.Remove(typ, id) })
+}
+
+// changeCompletions makes a change to the loaded completer, if there is one,
+// and to the one being loaded, if any, once it is. Both Put and Remove may
+// be made twice.
+func (s *Server) changeCompletions(change func(*search.Completer)) {
+	s.completions.mu.Lock()
+	defer s.completions.mu.Unlock()
+	if s.completions.c != nil {
+		change(s.completions.c)
+	}
+	if s.completions.loading != nil {
+		s.completions.pending = append(s.completions.pending, change)
+	}
+}
+
+// AutocompleteHandler serves /api/autocomplete?q=, suggesting up to ?limit=
+// movies and people whose names start like the query, allowing for typos
+// and for Greek names typed in Latin letters and the other way round.
+func (s *Server) AutocompleteHandler(w http.ResponseWriter, r *http.Request) {
+	limit, err := queryInt(r, "limit")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if limit == 0 {
+		limit = defaultCompletions
+	}
+	if limit < 0 || limit > maxCompletions {
+		writeError(w, r, fmt.Errorf("limit must be between 1 and %d, got %d: %w", maxCompletions, limit, database.ErrValidation))
+		return
+	}
+
+	c, err := s.completer(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	suggestions := c.Complete(r.URL.Query().Get("q"), limit)
+	if suggestions == nil {
+		suggestions = []search.Entry{}
+	}
+	writeJSON(w, http.StatusOK, suggestions)
+}
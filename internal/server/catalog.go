@@ -0,0 +1,293 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"lab2324omada7/internal/database"
+)
+
+// The payloads of the admin catalog endpoints use the field names of the
+// objects the API returns. POST and PUT need every field except slug; PATCH
+// only the ones to change.
+
+type MoviePayload struct {
+	Title       *string `json:"Title"`
+	ReleaseDate *string `json:"ReleaseDate"`
+	Genre       *string `json:"Genre"`
+	Slug        *string `json:"slug"`
+}
+
+type ActorPayload struct {
+	Name        *string `json:"ActorName"`
+	DateOfBirth *string `json:"DateOfBirth"`
+	Nationality *string `json:"Nationality"`
+	Slug        *string `json:"slug"`
+}
+
+type DirectorPayload struct {
+	Name        *string `json:"DirectorName"`
+	DateOfBirth *string `json:"DateOfBirth"`
+	Nationality *string `json:"Nationality"`
+	Slug        *string `json:"slug"`
+}
+
+// requireAll rejects PUT requests that leave out any of the named fields,
+// since PUT replaces the whole row.
+func requireAll(r *http.Request, fields map[string]*string) error {
+	if r.Method != http.MethodPut {
+		return nil
+	}
+	for name, v := range fields {
+		if v == nil {
+			return fmt.Errorf("PUT replaces every field, so %s is required; use PATCH to change only some: %w", name, database.ErrValidation)
+		}
+	}
+	return nil
+}
+
+func writeCreated(w http.ResponseWriter, location string, v interface{}) {
+	w.Header().Set("Location", location)
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"status": "ok",
+		"data":   v,
+	})
+}
+
+func writeUpdated(w http.ResponseWriter, v interface{}) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status": "ok",
+		"data":   v,
+	})
+}
+
+func writeOK(w http.ResponseWriter) {
+	writeJSON(w, http.StatusOK, map[string]string{
+		"status": "ok",
+	})
+}
+
+func (s *Server) CreateMovieHandler(w http.ResponseWriter, r *http.Request) {
+	var payload MoviePayload
+	if err := decodeStrictJSON(r, &payload); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	movie, err := s.db.CreateMovie(r.Context(), database.MovieFields(payload))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	s.putCompletion(movieEntry(movie))
+	writeCreated(w, "/api/movies/"+movie.Slug, movie)
+}
+
+// UpdateMovieHandler serves both PUT and PATCH.
+func (s *Server) UpdateMovieHandler(w http.ResponseWriter, r *http.Request) {
+	var payload MoviePayload
+	if err := decodeStrictJSON(r, &payload); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := requireAll(r, map[string]*string{"Title": payload.Title, "ReleaseDate": payload.ReleaseDate, "Genre": payload.Genre}); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	movie, err := s.db.GetMovie(r.Context(), chi.URLParam(r, "movie"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	movie, err = s.db.UpdateMovie(r.Context(), movie.Id, database.MovieFields(payload))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	s.putCompletion(movieEntry(movie))
+	writeUpdated(w, movie)
+}
+
+// DeleteMovieHandler refuses to delete movies that users have reviewed,
+// liked, listed or logged in their diaries unless the request says
+// ?cascade=true.
+func (s *Server) DeleteMovieHandler(w http.ResponseWriter, r *http.Request) {
+	cascade := false
+	if v := r.URL.Query().Get("cascade"); v != "" {
+		var err error
+		if cascade, err = strconv.ParseBool(v); err != nil {
+			writeError(w, r, fmt.Errorf("%w: cascade %q is not a boolean", errBadRequest, v))
+			return
+		}
+	}
+
+	movie, err := s.db.GetMovie(r.Context(), chi.URLParam(r, "movie"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := s.db.DeleteMovie(r.Context(), movie.Id, cascade); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	s.removeCompletion(database.SearchMovie, movie.Id)
+	writeOK(w)
+}
+
+func (s *Server) CreateActorHandler(w http.ResponseWriter, r *http.Request) {
+	var payload ActorPayload
+	if err := decodeStrictJSON(r, &payload); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	actor, err := s.db.CreateActor(r.Context(), database.PersonFields(payload))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	s.putCompletion(actorEntry(actor))
+	writeCreated(w, "/api/actors/"+actor.Slug, actor)
+}
+
+// UpdateActorHandler serves both PUT and PATCH.
+func (s *Server) UpdateActorHandler(w http.ResponseWriter, r *http.Request) {
+	var payload ActorPayload
+	if err := decodeStrictJSON(r, &payload); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := requireAll(r, map[string]*string{"ActorName": payload.Name, "DateOfBirth": payload.DateOfBirth, "Nationality": payload.Nationality}); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	actor, err := s.db.GetActor(r.Context(), chi.URLParam(r, "actor"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	actor, err = s.db.UpdateActor(r.Context(), actor.ID, database.PersonFields(payload))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	s.putCompletion(actorEntry(actor))
+	writeUpdated(w, actor)
+}
+
+func (s *Server) DeleteActorHandler(w http.ResponseWriter, r *http.Request) {
+	actor, err := s.db.GetActor(r.Context(), chi.URLParam(r, "actor"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := s.db.DeleteActor(r.Context(), actor.ID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	s.removeCompletion(database.SearchActor, actor.ID)
+	writeOK(w)
+}
+
+func (s *Server) CreateDirectorHandler(w http.ResponseWriter, r *http.Request) {
+	var payload DirectorPayload
+	if err := decodeStrictJSON(r, &payload); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	director, err := s.db.CreateDirector(r.Context(), database.PersonFields(payload))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	s.putCompletion(directorEntry(director))
+	writeCreated(w, "/api/directors/"+director.Slug, director)
+}
+
+// UpdateDirectorHandler serves both PUT and PATCH.
+func (s *Server) UpdateDirectorHandler(w http.ResponseWriter, r *http.Request) {
+	var payload DirectorPayload
+	if err := decodeStrictJSON(r, &payload); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := requireAll(r, map[string]*string{"DirectorName": payload.Name, "DateOfBirth": payload.DateOfBirth, "Nationality": payload.Nationality}); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	director, err := s.db.GetDirector(r.Context(), chi.URLParam(r, "director"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	director, err = s.db.UpdateDirector(r.Context(), director.ID, database.PersonFields(payload))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	s.putCompletion(directorEntry(director))
+	writeUpdated(w, director)
+}
+
+func (s *Server) DeleteDirectorHandler(w http.ResponseWriter, r *http.Request) {
+	director, err := s.db.GetDirector(r.Context(), chi.URLParam(r, "director"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := s.db.DeleteDirector(r.Context(), director.ID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	s.removeCompletion(database.SearchDirector, director.ID)
+	writeOK(w)
+}
+
+// creditTarget resolves the movie and the actor or director named by a
+// /api/movies/{movie}/actors/{actor} or .../directors/{director} URL.
+func (s *Server) creditTarget(r *http.Request) (movieID int, role string, personID int, err error) {
+	movie, err := s.db.GetMovie(r.Context(), chi.URLParam(r, "movie"))
+	if err != nil {
+		return 0, "", 0, err
+	}
+
+	if ref := chi.URLParam(r, "actor"); ref != "" {
+		actor, err := s.db.GetActor(r.Context(), ref)
+		return movie.Id, database.CreditActor, actor.ID, err
+	}
+	director, err := s.db.GetDirector(r.Context(), chi.URLParam(r, "director"))
+	return movie.Id, database.CreditDirector, director.ID, err
+}
+
+// AddCreditHandler is idempotent: crediting someone twice changes nothing.
+func (s *Server) AddCreditHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, role, personID, err := s.creditTarget(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := s.db.AddCredit(r.Context(), movieID, role, personID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeOK(w)
+}
+
+func (s *Server) RemoveCreditHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, role, personID, err := s.creditTarget(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := s.db.RemoveCredit(r.Context(), movieID, role, personID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeOK(w)
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"lab2324omada7/internal/database"
+)
+
+// MyLikesHandler and MyWatchlistHandler page through the signed-in user's
+// collections like the other list endpoints, sorted by ?sort=date_added,
+// title, release_date or avg_rating.
+func (s *Server) MyLikesHandler(w http.ResponseWriter, r *http.Request) {
+	s.listCollection(w, r, database.CollectionLikes)
+}
+
+func (s *Server) MyWatchlistHandler(w http.ResponseWriter, r *http.Request) {
+	s.listCollection(w, r, database.CollectionWatchlist)
+}
+
+func (s *Server) listCollection(w http.ResponseWriter, r *http.Request, collection string) {
+	userID, ok := s.currentUser(w, r, "")
+	if !ok {
+		return
+	}
+	req, err := pageRequest(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	page, err := s.db.GetSavedMovies(r.Context(), userID, collection, req)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writePage(w, r, page)
+}
+
+// PUT and DELETE /api/me/likes/{movie} and /api/me/watchlist/{movie} add a
+// movie to a collection and remove it. Both are idempotent, so a client can
+// retry them safely, and answer with the movie's resulting status.
+
+func (s *Server) LikeHandler(w http.ResponseWriter, r *http.Request) {
+	s.changeCollection(w, r, database.CollectionLikes, s.db.AddToCollection)
+}
+
+func (s *Server) UnlikeHandler(w http.ResponseWriter, r *http.Request) {
+	s.changeCollection(w, r, database.CollectionLikes, s.db.RemoveFromCollection)
+}
+
+func (s *Server) AddToWatchlistHandler(w http.ResponseWriter, r *http.Request) {
+	s.changeCollection(w, r, database.CollectionWatchlist, s.db.AddToCollection)
+}
+
+func (s *Server) RemoveFromWatchlistHandler(w http.ResponseWriter, r *http.Request) {
+	s.changeCollection(w, r, database.CollectionWatchlist, s.db.RemoveFromCollection)
+}
+
+func (s *Server) changeCollection(w http.ResponseWriter, r *http.Request, collection string,
+	change func(ctx context.Context, userID int, collection string, movieID int) (database.MovieStatus, error)) {
+	userID, ok := s.currentUser(w, r, "")
+	if !ok {
+		return
+	}
+	movie, err := s.db.GetMovie(r.Context(), chi.URLParam(r, "movie"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	status, err := change(r.Context(), userID, collection, movie.Id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeUpdated(w, status)
+}
+
+// deprecated marks the response of a deprecated endpoint, pointing to the
+// one replacing it.
+func deprecated(w http.ResponseWriter, successor string) {
+	w.Header().Set("Deprecation", "true")
+	w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", successor))
+}
+
+// MovieStatusesHandler serves /api/me/status?movies=1,2,3, saying for each
+// movie ID whether the user liked it and whether it's on their watchlist.
+func (s *Server) MovieStatusesHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.currentUser(w, r, "")
+	if !ok {
+		return
+	}
+
+	var movieIDs []int
+	if v := r.URL.Query().Get("movies"); v != "" {
+		for _, field := range strings.Split(v, ",") {
+			id, err := strconv.Atoi(strings.TrimSpace(field))
+			if err != nil {
+				writeError(w, r, fmt.Errorf("%w: movie ID %q is not a number", errBadRequest, field))
+				return
+			}
+			movieIDs = append(movieIDs, id)
+		}
+	}
+
+	statuses, err := s.db.GetMovieStatuses(r.Context(), userID, movieIDs)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, statuses)
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"lab2324omada7/internal/database"
+)
+
+// DiaryPayload logs a watch of Movie, an ID or slug, or changes an entry,
+// where Movie can't be given. A rating or review_id of 0 removes it.
+type DiaryPayload struct {
+	Movie     string  `json:"movie"`
+	WatchedOn *string `json:"watched_on"`
+	Rewatch   *bool   `json:"rewatch"`
+	Rating    *int    `json:"rating"`
+	ReviewID  *int    `json:"review_id"`
+}
+
+func (p DiaryPayload) fields() database.DiaryFields {
+	return database.DiaryFields{WatchedOn: p.WatchedOn, Rewatch: p.Rewatch, Rating: p.Rating, ReviewID: p.ReviewID}
+}
+
+// DiaryHandler pages through the signed-in user's diary, newest first unless
+// ?sort= says otherwise, narrowed down with ?year= and ?month=.
+func (s *Server) DiaryHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.currentUser(w, r, "")
+	if !ok {
+		return
+	}
+	req, err := pageRequest(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if req.Sort == "" {
+		req.Sort = "-watched_on"
+	}
+	var f database.DiaryFilter
+	if f.Year, err = queryInt(r, "year"); err == nil {
+		f.Month, err = queryInt(r, "month")
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	page, err := s.db.GetDiary(r.Context(), userID, f, req)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writePage(w, r, page)
+}
+
+// DiaryMonthsHandler says how many movies the user watched in each month
+// they logged any, for browsing the diary by month.
+func (s *Server) DiaryMonthsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.currentUser(w, r, "")
+	if !ok {
+		return
+	}
+
+	months, err := s.db.GetDiaryMonths(r.Context(), userID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, months)
+}
+
+func (s *Server) DiaryEntryHandler(w http.ResponseWriter, r *http.Request) {
+	userID, entryID, ok := s.diaryEntryParams(w, r)
+	if !ok {
+		return
+	}
+
+	entry, err := s.db.GetDiaryEntry(r.Context(), userID, entryID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, entry)
+}
+
+// LogWatchHandler adds an entry to the diary, taking the movie off the
+// user's watchlist.
+func (s *Server) LogWatchHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.currentUser(w, r, "")
+	if !ok {
+		return
+	}
+	var payload DiaryPayload
+	if err := decodeStrictJSON(r, &payload); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if payload.Movie == "" {
+		writeError(w, r, fmt.Errorf("movie is required: %w", database.ErrValidation))
+		return
+	}
+	movie, err := s.db.GetMovie(r.Context(), payload.Movie)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	entry, err := s.db.LogWatch(r.Context(), userID, movie.Id, payload.fields())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeCreated(w, fmt.Sprintf("/api/me/diary/%d", entry.ID), entry)
+}
+
+func (s *Server) UpdateDiaryEntryHandler(w http.ResponseWriter, r *http.Request) {
+	userID, entryID, ok := s.diaryEntryParams(w, r)
+	if !ok {
+		return
+	}
+	var payload DiaryPayload
+	if err := decodeStrictJSON(r, &payload); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if payload.Movie != "" {
+		writeError(w, r, fmt.Errorf("the movie of a diary entry can't change; log a new one: %w", database.ErrValidation))
+		return
+	}
+
+	entry, err := s.db.UpdateDiaryEntry(r.Context(), userID, entryID, payload.fields())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeUpdated(w, entry)
+}
+
+func (s *Server) DeleteDiaryEntryHandler(w http.ResponseWriter, r *http.Request) {
+	userID, entryID, ok := s.diaryEntryParams(w, r)
+	if !ok {
+		return
+	}
+	if err := s.db.DeleteDiaryEntry(r.Context(), userID, entryID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeOK(w)
+}
+
+// diaryEntryParams returns the signed-in user and the diary entry ID in the
+// URL, or writes the error and returns false.
+func (s *Server) diaryEntryParams(w http.ResponseWriter, r *http.Request) (int, int, bool) {
+	userID, ok := s.currentUser(w, r, "")
+	if !ok {
+		return 0, 0, false
+	}
+	entryID, ok := urlID(w, r, "entry", "diary entry")
+	if !ok {
+		return 0, 0, false
+	}
+	return userID, entryID, true
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"lab2324omada7/internal/database"
+)
+
+// errBadRequest marks request bodies or parameters that couldn't be parsed.
+var errBadRequest = errors.New("bad request")
+
+type errorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// errorResponse is the envelope every failed request is answered with. It
+// mirrors the {"status": "ok", "data": ...} shape of successful responses.
+type errorResponse struct {
+	Status string    `json:"status"`
+	Error  errorBody `json:"error"`
+}
+
+// errorStatus maps an error returned by database.Service (or the handlers
+// themselves) onto an HTTP status and a stable, machine readable code.
+func errorStatus(err error) (int, string) {
+	switch {
+	case errors.Is(err, errBadRequest):
+		return http.StatusBadRequest, "bad_request"
+	case errors.Is(err, database.ErrUnauthorized):
+		return http.StatusUnauthorized, "unauthorized"
+	case errors.Is(err, database.ErrForbidden):
+		return http.StatusForbidden, "forbidden"
+	case errors.Is(err, database.ErrNotFound):
+		return http.StatusNotFound, "not_found"
+	case errors.Is(err, database.ErrConflict):
+		return http.StatusConflict, "conflict"
+	case errors.Is(err, database.ErrValidation):
+		return http.StatusUnprocessableEntity, "validation_failed"
+	case errors.Is(err, database.ErrTimeout):
+		return http.StatusGatewayTimeout, "timeout"
+	case errors.Is(err, database.ErrUnavailable):
+		return http.StatusServiceUnavailable, "unavailable"
+	default:
+		return http.StatusInternalServerError, "internal"
+	}
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	status, code := errorStatus(err)
+
+	message := err.Error()
+	if status == http.StatusInternalServerError {
+		log.Printf("%s %s failed. Err: %v", r.Method, r.URL.Path, err)
+		message = http.StatusText(status)
+	}
+	// The message of a DriverError leaves the driver's own out, so that
+	// only the logs see it.
+	var driverErr *database.DriverError
+	if errors.As(err, &driverErr) {
+		log.Printf("%s %s: %v", r.Method, r.URL.Path, driverErr.Err)
+	}
+
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+	}
+
+	writeJSON(w, status, errorResponse{
+		Status: "error",
+		Error:  errorBody{Code: code, Message: message},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// decodeJSON reads the request body into v, reporting malformed input as a
+// bad request.
+func decodeJSON(r *http.Request, v interface{}) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return fmt.Errorf("%w: %v", errBadRequest, err)
+	}
+	return nil
+}
+
+// decodeStrictJSON is decodeJSON for bodies where a misspelt field would
+// otherwise be silently ignored: unknown fields are a bad request too.
+func decodeStrictJSON(r *http.Request, v interface{}) error {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("%w: %v", errBadRequest, err)
+	}
+	return nil
+}
+
+// urlID returns the numeric URL parameter, the ID of a what, or writes the
+// error and returns false.
+func urlID(w http.ResponseWriter, r *http.Request, param, what string) (int, bool) {
+	ref := chi.URLParam(r, param)
+	id, err := strconv.Atoi(ref)
+	if err != nil {
+		writeError(w, r, fmt.Errorf("%w: %s ID %q is not a number", errBadRequest, what, ref))
+		return 0, false
+	}
+	return id, true
+}
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"lab2324omada7/internal/database"
+	"lab2324omada7/internal/imports"
+)
+
+// Imports run in the background: ImportHandler reads the upload, answers
+// 202 Accepted with the running job, and leaves the rest to a goroutine.
+// Clients poll the job until its status is no longer "running".
+
+// maxImportUpload bounds the request body of an import, all files included.
+const maxImportUpload = imports.MaxFileSize
+
+// ImportRowPayload resolves a row of an import as Movie, an ID or slug.
+type ImportRowPayload struct {
+	Movie string `json:"movie"`
+}
+
+// ImportHandler starts importing the files uploaded as the "file" fields of
+// a multipart form: a Letterboxd export, as a ZIP archive or CSV files out
+// of it, or IMDb's ratings.csv.
+func (s *Server) ImportHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.currentUser(w, r, "")
+	if !ok {
+		return
+	}
+	files, err := uploadedFiles(w, r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	batch, err := imports.Parse(files)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	job, err := s.db.CreateImport(r.Context(), userID, batch.Source)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), database.ImportTimeout)
+		defer cancel()
+		if err := imports.Run(ctx, s.db, userID, job.ID, batch); err != nil {
+			log.Printf("import %d: %v", job.ID, err)
+		}
+	}()
+
+	w.Header().Set("Location", fmt.Sprintf("/api/me/imports/%d", job.ID))
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{
+		"status": "ok",
+		"data":   job,
+	})
+}
+
+// uploadedFiles reads the files of an import request.
+func uploadedFiles(w http.ResponseWriter, r *http.Request) ([]imports.File, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportUpload)
+	if err := r.ParseMultipartForm(maxImportUpload); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, fmt.Errorf("an import takes at most %d MB: %w", maxImportUpload>>20, database.ErrValidation)
+		}
+		return nil, fmt.Errorf("%w: %v", errBadRequest, err)
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	headers := r.MultipartForm.File["file"]
+	if len(headers) == 0 {
+		return nil, fmt.Errorf("file is required: %w", database.ErrValidation)
+	}
+	files := make([]imports.File, 0, len(headers))
+	for _, h := range headers {
+		f, err := h.Open()
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, imports.File{Name: h.Filename, Data: data})
+	}
+	return files, nil
+}
+
+// ImportStatusHandler shows how an import is going, and, once it's done,
+// the rows left to resolve.
+func (s *Server) ImportStatusHandler(w http.ResponseWriter, r *http.Request) {
+	userID, jobID, ok := s.importParams(w, r)
+	if !ok {
+		return
+	}
+	job, err := s.db.GetImport(r.Context(), userID, jobID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+// ResolveImportRowHandler imports a row that matched no movie, or several,
+// as the movie the user picked.
+func (s *Server) ResolveImportRowHandler(w http.ResponseWriter, r *http.Request) {
+	userID, jobID, ok := s.importParams(w, r)
+	if !ok {
+		return
+	}
+	rowID, ok := urlID(w, r, "row", "import row")
+	if !ok {
+		return
+	}
+	var payload ImportRowPayload
+	if err := decodeStrictJSON(r, &payload); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if payload.Movie == "" {
+		writeError(w, r, fmt.Errorf("movie is required: %w", database.ErrValidation))
+		return
+	}
+	movie, err := s.db.GetMovie(r.Context(), payload.Movie)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// Resolving the row first claims it, so that a second request, such as
+	// a double click, can't import it again.
+	resolved, err := s.db.ResolveImportRow(r.Context(), userID, jobID, rowID, movie.Id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := imports.Apply(r.Context(), s.db, userID, movie.Id, resolved.Item); err != nil {
+		if rerr := s.db.ReopenImportRow(context.WithoutCancel(r.Context()), userID, jobID, rowID); rerr != nil {
+			log.Printf("reopening import row %d: %v", rowID, rerr)
+		}
+		writeError(w, r, err)
+		return
+	}
+	writeUpdated(w, resolved)
+}
+
+// DismissImportRowHandler resolves a row without importing it.
+func (s *Server) DismissImportRowHandler(w http.ResponseWriter, r *http.Request) {
+	userID, jobID, ok := s.importParams(w, r)
+	if !ok {
+		return
+	}
+	rowID, ok := urlID(w, r, "row", "import row")
+	if !ok {
+		return
+	}
+
+	row, err := s.db.ResolveImportRow(r.Context(), userID, jobID, rowID, 0)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeUpdated(w, row)
+}
+
+// importParams returns the signed-in user and the import ID in the URL, or
+// writes the error and returns false.
+func (s *Server) importParams(w http.ResponseWriter, r *http.Request) (int, int, bool) {
+	userID, ok := s.currentUser(w, r, "")
+	if !ok {
+		return 0, 0, false
+	}
+	jobID, ok := urlID(w, r, "import", "import")
+	if !ok {
+		return 0, 0, false
+	}
+	return userID, jobID, true
+}
@@ -0,0 +1,204 @@
+package server
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"lab2324omada7/internal/database"
+)
+
+// Lists are addressed by the random key in their list_id. Who may see and
+// change one is decided by database.Service, which answers 404 for private
+// lists to everyone but their owner and collaborators.
+
+type ListPayload struct {
+	Title       *string `json:"title"`
+	Description *string `json:"description"`
+	Visibility  *string `json:"visibility"`
+}
+
+type ListEntryPayload struct {
+	Note     *string `json:"note"`
+	Position *int    `json:"position"`
+}
+
+// ListsHandler pages through public lists, those of one user with
+// ?user=username, sorted by ?sort=title, created or updated.
+func (s *Server) ListsHandler(w http.ResponseWriter, r *http.Request) {
+	var f database.ListFilter
+	if username := r.URL.Query().Get("user"); username != "" {
+		userID, err := s.db.GetUserID(r.Context(), username)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		f.OwnerID = userID
+	}
+	s.listLists(w, r, f)
+}
+
+// MyListsHandler pages through the lists the signed-in user owns or
+// collaborates on, private and unlisted ones included.
+func (s *Server) MyListsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.currentUser(w, r, "")
+	if !ok {
+		return
+	}
+	s.listLists(w, r, database.ListFilter{MemberID: userID})
+}
+
+func (s *Server) listLists(w http.ResponseWriter, r *http.Request, f database.ListFilter) {
+	req, err := pageRequest(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	page, err := s.db.GetLists(r.Context(), f, req)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writePage(w, r, page)
+}
+
+// ListHandler shows a list with its entries. It runs behind IdentifyUser, so
+// that owners and collaborators see their private lists.
+func (s *Server) ListHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := UserIDFromContext(r.Context())
+
+	list, err := s.db.GetList(r.Context(), userID, chi.URLParam(r, "list"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) CreateListHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.currentUser(w, r, "")
+	if !ok {
+		return
+	}
+	var payload ListPayload
+	if err := decodeStrictJSON(r, &payload); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	list, err := s.db.CreateList(r.Context(), userID, database.ListFields(payload))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeCreated(w, "/api/lists/"+list.ID, list)
+}
+
+func (s *Server) UpdateListHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.currentUser(w, r, "")
+	if !ok {
+		return
+	}
+	var payload ListPayload
+	if err := decodeStrictJSON(r, &payload); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	list, err := s.db.UpdateList(r.Context(), userID, chi.URLParam(r, "list"), database.ListFields(payload))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeUpdated(w, list)
+}
+
+func (s *Server) DeleteListHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.currentUser(w, r, "")
+	if !ok {
+		return
+	}
+	if err := s.db.DeleteList(r.Context(), userID, chi.URLParam(r, "list")); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeOK(w)
+}
+
+// PutListEntryHandler adds a movie to a list or changes its note or
+// position. The body is optional: without one, the movie goes last, or
+// stays where it is.
+func (s *Server) PutListEntryHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.currentUser(w, r, "")
+	if !ok {
+		return
+	}
+	var payload ListEntryPayload
+	if r.ContentLength != 0 {
+		if err := decodeStrictJSON(r, &payload); err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+	movie, err := s.db.GetMovie(r.Context(), chi.URLParam(r, "movie"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	list, err := s.db.PutListEntry(r.Context(), userID, chi.URLParam(r, "list"), movie.Id, database.EntryFields(payload))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeUpdated(w, list)
+}
+
+func (s *Server) RemoveListEntryHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.currentUser(w, r, "")
+	if !ok {
+		return
+	}
+	movie, err := s.db.GetMovie(r.Context(), chi.URLParam(r, "movie"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	list, err := s.db.RemoveListEntry(r.Context(), userID, chi.URLParam(r, "list"), movie.Id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeUpdated(w, list)
+}
+
+// AddCollaboratorHandler invites a user to edit a list's entries, and
+// RemoveCollaboratorHandler stops them, or lets them leave.
+func (s *Server) AddCollaboratorHandler(w http.ResponseWriter, r *http.Request) {
+	s.changeCollaborator(w, r, s.db.AddCollaborator)
+}
+
+func (s *Server) RemoveCollaboratorHandler(w http.ResponseWriter, r *http.Request) {
+	s.changeCollaborator(w, r, s.db.RemoveCollaborator)
+}
+
+func (s *Server) changeCollaborator(w http.ResponseWriter, r *http.Request,
+	change func(ctx context.Context, userID int, key string, collaboratorID int) error) {
+	userID, ok := s.currentUser(w, r, "")
+	if !ok {
+		return
+	}
+	collaboratorID, err := s.db.GetUserID(r.Context(), chi.URLParam(r, "username"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if err := change(r.Context(), userID, chi.URLParam(r, "list"), collaboratorID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeOK(w)
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"lab2324omada7/internal/database"
+)
+
+// List endpoints take ?sort=, ?cursor= and ?limit=, and answer with the
+// page's items as a plain JSON array, as they did before paging. The total
+// goes in X-Total-Count, and the links to the first and next pages in a Link
+// header (RFC 8288).
+
+// pageRequest reads the paging parameters of r.
+func pageRequest(r *http.Request) (database.PageRequest, error) {
+	q := r.URL.Query()
+	limit, err := queryInt(r, "limit")
+	if err != nil {
+		return database.PageRequest{}, err
+	}
+	return database.PageRequest{
+		Sort:   q.Get("sort"),
+		Cursor: q.Get("cursor"),
+		Limit:  limit,
+	}, nil
+}
+
+func movieFilter(r *http.Request) (database.MovieFilter, error) {
+	f := database.MovieFilter{Genre: r.URL.Query().Get("genre")}
+
+	var err error
+	if f.YearFrom, err = queryInt(r, "year_from"); err != nil {
+		return f, err
+	}
+	if f.YearTo, err = queryInt(r, "year_to"); err != nil {
+		return f, err
+	}
+	if v := r.URL.Query().Get("min_rating"); v != "" {
+		if f.MinRating, err = strconv.ParseFloat(v, 64); err != nil {
+			return f, fmt.Errorf("%w: min_rating %q is not a number", errBadRequest, v)
+		}
+	}
+	return f, nil
+}
+
+func personFilter(r *http.Request) database.PersonFilter {
+	return database.PersonFilter{Nationality: r.URL.Query().Get("nationality")}
+}
+
+// reviewFilter reads the filters of a movie's reviews. The signed-in user's
+// own review is left out, for GET /api/me/reviews/{movie} shows it apart.
+func reviewFilter(r *http.Request) (database.ReviewFilter, error) {
+	userID, _ := UserIDFromContext(r.Context())
+	stars, err := queryInt(r, "stars")
+	return database.ReviewFilter{Stars: stars, ExcludeUserID: userID}, err
+}
+
+// queryInt parses the named query parameter, returning 0 when it's absent.
+func queryInt(r *http.Request, name string) (int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s %q is not a whole number", errBadRequest, name, v)
+	}
+	return n, nil
+}
+
+func writePage[T any](w http.ResponseWriter, r *http.Request, page database.Page[T]) {
+	links := []string{pageLink(r, "", "first")}
+	if page.Next != "" {
+		links = append(links, pageLink(r, page.Next, "next"))
+	}
+	w.Header().Set("Link", strings.Join(links, ", "))
+	w.Header().Set("X-Total-Count", strconv.Itoa(page.Total))
+	writeJSON(w, http.StatusOK, page.Items)
+}
+
+// pageLink returns a Link header entry for r's URL with its cursor replaced.
+func pageLink(r *http.Request, cursor, rel string) string {
+	u := *r.URL
+	q := u.Query()
+	if cursor == "" {
+		q.Del("cursor")
+	} else {
+		q.Set("cursor", cursor)
+	}
+	u.RawQuery = q.Encode()
+	return fmt.Sprintf("<%s>; rel=%q", u.RequestURI(), rel)
+}
@@ -0,0 +1,178 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"lab2324omada7/internal/database"
+)
+
+// publicProfileItems is how many of their latest reviews, likes and
+// watchlist entries a public profile shows.
+const publicProfileItems = 10
+
+type ProfilePayload struct {
+	DisplayName *string         `json:"display_name"`
+	Bio         *string         `json:"bio"`
+	AvatarURL   *string         `json:"avatar_url"`
+	Email       *string         `json:"email"`
+	Privacy     *PrivacyPayload `json:"privacy"`
+}
+
+type PrivacyPayload struct {
+	Reviews   *bool `json:"reviews"`
+	Likes     *bool `json:"likes"`
+	Watchlist *bool `json:"watchlist"`
+}
+
+type PasswordPayload struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// Me is what /api/me returns: the user's own profile, and the roles and
+// permissions their access token carries.
+type Me struct {
+	database.Profile
+	Roles       []string `json:"roles"`
+	Permissions []string `json:"permissions"`
+}
+
+// PublicProfile is a profile as anyone may see it. A section the user keeps
+// private is null.
+type PublicProfile struct {
+	Username    string                               `json:"username"`
+	DisplayName string                               `json:"display_name"`
+	Bio         string                               `json:"bio"`
+	AvatarURL   string                               `json:"avatar_url"`
+	Reviews     *ProfileSection[database.UserReview] `json:"reviews"`
+	Likes       *ProfileSection[database.SavedMovie] `json:"likes"`
+	Watchlist   *ProfileSection[database.SavedMovie] `json:"watchlist"`
+}
+
+// ProfileSection holds the latest items of a list and how long it is.
+type ProfileSection[T any] struct {
+	Total int `json:"total"`
+	Items []T `json:"items"`
+}
+
+func (s *Server) MeHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.currentUser(w, r, "")
+	if !ok {
+		return
+	}
+
+	profile, err := s.db.GetProfile(r.Context(), userID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	granted, _ := r.Context().Value(grantsKey).(grants)
+	writeJSON(w, http.StatusOK, Me{Profile: profile, Roles: granted.roles, Permissions: granted.permissions})
+}
+
+func (s *Server) UpdateProfileHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.currentUser(w, r, "")
+	if !ok {
+		return
+	}
+
+	var payload ProfilePayload
+	if err := decodeStrictJSON(r, &payload); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	f := database.ProfileFields{
+		DisplayName: payload.DisplayName,
+		Bio:         payload.Bio,
+		AvatarURL:   payload.AvatarURL,
+		Email:       payload.Email,
+	}
+	if p := payload.Privacy; p != nil {
+		f.ReviewsPublic, f.LikesPublic, f.WatchlistPublic = p.Reviews, p.Likes, p.Watchlist
+	}
+
+	profile, err := s.db.UpdateProfile(r.Context(), userID, f)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeUpdated(w, profile)
+}
+
+// ChangePasswordHandler signs the user out everywhere and answers with the
+// tokens of a new session, which the client should switch to.
+func (s *Server) ChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.currentUser(w, r, "")
+	if !ok {
+		return
+	}
+
+	var payload PasswordPayload
+	if err := decodeStrictJSON(r, &payload); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	tokens, err := s.db.ChangePassword(r.Context(), userID, payload.CurrentPassword, payload.NewPassword)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeUpdated(w, tokens)
+}
+
+// PublicProfileHandler shows a user's profile with their latest reviews,
+// likes and watchlist entries, leaving out the sections they keep private.
+func (s *Server) PublicProfileHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, err := s.db.GetUserID(ctx, chi.URLParam(r, "username"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	profile, err := s.db.GetProfile(ctx, userID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	public := PublicProfile{
+		Username:    profile.Username,
+		DisplayName: profile.DisplayName,
+		Bio:         profile.Bio,
+		AvatarURL:   profile.AvatarURL,
+	}
+	latest := database.PageRequest{Limit: publicProfileItems}
+	if profile.Privacy.Reviews {
+		latest.Sort = "-date"
+		page, err := s.db.GetUserReviews(ctx, userID, latest)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		public.Reviews = &ProfileSection[database.UserReview]{Total: page.Total, Items: page.Items}
+	}
+	latest.Sort = "-date_added"
+	for _, c := range []struct {
+		collection string
+		public     bool
+		section    **ProfileSection[database.SavedMovie]
+	}{
+		{database.CollectionLikes, profile.Privacy.Likes, &public.Likes},
+		{database.CollectionWatchlist, profile.Privacy.Watchlist, &public.Watchlist},
+	} {
+		if !c.public {
+			continue
+		}
+		page, err := s.db.GetSavedMovies(ctx, userID, c.collection, latest)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		*c.section = &ProfileSection[database.SavedMovie]{Total: page.Total, Items: page.Items}
+	}
+
+	writeJSON(w, http.StatusOK, public)
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// MyReviewHandler shows the signed-in user's review of a movie, which the
+// movie's list of reviews leaves out for them.
+func (s *Server) MyReviewHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.currentUser(w, r, "")
+	if !ok {
+		return
+	}
+	movie, err := s.db.GetMovie(r.Context(), chi.URLParam(r, "movie"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	review, err := s.db.GetMovieReview(r.Context(), userID, movie.Id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, review)
+}
+
+// MarkHelpfulHandler and UnmarkHelpfulHandler record whether the user finds
+// a review helpful. Both can be repeated safely.
+func (s *Server) MarkHelpfulHandler(w http.ResponseWriter, r *http.Request) {
+	s.setHelpful(w, r, true)
+}
+
+func (s *Server) UnmarkHelpfulHandler(w http.ResponseWriter, r *http.Request) {
+	s.setHelpful(w, r, false)
+}
+
+func (s *Server) setHelpful(w http.ResponseWriter, r *http.Request, helpful bool) {
+	userID, ok := s.currentUser(w, r, "")
+	if !ok {
+		return
+	}
+	reviewID, ok := urlID(w, r, "reviewId", "review")
+	if !ok {
+		return
+	}
+
+	vote, err := s.db.SetHelpful(r.Context(), userID, reviewID, helpful)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeUpdated(w, vote)
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"lab2324omada7/internal/database"
+)
+
+// UserRoles is what the role endpoints return: the user's roles, the
+// permissions they grant, and the audit trail of changes, newest first.
+type UserRoles struct {
+	UserID      int                   `json:"user_id"`
+	Username    string                `json:"username"`
+	Roles       []string              `json:"roles"`
+	Permissions []string              `json:"permissions"`
+	History     []database.RoleChange `json:"history"`
+}
+
+func (s *Server) userRoles(ctx context.Context, username string, userID int) (UserRoles, error) {
+	roles, err := s.db.GetUserRoles(ctx, userID)
+	if err != nil {
+		return UserRoles{}, err
+	}
+	history, err := s.db.GetRoleChanges(ctx, userID)
+	if err != nil {
+		return UserRoles{}, err
+	}
+
+	return UserRoles{
+		UserID:      userID,
+		Username:    username,
+		Roles:       roles,
+		Permissions: database.Permissions(roles),
+		History:     history,
+	}, nil
+}
+
+func (s *Server) GetUserRolesHandler(w http.ResponseWriter, r *http.Request) {
+	username := chi.URLParam(r, "username")
+	userID, err := s.db.GetUserID(r.Context(), username)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	roles, err := s.userRoles(r.Context(), username, userID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, roles)
+}
+
+// GrantRoleHandler is idempotent: granting a role twice changes nothing.
+func (s *Server) GrantRoleHandler(w http.ResponseWriter, r *http.Request) {
+	s.changeRole(w, r, s.db.GrantRole)
+}
+
+// RevokeRoleHandler also signs the user out everywhere; see
+// database.Service.RevokeRole.
+func (s *Server) RevokeRoleHandler(w http.ResponseWriter, r *http.Request) {
+	s.changeRole(w, r, s.db.RevokeRole)
+}
+
+func (s *Server) changeRole(w http.ResponseWriter, r *http.Request, change func(ctx context.Context, actorID, userID int, role string) error) {
+	actorID, ok := s.currentUser(w, r, "")
+	if !ok {
+		return
+	}
+
+	username := chi.URLParam(r, "username")
+	userID, err := s.db.GetUserID(r.Context(), username)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := change(r.Context(), actorID, userID, chi.URLParam(r, "role")); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	roles, err := s.userRoles(r.Context(), username, userID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeUpdated(w, roles)
+}
@@ -1,21 +1,19 @@
 package server
 
 import (
-	"encoding/json"
-	"errors"
 	"fmt"
-	"log"
 	"net/http"
-	"os"
+	"net/url"
 	"strconv"
+	"strings"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
+	"lab2324omada7/internal/database"
+	"lab2324omada7/internal/slug"
 )
 
-var JWT_SECRET = []byte(fmt.Sprint(os.Getenv("KEY")))
-
 type LikedPayload struct {
 	MovieID  string `json:"movieId"`
 	Username string `json:"userName"`
@@ -32,6 +30,10 @@ type UserPayload struct {
 	Password string `json:"password"`
 }
 
+type RefreshPayload struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
 type ReviewPayload struct {
 	ReviewText   string `json:"reviewText"`
 	UserNametext string `json:"userName"`
@@ -40,168 +42,330 @@ type ReviewPayload struct {
 func (s *Server) RegisterRoutes() http.Handler {
 	r := chi.NewRouter()
 	r.Use(middleware.Logger)
+	r.Use(middleware.Recoverer)
 	r.Use(cors.Handler(cors.Options{
 		// AllowedOrigins:   []string{"https://foo.com"}, // Use this to allow specific origin hosts
 		//AllowedOrigins: []string{"https://*", "http://*"},
 		// AllowOriginFunc:  func(r *http.Request, origin string) bool { return true },
-		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedOrigins:   s.corsOrigins,
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
+		ExposedHeaders:   []string{"Link", "X-Total-Count"},
 		AllowCredentials: false,
 		MaxAge:           300, // Maximum value not ignored by any of the major browsers
 	}))
 
 	r.Get("/health", s.healthHandler)
 	r.Get("/api/directors", s.GetAllDirectorsHandler)
-	r.Get("/api/movies/staff/{name}", s.GetAllMovieStaffHandler)
-	r.Get("/api/directors/{name}", s.GetDirectorHandler)
+	r.Get("/api/movies/staff/{movie}", s.GetAllMovieStaffHandler)
+	r.Get("/api/directors/{director}", s.GetDirectorHandler)
+	r.Get("/api/directors/{director}/filmography", s.DirectedHandler)
 	r.Get("/api/actors", s.GetAllActorsHandler)
-	r.Get("/api/actors/{name}", s.GetActorHandler)
+	r.Get("/api/actors/{actor}", s.GetActorHandler)
+	r.Get("/api/actors/{actor}/filmography", s.ActedHandler)
 	r.Get("/api/movies", s.GetAllMoviesHandler)
-	r.Get("/api/movies/{title}", s.GetMovieHandler)
-	r.Get("/api/movies/reviews/{title}", s.GetReviewsHandler)
-	//r.Get("/userdata/{id}", s.UserDataHandler)
-	r.Get("/api/directors/{id}", s.DirectedHandler)
-	r.Get("/api/actors/{id}", s.ActedHandler)
-	r.Post("/api/movies/add-review/{title}/{stars}", s.AddReviewHandler)
+	r.Get("/api/movies/{movie}", s.GetMovieHandler)
+	r.With(s.IdentifyUser).Get("/api/movies/reviews/{movie}", s.GetReviewsHandler)
+	r.Get("/api/search", s.SearchHandler)
+	r.Get("/api/autocomplete", s.AutocompleteHandler)
+	r.Get("/api/users/{username}", s.PublicProfileHandler)
+	r.Get("/api/lists", s.ListsHandler)
+	r.With(s.IdentifyUser).Get("/api/lists/{list}", s.ListHandler)
 	r.Post("/create-account", s.CreateAccountHandler)
 	r.Post("/login", s.LoginHandler)
-	r.Post("/api/watchlist", s.ToggleWatchlistHandler)
-	r.Get("/watchlistStatus/{movie_name}/{username}", s.GetWatchlistHandler)
-	r.Post("/api/liked", s.ToggleLikedHandler)
-	r.Get("/likedStatus/{movie_name}/{username}", s.GetLikedHandler)
+	r.Post("/token/refresh", s.RefreshTokenHandler)
+	r.Post("/logout", s.LogoutHandler)
+	r.With(s.IdentifyUser).Get("/watchlistStatus/{movie}/{username}", s.GetWatchlistHandler)
+	r.With(s.IdentifyUser).Get("/likedStatus/{movie}/{username}", s.GetLikedHandler)
+
+	r.Group(func(r chi.Router) {
+		r.Use(s.RequireAuth)
+		r.Post("/api/movies/add-review/{movie}/{stars}", s.AddReviewHandler)
+		r.Post("/api/watchlist", s.ToggleWatchlistHandler)
+		r.Post("/api/liked", s.ToggleLikedHandler)
+		r.Get("/api/me", s.MeHandler)
+		r.Patch("/api/me", s.UpdateProfileHandler)
+		r.Delete("/api/me", s.DeleteAccountHandler)
+		r.Get("/api/me/export", s.ExportHandler)
+		r.Put("/api/me/password", s.ChangePasswordHandler)
+		r.Get("/api/me/likes", s.MyLikesHandler)
+		r.Get("/api/me/watchlist", s.MyWatchlistHandler)
+		r.Get("/api/me/status", s.MovieStatusesHandler)
+		r.Get("/api/me/reviews/{movie}", s.MyReviewHandler)
+		r.Put("/api/me/likes/{movie}", s.LikeHandler)
+		r.Delete("/api/me/likes/{movie}", s.UnlikeHandler)
+		r.Put("/api/me/watchlist/{movie}", s.AddToWatchlistHandler)
+		r.Delete("/api/me/watchlist/{movie}", s.RemoveFromWatchlistHandler)
+		r.Get("/api/me/lists", s.MyListsHandler)
+		r.Get("/api/me/diary", s.DiaryHandler)
+		r.Post("/api/me/diary", s.LogWatchHandler)
+		r.Get("/api/me/diary/months", s.DiaryMonthsHandler)
+		r.Get("/api/me/diary/{entry}", s.DiaryEntryHandler)
+		r.Patch("/api/me/diary/{entry}", s.UpdateDiaryEntryHandler)
+		r.Delete("/api/me/diary/{entry}", s.DeleteDiaryEntryHandler)
+		r.Post("/api/me/imports", s.ImportHandler)
+		r.Get("/api/me/imports/{import}", s.ImportStatusHandler)
+		r.Post("/api/me/imports/{import}/rows/{row}", s.ResolveImportRowHandler)
+		r.Delete("/api/me/imports/{import}/rows/{row}", s.DismissImportRowHandler)
+		r.Put("/api/reviews/{reviewId}/helpful", s.MarkHelpfulHandler)
+		r.Delete("/api/reviews/{reviewId}/helpful", s.UnmarkHelpfulHandler)
+		r.Post("/api/lists", s.CreateListHandler)
+		r.Patch("/api/lists/{list}", s.UpdateListHandler)
+		r.Delete("/api/lists/{list}", s.DeleteListHandler)
+		r.Put("/api/lists/{list}/entries/{movie}", s.PutListEntryHandler)
+		r.Delete("/api/lists/{list}/entries/{movie}", s.RemoveListEntryHandler)
+		r.Put("/api/lists/{list}/collaborators/{username}", s.AddCollaboratorHandler)
+		r.Delete("/api/lists/{list}/collaborators/{username}", s.RemoveCollaboratorHandler)
+	})
+
+	r.Group(func(r chi.Router) {
+		r.Use(s.RequireAuth)
+		r.Use(s.RequirePermission(database.PermEditCatalog))
+		r.Post("/api/movies", s.CreateMovieHandler)
+		r.Put("/api/movies/{movie}", s.UpdateMovieHandler)
+		r.Patch("/api/movies/{movie}", s.UpdateMovieHandler)
+		r.Delete("/api/movies/{movie}", s.DeleteMovieHandler)
+		r.Put("/api/movies/{movie}/actors/{actor}", s.AddCreditHandler)
+		r.Delete("/api/movies/{movie}/actors/{actor}", s.RemoveCreditHandler)
+		r.Put("/api/movies/{movie}/directors/{director}", s.AddCreditHandler)
+		r.Delete("/api/movies/{movie}/directors/{director}", s.RemoveCreditHandler)
+		r.Post("/api/actors", s.CreateActorHandler)
+		r.Put("/api/actors/{actor}", s.UpdateActorHandler)
+		r.Patch("/api/actors/{actor}", s.UpdateActorHandler)
+		r.Delete("/api/actors/{actor}", s.DeleteActorHandler)
+		r.Post("/api/directors", s.CreateDirectorHandler)
+		r.Put("/api/directors/{director}", s.UpdateDirectorHandler)
+		r.Patch("/api/directors/{director}", s.UpdateDirectorHandler)
+		r.Delete("/api/directors/{director}", s.DeleteDirectorHandler)
+	})
+
+	r.Group(func(r chi.Router) {
+		r.Use(s.RequireAuth)
+		r.Use(s.RequirePermission(database.PermModerateReviews))
+		r.Delete("/api/reviews/{reviewId}", s.DeleteReviewHandler)
+	})
+
+	r.Group(func(r chi.Router) {
+		r.Use(s.RequireAuth)
+		r.Use(s.RequireRole(database.RoleAdmin))
+		r.Use(s.RequirePermission(database.PermManageRoles))
+		r.Get("/api/admin/users/{username}/roles", s.GetUserRolesHandler)
+		r.Put("/api/admin/users/{username}/roles/{role}", s.GrantRoleHandler)
+		r.Delete("/api/admin/users/{username}/roles/{role}", s.RevokeRoleHandler)
+	})
 
 	return r
 }
 
 func (s *Server) healthHandler(w http.ResponseWriter, r *http.Request) {
-	jsonResp, _ := json.Marshal(s.db.Health())
-	_, _ = w.Write(jsonResp)
+	health, err := s.db.Health(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, health)
 }
 
 func (s *Server) GetAllMoviesHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(s.db.GetMovies())
+	req, err := pageRequest(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	filter, err := movieFilter(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	movies, err := s.db.GetMovies(r.Context(), filter, req)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writePage(w, r, movies)
 }
 
 func (s *Server) GetAllDirectorsHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(s.db.GetDirectors())
+	req, err := pageRequest(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	directors, err := s.db.GetDirectors(r.Context(), personFilter(r), req)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writePage(w, r, directors)
 }
 
 func (s *Server) GetAllActorsHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(s.db.GetActors())
+	req, err := pageRequest(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	actors, err := s.db.GetActors(r.Context(), personFilter(r), req)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writePage(w, r, actors)
 }
 
 func (s *Server) GetMovieHandler(w http.ResponseWriter, r *http.Request) {
-	title := chi.URLParam(r, "title")
-	getMovie, err := s.db.GetMovie(title)
+	ref := chi.URLParam(r, "movie")
+	getMovie, err := s.db.GetMovie(r.Context(), ref)
 	if err != nil {
-		log.Fatalf("Failed to get movie. Err: %v", err)
+		writeError(w, r, err)
+		return
+	}
+	if redirectToSlug(w, r, ref, getMovie.Slug) {
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(getMovie)
+	writeJSON(w, http.StatusOK, getMovie)
 }
 
 func (s *Server) GetDirectorHandler(w http.ResponseWriter, r *http.Request) {
-	name := chi.URLParam(r, "name")
-	getDirector, err := s.db.GetDirector(name)
+	ref := chi.URLParam(r, "director")
+	getDirector, err := s.db.GetDirector(r.Context(), ref)
 	if err != nil {
-		log.Fatalf("Failed to get director. Err: %v", err)
+		writeError(w, r, err)
+		return
+	}
+	if redirectToSlug(w, r, ref, getDirector.Slug) {
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(getDirector)
+	writeJSON(w, http.StatusOK, getDirector)
 }
 
 func (s *Server) GetActorHandler(w http.ResponseWriter, r *http.Request) {
-	name := chi.URLParam(r, "name")
-	getActor, err := s.db.GetActor(name)
+	ref := chi.URLParam(r, "actor")
+	getActor, err := s.db.GetActor(r.Context(), ref)
 	if err != nil {
-		log.Fatalf("Failed to get actor. Err: %v", err)
+		writeError(w, r, err)
+		return
+	}
+	if redirectToSlug(w, r, ref, getActor.Slug) {
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(getActor)
+	writeJSON(w, http.StatusOK, getActor)
 }
 
 func (s *Server) GetAllMovieStaffHandler(w http.ResponseWriter, r *http.Request) {
-	name := chi.URLParam(r, "name")
-	movie, err := s.db.GetMovie(name)
+	ref := chi.URLParam(r, "movie")
+	movie, err := s.db.GetMovie(r.Context(), ref)
 	if err != nil {
-		fmt.Println(err)
-		log.Fatalf("Failed to get movie. Err: %v", err)
+		writeError(w, r, err)
+		return
+	}
+	if redirectToSlug(w, r, ref, movie.Slug) {
+		return
 	}
-	getStaff, err := s.db.GetStaffByMovieID(movie.Id)
+	getStaff, err := s.db.GetStaffByMovieID(r.Context(), movie.Id)
 	if err != nil {
-		log.Fatalf("Failed to get staff. Err: %v", err)
+		writeError(w, r, err)
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(getStaff)
+	writeJSON(w, http.StatusOK, getStaff)
 }
 
 func (s *Server) GetReviewsHandler(w http.ResponseWriter, r *http.Request) {
-	title := chi.URLParam(r, "title")
-	getReviews, err := s.db.ShowReview(title)
-	if err != nil {
-		if errors.Is(err, errors.New("no reviews")) {
-			http.Error(w, "No reviews found", http.StatusNotFound)
-			return
-		} else {
-			log.Printf("Failed to get review. Err: %v", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-			return
-		}
+	ref := chi.URLParam(r, "movie")
+	movie, err := s.db.GetMovie(r.Context(), ref)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if redirectToSlug(w, r, ref, movie.Slug) {
+		return
+	}
+	req, err := pageRequest(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	f, err := reviewFilter(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	getReviews, err := s.db.ShowReview(r.Context(), strconv.Itoa(movie.Id), f, req)
+	if err != nil {
+		writeError(w, r, err)
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(getReviews)
+	writePage(w, r, getReviews)
 }
 
 func (s *Server) AddReviewHandler(w http.ResponseWriter, r *http.Request) {
-	title := chi.URLParam(r, "title")
+	ref := chi.URLParam(r, "movie")
 	stars := chi.URLParam(r, "stars")
 	var payload ReviewPayload
-	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if err := decodeJSON(r, &payload); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	userID, ok := s.currentUser(w, r, payload.UserNametext)
+	if !ok {
 		return
 	}
 
 	reviewText := payload.ReviewText
-	userNameText := payload.UserNametext
 
 	starsNum, err := strconv.Atoi(stars)
 	if err != nil {
-		log.Fatalf("failed converting ratingstars string to int")
+		writeError(w, r, fmt.Errorf("%w: rating %q is not a number", errBadRequest, stars))
+		return
+	}
+	if err := s.db.AddReview(r.Context(), ref, starsNum, reviewText, userID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, "ok")
+}
+
+// DeleteReviewHandler lets moderators remove any review.
+func (s *Server) DeleteReviewHandler(w http.ResponseWriter, r *http.Request) {
+	ref := chi.URLParam(r, "reviewId")
+	reviewID, err := strconv.Atoi(ref)
+	if err != nil {
+		writeError(w, r, fmt.Errorf("%w: review ID %q is not a number", errBadRequest, ref))
+		return
+	}
+
+	if err := s.db.DeleteReview(r.Context(), reviewID); err != nil {
+		writeError(w, r, err)
+		return
 	}
-	s.db.AddReview(title, starsNum, reviewText, userNameText)
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode("ok")
+	writeOK(w)
 }
 
 func (s *Server) CreateAccountHandler(w http.ResponseWriter, r *http.Request) {
 	var payload UserPayload
-	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-		fmt.Println(err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if err := decodeJSON(r, &payload); err != nil {
+		writeError(w, r, err)
 		return
 	}
 	username := payload.Username
 	email := payload.Email
 	password := payload.Password
-	register, err := s.db.RegisterUser(username, password, email)
+	register, err := s.db.RegisterUser(r.Context(), username, password, email)
 	if err != nil {
-		fmt.Println(err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
-	fmt.Println("User registered successfully:", username, email)
 
-	json.NewEncoder(w).Encode(map[string]interface{}{
+	writeJSON(w, http.StatusOK, map[string]interface{}{
 		"status": "ok",
 		"data":   register,
 	})
@@ -209,213 +373,253 @@ func (s *Server) CreateAccountHandler(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	var payload UserPayload
-	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if err := decodeJSON(r, &payload); err != nil {
+		writeError(w, r, err)
 		return
 	}
 	username := payload.Username
 	password := payload.Password
-	user, token, errMsg := s.db.AuthenticateUser(username, password)
-	if errMsg != "" {
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"status": errMsg,
-		})
+	user, tokens, err := s.db.AuthenticateUser(r.Context(), username, password)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 
-	fmt.Println("User logged in successfully:", username)
-
-	json.NewEncoder(w).Encode(map[string]interface{}{
+	writeJSON(w, http.StatusOK, map[string]interface{}{
 		"status": "ok",
 		"data": map[string]interface{}{
-			"user":  user,
-			"token": token,
+			"user":          user,
+			"token":         tokens.AccessToken,
+			"refresh_token": tokens.RefreshToken,
+			"expires_at":    tokens.ExpiresAt,
 		},
 	})
 }
 
-// func (s *Server) UserDataHandler(w http.ResponseWriter, r *http.Request) {
-// 	id := chi.URLParam(r, "id")
-// 	idNum, _ := strconv.Atoi(id)
-// 	fmt.Println(idNum)
-// 	getUserdata, err := s.db.GetUserData(idNum)
-// 	if err != nil {
-// 		if errors.Is(err, errors.New("no user")) {
-// 			http.Error(w, "No user found", http.StatusNotFound)
-// 			return
-// 		} else {
-// 			log.Printf("Failed to get review. Err: %v", err)
-// 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-// 			return
-// 		}
-// 	}
-//
-// 	w.Header().Set("Content-Type", "application/json")
-// 	json.NewEncoder(w).Encode(getUserdata)
-// }
+func (s *Server) RefreshTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var payload RefreshPayload
+	if err := decodeJSON(r, &payload); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	tokens, err := s.db.RefreshTokens(r.Context(), payload.RefreshToken)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status": "ok",
+		"data":   tokens,
+	})
+}
+
+func (s *Server) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	var payload RefreshPayload
+	if err := decodeJSON(r, &payload); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if err := s.db.RevokeRefreshToken(r.Context(), payload.RefreshToken); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{
+		"status": "ok",
+	})
+}
 
 func (s *Server) DirectedHandler(w http.ResponseWriter, r *http.Request) {
-	directorIDStr := chi.URLParam(r, "id")
-	directorID, _ := strconv.Atoi(directorIDStr)
-	movies, err := s.db.GetMoviesByDirectorID(directorID)
+	ref := chi.URLParam(r, "director")
+	director, err := s.db.GetDirector(r.Context(), ref)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if redirectToSlug(w, r, ref, director.Slug) {
+		return
+	}
+	movies, err := s.db.GetMoviesByDirectorID(r.Context(), director.ID)
 	if err != nil {
-		http.Error(w, "Error fetching directors", http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	fmt.Println(movies)
-	json.NewEncoder(w).Encode(movies)
+	writeJSON(w, http.StatusOK, movies)
 }
 
 func (s *Server) ActedHandler(w http.ResponseWriter, r *http.Request) {
-	actedIdStr := chi.URLParam(r, "id")
-	actedID, _ := strconv.Atoi(actedIdStr)
-	movies, err := s.db.GetMoviesByActorID(actedID)
+	ref := chi.URLParam(r, "actor")
+	actor, err := s.db.GetActor(r.Context(), ref)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if redirectToSlug(w, r, ref, actor.Slug) {
+		return
+	}
+	movies, err := s.db.GetMoviesByActorID(r.Context(), actor.ID)
 	if err != nil {
-		http.Error(w, "Error fetching actors", http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	fmt.Println(movies)
-	json.NewEncoder(w).Encode(movies)
+	writeJSON(w, http.StatusOK, movies)
 }
 
+// redirectToSlug permanently redirects requests that named a movie or person
+// by anything other than its numeric ID or canonical slug, such as the
+// hyphenated titles older clients built URLs from, to the same URL with the
+// slug in its place. It reports whether it wrote a response.
+func redirectToSlug(w http.ResponseWriter, r *http.Request, ref, canonical string) bool {
+	if ref == canonical || slug.IsID(ref) {
+		return false
+	}
+
+	segments := strings.Split(r.URL.Path, "/")
+	for i := len(segments) - 1; i >= 0; i-- {
+		if segments[i] == ref {
+			segments[i] = canonical
+			target := url.URL{Path: strings.Join(segments, "/"), RawQuery: r.URL.RawQuery}
+			http.Redirect(w, r, target.String(), http.StatusMovedPermanently)
+			return true
+		}
+	}
+	return false
+}
+
+// ToggleWatchlistHandler and ToggleLikedHandler are deprecated in favour of
+// PUT and DELETE /api/me/watchlist/{movie} and /api/me/likes/{movie}, since a
+// retried toggle undoes itself.
 func (s *Server) ToggleWatchlistHandler(w http.ResponseWriter, r *http.Request) {
 	var payload WatchlistPayload
-	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if err := decodeJSON(r, &payload); err != nil {
+		writeError(w, r, err)
 		return
 	}
-	username := payload.Username
-	userid, err := s.db.GetUserID(username)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	userid, ok := s.currentUser(w, r, payload.Username)
+	if !ok {
 		return
 	}
-	movieId := payload.MovieID
-	movieIdNum, _ := s.db.GetMovie(movieId)
-	err = s.db.ToggleWatchlist(movieIdNum.Id, userid)
+	movie, err := s.db.GetMovie(r.Context(), payload.MovieID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
+		return
+	}
+	deprecated(w, "/api/me/watchlist/"+strconv.Itoa(movie.Id))
+	if err := s.db.ToggleWatchlist(r.Context(), movie.Id, userid); err != nil {
+		writeError(w, r, err)
 		return
 	}
-	fmt.Println("toggled watchlist successfully for user:", username, "and movie_id:", movieIdNum.Id)
 
-	json.NewEncoder(w).Encode(map[string]string{
+	writeJSON(w, http.StatusOK, map[string]string{
 		"status": "ok",
 	})
 }
 
 func (s *Server) ToggleLikedHandler(w http.ResponseWriter, r *http.Request) {
 	var payload LikedPayload
-	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if err := decodeJSON(r, &payload); err != nil {
+		writeError(w, r, err)
 		return
 	}
-	username := payload.Username
-	userid, err := s.db.GetUserID(username)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	userid, ok := s.currentUser(w, r, payload.Username)
+	if !ok {
 		return
 	}
-	movieId := payload.MovieID
-	movieIdNum, _ := s.db.GetMovie(movieId)
-	err = s.db.ToggleLiked(movieIdNum.Id, userid)
+	movie, err := s.db.GetMovie(r.Context(), payload.MovieID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
+		return
+	}
+	deprecated(w, "/api/me/likes/"+strconv.Itoa(movie.Id))
+	if err := s.db.ToggleLiked(r.Context(), movie.Id, userid); err != nil {
+		writeError(w, r, err)
 		return
 	}
-	fmt.Println("toggled liked successfully for user:", username, " and movie_id:", movieIdNum.Id)
 
-	json.NewEncoder(w).Encode(map[string]string{
+	writeJSON(w, http.StatusOK, map[string]string{
 		"status": "ok",
 	})
 }
 
+// GetWatchlistHandler and GetLikedHandler tell whether a movie is on a
+// user's watchlist or among their likes. Anyone may ask about a user who
+// keeps that collection public; otherwise only the user may.
 func (s *Server) GetWatchlistHandler(w http.ResponseWriter, r *http.Request) {
-	movienameStr := chi.URLParam(r, "movie_name")
-	movieId, _ := s.db.GetMovie(movienameStr)
+	movie, err := s.db.GetMovie(r.Context(), chi.URLParam(r, "movie"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
 	username := chi.URLParam(r, "username")
-	status := s.db.GetWatchlistStatus(movieId.Id, username)
-	out := ""
-	if status == "1" {
+	if !s.collectionVisible(w, r, username, database.CollectionWatchlist) {
+		return
+	}
+	added, err := s.db.GetWatchlistStatus(r.Context(), movie.Id, username)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	out := "not added"
+	if added {
 		out = "added"
-	} else {
-		out = "not added"
 	}
-	json.NewEncoder(w).Encode(map[string]string{
+	writeJSON(w, http.StatusOK, map[string]string{
 		"status": "ok",
 		"data":   out,
 	})
 }
 
 func (s *Server) GetLikedHandler(w http.ResponseWriter, r *http.Request) {
-	movienameStr := chi.URLParam(r, "movie_name")
-	movieId, err := s.db.GetMovie(movienameStr)
+	movie, err := s.db.GetMovie(r.Context(), chi.URLParam(r, "movie"))
 	if err != nil {
-		fmt.Println(err)
+		writeError(w, r, err)
+		return
 	}
 	username := chi.URLParam(r, "username")
-	status := s.db.GetLikedStatus(movieId.Id, username)
-	out := ""
-	if status == "1" {
+	if !s.collectionVisible(w, r, username, database.CollectionLikes) {
+		return
+	}
+	liked, err := s.db.GetLikedStatus(r.Context(), movie.Id, username)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	out := "not liked"
+	if liked {
 		out = "liked"
-	} else {
-		out = "not liked"
 	}
-	json.NewEncoder(w).Encode(map[string]string{
+	writeJSON(w, http.StatusOK, map[string]string{
 		"status": "ok",
 		"data":   out,
 	})
 }
 
-//func (s *Server) UserDataHandler(w http.ResponseWriter, r *http.Request) {
-//	decoder := json.NewDecoder(r.Body)
-//	var requestData struct {
-//		Token string `json:"token"`
-//	}
-//	err := decoder.Decode(&requestData)
-//	if err != nil {
-//		http.Error(w, "Invalid request body", http.StatusBadRequest)
-//		return
-//	}
-//
-//	fmt.Println("Received Token:", requestData.Token)
-//
-//	token, err := jwt.Parse(requestData.Token, func(token *jwt.Token) (interface{}, error) {
-//		return JWT_SECRET, nil
-//	})
-//
-//	if err != nil {
-//		fmt.Println("Error parsing token:", err)
-//		http.Error(w, "Token verification failed", http.StatusUnauthorized)
-//		return
-//	}
-//
-//	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-//		var userEmail, userUsername string
-//
-//		if emailClaim, ok := claims["email"].(string); ok {
-//			userEmail = emailClaim
-//		}
-//
-//		if usernameClaim, ok := claims["username"].(string); ok {
-//			userUsername = usernameClaim
-//		}
-//
-//		userData := UserPayload{
-//			Username: userUsername,
-//			Email:    userEmail,
-//			Password: "",
-//		}
-//
-//		w.Header().Set("Content-Type", "application/json")
-//		json.NewEncoder(w).Encode(map[string]interface{}{
-//			"status": "ok",
-//			"data":   userData,
-//		})
-//	} else {
-//		http.Error(w, "Invalid token", http.StatusUnauthorized)
-//	}
-//
-//}
+// collectionVisible reports whether the caller may see the user's likes or
+// watchlist, and otherwise writes the error response.
+func (s *Server) collectionVisible(w http.ResponseWriter, r *http.Request, username, collection string) bool {
+	userID, err := s.db.GetUserID(r.Context(), username)
+	if err != nil {
+		writeError(w, r, err)
+		return false
+	}
+	if callerID, ok := UserIDFromContext(r.Context()); ok && callerID == userID {
+		return true
+	}
+	profile, err := s.db.GetProfile(r.Context(), userID)
+	if err != nil {
+		writeError(w, r, err)
+		return false
+	}
+	public := profile.Privacy.Likes
+	if collection == database.CollectionWatchlist {
+		public = profile.Privacy.Watchlist
+	}
+	if !public {
+		writeError(w, r, fmt.Errorf("%s keeps their %s private: %w", username, collection, database.ErrForbidden))
+		return false
+	}
+	return true
+}
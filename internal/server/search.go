@@ -0,0 +1,21 @@
+package server
+
+import "net/http"
+
+// SearchHandler serves /api/search?q=, returning the best matches among
+// movies, actors, directors and reviews, best first, as a JSON array.
+// ?limit= caps their number.
+func (s *Server) SearchHandler(w http.ResponseWriter, r *http.Request) {
+	limit, err := queryInt(r, "limit")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	results, err := s.db.Search(r.Context(), r.URL.Query().Get("q"), limit)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, results)
+}
@@ -1,35 +1,55 @@
 package server
 
 import (
+	"context"
 	"fmt"
+	"log"
 	"net/http"
-	"os"
-	"strconv"
 	"time"
 
-	_ "github.com/joho/godotenv/autoload"
+	"lab2324omada7/internal/config"
 	"lab2324omada7/internal/database"
 )
 
 type Server struct {
-	port int
-	db   database.Service
+	port        int
+	db          database.Service
+	jwtKey      []byte
+	corsOrigins []string
+	completions completions
+
+	// deletionGrace is how long a deleted account waits before it's purged.
+	deletionGrace time.Duration
 }
 
-func NewServer() *http.Server {
-	port, _ := strconv.Atoi(os.Getenv("PORT"))
+func NewServer(cfg *config.Config, db database.Service) *http.Server {
 	NewServer := &Server{
-		port: port,
-		db:   database.New(),
+		port:        cfg.Port,
+		db:          db,
+		jwtKey:      cfg.JWTKey,
+		corsOrigins: cfg.CORSAllowedOrigins,
+
+		deletionGrace: cfg.AccountDeletionGrace,
+	}
+
+	// Load the autocomplete index up front so the first keystrokes don't
+	// wait for it. If the database isn't reachable yet, the first request
+	// loads it instead.
+	ctx, cancel := context.WithTimeout(context.Background(), loadTimeout)
+	if c, err := NewServer.loadCompleter(ctx); err != nil {
+		log.Printf("loading autocomplete: %v", err)
+	} else {
+		NewServer.completions.c, NewServer.completions.loadedAt = c, time.Now()
 	}
+	cancel()
 
 	// Declare Server config
 	server := &http.Server{
 		Addr:         fmt.Sprintf(":%d", NewServer.port),
 		Handler:      NewServer.RegisterRoutes(),
-		IdleTimeout:  time.Minute,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 30 * time.Second,
+		IdleTimeout:  cfg.IdleTimeout,
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
 	}
 
 	return server
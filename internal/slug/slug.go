@@ -0,0 +1,59 @@
+// Package slug derives the URL identifiers used for movies and people.
+//
+// A slug is the lower-cased title or name with every run of characters other
+// than letters and digits replaced by a single hyphen, so "Kill Bill: Vol. 1"
+// becomes "kill-bill-vol-1". Letters outside ASCII are kept as they are.
+// Slugs are never made of digits alone, since those are read as numeric IDs.
+package slug
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Make returns the slug for a title or name. It may be empty, or all digits,
+// for titles like "1917"; For handles those.
+func Make(s string) string {
+	var b strings.Builder
+	hyphen := false
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if hyphen && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			hyphen = false
+			continue
+		}
+		hyphen = true
+	}
+	return b.String()
+}
+
+// For returns the slug for the row with the given ID: Make(name), or that
+// followed by "-<id>" when it is empty, reads as an ID, or taken reports it
+// as already used by another row. taken may be nil.
+func For(name string, id int, taken func(string) bool) string {
+	s := Make(name)
+	if s != "" && !IsID(s) && (taken == nil || !taken(s)) {
+		return s
+	}
+	if s == "" {
+		s = "untitled"
+	}
+	return s + "-" + strconv.Itoa(id)
+}
+
+// IsID reports whether ref is a numeric ID rather than a slug.
+func IsID(ref string) bool {
+	if ref == "" {
+		return false
+	}
+	for _, r := range ref {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,49 @@
+package slug
+
+import "testing"
+
+func TestMake(t *testing.T) {
+	cases := map[string]string{
+		"The Godfather":        "the-godfather",
+		"Kill Bill: Vol. 1":    "kill-bill-vol-1",
+		"Spider-Man":           "spider-man",
+		"  Samuel L. Jackson ": "samuel-l-jackson",
+		"Μια αιωνιότητα και μια μέρα": "μια-αιωνιότητα-και-μια-μέρα",
+		"Ποτέ την Κυριακή":            "ποτέ-την-κυριακή",
+		"1917":                        "1917",
+		"?!":                          "",
+	}
+	for in, want := range cases {
+		if got := Make(in); got != want {
+			t.Errorf("Make(%q) = %q; want %q", in, got, want)
+		}
+	}
+}
+
+func TestFor(t *testing.T) {
+	taken := func(s string) bool { return s == "the-lobster" }
+
+	cases := []struct {
+		name string
+		id   int
+		want string
+	}{
+		{"Poor Things", 12, "poor-things"},
+		{"The Lobster", 14, "the-lobster-14"},
+		{"1917", 3, "1917-3"},
+		{"???", 9, "untitled-9"},
+	}
+	for _, c := range cases {
+		if got := For(c.name, c.id, taken); got != c.want {
+			t.Errorf("For(%q, %d) = %q; want %q", c.name, c.id, got, c.want)
+		}
+	}
+}
+
+func TestIsID(t *testing.T) {
+	for ref, want := range map[string]bool{"42": true, "0": true, "": false, "42a": false, "-42": false, "the-godfather": false} {
+		if got := IsID(ref); got != want {
+			t.Errorf("IsID(%q) = %v; want %v", ref, got, want)
+		}
+	}
+}
@@ -1,32 +1,1562 @@
 package tests
 
 import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
-	"lab2324omada7/internal/server"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"lab2324omada7/internal/config"
+	"lab2324omada7/internal/database"
+	"lab2324omada7/internal/seed"
+	"lab2324omada7/internal/server"
 )
 
-func TestHandler(t *testing.T) {
-	s := &server.Server{}
-	server := httptest.NewServer(http.HandlerFunc(s.HelloWorldHandler))
-	defer server.Close()
-	resp, err := http.Get(server.URL)
+// The handler tests run the real router against database.Memory loaded with
+// the sample catalog from internal/seed, so they need no database server.
+
+const testOrigin = "http://localhost:3000"
+
+type testServer struct {
+	*httptest.Server
+	t *testing.T
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+
+	fixtures, err := seed.Default()
 	if err != nil {
-		t.Fatalf("error making request to server. Err: %v", err)
+		t.Fatalf("seed.Default: %v", err)
+	}
+
+	db := database.NewMemory([]byte("test-key"))
+	if err := db.Load(fixtures); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	return startServer(t, db)
+}
+
+func startServer(t *testing.T, db database.Service) *testServer {
+	t.Helper()
+
+	cfg := &config.Config{
+		JWTKey:             []byte("test-key"),
+		CORSAllowedOrigins: []string{testOrigin},
+	}
+	ts := httptest.NewServer(server.NewServer(cfg, db).Handler)
+	t.Cleanup(ts.Close)
+
+	return &testServer{Server: ts, t: t}
+}
+
+type response struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// decode unmarshals the response body into v, failing the test if it isn't
+// valid JSON.
+func (r response) decode(t *testing.T, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(r.body, v); err != nil {
+		t.Fatalf("decoding %s: %v", r.body, err)
+	}
+}
+
+// errorCode returns the code of a JSON error envelope.
+func (r response) errorCode(t *testing.T) string {
+	t.Helper()
+
+	var envelope struct {
+		Status string `json:"status"`
+		Error  struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	r.decode(t, &envelope)
+	if envelope.Status != "error" || envelope.Error.Message == "" {
+		t.Errorf("malformed error envelope: %s", r.body)
+	}
+	return envelope.Error.Code
+}
+
+// do sends a request with an optional JSON body (a string is sent verbatim)
+// and bearer token.
+func (ts *testServer) do(method, path, token string, body interface{}) response {
+	ts.t.Helper()
+
+	var reader io.Reader
+	switch b := body.(type) {
+	case nil:
+	case string:
+		reader = strings.NewReader(b)
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			ts.t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, ts.URL+path, reader)
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return ts.send(req, token)
+}
+
+// upload posts the files, name to content, as the "file" fields of a
+// multipart form.
+func (ts *testServer) upload(path, token string, files map[string]string) response {
+	ts.t.Helper()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for name, content := range files {
+		part, err := form.CreateFormFile("file", name)
+		if err != nil {
+			ts.t.Fatal(err)
+		}
+		part.Write([]byte(content))
+	}
+	if err := form.Close(); err != nil {
+		ts.t.Fatal(err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, ts.URL+path, &body)
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	return ts.send(req, token)
+}
+
+// send sends req with an optional bearer token and reads the response.
+func (ts *testServer) send(req *http.Request, token string) response {
+	ts.t.Helper()
+
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		ts.t.Fatalf("%s %s: %v", req.Method, req.URL.Path, err)
 	}
 	defer resp.Body.Close()
-	// Assertions
-	if resp.StatusCode != http.StatusOK {
-		t.Errorf("expected status OK; got %v", resp.Status)
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		ts.t.Fatalf("reading response body. Err: %v", err)
 	}
-	expected := "{\"message\":\"Hello World\"}"
-	body, err := io.ReadAll(resp.Body)
+	return response{status: resp.StatusCode, header: resp.Header, body: data}
+}
+
+func (ts *testServer) get(path string) response {
+	ts.t.Helper()
+	return ts.do(http.MethodGet, path, "", nil)
+}
+
+// redirect sends a GET without following redirects and returns the status
+// and Location header of the response.
+func (ts *testServer) redirect(path string) (int, string) {
+	ts.t.Helper()
+
+	client := *ts.Client()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	resp, err := client.Get(ts.URL + path)
 	if err != nil {
-		t.Fatalf("error reading response body. Err: %v", err)
+		ts.t.Fatalf("GET %s: %v", path, err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode, resp.Header.Get("Location")
+}
+
+type session struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresAt    int64  `json:"expires_at"`
+}
+
+// login signs in one of the seeded users, whose password is "<name>-password".
+func (ts *testServer) login(username string) session {
+	ts.t.Helper()
+
+	resp := ts.do(http.MethodPost, "/login", "", map[string]string{
+		"username": username,
+		"password": username + "-password",
+	})
+	if resp.status != http.StatusOK {
+		ts.t.Fatalf("login %s: %d %s", username, resp.status, resp.body)
+	}
+
+	var out struct {
+		Data session `json:"data"`
 	}
-	if expected != string(body) {
-		t.Errorf("expected response body to be %v; got %v", expected, string(body))
+	resp.decode(ts.t, &out)
+	return out.Data
+}
+
+func expectStatus(t *testing.T, resp response, want int) {
+	t.Helper()
+	if resp.status != want {
+		t.Errorf("status %d; want %d (body %s)", resp.status, want, resp.body)
 	}
 }
+
+func expectError(t *testing.T, resp response, status int, code string) {
+	t.Helper()
+	expectStatus(t, resp, status)
+	if got := resp.errorCode(t); got != code {
+		t.Errorf("error code %q; want %q", got, code)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	ts := newTestServer(t)
+
+	resp := ts.get("/health")
+	expectStatus(t, resp, http.StatusOK)
+
+	var health map[string]string
+	resp.decode(t, &health)
+	if health["message"] != "It's healthy" {
+		t.Errorf("health = %v", health)
+	}
+}
+
+func TestCatalogListings(t *testing.T) {
+	ts := newTestServer(t)
+
+	for _, tc := range []struct {
+		path string
+		want int
+	}{
+		{"/api/movies", 13},
+		{"/api/directors", 8},
+		{"/api/actors", 19},
+	} {
+		resp := ts.get(tc.path)
+		expectStatus(t, resp, http.StatusOK)
+
+		var items []map[string]interface{}
+		resp.decode(t, &items)
+		if len(items) != tc.want {
+			t.Errorf("GET %s returned %d items; want %d", tc.path, len(items), tc.want)
+		}
+	}
+}
+
+func TestListPaging(t *testing.T) {
+	ts := newTestServer(t)
+
+	// Follow the next links through the dramas, newest first, two at a time.
+	path := "/api/movies?genre=drama&sort=-release_date&limit=2"
+	var titles []string
+	for pages := 0; path != ""; pages++ {
+		if pages > 3 {
+			t.Fatal("too many pages")
+		}
+		resp := ts.get(path)
+		expectStatus(t, resp, http.StatusOK)
+		if got := resp.header.Get("X-Total-Count"); got != "4" {
+			t.Errorf("GET %s: X-Total-Count = %q; want 4", path, got)
+		}
+
+		var movies []struct{ Title string }
+		resp.decode(t, &movies)
+		for _, m := range movies {
+			titles = append(titles, m.Title)
+		}
+
+		links := resp.header.Get("Link")
+		if !strings.Contains(links, `</api/movies?genre=drama&limit=2&sort=-release_date>; rel="first"`) {
+			t.Errorf("GET %s: Link = %q; want a first link", path, links)
+		}
+		path = ""
+		if m := regexp.MustCompile(`<([^>]*)>; rel="next"`).FindStringSubmatch(links); m != nil {
+			path = m[1]
+		}
+	}
+	want := []string{"Κυνόδοντας", "Μια αιωνιότητα και μια μέρα", "Taxi Driver", "Αλέξης Ζορμπάς"}
+	if strings.Join(titles, "|") != strings.Join(want, "|") {
+		t.Errorf("got %q; want %q", titles, want)
+	}
+
+	resp := ts.get("/api/actors?nationality=greek&sort=name")
+	expectStatus(t, resp, http.StatusOK)
+	if got := resp.header.Get("X-Total-Count"); got != "4" {
+		t.Errorf("X-Total-Count = %q; want 4", got)
+	}
+
+	resp = ts.get("/api/movies/reviews/the-godfather?sort=-rating&limit=1")
+	var reviews []struct{ RatingStars int }
+	resp.decode(t, &reviews)
+	if len(reviews) != 1 || reviews[0].RatingStars != 5 || !strings.Contains(resp.header.Get("Link"), `rel="next"`) {
+		t.Errorf("reviews = %+v, Link %q", reviews, resp.header.Get("Link"))
+	}
+
+	expectError(t, ts.get("/api/movies?limit=ten"), http.StatusBadRequest, "bad_request")
+	expectError(t, ts.get("/api/movies?min_rating=high"), http.StatusBadRequest, "bad_request")
+	expectError(t, ts.get("/api/movies?limit=1000"), http.StatusUnprocessableEntity, "validation_failed")
+	expectError(t, ts.get("/api/movies?sort=budget"), http.StatusUnprocessableEntity, "validation_failed")
+	expectError(t, ts.get("/api/directors?cursor=garbage"), http.StatusUnprocessableEntity, "validation_failed")
+}
+
+func TestSearch(t *testing.T) {
+	ts := newTestServer(t)
+
+	resp := ts.get("/api/search?q=" + url.QueryEscape("λάνθιμος"))
+	expectStatus(t, resp, http.StatusOK)
+	var results []database.SearchResult
+	resp.decode(t, &results)
+	if len(results) != 1 || results[0].Type != "director" || results[0].Slug != "γιώργος-λάνθιμος" ||
+		results[0].Highlight != "Γιώργος <mark>Λάνθιμος</mark>" {
+		t.Errorf("search results = %+v", results)
+	}
+
+	resp = ts.get("/api/search?q=the&limit=3")
+	expectStatus(t, resp, http.StatusOK)
+	resp.decode(t, &results)
+	if len(results) != 3 {
+		t.Errorf("got %d results; want 3", len(results))
+	}
+
+	expectError(t, ts.get("/api/search"), http.StatusUnprocessableEntity, "validation_failed")
+	expectError(t, ts.get("/api/search?q=the&limit=all"), http.StatusBadRequest, "bad_request")
+}
+
+func TestAutocomplete(t *testing.T) {
+	ts := newTestServer(t)
+
+	complete := func(q string) []string {
+		t.Helper()
+		resp := ts.get("/api/autocomplete?q=" + url.QueryEscape(q))
+		expectStatus(t, resp, http.StatusOK)
+		var suggestions []struct {
+			Type string `json:"type"`
+			Slug string `json:"slug"`
+		}
+		resp.decode(t, &suggestions)
+		var got []string
+		for _, s := range suggestions {
+			got = append(got, s.Type+":"+s.Slug)
+		}
+		return got
+	}
+	for q, want := range map[string]string{
+		"lanthimos": "[director:γιώργος-λάνθιμος]",
+		"godfahter": "[movie:the-godfather movie:the-godfather-part-ii]",
+		"μερκουρ":   "[actor:μελίνα-μερκούρη]",
+		"kynodon":   "[movie:κυνόδοντας]",
+		"":          "[]",
+	} {
+		if got := fmt.Sprint(complete(q)); got != want {
+			t.Errorf("autocomplete %q = %s; want %s", q, got, want)
+		}
+	}
+
+	// Catalog changes show up at once.
+	admin := ts.login("alice").Token
+	expectStatus(t, ts.do(http.MethodPost, "/api/movies", admin,
+		map[string]string{"Title": "Heat", "ReleaseDate": "1995-12-15", "Genre": "Crime"}), http.StatusCreated)
+	if got := fmt.Sprint(complete("heat")); got != "[movie:heat]" {
+		t.Errorf("autocomplete after creating a movie = %s", got)
+	}
+	expectStatus(t, ts.do(http.MethodDelete, "/api/movies/heat", admin, nil), http.StatusOK)
+	if got := complete("heat"); len(got) != 0 {
+		t.Errorf("autocomplete after deleting the movie = %s", got)
+	}
+
+	expectError(t, ts.get("/api/autocomplete?q=god&limit=51"), http.StatusUnprocessableEntity, "validation_failed")
+}
+
+func TestGetMovie(t *testing.T) {
+	ts := newTestServer(t)
+
+	resp := ts.get("/api/movies/the-godfather")
+	expectStatus(t, resp, http.StatusOK)
+
+	var movie database.Movie
+	resp.decode(t, &movie)
+	if movie.Id != 1 || movie.Title != "The Godfather" || movie.AvgRating != 4.5 {
+		t.Errorf("movie = %+v", movie)
+	}
+
+	// Slugs may contain non-ASCII text.
+	resp = ts.get("/api/movies/%CE%BA%CF%85%CE%BD%CF%8C%CE%B4%CE%BF%CE%BD%CF%84%CE%B1%CF%82")
+	expectStatus(t, resp, http.StatusOK)
+
+	resp = ts.get("/api/movies/7")
+	expectStatus(t, resp, http.StatusOK)
+	resp.decode(t, &movie)
+	if movie.Title != "Spider-Man" || movie.Slug != "spider-man" {
+		t.Errorf("movie 7 = %+v", movie)
+	}
+
+	expectError(t, ts.get("/api/movies/no-such-movie"), http.StatusNotFound, "not_found")
+}
+
+func TestMovieStaff(t *testing.T) {
+	ts := newTestServer(t)
+
+	resp := ts.get("/api/movies/staff/pulp-fiction")
+	expectStatus(t, resp, http.StatusOK)
+
+	var staff []database.StaffMember
+	resp.decode(t, &staff)
+	roles := map[string]int{}
+	for _, member := range staff {
+		roles[member.Role]++
+	}
+	if roles["Actor"] != 3 || roles["Director"] != 1 {
+		t.Errorf("staff = %+v", staff)
+	}
+
+	expectError(t, ts.get("/api/movies/staff/no-such-movie"), http.StatusNotFound, "not_found")
+}
+
+func TestGetPeople(t *testing.T) {
+	ts := newTestServer(t)
+
+	for _, path := range []string{"/api/directors/7", "/api/directors/%CE%B3%CE%B9%CF%8E%CF%81%CE%B3%CE%BF%CF%82-%CE%BB%CE%AC%CE%BD%CE%B8%CE%B9%CE%BC%CE%BF%CF%82"} {
+		resp := ts.get(path)
+		expectStatus(t, resp, http.StatusOK)
+		var director database.Director
+		resp.decode(t, &director)
+		if director.ID != 7 || director.Name != "Γιώργος Λάνθιμος" {
+			t.Errorf("GET %s = %+v", path, director)
+		}
+	}
+
+	resp := ts.get("/api/actors/robert-de-niro")
+	expectStatus(t, resp, http.StatusOK)
+	var actor database.Actor
+	resp.decode(t, &actor)
+	if actor.ID != 3 || actor.Slug != "robert-de-niro" {
+		t.Errorf("actor = %+v", actor)
+	}
+
+	expectError(t, ts.get("/api/directors/99"), http.StatusNotFound, "not_found")
+	expectError(t, ts.get("/api/actors/nobody"), http.StatusNotFound, "not_found")
+}
+
+func TestFilmographies(t *testing.T) {
+	ts := newTestServer(t)
+
+	resp := ts.get("/api/directors/7/filmography")
+	expectStatus(t, resp, http.StatusOK)
+	var directed []database.DirectedMovie
+	resp.decode(t, &directed)
+	if len(directed) != 3 || directed[0].DirectorName != "Γιώργος Λάνθιμος" || directed[0].Slug == "" {
+		t.Errorf("directed = %+v", directed)
+	}
+
+	resp = ts.get("/api/actors/robert-de-niro/filmography")
+	expectStatus(t, resp, http.StatusOK)
+	var acted []database.ActedMovie
+	resp.decode(t, &acted)
+	if len(acted) != 3 || acted[0].ActorName != "Robert De Niro" {
+		t.Errorf("acted = %+v", acted)
+	}
+
+	expectError(t, ts.get("/api/actors/99/filmography"), http.StatusNotFound, "not_found")
+}
+
+// URLs built from titles or names before slugs existed redirect to the
+// canonical ones.
+func TestRedirectsToSlug(t *testing.T) {
+	ts := newTestServer(t)
+
+	for path, want := range map[string]string{
+		"/api/movies/The-Godfather":                                                "/api/movies/the-godfather",
+		"/api/movies/Kill-Bill:-Vol.-1?x=1":                                        "/api/movies/kill-bill-vol-1?x=1",
+		"/api/movies/reviews/The-Godfather":                                        "/api/movies/reviews/the-godfather",
+		"/api/movies/staff/Pulp-Fiction":                                           "/api/movies/staff/pulp-fiction",
+		"/api/actors/Robert-De-Niro/filmography":                                   "/api/actors/robert-de-niro/filmography",
+		"/api/movies/%CE%9A%CF%85%CE%BD%CF%8C%CE%B4%CE%BF%CE%BD%CF%84%CE%B1%CF%82": "/api/movies/%CE%BA%CF%85%CE%BD%CF%8C%CE%B4%CE%BF%CE%BD%CF%84%CE%B1%CF%82",
+	} {
+		status, location := ts.redirect(path)
+		if status != http.StatusMovedPermanently || location != want {
+			t.Errorf("GET %s = %d %q; want %d %q", path, status, location, http.StatusMovedPermanently, want)
+		}
+	}
+
+	// IDs and canonical slugs are answered directly.
+	for _, path := range []string{"/api/movies/1", "/api/movies/the-godfather", "/api/directors/7/filmography"} {
+		if status, _ := ts.redirect(path); status != http.StatusOK {
+			t.Errorf("GET %s = %d; want %d", path, status, http.StatusOK)
+		}
+	}
+
+	// Following the redirect ends at the movie.
+	resp := ts.get("/api/movies/Spider-Man")
+	expectStatus(t, resp, http.StatusOK)
+	var movie database.Movie
+	resp.decode(t, &movie)
+	if movie.Id != 7 {
+		t.Errorf("movie = %+v", movie)
+	}
+}
+
+func TestGetReviews(t *testing.T) {
+	ts := newTestServer(t)
+
+	resp := ts.get("/api/movies/reviews/the-godfather")
+	expectStatus(t, resp, http.StatusOK)
+	var reviews []database.Review
+	resp.decode(t, &reviews)
+	if len(reviews) != 2 {
+		t.Errorf("got %d reviews; want 2", len(reviews))
+	}
+
+	// A movie without reviews answers with an empty list, not null.
+	resp = ts.get("/api/movies/reviews/taxi-driver")
+	expectStatus(t, resp, http.StatusOK)
+	if got := strings.TrimSpace(string(resp.body)); got != "[]" {
+		t.Errorf("body = %s; want []", got)
+	}
+
+	expectError(t, ts.get("/api/movies/reviews/no-such-movie"), http.StatusNotFound, "not_found")
+}
+
+func TestReviewListing(t *testing.T) {
+	ts := newTestServer(t)
+	bob := ts.login("bob").Token
+	nikos := ts.login("nikos").Token
+
+	// Bob's review of the Godfather is review 2, Alice's review 1.
+	resp := ts.do(http.MethodPut, "/api/reviews/2/helpful", nikos, nil)
+	expectStatus(t, resp, http.StatusOK)
+	var marked struct {
+		Data database.ReviewVote `json:"data"`
+	}
+	resp.decode(t, &marked)
+	if marked.Data != (database.ReviewVote{ReviewID: 2, Helpful: true, HelpfulCount: 1}) {
+		t.Errorf("PUT /api/reviews/2/helpful = %+v", marked.Data)
+	}
+	expectError(t, ts.do(http.MethodPut, "/api/reviews/2/helpful", bob, nil), http.StatusUnprocessableEntity, "validation_failed")
+	expectError(t, ts.do(http.MethodPut, "/api/reviews/99/helpful", bob, nil), http.StatusNotFound, "not_found")
+	expectError(t, ts.do(http.MethodPut, "/api/reviews/2/helpful", "", nil), http.StatusUnauthorized, "unauthorized")
+
+	var reviews []database.MovieReview
+	resp = ts.get("/api/movies/reviews/the-godfather?sort=-helpful")
+	expectStatus(t, resp, http.StatusOK)
+	resp.decode(t, &reviews)
+	if len(reviews) != 2 || reviews[0].Id != 2 || reviews[0].Author.Username != "bob" || reviews[0].HelpfulCount != 1 {
+		t.Errorf("reviews by -helpful = %+v", reviews)
+	}
+	ts.get("/api/movies/reviews/the-godfather?stars=5").decode(t, &reviews)
+	if len(reviews) != 1 || reviews[0].Author.Username != "alice" {
+		t.Errorf("5-star reviews = %+v", reviews)
+	}
+	expectError(t, ts.get("/api/movies/reviews/the-godfather?stars=six"), http.StatusBadRequest, "bad_request")
+
+	// Signed in, Bob sees his own review apart from the others.
+	ts.do(http.MethodGet, "/api/movies/reviews/the-godfather", bob, nil).decode(t, &reviews)
+	if len(reviews) != 1 || reviews[0].Author.Username != "alice" {
+		t.Errorf("reviews for Bob = %+v; want only Alice's", reviews)
+	}
+	var mine database.MovieReview
+	resp = ts.do(http.MethodGet, "/api/me/reviews/the-godfather", bob, nil)
+	expectStatus(t, resp, http.StatusOK)
+	resp.decode(t, &mine)
+	if mine.Id != 2 || mine.Author.Username != "bob" || mine.HelpfulCount != 1 {
+		t.Errorf("GET /api/me/reviews/the-godfather = %+v", mine)
+	}
+	expectError(t, ts.do(http.MethodGet, "/api/me/reviews/taxi-driver", bob, nil), http.StatusNotFound, "not_found")
+
+	expectStatus(t, ts.do(http.MethodDelete, "/api/reviews/2/helpful", nikos, nil), http.StatusOK)
+	if ts.do(http.MethodGet, "/api/me/reviews/the-godfather", bob, nil).decode(t, &mine); mine.HelpfulCount != 0 {
+		t.Errorf("helpful count after DELETE = %d; want 0", mine.HelpfulCount)
+	}
+}
+
+func TestCreateAccount(t *testing.T) {
+	ts := newTestServer(t)
+
+	resp := ts.do(http.MethodPost, "/create-account", "", map[string]string{
+		"username": "carol", "email": "carol@example.com", "password": "secret",
+	})
+	expectStatus(t, resp, http.StatusOK)
+	var out struct {
+		Status string  `json:"status"`
+		Data   session `json:"data"`
+	}
+	resp.decode(t, &out)
+	if out.Status != "ok" || out.Data.Token == "" || out.Data.RefreshToken == "" {
+		t.Errorf("create-account = %s", resp.body)
+	}
+
+	for name, tc := range map[string]struct {
+		body   interface{}
+		status int
+		code   string
+	}{
+		"duplicate":     {map[string]string{"username": "Carol", "email": "c@example.com", "password": "x"}, http.StatusConflict, "conflict"},
+		"invalid email": {map[string]string{"username": "dave", "email": "dave", "password": "x"}, http.StatusUnprocessableEntity, "validation_failed"},
+		"no password":   {map[string]string{"username": "dave", "email": "dave@example.com"}, http.StatusUnprocessableEntity, "validation_failed"},
+		"malformed":     {"{not json", http.StatusBadRequest, "bad_request"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			expectError(t, ts.do(http.MethodPost, "/create-account", "", tc.body), tc.status, tc.code)
+		})
+	}
+}
+
+func TestLogin(t *testing.T) {
+	ts := newTestServer(t)
+
+	s := ts.login("alice")
+	if s.Token == "" || s.RefreshToken == "" || s.ExpiresAt == 0 {
+		t.Errorf("session = %+v", s)
+	}
+
+	resp := ts.do(http.MethodPost, "/login", "", map[string]string{"username": "alice", "password": "alice-password"})
+	expectStatus(t, resp, http.StatusOK)
+	var login struct {
+		Data struct {
+			User map[string]interface{} `json:"user"`
+		} `json:"data"`
+	}
+	resp.decode(t, &login)
+	if login.Data.User["Username"] != "alice" {
+		t.Errorf("user = %v", login.Data.User)
+	}
+	if _, ok := login.Data.User["Password"]; ok {
+		t.Error("login response includes the password hash")
+	}
+
+	resp = ts.do(http.MethodPost, "/login", "", map[string]string{"username": "alice", "password": "wrong"})
+	expectError(t, resp, http.StatusUnauthorized, "unauthorized")
+	if resp.header.Get("WWW-Authenticate") == "" {
+		t.Error("401 response without a WWW-Authenticate header")
+	}
+
+	// An unknown username gets the same answer, so logins can't probe for users.
+	resp = ts.do(http.MethodPost, "/login", "", map[string]string{"username": "nobody", "password": "x"})
+	expectError(t, resp, http.StatusUnauthorized, "unauthorized")
+	var body struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	resp.decode(t, &body)
+	if body.Error.Message != "invalid username or password: unauthorized" {
+		t.Errorf("unknown user message = %q", body.Error.Message)
+	}
+
+	expectError(t, ts.do(http.MethodPost, "/login", "", "[]"), http.StatusBadRequest, "bad_request")
+}
+
+func TestRequireAuth(t *testing.T) {
+	ts := newTestServer(t)
+	s := ts.login("bob")
+
+	// Forged tokens start from the claims of bob's real one, so each is
+	// wrong in one way only.
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(s.Token, claims); err != nil {
+		t.Fatal(err)
+	}
+	forge := func(method jwt.SigningMethod, key interface{}, change func(jwt.MapClaims)) string {
+		t.Helper()
+		forged := jwt.MapClaims{}
+		for k, v := range claims {
+			forged[k] = v
+		}
+		if change != nil {
+			change(forged)
+		}
+		token, err := jwt.NewWithClaims(method, forged).SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	me := func(authorization string) response {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/me", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		return ts.send(req, "")
+	}
+
+	key := []byte("test-key")
+	for name, authorization := range map[string]string{
+		"no token":        "",
+		"other scheme":    "Basic " + s.Token,
+		"empty bearer":    "Bearer ",
+		"not a jwt":       "Bearer not-a-jwt",
+		"other key":       "Bearer " + forge(jwt.SigningMethodHS256, []byte("other-key"), nil),
+		"other algorithm": "Bearer " + forge(jwt.SigningMethodHS512, key, nil),
+		"unsigned":        "Bearer " + forge(jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, nil),
+		"expired":         "Bearer " + forge(jwt.SigningMethodHS256, key, func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Minute).Unix() }),
+		"no expiry":       "Bearer " + forge(jwt.SigningMethodHS256, key, func(c jwt.MapClaims) { delete(c, "exp") }),
+		"no subject":      "Bearer " + forge(jwt.SigningMethodHS256, key, func(c jwt.MapClaims) { delete(c, "sub") }),
+		"unknown session": "Bearer " + forge(jwt.SigningMethodHS256, key, func(c jwt.MapClaims) { c["sid"] = "no-such-session" }),
+	} {
+		t.Run(name, func(t *testing.T) {
+			resp := me(authorization)
+			expectError(t, resp, http.StatusUnauthorized, "unauthorized")
+			if resp.header.Get("WWW-Authenticate") == "" {
+				t.Error("401 response without a WWW-Authenticate header")
+			}
+		})
+	}
+
+	resp := me("bearer " + s.Token)
+	expectStatus(t, resp, http.StatusOK)
+	var who server.Me
+	resp.decode(t, &who)
+	if who.Username != "bob" {
+		t.Errorf("/api/me as bob = %s", resp.body)
+	}
+
+	// The write endpoints act on the token's subject, not on a username in
+	// the body, and refuse anonymous callers.
+	for _, path := range []string{"/api/watchlist", "/api/liked", "/api/movies/add-review/poor-things/4"} {
+		body := map[string]string{"movieId": "poor-things", "reviewText": "Strange and tender."}
+		expectError(t, ts.do(http.MethodPost, path, "", body), http.StatusUnauthorized, "unauthorized")
+		expectStatus(t, ts.do(http.MethodPost, path, s.Token, body), http.StatusOK)
+	}
+	for path, want := range map[string]string{
+		"/watchlistStatus/poor-things/bob": "added",
+		"/likedStatus/poor-things/bob":     "liked",
+	} {
+		resp := ts.do(http.MethodGet, path, s.Token, nil)
+		expectStatus(t, resp, http.StatusOK)
+		var out struct {
+			Data string `json:"data"`
+		}
+		resp.decode(t, &out)
+		if out.Data != want {
+			t.Errorf("%s = %q; want %q", path, out.Data, want)
+		}
+	}
+	expectStatus(t, ts.do(http.MethodGet, "/api/me/reviews/poor-things", s.Token, nil), http.StatusOK)
+}
+
+func TestRefreshAndLogout(t *testing.T) {
+	ts := newTestServer(t)
+	s := ts.login("bob")
+
+	resp := ts.do(http.MethodPost, "/token/refresh", "", map[string]string{"refresh_token": s.RefreshToken})
+	expectStatus(t, resp, http.StatusOK)
+	var out struct {
+		Data session `json:"data"`
+	}
+	resp.decode(t, &out)
+	if out.Data.RefreshToken == "" || out.Data.RefreshToken == s.RefreshToken {
+		t.Fatalf("refresh did not rotate the token: %s", resp.body)
+	}
+
+	// Replaying the old token ends the session, including the new tokens.
+	resp = ts.do(http.MethodPost, "/token/refresh", "", map[string]string{"refresh_token": s.RefreshToken})
+	expectError(t, resp, http.StatusUnauthorized, "unauthorized")
+	resp = ts.do(http.MethodPost, "/api/liked", out.Data.Token, map[string]string{"movieId": "spider-man"})
+	expectError(t, resp, http.StatusUnauthorized, "unauthorized")
+
+	resp = ts.do(http.MethodPost, "/token/refresh", "", map[string]string{"refresh_token": "bogus"})
+	expectError(t, resp, http.StatusUnauthorized, "unauthorized")
+	expectError(t, ts.do(http.MethodPost, "/token/refresh", "", "nope"), http.StatusBadRequest, "bad_request")
+
+	s = ts.login("bob")
+	resp = ts.do(http.MethodPost, "/logout", "", map[string]string{"refresh_token": s.RefreshToken})
+	expectStatus(t, resp, http.StatusOK)
+	resp = ts.do(http.MethodPost, "/api/liked", s.Token, map[string]string{"movieId": "spider-man"})
+	expectError(t, resp, http.StatusUnauthorized, "unauthorized")
+
+	// Logging out twice, or with an unknown token, is harmless.
+	expectStatus(t, ts.do(http.MethodPost, "/logout", "", map[string]string{"refresh_token": s.RefreshToken}), http.StatusOK)
+	expectStatus(t, ts.do(http.MethodPost, "/logout", "", map[string]string{"refresh_token": "bogus"}), http.StatusOK)
+	expectError(t, ts.do(http.MethodPost, "/logout", "", "{"), http.StatusBadRequest, "bad_request")
+}
+
+func TestAddReview(t *testing.T) {
+	ts := newTestServer(t)
+	s := ts.login("nikos")
+
+	review := map[string]string{"reviewText": "Absurd in the best way."}
+
+	resp := ts.do(http.MethodPost, "/api/movies/add-review/the-lobster/4", s.Token, review)
+	expectStatus(t, resp, http.StatusOK)
+
+	resp = ts.get("/api/movies/reviews/the-lobster")
+	var reviews []database.Review
+	resp.decode(t, &reviews)
+	if len(reviews) != 1 || reviews[0].Review != review["reviewText"] || reviews[0].Stars != 4 {
+		t.Errorf("reviews = %+v", reviews)
+	}
+
+	resp = ts.get("/api/movies/the-lobster")
+	var movie database.Movie
+	resp.decode(t, &movie)
+	if movie.AvgRating != 4 {
+		t.Errorf("AvgRating = %v; want 4", movie.AvgRating)
+	}
+
+	for name, tc := range map[string]struct {
+		path   string
+		token  string
+		body   interface{}
+		status int
+		code   string
+	}{
+		"other user":      {"/api/movies/add-review/the-lobster/4", s.Token, map[string]string{"reviewText": "x", "userName": "alice"}, http.StatusForbidden, "forbidden"},
+		"stars not a num": {"/api/movies/add-review/the-lobster/four", s.Token, review, http.StatusBadRequest, "bad_request"},
+		"too many stars":  {"/api/movies/add-review/the-lobster/6", s.Token, review, http.StatusUnprocessableEntity, "validation_failed"},
+		"unknown movie":   {"/api/movies/add-review/no-such-movie/4", s.Token, review, http.StatusNotFound, "not_found"},
+		"malformed body":  {"/api/movies/add-review/the-lobster/4", s.Token, "{", http.StatusBadRequest, "bad_request"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			expectError(t, ts.do(http.MethodPost, tc.path, tc.token, tc.body), tc.status, tc.code)
+		})
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	ts := newTestServer(t)
+	s := ts.login("nikos")
+
+	expectError(t, ts.get("/api/me"), http.StatusUnauthorized, "unauthorized")
+
+	resp := ts.do(http.MethodGet, "/api/me", s.Token, nil)
+	expectStatus(t, resp, http.StatusOK)
+	var me server.Me
+	resp.decode(t, &me)
+	if me.Username != "nikos" || me.Email != "nikos@example.com" || len(me.Roles) != 2 || len(me.Permissions) != 1 {
+		t.Errorf("/api/me = %+v", me)
+	}
+
+	resp = ts.do(http.MethodPatch, "/api/me", s.Token, map[string]interface{}{
+		"display_name": "Νίκος",
+		"bio":          "Greek cinema, mostly.",
+		"avatar_url":   "https://example.com/nikos.png",
+		"privacy":      map[string]bool{"likes": false, "watchlist": true},
+	})
+	expectStatus(t, resp, http.StatusOK)
+	var updated struct {
+		Data database.Profile `json:"data"`
+	}
+	resp.decode(t, &updated)
+	if updated.Data.DisplayName != "Νίκος" || updated.Data.Privacy != (database.Privacy{Reviews: true, Watchlist: true}) {
+		t.Errorf("PATCH /api/me = %+v", updated.Data)
+	}
+	expectError(t, ts.do(http.MethodPatch, "/api/me", s.Token, map[string]string{"avatar_url": "ftp://example.com/x"}), http.StatusUnprocessableEntity, "validation_failed")
+	expectError(t, ts.do(http.MethodPatch, "/api/me", s.Token, map[string]string{"username": "alice"}), http.StatusBadRequest, "bad_request")
+
+	resp = ts.get("/api/users/nikos")
+	expectStatus(t, resp, http.StatusOK)
+	var public map[string]json.RawMessage
+	resp.decode(t, &public)
+	if _, ok := public["email"]; ok {
+		t.Errorf("public profile shows the email: %s", resp.body)
+	}
+	if string(public["likes"]) != "null" {
+		t.Errorf("public profile shows hidden likes: %s", public["likes"])
+	}
+	var reviews server.ProfileSection[database.UserReview]
+	if err := json.Unmarshal(public["reviews"], &reviews); err != nil || reviews.Total != 2 || reviews.Items[0].MovieSlug == "" {
+		t.Errorf("public reviews = %s", public["reviews"])
+	}
+	var watchlist server.ProfileSection[database.SavedMovie]
+	if err := json.Unmarshal(public["watchlist"], &watchlist); err != nil || watchlist.Total != 1 || watchlist.Items[0].Id != 8 {
+		t.Errorf("public watchlist = %s", public["watchlist"])
+	}
+	expectError(t, ts.get("/api/users/nobody"), http.StatusNotFound, "not_found")
+
+	password := map[string]string{"current_password": "wrong", "new_password": "new-password"}
+	expectError(t, ts.do(http.MethodPut, "/api/me/password", s.Token, password), http.StatusForbidden, "forbidden")
+	password["current_password"] = "nikos-password"
+	resp = ts.do(http.MethodPut, "/api/me/password", s.Token, password)
+	expectStatus(t, resp, http.StatusOK)
+	var changed struct {
+		Data session `json:"data"`
+	}
+	resp.decode(t, &changed)
+
+	// The old session ends; the one handed back works.
+	expectError(t, ts.do(http.MethodGet, "/api/me", s.Token, nil), http.StatusUnauthorized, "unauthorized")
+	expectStatus(t, ts.do(http.MethodGet, "/api/me", changed.Data.Token, nil), http.StatusOK)
+	resp = ts.do(http.MethodPost, "/login", "", map[string]string{"username": "nikos", "password": "new-password"})
+	expectStatus(t, resp, http.StatusOK)
+}
+
+func TestMyCollections(t *testing.T) {
+	ts := newTestServer(t)
+	s := ts.login("bob")
+
+	expectError(t, ts.get("/api/me/watchlist"), http.StatusUnauthorized, "unauthorized")
+
+	resp := ts.do(http.MethodGet, "/api/me/watchlist?sort=-date_added&limit=1", s.Token, nil)
+	expectStatus(t, resp, http.StatusOK)
+	var watchlist []database.SavedMovie
+	resp.decode(t, &watchlist)
+	if len(watchlist) != 1 || watchlist[0].Id != 11 || watchlist[0].DateAdded != "2024-05-02 11:16:00" || watchlist[0].Title == "" {
+		t.Errorf("watchlist = %+v; want the latest of bob's two", watchlist)
+	}
+	if resp.header.Get("X-Total-Count") != "2" || !strings.Contains(resp.header.Get("Link"), `rel="next"`) {
+		t.Errorf("headers = %v", resp.header)
+	}
+
+	resp = ts.do(http.MethodGet, "/api/me/likes", s.Token, nil)
+	expectStatus(t, resp, http.StatusOK)
+	var likes []database.SavedMovie
+	resp.decode(t, &likes)
+	if len(likes) != 1 || likes[0].Id != 7 {
+		t.Errorf("likes = %+v", likes)
+	}
+	expectError(t, ts.do(http.MethodGet, "/api/me/likes?sort=genre", s.Token, nil), http.StatusUnprocessableEntity, "validation_failed")
+
+	resp = ts.do(http.MethodGet, "/api/me/status?movies=2,7,11,1", s.Token, nil)
+	expectStatus(t, resp, http.StatusOK)
+	var statuses map[string]database.MovieStatus
+	resp.decode(t, &statuses)
+	want := map[string]database.MovieStatus{"2": {Watchlisted: true}, "7": {Liked: true}, "11": {Watchlisted: true}, "1": {}}
+	if fmt.Sprint(statuses) != fmt.Sprint(want) {
+		t.Errorf("statuses = %v; want %v", statuses, want)
+	}
+	expectError(t, ts.do(http.MethodGet, "/api/me/status?movies=1,x", s.Token, nil), http.StatusBadRequest, "bad_request")
+}
+
+func TestToggleWatchlistAndLiked(t *testing.T) {
+	ts := newTestServer(t)
+	s := ts.login("alice")
+	bob := ts.login("bob")
+
+	// Alice keeps her likes public and her watchlist private, as new users do.
+	for _, tc := range []struct {
+		toggle, status, on, off string
+		public                  bool
+	}{
+		{"/api/watchlist", "/watchlistStatus", "added", "not added", false},
+		{"/api/liked", "/likedStatus", "liked", "not liked", true},
+	} {
+		t.Run(tc.toggle, func(t *testing.T) {
+			state := func() string {
+				t.Helper()
+				resp := ts.do(http.MethodGet, tc.status+"/poor-things/alice", s.Token, nil)
+				expectStatus(t, resp, http.StatusOK)
+				var out struct {
+					Data string `json:"data"`
+				}
+				resp.decode(t, &out)
+				return out.Data
+			}
+
+			if got := state(); got != tc.off {
+				t.Fatalf("initial state %q; want %q", got, tc.off)
+			}
+
+			body := map[string]string{"movieId": "poor-things", "userName": "alice"}
+			resp := ts.do(http.MethodPost, tc.toggle, s.Token, body)
+			expectStatus(t, resp, http.StatusOK)
+			if resp.header.Get("Deprecation") != "true" || !strings.Contains(resp.header.Get("Link"), "/api/me/") {
+				t.Errorf("deprecated toggle headers = %v", resp.header)
+			}
+			if got := state(); got != tc.on {
+				t.Errorf("after toggling on: %q; want %q", got, tc.on)
+			}
+
+			expectStatus(t, ts.do(http.MethodPost, tc.toggle, s.Token, body), http.StatusOK)
+			if got := state(); got != tc.off {
+				t.Errorf("after toggling off: %q; want %q", got, tc.off)
+			}
+
+			expectError(t, ts.do(http.MethodPost, tc.toggle, s.Token, map[string]string{"movieId": "poor-things", "userName": "bob"}),
+				http.StatusForbidden, "forbidden")
+			expectError(t, ts.do(http.MethodPost, tc.toggle, s.Token, map[string]string{"movieId": "no-such-movie"}),
+				http.StatusNotFound, "not_found")
+			expectError(t, ts.do(http.MethodPost, tc.toggle, s.Token, "{"), http.StatusBadRequest, "bad_request")
+
+			expectError(t, ts.get(tc.status+"/no-such-movie/alice"), http.StatusNotFound, "not_found")
+			expectError(t, ts.get(tc.status+"/poor-things/nobody"), http.StatusNotFound, "not_found")
+
+			for _, token := range []string{"", bob.Token} {
+				resp := ts.do(http.MethodGet, tc.status+"/poor-things/alice", token, nil)
+				if tc.public {
+					expectStatus(t, resp, http.StatusOK)
+				} else {
+					expectError(t, resp, http.StatusForbidden, "forbidden")
+				}
+			}
+		})
+	}
+}
+
+func TestSetAndUnsetCollections(t *testing.T) {
+	ts := newTestServer(t)
+	s := ts.login("alice")
+
+	status := func(resp response) database.MovieStatus {
+		t.Helper()
+		expectStatus(t, resp, http.StatusOK)
+		var out struct {
+			Data database.MovieStatus `json:"data"`
+		}
+		resp.decode(t, &out)
+		return out.Data
+	}
+
+	// Repeating a request leaves things as the first one did.
+	for i := 0; i < 2; i++ {
+		if got := status(ts.do(http.MethodPut, "/api/me/likes/poor-things", s.Token, nil)); got != (database.MovieStatus{Liked: true}) {
+			t.Errorf("PUT likes (%d) = %+v", i+1, got)
+		}
+	}
+	if got := status(ts.do(http.MethodPut, "/api/me/watchlist/12", s.Token, nil)); got != (database.MovieStatus{Liked: true, Watchlisted: true}) {
+		t.Errorf("PUT watchlist = %+v", got)
+	}
+	for i := 0; i < 2; i++ {
+		if got := status(ts.do(http.MethodDelete, "/api/me/likes/poor-things", s.Token, nil)); got != (database.MovieStatus{Watchlisted: true}) {
+			t.Errorf("DELETE likes (%d) = %+v", i+1, got)
+		}
+	}
+
+	resp := ts.do(http.MethodGet, "/api/me/likes", s.Token, nil)
+	var likes []database.SavedMovie
+	resp.decode(t, &likes)
+	if len(likes) != 2 {
+		t.Errorf("likes = %+v; want alice's two seeded likes", likes)
+	}
+
+	expectError(t, ts.do(http.MethodPut, "/api/me/likes/poor-things", "", nil), http.StatusUnauthorized, "unauthorized")
+	expectError(t, ts.do(http.MethodPut, "/api/me/watchlist/no-such-movie", s.Token, nil), http.StatusNotFound, "not_found")
+	expectError(t, ts.do(http.MethodDelete, "/api/me/watchlist/no-such-movie", s.Token, nil), http.StatusNotFound, "not_found")
+}
+
+func TestLists(t *testing.T) {
+	ts := newTestServer(t)
+	alice, bob := ts.login("alice").Token, ts.login("bob").Token
+
+	list := func(resp response, status int) database.MovieList {
+		t.Helper()
+		expectStatus(t, resp, status)
+		var out struct {
+			Data database.MovieList `json:"data"`
+		}
+		resp.decode(t, &out)
+		return out.Data
+	}
+
+	resp := ts.do(http.MethodPost, "/api/lists", alice, map[string]string{"title": "Best of Greek cinema", "visibility": "private"})
+	created := list(resp, http.StatusCreated)
+	path := "/api/lists/" + created.ID
+	if resp.header.Get("Location") != path || created.Owner != "alice" || created.Visibility != database.ListPrivate {
+		t.Errorf("POST /api/lists = %+v, Location %q", created, resp.header.Get("Location"))
+	}
+	expectError(t, ts.do(http.MethodPost, "/api/lists", alice, map[string]string{"title": ""}), http.StatusUnprocessableEntity, "validation_failed")
+	expectError(t, ts.do(http.MethodPost, "/api/lists", alice, map[string]string{"name": "Halloween"}), http.StatusBadRequest, "bad_request")
+	expectError(t, ts.do(http.MethodPost, "/api/lists", "", map[string]string{"title": "Halloween"}), http.StatusUnauthorized, "unauthorized")
+
+	list(ts.do(http.MethodPut, path+"/entries/1", alice, nil), http.StatusOK)
+	list(ts.do(http.MethodPut, path+"/entries/5", alice, nil), http.StatusOK)
+	got := list(ts.do(http.MethodPut, path+"/entries/5", alice, map[string]interface{}{"note": "Watch first.", "position": 1}), http.StatusOK)
+	if len(got.Entries) != 2 || got.Entries[0].Id != 5 || got.Entries[0].Note != "Watch first." || got.Entries[1].Position != 2 {
+		t.Errorf("entries = %+v; want 5 then 1", got.Entries)
+	}
+	expectError(t, ts.do(http.MethodPut, path+"/entries/no-such-movie", alice, nil), http.StatusNotFound, "not_found")
+
+	// Private lists don't exist for others, signed in or not.
+	expectError(t, ts.get(path), http.StatusNotFound, "not_found")
+	expectError(t, ts.do(http.MethodGet, path, bob, nil), http.StatusNotFound, "not_found")
+	expectError(t, ts.do(http.MethodGet, path, "not-a-token", nil), http.StatusUnauthorized, "unauthorized")
+	resp = ts.do(http.MethodGet, path, alice, nil)
+	expectStatus(t, resp, http.StatusOK)
+	var own database.MovieList
+	resp.decode(t, &own)
+	if own.EntryCount != 2 || len(own.Entries) != 2 {
+		t.Errorf("GET own private list = %+v", own)
+	}
+
+	expectStatus(t, ts.do(http.MethodPut, path+"/collaborators/bob", alice, nil), http.StatusOK)
+	got = list(ts.do(http.MethodPut, path+"/entries/7", bob, map[string]string{"note": "Bob's pick"}), http.StatusOK)
+	if len(got.Entries) != 3 || got.Entries[2].AddedBy != "bob" || len(got.Collaborators) != 1 {
+		t.Errorf("after bob's entry = %+v", got)
+	}
+	expectError(t, ts.do(http.MethodPatch, path, bob, map[string]string{"title": "Mine"}), http.StatusForbidden, "forbidden")
+	expectError(t, ts.do(http.MethodDelete, path, bob, nil), http.StatusForbidden, "forbidden")
+	got = list(ts.do(http.MethodDelete, path+"/entries/1", bob, nil), http.StatusOK)
+	if len(got.Entries) != 2 {
+		t.Errorf("after removing 1 = %+v", got.Entries)
+	}
+
+	var mine []database.ListSummary
+	ts.do(http.MethodGet, "/api/me/lists", bob, nil).decode(t, &mine)
+	if len(mine) != 1 || mine[0].ID != created.ID {
+		t.Errorf("GET /api/me/lists (bob) = %+v", mine)
+	}
+
+	got = list(ts.do(http.MethodPatch, path, alice, map[string]string{"visibility": "public"}), http.StatusOK)
+	if got.Visibility != database.ListPublic || got.Title != "Best of Greek cinema" {
+		t.Errorf("PATCH = %+v", got)
+	}
+	expectStatus(t, ts.get(path), http.StatusOK)
+	var public []database.ListSummary
+	resp = ts.get("/api/lists?user=alice&sort=-updated")
+	resp.decode(t, &public)
+	if len(public) != 1 || public[0].EntryCount != 2 || resp.header.Get("X-Total-Count") != "1" {
+		t.Errorf("GET /api/lists?user=alice = %+v", public)
+	}
+	expectError(t, ts.get("/api/lists?user=nobody"), http.StatusNotFound, "not_found")
+
+	expectStatus(t, ts.do(http.MethodDelete, path+"/collaborators/bob", bob, nil), http.StatusOK)
+	expectError(t, ts.do(http.MethodPut, path+"/entries/8", bob, nil), http.StatusForbidden, "forbidden")
+
+	expectStatus(t, ts.do(http.MethodDelete, path, alice, nil), http.StatusOK)
+	expectError(t, ts.get(path), http.StatusNotFound, "not_found")
+}
+
+func TestDiary(t *testing.T) {
+	ts := newTestServer(t)
+	bob := ts.login("bob").Token
+
+	resp := ts.do(http.MethodPost, "/api/me/diary", bob, map[string]interface{}{"movie": "11", "watched_on": "2024-06-01", "rating": 4})
+	expectStatus(t, resp, http.StatusCreated)
+	var created struct {
+		Data database.DiaryEntry `json:"data"`
+	}
+	resp.decode(t, &created)
+	path := resp.header.Get("Location")
+	if created.Data.Id != 11 || *created.Data.Rating != 4 || path != fmt.Sprintf("/api/me/diary/%d", created.Data.ID) {
+		t.Errorf("POST /api/me/diary = %+v, Location %q", created.Data, path)
+	}
+	expectStatus(t, ts.do(http.MethodPost, "/api/me/diary", bob, map[string]string{"movie": "2", "watched_on": "2024-07-10"}), http.StatusCreated)
+
+	var watchlist []database.SavedMovie
+	ts.do(http.MethodGet, "/api/me/watchlist", bob, nil).decode(t, &watchlist)
+	if len(watchlist) != 0 {
+		t.Errorf("watchlist = %+v; want the watched movies taken off it", watchlist)
+	}
+
+	var diary []database.DiaryEntry
+	ts.do(http.MethodGet, "/api/me/diary", bob, nil).decode(t, &diary)
+	if len(diary) != 2 || diary[0].WatchedOn != "2024-07-10" {
+		t.Errorf("GET /api/me/diary = %+v; want newest first", diary)
+	}
+	ts.do(http.MethodGet, "/api/me/diary?year=2024&month=6", bob, nil).decode(t, &diary)
+	if len(diary) != 1 || diary[0].Id != 11 {
+		t.Errorf("GET /api/me/diary?year=2024&month=6 = %+v", diary)
+	}
+	var months []database.DiaryMonth
+	ts.do(http.MethodGet, "/api/me/diary/months", bob, nil).decode(t, &months)
+	if fmt.Sprint(months) != "[{2024 7 1} {2024 6 1}]" {
+		t.Errorf("GET /api/me/diary/months = %v", months)
+	}
+
+	resp = ts.do(http.MethodPatch, path, bob, map[string]interface{}{"rating": 0, "rewatch": true})
+	expectStatus(t, resp, http.StatusOK)
+	var updated struct {
+		Data database.DiaryEntry `json:"data"`
+	}
+	resp.decode(t, &updated)
+	if updated.Data.Rating != nil || !updated.Data.Rewatch {
+		t.Errorf("PATCH = %+v", updated.Data)
+	}
+
+	expectError(t, ts.do(http.MethodPost, "/api/me/diary", bob, map[string]string{"watched_on": "2024-06-01"}), http.StatusUnprocessableEntity, "validation_failed")
+	expectError(t, ts.do(http.MethodPost, "/api/me/diary", bob, map[string]string{"movie": "no-such-movie"}), http.StatusNotFound, "not_found")
+	expectError(t, ts.do(http.MethodPatch, path, bob, map[string]string{"movie": "1"}), http.StatusUnprocessableEntity, "validation_failed")
+	expectError(t, ts.do(http.MethodGet, "/api/me/diary?month=6", bob, nil), http.StatusUnprocessableEntity, "validation_failed")
+	expectError(t, ts.do(http.MethodGet, "/api/me/diary/first", bob, nil), http.StatusBadRequest, "bad_request")
+	expectError(t, ts.do(http.MethodGet, path, ts.login("alice").Token, nil), http.StatusNotFound, "not_found")
+	expectError(t, ts.get("/api/me/diary"), http.StatusUnauthorized, "unauthorized")
+
+	expectStatus(t, ts.do(http.MethodDelete, path, bob, nil), http.StatusOK)
+	expectError(t, ts.do(http.MethodGet, path, bob, nil), http.StatusNotFound, "not_found")
+}
+
+func TestImports(t *testing.T) {
+	ts := newTestServer(t)
+	bob := ts.login("bob").Token
+
+	ratings := "Date,Name,Year,Letterboxd URI,Rating\n" +
+		"2024-01-03,Goodfellas,1990,https://boxd.it/2,3.5\n" +
+		"2024-01-04,The Lobstr,2015,https://boxd.it/5,4\n"
+	resp := ts.upload("/api/me/imports", bob, map[string]string{"ratings.csv": ratings})
+	expectStatus(t, resp, http.StatusAccepted)
+	var started struct {
+		Data database.ImportJob `json:"data"`
+	}
+	resp.decode(t, &started)
+	path := resp.header.Get("Location")
+	if started.Data.Source != "letterboxd" || path != fmt.Sprintf("/api/me/imports/%d", started.Data.ID) {
+		t.Errorf("POST /api/me/imports = %+v, Location %q", started.Data, path)
+	}
+
+	var job database.ImportJob
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		ts.do(http.MethodGet, path, bob, nil).decode(t, &job)
+		if job.Status != database.ImportRunning || time.Now().After(deadline) {
+			break
+		}
+	}
+	if job.Status != database.ImportDone || job.Imported != 1 || len(job.Rows) != 1 || job.Rows[0].Line != 3 {
+		t.Fatalf("GET %s = %+v; want Goodfellas imported and the misspelt title left to resolve", path, job)
+	}
+	expectError(t, ts.do(http.MethodGet, path, ts.login("nikos").Token, nil), http.StatusNotFound, "not_found")
+
+	rowPath := fmt.Sprintf("%s/rows/%d", path, job.Rows[0].ID)
+	expectError(t, ts.do(http.MethodPost, rowPath, bob, map[string]string{}), http.StatusUnprocessableEntity, "validation_failed")
+	// Of two requests at once, such as a double click, only one imports the row.
+	responses := make(chan response, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			responses <- ts.do(http.MethodPost, rowPath, bob, map[string]string{"movie": "the-lobster"})
+		}()
+	}
+	first, second := <-responses, <-responses
+	if first.status != http.StatusOK {
+		first, second = second, first
+	}
+	resp = first
+	expectStatus(t, resp, http.StatusOK)
+	expectError(t, second, http.StatusConflict, "conflict")
+	var resolved struct {
+		Data database.ImportRow `json:"data"`
+	}
+	resp.decode(t, &resolved)
+	if !resolved.Data.Resolved || resolved.Data.MovieID == nil || *resolved.Data.MovieID != 11 {
+		t.Errorf("POST %s = %+v", rowPath, resolved.Data)
+	}
+	var reviews []database.Review
+	ts.get("/api/movies/reviews/11").decode(t, &reviews)
+	if len(reviews) != 1 || reviews[0].Stars != 4 {
+		t.Errorf("reviews of the resolved movie = %+v; want the imported rating", reviews)
+	}
+	expectError(t, ts.do(http.MethodPost, rowPath, bob, map[string]string{"movie": "11"}), http.StatusConflict, "conflict")
+	expectError(t, ts.do(http.MethodDelete, rowPath, bob, nil), http.StatusConflict, "conflict")
+	expectError(t, ts.do(http.MethodDelete, path+"/rows/x", bob, nil), http.StatusBadRequest, "bad_request")
+
+	expectError(t, ts.upload("/api/me/imports", bob, map[string]string{"notes.csv": "a,b\n1,2\n"}), http.StatusUnprocessableEntity, "validation_failed")
+	expectError(t, ts.upload("/api/me/imports", bob, nil), http.StatusUnprocessableEntity, "validation_failed")
+	expectError(t, ts.upload("/api/me/imports", "", map[string]string{"ratings.csv": ratings}), http.StatusUnauthorized, "unauthorized")
+}
+
+func TestAccountExportAndDeletion(t *testing.T) {
+	ts := newTestServer(t)
+	bob := ts.login("bob").Token
+
+	resp := ts.do(http.MethodGet, "/api/me/export", bob, nil)
+	expectStatus(t, resp, http.StatusOK)
+	if ct, cd := resp.header.Get("Content-Type"), resp.header.Get("Content-Disposition"); ct != "application/zip" || !strings.HasPrefix(cd, "attachment; filename=\"bob-") {
+		t.Errorf("export headers = %q, %q", ct, cd)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(resp.body), int64(len(resp.body)))
+	if err != nil {
+		t.Fatalf("export is not a ZIP archive: %v", err)
+	}
+	names := make(map[string]bool)
+	for _, f := range archive.File {
+		names[f.Name] = true
+	}
+	if !names["profile.json"] || !names["reviews.csv"] || !names["watchlist.csv"] || !names["lists.json"] {
+		t.Errorf("export holds %v", names)
+	}
+	expectError(t, ts.get("/api/me/export"), http.StatusUnauthorized, "unauthorized")
+
+	// The test server has no grace period, so the account goes at once.
+	expectError(t, ts.do(http.MethodDelete, "/api/me", bob, map[string]string{"password": "wrong"}), http.StatusForbidden, "forbidden")
+	resp = ts.do(http.MethodDelete, "/api/me", bob, map[string]string{"password": "bob-password"})
+	expectStatus(t, resp, http.StatusOK)
+	var deleted struct {
+		Data struct {
+			DeleteAfter int64 `json:"delete_after"`
+		} `json:"data"`
+	}
+	resp.decode(t, &deleted)
+	if deleted.Data.DeleteAfter > time.Now().Unix() {
+		t.Errorf("delete_after = %d; want now", deleted.Data.DeleteAfter)
+	}
+
+	expectError(t, ts.do(http.MethodGet, "/api/me", bob, nil), http.StatusUnauthorized, "unauthorized")
+	resp = ts.do(http.MethodPost, "/login", "", map[string]string{"username": "bob", "password": "bob-password"})
+	expectError(t, resp, http.StatusUnauthorized, "unauthorized")
+	expectError(t, ts.get("/api/users/bob"), http.StatusNotFound, "not_found")
+}
+
+func TestAdminCatalog(t *testing.T) {
+	ts := newTestServer(t)
+	admin := ts.login("alice").Token
+	user := ts.login("bob").Token
+
+	heat := map[string]string{"Title": "Heat", "ReleaseDate": "1995-12-15", "Genre": "Crime"}
+
+	expectError(t, ts.do(http.MethodPost, "/api/movies", "", heat), http.StatusUnauthorized, "unauthorized")
+	expectError(t, ts.do(http.MethodPost, "/api/movies", user, heat), http.StatusForbidden, "forbidden")
+
+	resp := ts.do(http.MethodPost, "/api/movies", admin, heat)
+	expectStatus(t, resp, http.StatusCreated)
+	var created struct {
+		Status string         `json:"status"`
+		Data   database.Movie `json:"data"`
+	}
+	resp.decode(t, &created)
+	if created.Status != "ok" || created.Data.Slug != "heat" || resp.header.Get("Location") != "/api/movies/heat" {
+		t.Errorf("created %+v at %q", created, resp.header.Get("Location"))
+	}
+	expectStatus(t, ts.get("/api/movies/heat"), http.StatusOK)
+
+	expectStatus(t, ts.do(http.MethodPatch, "/api/movies/heat", admin, map[string]string{"Genre": "Thriller"}), http.StatusOK)
+	var movie database.Movie
+	ts.get("/api/movies/heat").decode(t, &movie)
+	if movie.Genre != "Thriller" || movie.Title != "Heat" {
+		t.Errorf("after PATCH: %+v", movie)
+	}
+
+	expectStatus(t, ts.do(http.MethodPut, "/api/movies/heat", admin,
+		map[string]string{"Title": "Heat", "ReleaseDate": "1995-12-15", "Genre": "Crime", "slug": "heat-1995"}), http.StatusOK)
+	if code, location := ts.redirect("/api/movies/heat"); code != http.StatusMovedPermanently || location != "/api/movies/heat-1995" {
+		t.Errorf("old slug: %d to %q", code, location)
+	}
+
+	for name, tc := range map[string]struct {
+		method, path string
+		body         interface{}
+		status       int
+		code         string
+	}{
+		"PUT missing fields": {http.MethodPut, "/api/movies/heat-1995", map[string]string{"Genre": "Crime"}, http.StatusUnprocessableEntity, "validation_failed"},
+		"unknown field":      {http.MethodPatch, "/api/movies/heat-1995", map[string]string{"Rating": "5"}, http.StatusBadRequest, "bad_request"},
+		"bad date":           {http.MethodPatch, "/api/movies/heat-1995", map[string]string{"ReleaseDate": "soon"}, http.StatusUnprocessableEntity, "validation_failed"},
+		"taken slug":         {http.MethodPatch, "/api/movies/heat-1995", map[string]string{"slug": "goodfellas"}, http.StatusConflict, "conflict"},
+		"unknown movie":      {http.MethodPatch, "/api/movies/no-such-movie", map[string]string{"Genre": "Crime"}, http.StatusNotFound, "not_found"},
+		"reviewed movie":     {http.MethodDelete, "/api/movies/the-godfather", nil, http.StatusConflict, "conflict"},
+		"bad cascade":        {http.MethodDelete, "/api/movies/the-godfather?cascade=maybe", nil, http.StatusBadRequest, "bad_request"},
+		"unknown actor":      {http.MethodPut, "/api/movies/heat-1995/actors/no-such-actor", nil, http.StatusNotFound, "not_found"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			expectError(t, ts.do(tc.method, tc.path, admin, tc.body), tc.status, tc.code)
+		})
+	}
+
+	resp = ts.do(http.MethodPost, "/api/actors", admin, map[string]string{"ActorName": "Val Kilmer", "DateOfBirth": "1959-12-31", "Nationality": "American"})
+	expectStatus(t, resp, http.StatusCreated)
+	if location := resp.header.Get("Location"); location != "/api/actors/val-kilmer" {
+		t.Errorf("actor Location %q", location)
+	}
+	resp = ts.do(http.MethodPost, "/api/directors", admin, map[string]string{"DirectorName": "Michael Mann", "DateOfBirth": "1943-02-05", "Nationality": "American"})
+	expectStatus(t, resp, http.StatusCreated)
+
+	for _, path := range []string{"/api/movies/heat-1995/actors/val-kilmer", "/api/movies/heat-1995/actors/robert-de-niro", "/api/movies/heat-1995/directors/michael-mann"} {
+		expectStatus(t, ts.do(http.MethodPut, path, admin, nil), http.StatusOK)
+		expectStatus(t, ts.do(http.MethodPut, path, admin, nil), http.StatusOK)
+	}
+	var staff []database.StaffMember
+	ts.get("/api/movies/staff/heat-1995").decode(t, &staff)
+	if len(staff) != 3 {
+		t.Errorf("staff = %+v; want 3 members", staff)
+	}
+
+	expectStatus(t, ts.do(http.MethodDelete, "/api/movies/heat-1995/actors/val-kilmer", admin, nil), http.StatusOK)
+	expectError(t, ts.do(http.MethodDelete, "/api/movies/heat-1995/actors/val-kilmer", admin, nil), http.StatusNotFound, "not_found")
+	expectStatus(t, ts.do(http.MethodPatch, "/api/actors/val-kilmer", admin, map[string]string{"Nationality": "USA"}), http.StatusOK)
+	expectStatus(t, ts.do(http.MethodDelete, "/api/actors/val-kilmer", admin, nil), http.StatusOK)
+	expectError(t, ts.get("/api/actors/val-kilmer"), http.StatusNotFound, "not_found")
+	expectStatus(t, ts.do(http.MethodDelete, "/api/directors/michael-mann", admin, nil), http.StatusOK)
+	expectError(t, ts.do(http.MethodDelete, "/api/directors/michael-mann", user, nil), http.StatusForbidden, "forbidden")
+
+	expectStatus(t, ts.do(http.MethodDelete, "/api/movies/heat-1995", admin, nil), http.StatusOK)
+	expectStatus(t, ts.do(http.MethodDelete, "/api/movies/the-godfather?cascade=true", admin, nil), http.StatusOK)
+	expectError(t, ts.get("/api/movies/reviews/the-godfather"), http.StatusNotFound, "not_found")
+}
+
+func TestRoles(t *testing.T) {
+	ts := newTestServer(t)
+	admin := ts.login("alice").Token
+	moderator := ts.login("nikos").Token
+	bob := ts.login("bob")
+
+	// Only admins reach the admin endpoints, and only moderators and admins
+	// may delete reviews.
+	expectError(t, ts.do(http.MethodGet, "/api/admin/users/bob/roles", "", nil), http.StatusUnauthorized, "unauthorized")
+	expectError(t, ts.do(http.MethodGet, "/api/admin/users/bob/roles", bob.Token, nil), http.StatusForbidden, "forbidden")
+	expectError(t, ts.do(http.MethodGet, "/api/admin/users/bob/roles", moderator, nil), http.StatusForbidden, "forbidden")
+	expectError(t, ts.do(http.MethodDelete, "/api/reviews/1", bob.Token, nil), http.StatusForbidden, "forbidden")
+	expectError(t, ts.do(http.MethodPost, "/api/movies", moderator, map[string]string{}), http.StatusForbidden, "forbidden")
+
+	expectStatus(t, ts.do(http.MethodDelete, "/api/reviews/3", moderator, nil), http.StatusOK)
+	expectError(t, ts.do(http.MethodDelete, "/api/reviews/3", moderator, nil), http.StatusNotFound, "not_found")
+	expectError(t, ts.do(http.MethodDelete, "/api/reviews/three", moderator, nil), http.StatusBadRequest, "bad_request")
+
+	resp := ts.do(http.MethodPut, "/api/admin/users/bob/roles/moderator", admin, nil)
+	expectStatus(t, resp, http.StatusOK)
+	var granted struct {
+		Data server.UserRoles `json:"data"`
+	}
+	resp.decode(t, &granted)
+	if fmt.Sprint(granted.Data.Roles) != "[moderator user]" || fmt.Sprint(granted.Data.Permissions) != "[reviews:moderate]" || len(granted.Data.History) != 1 {
+		t.Errorf("after grant: %+v", granted.Data)
+	}
+
+	// Bob's token predates the grant; a refreshed one carries it.
+	expectError(t, ts.do(http.MethodDelete, "/api/reviews/2", bob.Token, nil), http.StatusForbidden, "forbidden")
+	resp = ts.do(http.MethodPost, "/token/refresh", "", map[string]string{"refresh_token": bob.RefreshToken})
+	expectStatus(t, resp, http.StatusOK)
+	var refreshed struct {
+		Data session `json:"data"`
+	}
+	resp.decode(t, &refreshed)
+	expectStatus(t, ts.do(http.MethodDelete, "/api/reviews/2", refreshed.Data.Token, nil), http.StatusOK)
+
+	// Revoking signs Bob out at once.
+	expectStatus(t, ts.do(http.MethodDelete, "/api/admin/users/bob/roles/moderator", admin, nil), http.StatusOK)
+	expectError(t, ts.do(http.MethodDelete, "/api/reviews/1", refreshed.Data.Token, nil), http.StatusUnauthorized, "unauthorized")
+
+	var roles server.UserRoles
+	resp = ts.do(http.MethodGet, "/api/admin/users/bob/roles", admin, nil)
+	expectStatus(t, resp, http.StatusOK)
+	resp.decode(t, &roles)
+	if roles.Username != "bob" || fmt.Sprint(roles.Roles) != "[user]" || len(roles.History) != 2 || roles.History[0].Action != "revoke" {
+		t.Errorf("roles = %+v", roles)
+	}
+
+	for name, tc := range map[string]struct {
+		method, path string
+		status       int
+		code         string
+	}{
+		"unknown user":     {http.MethodPut, "/api/admin/users/nobody/roles/admin", http.StatusNotFound, "not_found"},
+		"unknown role":     {http.MethodPut, "/api/admin/users/bob/roles/owner", http.StatusUnprocessableEntity, "validation_failed"},
+		"user role":        {http.MethodDelete, "/api/admin/users/bob/roles/user", http.StatusUnprocessableEntity, "validation_failed"},
+		"role not held":    {http.MethodDelete, "/api/admin/users/bob/roles/admin", http.StatusNotFound, "not_found"},
+		"last admin":       {http.MethodDelete, "/api/admin/users/alice/roles/admin", http.StatusConflict, "conflict"},
+		"unknown username": {http.MethodGet, "/api/admin/users/nobody/roles", http.StatusNotFound, "not_found"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			expectError(t, ts.do(tc.method, tc.path, admin, nil), tc.status, tc.code)
+		})
+	}
+}
+
+func TestCORS(t *testing.T) {
+	ts := newTestServer(t)
+
+	req, _ := http.NewRequest(http.MethodOptions, ts.URL+"/api/liked", nil)
+	req.Header.Set("Origin", testOrigin)
+	req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	req.Header.Set("Access-Control-Request-Headers", "Authorization, Content-Type")
+
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if got := resp.Header.Get("Access-Control-Allow-Origin"); got != testOrigin {
+		t.Errorf("Access-Control-Allow-Origin = %q; want %q", got, testOrigin)
+	}
+
+	req.Header.Set("Origin", "https://evil.example")
+	resp, err = ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if got := resp.Header.Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("disallowed origin got Access-Control-Allow-Origin %q", got)
+	}
+}
+
+// failingService makes every catalog read fail so that the error mapping
+// for unexpected failures can be checked.
+type failingService struct {
+	database.Service
+}
+
+func (failingService) Health(ctx context.Context) (map[string]string, error) {
+	return nil, fmt.Errorf("db down: %w", database.ErrUnavailable)
+}
+
+func (failingService) GetMovies(ctx context.Context, f database.MovieFilter, req database.PageRequest) (database.Page[database.Movie], error) {
+	return database.Page[database.Movie]{}, errors.New("connection reset by peer")
+}
+
+func (failingService) GetDirectors(ctx context.Context, f database.PersonFilter, req database.PageRequest) (database.Page[database.Director], error) {
+	return database.Page[database.Director]{}, fmt.Errorf("%w: %w", database.ErrTimeout, context.DeadlineExceeded)
+}
+
+func (failingService) SessionActive(ctx context.Context, familyID string) (bool, error) {
+	return false, fmt.Errorf("%w: %w", database.ErrTimeout, context.DeadlineExceeded)
+}
+
+func TestServiceFailures(t *testing.T) {
+	ts := startServer(t, failingService{database.NewMemory(nil)})
+
+	expectError(t, ts.get("/health"), http.StatusServiceUnavailable, "unavailable")
+
+	// Internal errors are logged, not leaked to the client.
+	resp := ts.get("/api/movies")
+	expectError(t, resp, http.StatusInternalServerError, "internal")
+	if strings.Contains(string(resp.body), "connection reset") {
+		t.Errorf("internal error leaked to the client: %s", resp.body)
+	}
+
+	expectError(t, ts.get("/api/directors"), http.StatusGatewayTimeout, "timeout")
+}
+
+// The request context reaches the Service, so a client that goes away
+// cancels the work done on its behalf.
+func TestRequestContextReachesService(t *testing.T) {
+	seen := make(chan context.Context, 1)
+	ts := startServer(t, contextSpy{Service: database.NewMemory(nil), seen: seen})
+
+	ts.get("/api/movies")
+
+	select {
+	case ctx := <-seen:
+		if ctx.Err() == nil {
+			t.Error("request context still live after the request finished")
+		}
+	default:
+		t.Fatal("GetMovies was not called")
+	}
+}
+
+type contextSpy struct {
+	database.Service
+	seen chan<- context.Context
+}
+
+func (s contextSpy) GetMovies(ctx context.Context, f database.MovieFilter, req database.PageRequest) (database.Page[database.Movie], error) {
+	// The server also lists the movies when it starts, outside any request.
+	if ctx.Value(http.ServerContextKey) != nil {
+		s.seen <- ctx
+	}
+	return s.Service.GetMovies(ctx, f, req)
+}
+
+func TestQueryTimeoutOnAuthenticatedRoute(t *testing.T) {
+	mem := newTestServer(t)
+	token := mem.login("alice").Token
+
+	ts := startServer(t, failingService{database.NewMemory(nil)})
+	resp := ts.do(http.MethodPost, "/api/liked", token, map[string]string{"movieId": "poor-things"})
+	expectError(t, resp, http.StatusGatewayTimeout, "timeout")
+}